@@ -0,0 +1,363 @@
+package opennebula
+
+// Typed wrappers of the XML-RPC methods used by the provider. Their
+// arguments follow the signatures documented for OpenNebula 5.x, so a call
+// can't be sent with a missing, extra or mistyped argument: some versions
+// of oned reject them. Resources should call these instead of Client.Call.
+//
+// Pool filters: -3 for the resources of the user, -2 for all resources,
+// -1 for the resources of the user and its group. Start and end IDs of -1
+// list the whole pool.
+
+// System
+
+func (c *Client) SystemVersion() (string, error) {
+	return c.Call("one.system.version")
+}
+
+// Virtual machines
+
+func (c *Client) VmAllocate(template string, hold bool) (string, error) {
+	return c.Call("one.vm.allocate", template, hold)
+}
+
+func (c *Client) VmInfo(id int) (string, error) {
+	return c.Call("one.vm.info", id)
+}
+
+func (c *Client) VmAction(action string, id int) (string, error) {
+	return c.Call("one.vm.action", action, id)
+}
+
+// VmDeploy deploys a pending or held VM on the host hostId, in the system
+// datastore dsId (-1 to let OpenNebula choose). Unless enforce is set, the
+// capacity of the host is checked.
+func (c *Client) VmDeploy(id, hostId int, enforce bool, dsId int) (string, error) {
+	return c.Call("one.vm.deploy", id, hostId, enforce, dsId)
+}
+
+// Operations of VmRecover.
+const (
+	VmRecoverFailure = iota
+	VmRecoverSuccess
+	VmRecoverRetry
+	VmRecoverDelete
+	VmRecoverDeleteRecreate
+	VmRecoverDeleteDb
+)
+
+// VmRecover recovers a VM stuck in a transient or failure state with one of
+// the VmRecover operations.
+func (c *Client) VmRecover(id, operation int) (string, error) {
+	return c.Call("one.vm.recover", id, operation)
+}
+
+func (c *Client) VmRename(id int, name string) (string, error) {
+	return c.Call("one.vm.rename", id, name)
+}
+
+// VmUpdate replaces the user template (mergeType 0) or merges it with the
+// existing one (mergeType 1).
+func (c *Client) VmUpdate(id int, template string, mergeType int) (string, error) {
+	return c.Call("one.vm.update", id, template, mergeType)
+}
+
+// VmUpdateConf replaces the configuration sections of a VM that can change
+// after creation, e.g. OS, GRAPHICS, RAW or CONTEXT.
+func (c *Client) VmUpdateConf(id int, template string) (string, error) {
+	return c.Call("one.vm.updateconf", id, template)
+}
+
+// VmChown changes the owner of a VM, -1 keeping the current user or group.
+func (c *Client) VmChown(id, uid, gid int) (string, error) {
+	return c.Call("one.vm.chown", id, uid, gid)
+}
+
+// VmDiskSaveas copies a disk of a VM, or its snapshot snapId (-1 for the
+// current disk), to a new image of the given type ("" for the type of the
+// disk image). It returns the ID of the image.
+func (c *Client) VmDiskSaveas(id, diskId int, name, imageType string, snapId int) (string, error) {
+	return c.Call("one.vm.disksaveas", id, diskId, name, imageType, snapId)
+}
+
+// VmDiskResize grows a disk of a VM to size MB.
+func (c *Client) VmDiskResize(id, diskId int, size string) (string, error) {
+	return c.Call("one.vm.diskresize", id, diskId, size)
+}
+
+// VmSnapshotCreate takes a system snapshot of a VM, and returns its ID.
+func (c *Client) VmSnapshotCreate(id int, name string) (string, error) {
+	return c.Call("one.vm.snapshotcreate", id, name)
+}
+
+func (c *Client) VmSnapshotDelete(id, snapId int) (string, error) {
+	return c.Call("one.vm.snapshotdelete", id, snapId)
+}
+
+// VmDiskSnapshotCreate takes a snapshot of a disk of a VM, and returns its
+// ID.
+func (c *Client) VmDiskSnapshotCreate(id, diskId int, name string) (string, error) {
+	return c.Call("one.vm.disksnapshotcreate", id, diskId, name)
+}
+
+func (c *Client) VmDiskSnapshotDelete(id, diskId, snapId int) (string, error) {
+	return c.Call("one.vm.disksnapshotdelete", id, diskId, snapId)
+}
+
+func (c *Client) VmChmod(id int, p *Permissions) (string, error) {
+	return changePermissions(id, p, c, "one.vm.chmod")
+}
+
+// VmMonitoring returns the monitoring records of a VM, oldest first.
+func (c *Client) VmMonitoring(id int) (string, error) {
+	return c.Call("one.vm.monitoring", id)
+}
+
+// VmPoolInfo lists VMs, state being a VM state number, -1 for any state
+// but DONE or -2 for any state.
+func (c *Client) VmPoolInfo(filter, start, end, state int) (string, error) {
+	return c.Call("one.vmpool.info", filter, start, end, state)
+}
+
+// Templates
+
+func (c *Client) TemplateAllocate(template string) (string, error) {
+	return c.Call("one.template.allocate", template)
+}
+
+// TemplateInfo returns a template, with the images and vnets it uses
+// expanded when extended is set.
+func (c *Client) TemplateInfo(id int, extended bool) (string, error) {
+	return c.Call("one.template.info", id, extended)
+}
+
+// TemplateInstantiate creates a VM from a template. extra is merged into
+// the template, persistent makes private copies of the template and images.
+func (c *Client) TemplateInstantiate(id int, name string, hold bool, extra string, persistent bool) (string, error) {
+	return c.Call("one.template.instantiate", id, name, hold, extra, persistent)
+}
+
+func (c *Client) TemplateRename(id int, name string) (string, error) {
+	return c.Call("one.template.rename", id, name)
+}
+
+// TemplateUpdate replaces the template (mergeType 0) or merges it with the
+// existing one (mergeType 1).
+func (c *Client) TemplateUpdate(id int, template string, mergeType int) (string, error) {
+	return c.Call("one.template.update", id, template, mergeType)
+}
+
+// TemplateChmod changes the permissions of a template, and of the images it
+// uses when recursive is set.
+func (c *Client) TemplateChmod(id int, p *Permissions, recursive bool) (string, error) {
+	return changePermissions(id, p, c, "one.template.chmod", recursive)
+}
+
+// TemplateDelete deletes a template, and the images it uses when
+// deleteImages is set.
+func (c *Client) TemplateDelete(id int, deleteImages bool) (string, error) {
+	return c.Call("one.template.delete", id, deleteImages)
+}
+
+func (c *Client) TemplatePoolInfo(filter, start, end int) (string, error) {
+	return c.Call("one.templatepool.info", filter, start, end)
+}
+
+// Images
+
+func (c *Client) ImageAllocate(template string, datastoreId int) (string, error) {
+	return c.Call("one.image.allocate", template, datastoreId)
+}
+
+func (c *Client) ImageClone(id int, name string, datastoreId int) (string, error) {
+	return c.Call("one.image.clone", id, name, datastoreId)
+}
+
+func (c *Client) ImageInfo(id int) (string, error) {
+	return c.Call("one.image.info", id)
+}
+
+func (c *Client) ImagePersistent(id int, persistent bool) (string, error) {
+	return c.Call("one.image.persistent", id, persistent)
+}
+
+// ImageEnable enables or disables an image, disabled images can't be used
+// by new VMs.
+func (c *Client) ImageEnable(id int, enable bool) (string, error) {
+	return c.Call("one.image.enable", id, enable)
+}
+
+// ImageLock locks an image at the given level, 1 (use) to 4 (all).
+func (c *Client) ImageLock(id, level int) (string, error) {
+	return c.Call("one.image.lock", id, level)
+}
+
+func (c *Client) ImageUnlock(id int) (string, error) {
+	return c.Call("one.image.unlock", id)
+}
+
+// ImageUpdate replaces the template (mergeType 0) or merges it with the
+// existing one (mergeType 1).
+func (c *Client) ImageUpdate(id int, template string, mergeType int) (string, error) {
+	return c.Call("one.image.update", id, template, mergeType)
+}
+
+func (c *Client) ImageRename(id int, name string) (string, error) {
+	return c.Call("one.image.rename", id, name)
+}
+
+// ImageChown changes the owner of an image, -1 keeping the current user or
+// group.
+func (c *Client) ImageChown(id, uid, gid int) (string, error) {
+	return c.Call("one.image.chown", id, uid, gid)
+}
+
+func (c *Client) ImageChmod(id int, p *Permissions) (string, error) {
+	return changePermissions(id, p, c, "one.image.chmod")
+}
+
+func (c *Client) ImageDelete(id int) (string, error) {
+	return c.Call("one.image.delete", id)
+}
+
+func (c *Client) ImagePoolInfo(filter, start, end int) (string, error) {
+	return c.Call("one.imagepool.info", filter, start, end)
+}
+
+// Datastores
+
+func (c *Client) DatastoreInfo(id int) (string, error) {
+	return c.Call("one.datastore.info", id)
+}
+
+// Marketplace apps
+
+func (c *Client) MarketAppInfo(id int) (string, error) {
+	return c.Call("one.marketapp.info", id)
+}
+
+func (c *Client) MarketAppPoolInfo(filter, start, end int) (string, error) {
+	return c.Call("one.marketapppool.info", filter, start, end)
+}
+
+// Security groups
+
+func (c *Client) SecurityGroupAllocate(template string) (string, error) {
+	return c.Call("one.secgroup.allocate", template)
+}
+
+func (c *Client) SecurityGroupInfo(id int) (string, error) {
+	return c.Call("one.secgroup.info", id)
+}
+
+// SecurityGroupUpdate replaces the template (mergeType 0) or merges it with
+// the existing one (mergeType 1).
+func (c *Client) SecurityGroupUpdate(id int, template string, mergeType int) (string, error) {
+	return c.Call("one.secgroup.update", id, template, mergeType)
+}
+
+// SecurityGroupCommit applies the rules to the VMs using the group: only
+// the outdated and errored ones when recovery is set, all of them otherwise.
+func (c *Client) SecurityGroupCommit(id int, recovery bool) (string, error) {
+	return c.Call("one.secgroup.commit", id, recovery)
+}
+
+func (c *Client) SecurityGroupRename(id int, name string) (string, error) {
+	return c.Call("one.secgroup.rename", id, name)
+}
+
+// SecurityGroupChown changes the owner of a Security Group, -1 keeping the
+// current user or group.
+func (c *Client) SecurityGroupChown(id, uid, gid int) (string, error) {
+	return c.Call("one.secgroup.chown", id, uid, gid)
+}
+
+func (c *Client) SecurityGroupChmod(id int, p *Permissions) (string, error) {
+	return changePermissions(id, p, c, "one.secgroup.chmod")
+}
+
+func (c *Client) SecurityGroupDelete(id int) (string, error) {
+	return c.Call("one.secgroup.delete", id)
+}
+
+func (c *Client) SecurityGroupPoolInfo(filter, start, end int) (string, error) {
+	return c.Call("one.secgrouppool.info", filter, start, end)
+}
+
+// Virtual networks
+
+func (c *Client) VnetAllocate(template string, clusterId int) (string, error) {
+	return c.Call("one.vn.allocate", template, clusterId)
+}
+
+func (c *Client) VnetInfo(id int) (string, error) {
+	return c.Call("one.vn.info", id)
+}
+
+// VnetUpdate replaces the template (mergeType 0) or merges it with the
+// existing one (mergeType 1).
+func (c *Client) VnetUpdate(id int, template string, mergeType int) (string, error) {
+	return c.Call("one.vn.update", id, template, mergeType)
+}
+
+func (c *Client) VnetAddAR(id int, template string) (string, error) {
+	return c.Call("one.vn.add_ar", id, template)
+}
+
+func (c *Client) VnetUpdateAR(id int, template string) (string, error) {
+	return c.Call("one.vn.update_ar", id, template)
+}
+
+func (c *Client) VnetHold(id int, template string) (string, error) {
+	return c.Call("one.vn.hold", id, template)
+}
+
+func (c *Client) VnetRelease(id int, template string) (string, error) {
+	return c.Call("one.vn.release", id, template)
+}
+
+// VnetReserve reserves addresses of the vnet id, template giving the SIZE
+// and either the NAME of a new vnet or the NETWORK_ID of an existing one.
+func (c *Client) VnetReserve(id int, template string) (string, error) {
+	return c.Call("one.vn.reserve", id, template)
+}
+
+func (c *Client) VnetRename(id int, name string) (string, error) {
+	return c.Call("one.vn.rename", id, name)
+}
+
+// VnetChown changes the owner of a vnet, -1 keeping the current user or
+// group.
+func (c *Client) VnetChown(id, uid, gid int) (string, error) {
+	return c.Call("one.vn.chown", id, uid, gid)
+}
+
+func (c *Client) VnetChmod(id int, p *Permissions) (string, error) {
+	return changePermissions(id, p, c, "one.vn.chmod")
+}
+
+func (c *Client) VnetDelete(id int) (string, error) {
+	return c.Call("one.vn.delete", id)
+}
+
+func (c *Client) VnetPoolInfo(filter, start, end int) (string, error) {
+	return c.Call("one.vnpool.info", filter, start, end)
+}
+
+// Users and groups
+
+func (c *Client) UserInfo(id int) (string, error) {
+	return c.Call("one.user.info", id)
+}
+
+func (c *Client) UserPoolInfo() (string, error) {
+	return c.Call("one.userpool.info")
+}
+
+func (c *Client) GroupInfo(id int) (string, error) {
+	return c.Call("one.group.info", id)
+}
+
+func (c *Client) GroupPoolInfo() (string, error) {
+	return c.Call("one.grouppool.info")
+}
@@ -0,0 +1,66 @@
+package opennebula
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClientApiArguments(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		return []interface{}{true, "", int64(0)}, nil
+	})
+
+	p := &Permissions{Owner_U: 1, Owner_M: 1}
+	perms := []interface{}{1, 1, 0, 0, 0, 0, 0, 0, 0}
+
+	cases := []struct {
+		call   func() (string, error)
+		method string
+		args   []interface{}
+	}{
+		{func() (string, error) { return client.VmAllocate("NAME=vm", false) }, "one.vm.allocate", []interface{}{"NAME=vm", false}},
+		{func() (string, error) { return client.VmPoolInfo(-2, -1, -1, -1) }, "one.vmpool.info", []interface{}{-2, -1, -1, -1}},
+		{func() (string, error) { return client.VmRecover(42, VmRecoverRetry) }, "one.vm.recover", []interface{}{42, 2}},
+		{func() (string, error) { return client.VmUpdateConf(42, "<TEMPLATE/>") }, "one.vm.updateconf", []interface{}{42, "<TEMPLATE/>"}},
+		{func() (string, error) { return client.VmDiskSaveas(42, 0, "backup", "", -1) }, "one.vm.disksaveas", []interface{}{42, 0, "backup", "", -1}},
+		{func() (string, error) { return client.VmDiskResize(42, 1, "4096") }, "one.vm.diskresize", []interface{}{42, 1, "4096"}},
+		{func() (string, error) { return client.VmSnapshotCreate(42, "before-upgrade") }, "one.vm.snapshotcreate", []interface{}{42, "before-upgrade"}},
+		{func() (string, error) { return client.VmSnapshotDelete(42, 3) }, "one.vm.snapshotdelete", []interface{}{42, 3}},
+		{func() (string, error) { return client.VmDiskSnapshotCreate(42, 0, "nightly") }, "one.vm.disksnapshotcreate", []interface{}{42, 0, "nightly"}},
+		{func() (string, error) { return client.VmDiskSnapshotDelete(42, 0, 3) }, "one.vm.disksnapshotdelete", []interface{}{42, 0, 3}},
+		{func() (string, error) { return client.VmChmod(42, p) }, "one.vm.chmod", append([]interface{}{42}, perms...)},
+		{func() (string, error) { return client.TemplateInfo(42, false) }, "one.template.info", []interface{}{42, false}},
+		{func() (string, error) { return client.TemplateChmod(42, p, true) }, "one.template.chmod", append(append([]interface{}{42}, perms...), true)},
+		{func() (string, error) { return client.TemplateDelete(42, false) }, "one.template.delete", []interface{}{42, false}},
+		{func() (string, error) { return client.ImageInfo(42) }, "one.image.info", []interface{}{42}},
+		{func() (string, error) { return client.ImageDelete(42) }, "one.image.delete", []interface{}{42}},
+		{func() (string, error) { return client.ImagePersistent(42, true) }, "one.image.persistent", []interface{}{42, true}},
+		{func() (string, error) { return client.ImageEnable(42, false) }, "one.image.enable", []interface{}{42, false}},
+		{func() (string, error) { return client.ImageLock(42, 4) }, "one.image.lock", []interface{}{42, 4}},
+		{func() (string, error) { return client.ImageUnlock(42) }, "one.image.unlock", []interface{}{42}},
+		{func() (string, error) { return client.ImageChown(42, -1, 105) }, "one.image.chown", []interface{}{42, -1, 105}},
+		{func() (string, error) { return client.MarketAppInfo(42) }, "one.marketapp.info", []interface{}{42}},
+		{func() (string, error) { return client.MarketAppPoolInfo(-2, -1, -1) }, "one.marketapppool.info", []interface{}{-2, -1, -1}},
+		{func() (string, error) { return client.SecurityGroupCommit(42, false) }, "one.secgroup.commit", []interface{}{42, false}},
+		{func() (string, error) { return client.SecurityGroupRename(42, "web") }, "one.secgroup.rename", []interface{}{42, "web"}},
+		{func() (string, error) { return client.SecurityGroupChown(42, 3, -1) }, "one.secgroup.chown", []interface{}{42, 3, -1}},
+		{func() (string, error) { return client.VnetInfo(42) }, "one.vn.info", []interface{}{42}},
+		{func() (string, error) { return client.VnetDelete(42) }, "one.vn.delete", []interface{}{42}},
+		{func() (string, error) { return client.VnetPoolInfo(-2, -1, -1) }, "one.vnpool.info", []interface{}{-2, -1, -1}},
+		{func() (string, error) { return client.UserPoolInfo() }, "one.userpool.info", nil},
+	}
+
+	for _, c := range cases {
+		if _, err := c.call(); err != nil {
+			t.Fatalf("%s: unexpected error: %s", c.method, err)
+		}
+
+		calls := caller.callsTo(c.method)
+		if len(calls) != 1 {
+			t.Fatalf("%s: expected 1 call, got %d", c.method, len(calls))
+		}
+		if len(calls[0].Args) != len(c.args) || (len(c.args) > 0 && !reflect.DeepEqual(calls[0].Args, c.args)) {
+			t.Errorf("%s: expected arguments %v, got %v", c.method, c.args, calls[0].Args)
+		}
+	}
+}
@@ -5,13 +5,62 @@ import (
 	"github.com/kolo/xmlrpc"
 	"log"
 	"strconv"
+	"time"
 )
 
+// ResponseError is a failure reported by OpenNebula in its response, as
+// opposed to a failure to reach it.
+type ResponseError struct {
+	Message string
+	// Code is the OpenNebula error code, e.g. 0x0400 when the object
+	// doesn't exist.
+	Code int
+}
+
+func (e *ResponseError) Error() string {
+	return e.Message
+}
+
+// isNotFound returns whether err is OpenNebula reporting the object
+// doesn't exist.
+func isNotFound(err error) bool {
+	respErr, ok := err.(*ResponseError)
+	return ok && respErr.Code == 0x0400
+}
+
+// transportRetries is the number of attempts of retryTransport, and
+// transportRetryDelay the time before the first retry, doubled for each
+// next one.
+var (
+	transportRetries    = 3
+	transportRetryDelay = 2 * time.Second
+)
+
+// Caller is the transport used by Client to reach the XML-RPC endpoint.
+// It is satisfied by *xmlrpc.Client and can be replaced in tests to
+// simulate an OpenNebula frontend.
+type Caller interface {
+	Call(serviceMethod string, args interface{}, reply interface{}) error
+}
+
 type Client struct {
-	Rcp      xmlrpc.Client
+	Rcp      Caller
 	session  string
 	Username string
 	Password string
+
+	// StrictDecoding makes Decode report the XML elements of responses
+	// that are unknown to the provider.
+	StrictDecoding bool
+
+	// Version of the OpenNebula endpoint, nil when it could not be found.
+	Version *Version
+
+	// DefaultNicModel is the model of the VM NICs declared without one.
+	DefaultNicModel string
+
+	// SkipNicModelValidation accepts NIC models unknown to KVM.
+	SkipNicModelValidation bool
 }
 
 func NewClient(endpoint, username, password string) (*Client, error) {
@@ -20,12 +69,18 @@ func NewClient(endpoint, username, password string) (*Client, error) {
 		return nil, err
 	}
 
+	return NewClientWithCaller(client, username, password), nil
+}
+
+// NewClientWithCaller returns a Client sending its calls through rpc
+// instead of a real XML-RPC connection.
+func NewClientWithCaller(rpc Caller, username, password string) *Client {
 	return &Client{
-		Rcp:      *client,
+		Rcp:      rpc,
 		session:  fmt.Sprintf("%s:%s", username, password),
 		Username: username,
 		Password: password,
-	}, nil
+	}
 }
 
 func (c *Client) Call(command string, args ...interface{}) (string, error) {
@@ -50,7 +105,13 @@ func (c *Client) Call(command string, args ...interface{}) (string, error) {
 
 func (c *Client) IsSuccess(result []interface{}) (res string, err error) {
 	if !result[0].(bool) {
-		err = fmt.Errorf("%s", result[1].(string))
+		respErr := &ResponseError{Message: fmt.Sprintf("%s", result[1].(string))}
+		if len(result) > 2 {
+			if code, ok := result[2].(int64); ok {
+				respErr.Code = int(code)
+			}
+		}
+		err = respErr
 		return
 	}
 
@@ -63,6 +124,24 @@ func (c *Client) IsSuccess(result []interface{}) (res string, err error) {
 	return
 }
 
+// retryTransport makes call up to transportRetries times, backing off
+// exponentially, while OpenNebula can't be reached, e.g. on an HTTP 500 or a
+// connection reset. Errors reported by OpenNebula itself, like an
+// authorization failure or a missing object, are returned right away.
+func retryTransport(call func() (string, error)) (string, error) {
+	delay := transportRetryDelay
+	for try := 1; ; try++ {
+		resp, err := call()
+		if _, ok := err.(*ResponseError); err == nil || ok || try >= transportRetries {
+			return resp, err
+		}
+
+		log.Printf("[WARN] Error reaching OpenNebula, retrying in %s (%d/%d): %s", delay, try, transportRetries-1, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
 func intId(id string) int {
 	i, err := strconv.Atoi(id)
 	if err != nil {
@@ -0,0 +1,147 @@
+package opennebula
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testCall records a single XML-RPC call received by testCaller, without
+// the session argument.
+type testCall struct {
+	Method string
+	Args   []interface{}
+}
+
+// testCaller is a fake OpenNebula endpoint. Each call is answered by
+// handler, which returns the values OpenNebula would put in the response
+// array (success flag, result, error code).
+type testCaller struct {
+	calls   []testCall
+	handler func(method string, args []interface{}) ([]interface{}, error)
+}
+
+func (c *testCaller) Call(method string, args interface{}, reply interface{}) error {
+	params := args.([]interface{})[1:]
+	c.calls = append(c.calls, testCall{Method: method, Args: params})
+
+	result, err := c.handler(method, params)
+	if err != nil {
+		return err
+	}
+
+	*reply.(*[]interface{}) = result
+	return nil
+}
+
+// callsTo returns the calls received for the given method.
+func (c *testCaller) callsTo(method string) []testCall {
+	var calls []testCall
+	for _, call := range c.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func testClient(handler func(method string, args []interface{}) ([]interface{}, error)) (*Client, *testCaller) {
+	caller := &testCaller{handler: handler}
+	return NewClientWithCaller(caller, "oneadmin", "secret"), caller
+}
+
+func TestClientCall(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.vm.allocate":
+			return []interface{}{true, int64(42), int64(0)}, nil
+		case "one.vm.info":
+			return []interface{}{true, "<VM><ID>42</ID></VM>", int64(0)}, nil
+		case "one.vm.delete":
+			return []interface{}{false, "[one.vm.delete] Not authorized", int64(0x0200)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	resp, err := client.Call("one.vm.allocate", "NAME=test", false)
+	if err != nil || resp != "42" {
+		t.Fatalf("Expected allocate to return 42, got %q (err: %v)", resp, err)
+	}
+
+	resp, err = client.Call("one.vm.info", 42)
+	if err != nil || resp != "<VM><ID>42</ID></VM>" {
+		t.Fatalf("Expected info to return the VM body, got %q (err: %v)", resp, err)
+	}
+
+	_, err = client.Call("one.vm.delete", 42)
+	if respErr, ok := err.(*ResponseError); !ok || respErr.Code != 0x0200 || respErr.Error() != "[one.vm.delete] Not authorized" {
+		t.Fatalf("Expected a response error with code 0x0200 when OpenNebula reports a failure, got %#v", err)
+	}
+
+	if _, err = client.Call("one.vm.unknown"); err == nil {
+		t.Fatalf("Expected transport errors to be returned")
+	} else if _, ok := err.(*ResponseError); ok {
+		t.Fatalf("Expected transport errors not to be response errors")
+	}
+
+	if len(caller.calls) != 4 {
+		t.Fatalf("Expected 4 calls, got %d", len(caller.calls))
+	}
+	if args := caller.calls[0].Args; len(args) != 2 || args[0] != "NAME=test" || args[1] != false {
+		t.Fatalf("Unexpected arguments sent to one.vm.allocate: %v", args)
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	defer func(delay time.Duration) { transportRetryDelay = delay }(transportRetryDelay)
+	transportRetryDelay = 0
+
+	failures := 1
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.vm.info":
+			if failures > 0 {
+				failures--
+				return nil, fmt.Errorf("connection reset by peer")
+			}
+			return []interface{}{true, "<VM><ID>42</ID></VM>", int64(0)}, nil
+		case "one.image.info":
+			return []interface{}{false, "[one.image.info] Error getting image [42].", int64(0x0400)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	resp, err := retryTransport(func() (string, error) { return client.VmInfo(42) })
+	if err != nil || resp != "<VM><ID>42</ID></VM>" {
+		t.Fatalf("Expected the call to succeed once retried, got %q (err: %v)", resp, err)
+	}
+	if calls := caller.callsTo("one.vm.info"); len(calls) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(calls))
+	}
+
+	if _, err = retryTransport(func() (string, error) { return client.ImageInfo(42) }); err == nil {
+		t.Fatalf("Expected OpenNebula errors to be returned")
+	}
+	if calls := caller.callsTo("one.image.info"); len(calls) != 1 {
+		t.Fatalf("Expected OpenNebula errors not to be retried, got %d attempts", len(calls))
+	}
+
+	failures = 10
+	if _, err = retryTransport(func() (string, error) { return client.VmInfo(42) }); err == nil {
+		t.Fatalf("Expected the transport error once the attempts are exhausted")
+	}
+	if calls := caller.callsTo("one.vm.info"); len(calls) != 2+transportRetries {
+		t.Fatalf("Expected %d attempts, got %d", transportRetries, len(calls)-2)
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	if !isNotFound(&ResponseError{Message: "[one.image.info] Error getting image [42].", Code: 0x0400}) {
+		t.Errorf("Expected a missing image to be reported as not found")
+	}
+	for _, err := range []error{&ResponseError{Message: "[one.image.info] User couldn't be authorized", Code: 0x0200}, fmt.Errorf("connection refused")} {
+		if isNotFound(err) {
+			t.Errorf("Expected %q not to be reported as not found", err)
+		}
+	}
+}
@@ -1,19 +1,170 @@
 package opennebula
 
 import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func dataImage() *schema.Resource {
 	return &schema.Resource{
-		Read:   resourceImageRead,
+		Read: dataImageRead,
 
 		Schema: map[string]*schema.Schema{
+			"id": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ConflictsWith: []string{"name"},
+				Description:   "ID of the Image. One of 'id' or 'name' is required",
+			},
 			"name": {
-				Type:			schema.TypeString,
-				Required:		true,
-				Description:	"Name of the Image",
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ConflictsWith: []string{"id"},
+				Description:   "Name of the Image, which must be unique among the images visible to the user",
+			},
+			"uid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the user owning the Image",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the group owning the Image",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user owning the Image",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group owning the Image",
+			},
+			"datastore_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the datastore of the Image",
+			},
+			"type": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Type of the Image: OS, CDROM, DATABLOCK, KERNEL, RAMDISK or CONTEXT",
+			},
+			"size": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Size of the Image in MB",
+			},
+			"persistent": {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "Whether the Image is persistent",
+			},
+			"dev_prefix": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Device prefix of the Image",
+			},
+			"driver": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Driver of the Image, e.g. 'raw' or 'qcow2'",
 			},
 		},
 	}
 }
+
+func dataImageRead(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	img, err := dataImageLookup(client, d.Get("id").(string), d.Get("name").(string))
+	if err != nil {
+		return err
+	}
+
+	d.SetId(strconv.Itoa(img.Id))
+	d.Set("name", img.Name)
+	d.Set("uid", img.Uid)
+	d.Set("gid", img.Gid)
+	d.Set("uname", img.Uname)
+	d.Set("gname", img.Gname)
+	d.Set("datastore_id", img.DatastoreID)
+	d.Set("size", img.Size)
+	if persistent, err := imagePersistent(img.Persistent); err == nil {
+		d.Set("persistent", persistent)
+	} else {
+		log.Printf("[WARN] %s", err)
+	}
+	if imgtype, err := imageType(img.Type); err == nil {
+		d.Set("type", imgtype)
+	} else {
+		log.Printf("[WARN] %s", err)
+	}
+	d.Set("dev_prefix", img.Template.DevPrefix)
+	d.Set("driver", img.Template.Driver)
+
+	return nil
+}
+
+// dataImageLookup returns the image with the given ID or else the one with
+// the given name. Several images with that name are an error rather than a
+// guess.
+func dataImageLookup(client *Client, id, name string) (*Image, error) {
+	var img *Image
+	var imgs *Images
+
+	if id == "" {
+		if name == "" {
+			return nil, fmt.Errorf("One of id or name must be set")
+		}
+
+		resp, err := client.ImagePoolInfo(-2, -1, -1)
+		if err != nil {
+			return nil, err
+		}
+		if err = client.Decode(resp, &imgs); err != nil {
+			return nil, err
+		}
+
+		var ids []string
+		for _, i := range imgs.Image {
+			if i.Name == name {
+				ids = append(ids, strconv.Itoa(i.Id))
+			}
+		}
+
+		switch len(ids) {
+		case 0:
+			return nil, fmt.Errorf("Could not find an Image named %q", name)
+		case 1:
+			id = ids[0]
+		default:
+			return nil, fmt.Errorf("Images %s are named %q, look one of them up by id", strings.Join(ids, ", "), name)
+		}
+	}
+
+	imageID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("Unexpected Image ID %q, expected an integer", id)
+	}
+	resp, err := client.ImageInfo(imageID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find Image %s: %s", id, err)
+	}
+	if err = client.Decode(resp, &img); err != nil {
+		return nil, err
+	}
+	if img.Template == nil {
+		img.Template = &ImageTemplate{}
+	}
+
+	return img, nil
+}
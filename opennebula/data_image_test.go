@@ -0,0 +1,92 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"strings"
+	"testing"
+)
+
+func TestAccDataImage(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckImageDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccDataImageConfig, testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair("data.opennebula_image.by_name", "id", "opennebula_image.test", "id"),
+					resource.TestCheckResourceAttrPair("data.opennebula_image.by_id", "name", "opennebula_image.test", "name"),
+					resource.TestCheckResourceAttrPair("data.opennebula_image.by_id", "datastore_id", "opennebula_image.test", "datastore_id"),
+					resource.TestCheckResourceAttr("data.opennebula_image.by_id", "type", "DATABLOCK"),
+					resource.TestCheckResourceAttr("data.opennebula_image.by_id", "size", "16"),
+					resource.TestCheckResourceAttr("data.opennebula_image.by_id", "driver", "qcow2"),
+					resource.TestCheckResourceAttr("data.opennebula_image.by_id", "persistent", "false"),
+					resource.TestCheckResourceAttrSet("data.opennebula_image.by_id", "uname"),
+				),
+			},
+		},
+	})
+}
+
+func TestDataImageLookup(t *testing.T) {
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.image.info":
+			return []interface{}{true, fmt.Sprintf("<IMAGE><ID>%d</ID><NAME>image</NAME></IMAGE>", args[0].(int)), int64(0)}, nil
+		case "one.imagepool.info":
+			return []interface{}{true, `<IMAGE_POOL>
+  <IMAGE><ID>41</ID><NAME>debian</NAME></IMAGE>
+  <IMAGE><ID>42</ID><NAME>ubuntu</NAME></IMAGE>
+  <IMAGE><ID>43</ID><NAME>ubuntu</NAME></IMAGE>
+</IMAGE_POOL>`, int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	cases := []struct {
+		id       string
+		name     string
+		expected int
+		err      string
+	}{
+		{"40", "", 40, ""},
+		{"debian", "", 0, "expected an integer"},
+		{"", "debian", 41, ""},
+		{"", "ubuntu", 0, "42, 43"},
+		{"", "centos", 0, "Could not find"},
+		{"", "", 0, "must be set"},
+	}
+
+	for _, c := range cases {
+		img, err := dataImageLookup(client, c.id, c.name)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q/%q: Expected an error with %q, got %v", c.id, c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil || img.Id != c.expected || img.Template == nil {
+			t.Errorf("%q/%q: Expected image %d, got %v (err: %v)", c.id, c.name, c.expected, img, err)
+		}
+	}
+}
+
+var testAccDataImageConfig = `
+resource "opennebula_image" "test" {
+  name = "tf-acc-test-data-image"
+  datastore_id = %d
+  type = "DATABLOCK"
+  size = 16
+  driver = "qcow2"
+}
+
+data "opennebula_image" "by_name" {
+  name = "${opennebula_image.test.name}"
+}
+
+data "opennebula_image" "by_id" {
+  id = "${opennebula_image.test.id}"
+}
+`
@@ -0,0 +1,168 @@
+package opennebula
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func dataImages() *schema.Resource {
+	return &schema.Resource{
+		Read: dataImagesRead,
+
+		Schema: map[string]*schema.Schema{
+			"type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Type the images must have: OS, CDROM, DATABLOCK, KERNEL, RAMDISK or CONTEXT",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					if _, err := imageType(v.(string)); err != nil {
+						errors = append(errors, fmt.Errorf("%q: %s", k, err))
+					}
+					return
+				},
+			},
+			"datastore_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     -1,
+				Description: "ID of the datastore the images must be in, -1 for any",
+			},
+			"template_attributes": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "Attributes the template of the images must have, e.g. OS_FAMILY = \"ubuntu\"",
+			},
+			"ids": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "IDs of the matching images, sorted",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+			},
+			"names": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Names of the matching images, in the order of ids",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"images": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Matching images, by ID",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"size": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"template_attributes": {
+							Type:     schema.TypeMap,
+							Computed: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataImagesRead(d *schema.ResourceData, meta interface{}) error {
+	var imgs *Images
+
+	client := meta.(*Client)
+	imgtype := d.Get("type").(string)
+	datastoreID := d.Get("datastore_id").(int)
+	attrs := d.Get("template_attributes").(map[string]interface{})
+
+	resp, err := client.ImagePoolInfo(-2, -1, -1)
+	if err != nil {
+		return err
+	}
+	if err = client.Decode(resp, &imgs); err != nil {
+		return err
+	}
+
+	ids := make([]int, 0)
+	names := make([]string, 0)
+	flattened := make([]interface{}, 0)
+	for _, img := range imagesMatching(imgs.Image, imgtype, datastoreID, attrs) {
+		ids = append(ids, img.Id)
+		names = append(names, img.Name)
+		flattened = append(flattened, map[string]interface{}{
+			"id":                  img.Id,
+			"name":                img.Name,
+			"size":                img.Size,
+			"template_attributes": imageCustomAttributes(img),
+		})
+	}
+
+	d.SetId(fmt.Sprint(hashcode.String(fmt.Sprintf("%s/%d/%s", strings.ToUpper(imgtype), datastoreID, vmTagsString(attrs)))))
+	d.Set("ids", ids)
+	d.Set("names", names)
+	if err = d.Set("images", flattened); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// imagesMatching returns the images of the given type, in the given
+// datastore and with all the given template attributes, sorted by ID. An
+// empty type and a negative datastore ID match any.
+func imagesMatching(imgs []*Image, imgtype string, datastoreID int, attrs map[string]interface{}) []*Image {
+	var found []*Image
+	for _, img := range imgs {
+		if imgtype != "" {
+			if t, err := imageType(img.Type); err != nil || t != strings.ToUpper(imgtype) {
+				continue
+			}
+		}
+		if datastoreID >= 0 && img.DatastoreID != datastoreID {
+			continue
+		}
+
+		match := true
+		for key, value := range attrs {
+			if img.Template == nil || img.Template.Get(strings.ToUpper(key)) != fmt.Sprint(value) {
+				match = false
+				break
+			}
+		}
+		if match {
+			found = append(found, img)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].Id < found[j].Id
+	})
+	return found
+}
+
+// imageCustomAttributes returns the custom attributes of the template of an
+// image.
+func imageCustomAttributes(img *Image) map[string]interface{} {
+	attrs := make(map[string]interface{})
+	if img.Template == nil {
+		return attrs
+	}
+	for _, a := range img.Template.Custom {
+		attrs[a.XMLName.Local] = img.Template.Get(a.XMLName.Local)
+	}
+	return attrs
+}
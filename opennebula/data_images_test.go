@@ -0,0 +1,105 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"reflect"
+	"testing"
+)
+
+func TestAccDataImages(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckImageDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccDataImagesConfig, testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.opennebula_images.golden", "ids.#", "2"),
+					resource.TestCheckResourceAttrPair("data.opennebula_images.golden", "ids.0", "opennebula_image.old", "id"),
+					resource.TestCheckResourceAttrPair("data.opennebula_images.golden", "ids.1", "opennebula_image.new", "id"),
+					resource.TestCheckResourceAttr("data.opennebula_images.golden", "images.1.size", "16"),
+					resource.TestCheckResourceAttr("data.opennebula_images.golden", "images.1.template_attributes.BUILD_DATE", "2020-10-01"),
+				),
+			},
+		},
+	})
+}
+
+func TestImagesMatching(t *testing.T) {
+	var imgs Images
+	err := xml.Unmarshal([]byte(`<IMAGE_POOL>
+  <IMAGE><ID>43</ID><NAME>ubuntu-2010</NAME><TYPE>0</TYPE><DATASTORE_ID>1</DATASTORE_ID><TEMPLATE><OS_FAMILY>ubuntu</OS_FAMILY><BUILD_DATE>2020-10-01</BUILD_DATE></TEMPLATE></IMAGE>
+  <IMAGE><ID>41</ID><NAME>ubuntu-2004</NAME><TYPE>0</TYPE><DATASTORE_ID>1</DATASTORE_ID><TEMPLATE><OS_FAMILY>ubuntu</OS_FAMILY></TEMPLATE></IMAGE>
+  <IMAGE><ID>42</ID><NAME>debian</NAME><TYPE>0</TYPE><DATASTORE_ID>100</DATASTORE_ID><TEMPLATE><OS_FAMILY>debian</OS_FAMILY></TEMPLATE></IMAGE>
+  <IMAGE><ID>44</ID><NAME>scratch</NAME><TYPE>2</TYPE><DATASTORE_ID>1</DATASTORE_ID><TEMPLATE/></IMAGE>
+</IMAGE_POOL>`), &imgs)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := []struct {
+		imgtype     string
+		datastoreID int
+		attrs       map[string]interface{}
+		expected    []int
+	}{
+		{"", -1, nil, []int{41, 42, 43, 44}},
+		{"OS", -1, nil, []int{41, 42, 43}},
+		{"datablock", -1, nil, []int{44}},
+		{"", 100, nil, []int{42}},
+		{"", -1, map[string]interface{}{"os_family": "ubuntu"}, []int{41, 43}},
+		{"OS", 1, map[string]interface{}{"OS_FAMILY": "ubuntu", "BUILD_DATE": "2020-10-01"}, []int{43}},
+		{"CDROM", -1, nil, []int{}},
+	}
+
+	for i, c := range cases {
+		ids := make([]int, 0)
+		for _, img := range imagesMatching(imgs.Image, c.imgtype, c.datastoreID, c.attrs) {
+			ids = append(ids, img.Id)
+		}
+		if !reflect.DeepEqual(ids, c.expected) {
+			t.Errorf("%d: Expected images %v, got %v", i, c.expected, ids)
+		}
+	}
+
+	attrs := imageCustomAttributes(imgs.Image[0])
+	if !reflect.DeepEqual(attrs, map[string]interface{}{"OS_FAMILY": "ubuntu", "BUILD_DATE": "2020-10-01"}) {
+		t.Errorf("Expected the custom attributes of image 43, got %v", attrs)
+	}
+}
+
+var testAccDataImagesConfig = `
+resource "opennebula_image" "old" {
+  name = "tf-acc-test-data-images-old"
+  datastore_id = %[1]d
+  type = "DATABLOCK"
+  size = 16
+  template_attributes = {
+    TF_ACC_TEST = "data-images"
+    BUILD_DATE = "2020-09-01"
+  }
+}
+
+resource "opennebula_image" "new" {
+  name = "tf-acc-test-data-images-new"
+  datastore_id = %[1]d
+  type = "DATABLOCK"
+  size = 16
+  template_attributes = {
+    TF_ACC_TEST = "data-images"
+    BUILD_DATE = "2020-10-01"
+  }
+}
+
+data "opennebula_images" "golden" {
+  type = "DATABLOCK"
+  datastore_id = %[1]d
+  template_attributes = {
+    TF_ACC_TEST = "${opennebula_image.new.template_attributes["TF_ACC_TEST"]}"
+  }
+  depends_on = ["opennebula_image.old"]
+}
+`
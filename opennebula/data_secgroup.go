@@ -1,21 +1,181 @@
 package opennebula
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func dataSecurityGroup() *schema.Resource {
 	return &schema.Resource{
-		Read:   resourceSecurityGroupRead,
+		Read: dataSecurityGroupRead,
 
-		Schema: map[string]*schema.Schema {
+		Schema: map[string]*schema.Schema{
+			"id": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ConflictsWith: []string{"name"},
+				Description:   "ID of the Security Group. One of 'id' or 'name' is required",
+			},
 			"name": {
-				Type:			schema.TypeString,
-				Required:		true,
-				ForceNew:		true,
-				Description:	"Name of the Security Group",
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ConflictsWith: []string{"id"},
+				Description:   "Name of the Security Group, which must be unique among the groups visible to the user",
+			},
+			"description": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Description of the Security Group",
+			},
+			"permissions": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Permissions of the Security Group (in Unix format, owner-group-other, use-manage-admin)",
+			},
+			"uid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the user owning the Security Group",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the group owning the Security Group",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user owning the Security Group",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group owning the Security Group",
+			},
+			"rule": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Rules of the Security Group, with the attributes of the opennebula_secgroup rules",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"protocol": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"rule_type": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ip": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"size": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"range": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"icmp_type": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"icmpv6_type": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"network_id": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
 			},
 		},
 	}
 }
 
+func dataSecurityGroupRead(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	secgroup, err := dataSecurityGroupLookup(client, d.Get("id").(string), d.Get("name").(string))
+	if err != nil {
+		return err
+	}
+
+	d.SetId(secgroup.Id)
+	d.Set("name", secgroup.Name)
+	d.Set("description", secgroup.SecurityGroupTemplate.Description)
+	d.Set("permissions", permissionString(secgroup.Permissions))
+	d.Set("uid", secgroup.Uid)
+	d.Set("gid", secgroup.Gid)
+	d.Set("uname", secgroup.Uname)
+	d.Set("gname", secgroup.Gname)
+	if err = d.Set("rule", flattenSecurityGroupRules(secgroup.SecurityGroupTemplate.SecurityGroupRules)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// dataSecurityGroupLookup returns the Security Group with the given ID or
+// else the one with the given name. Several groups with that name are an
+// error rather than a guess.
+func dataSecurityGroupLookup(client *Client, id, name string) (*SecurityGroup, error) {
+	var secgroup *SecurityGroup
+	var secgroups *SecurityGroups
+
+	if id == "" {
+		if name == "" {
+			return nil, fmt.Errorf("One of id or name must be set")
+		}
+
+		resp, err := client.SecurityGroupPoolInfo(-2, -1, -1)
+		if err != nil {
+			return nil, err
+		}
+		if err = client.Decode(resp, &secgroups); err != nil {
+			return nil, err
+		}
+
+		var ids []string
+		for _, s := range secgroups.SecurityGroup {
+			if s.Name == name {
+				ids = append(ids, s.Id)
+			}
+		}
+
+		switch len(ids) {
+		case 0:
+			return nil, fmt.Errorf("Could not find a Security Group named %q", name)
+		case 1:
+			id = ids[0]
+		default:
+			return nil, fmt.Errorf("Security Groups %s are named %q, look one of them up by id", strings.Join(ids, ", "), name)
+		}
+	}
+
+	secgroupID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("Unexpected Security Group ID %q, expected an integer", id)
+	}
+	resp, err := client.SecurityGroupInfo(secgroupID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find Security Group %s: %s", id, err)
+	}
+	if err = client.Decode(resp, &secgroup); err != nil {
+		return nil, err
+	}
+	if secgroup.SecurityGroupTemplate == nil {
+		secgroup.SecurityGroupTemplate = &SecurityGroupTemplate{}
+	}
+
+	return secgroup, nil
+}
@@ -0,0 +1,100 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"strings"
+	"testing"
+)
+
+func TestAccDataSecurityGroup(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckSecurityGroupDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataSecurityGroupConfig,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair("data.opennebula_secgroup.by_name", "id", "opennebula_secgroup.test", "id"),
+					resource.TestCheckResourceAttrPair("data.opennebula_secgroup.by_id", "name", "opennebula_secgroup.test", "name"),
+					resource.TestCheckResourceAttr("data.opennebula_secgroup.by_id", "description", "Created by the acceptance tests"),
+					resource.TestCheckResourceAttr("data.opennebula_secgroup.by_id", "rule.#", "2"),
+					resource.TestCheckResourceAttr("data.opennebula_secgroup.by_id", "rule.0.protocol", "ALL"),
+					resource.TestCheckResourceAttr("data.opennebula_secgroup.by_id", "rule.1.rule_type", "INBOUND"),
+					resource.TestCheckResourceAttr("data.opennebula_secgroup.by_id", "rule.1.range", "22"),
+					resource.TestCheckResourceAttrSet("data.opennebula_secgroup.by_id", "uid"),
+				),
+			},
+		},
+	})
+}
+
+func TestDataSecurityGroupLookup(t *testing.T) {
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.secgroup.info":
+			return []interface{}{true, fmt.Sprintf("<SECURITY_GROUP><ID>%d</ID><NAME>secgroup</NAME></SECURITY_GROUP>", args[0].(int)), int64(0)}, nil
+		case "one.secgrouppool.info":
+			return []interface{}{true, `<SECURITY_GROUP_POOL>
+  <SECURITY_GROUP><ID>101</ID><NAME>web</NAME></SECURITY_GROUP>
+  <SECURITY_GROUP><ID>102</ID><NAME>ssh</NAME></SECURITY_GROUP>
+  <SECURITY_GROUP><ID>103</ID><NAME>ssh</NAME></SECURITY_GROUP>
+</SECURITY_GROUP_POOL>`, int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	cases := []struct {
+		id       string
+		name     string
+		expected string
+		err      string
+	}{
+		{"100", "", "100", ""},
+		{"web", "", "", "expected an integer"},
+		{"", "web", "101", ""},
+		{"", "ssh", "", "102, 103"},
+		{"", "db", "", "Could not find"},
+		{"", "", "", "must be set"},
+	}
+
+	for _, c := range cases {
+		secgroup, err := dataSecurityGroupLookup(client, c.id, c.name)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q/%q: Expected an error with %q, got %v", c.id, c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil || secgroup.Id != c.expected || secgroup.SecurityGroupTemplate == nil {
+			t.Errorf("%q/%q: Expected Security Group %s, got %v (err: %v)", c.id, c.name, c.expected, secgroup, err)
+		}
+	}
+}
+
+var testAccDataSecurityGroupConfig = `
+resource "opennebula_secgroup" "test" {
+  name = "tf-acc-test-data-secgroup"
+  description = "Created by the acceptance tests"
+
+  rule {
+    protocol = "ALL"
+    rule_type = "OUTBOUND"
+  }
+
+  rule {
+    protocol = "TCP"
+    rule_type = "INBOUND"
+    range = "22"
+  }
+}
+
+data "opennebula_secgroup" "by_name" {
+  name = "${opennebula_secgroup.test.name}"
+}
+
+data "opennebula_secgroup" "by_id" {
+  id = "${opennebula_secgroup.test.id}"
+}
+`
@@ -0,0 +1,208 @@
+package opennebula
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func dataVm() *schema.Resource {
+	return &schema.Resource{
+		Read: dataVmRead,
+
+		Schema: map[string]*schema.Schema{
+			"id": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ConflictsWith: []string{"name"},
+				Description:   "ID of the VM. Either 'id' or 'name' is required",
+			},
+			"name": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ConflictsWith: []string{"id"},
+				Description:   "Name of the VM, which must be unique among the VMs visible to the user",
+			},
+			"ip": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Primary IP address of the VM",
+			},
+			"ips": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "IP addresses of the NICs of the VM, in NIC order",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"state": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Current state of the VM",
+			},
+			"lcmstate": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Current LCM state of the VM",
+			},
+			"state_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the current state of the VM, e.g. ACTIVE",
+			},
+			"lcm_state_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the current LCM state of the VM, e.g. RUNNING",
+			},
+			"uid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the user owning the VM",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the group owning the VM",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user owning the VM",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group owning the VM",
+			},
+			"template_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the template the VM was instantiated from, -1 if none",
+			},
+			"nic": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Network adapters of the VM",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"ip": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"mac": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"model": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"network_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"nic_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"security_groups": {
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataVmRead(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	vm, err := dataVmLookup(client, d.Get("id").(string), d.Get("name").(string))
+	if err != nil {
+		return err
+	}
+
+	templateID, ok := vmTemplateID(vm)
+	if !ok {
+		templateID = -1
+	}
+
+	d.SetId(vm.Id)
+	d.Set("name", vm.Name)
+	d.Set("ip", vmPrimaryIP(vm.VmTemplate.NICs))
+	d.Set("ips", vmIPs(vm.VmTemplate.NICs))
+	d.Set("state", vm.State)
+	d.Set("lcmstate", vm.LcmState)
+	d.Set("state_name", vmStateName(vm.State))
+	d.Set("lcm_state_name", vmLcmStateName(vm.LcmState))
+	d.Set("uid", vm.Uid)
+	d.Set("gid", vm.Gid)
+	d.Set("uname", vm.Uname)
+	d.Set("gname", vm.Gname)
+	d.Set("template_id", templateID)
+	if err = d.Set("nic", flattenVmNICs(orderedVmNICs(vm.VmTemplate.NICs))); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// dataVmLookup returns the VM with the given ID or else name. A name used by
+// several VMs is an error rather than a guess.
+func dataVmLookup(client *Client, id, name string) (*UserVm, error) {
+	var vm *UserVm
+
+	if id != "" {
+		vmID, err := strconv.Atoi(id)
+		if err != nil {
+			return nil, fmt.Errorf("Unexpected VM ID %q, expected an integer", id)
+		}
+		resp, err := client.VmInfo(vmID)
+		if err != nil {
+			return nil, fmt.Errorf("Could not find VM %s: %s", id, err)
+		}
+		if err = client.Decode(resp, &vm); err != nil {
+			return nil, err
+		}
+		return vm, nil
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("One of id or name must be set")
+	}
+
+	vms, err := vmsByName(client, name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(vms) {
+	case 0:
+		return nil, fmt.Errorf("Could not find a VM named %q", name)
+	case 1:
+		return vms[0], nil
+	default:
+		return nil, fmt.Errorf("VM name %q is used by VMs %s, look one of them up by id", name, vmIdsString(vms))
+	}
+}
+
+// vmIPs returns the IPv4 addresses of the NICs of a VM, by NIC_ID.
+func vmIPs(nics []VirtualMachineNIC) []string {
+	ips := make([]string, 0, len(nics))
+	for _, nic := range *orderedVmNICs(nics) {
+		if nic.IP != "" {
+			ips = append(ips, nic.IP)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,101 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAccDataVm(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataVmConfig,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair("data.opennebula_vm.by_name", "id", "opennebula_vm.test", "id"),
+					resource.TestCheckResourceAttrPair("data.opennebula_vm.by_id", "name", "opennebula_vm.test", "name"),
+					resource.TestCheckResourceAttr("data.opennebula_vm.by_id", "template_id", "-1"),
+					resource.TestCheckResourceAttr("data.opennebula_vm.by_id", "nic.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestDataVmLookup(t *testing.T) {
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.vm.info":
+			return []interface{}{true, "<VM><ID>40</ID><NAME>dns</NAME><TEMPLATE></TEMPLATE></VM>", int64(0)}, nil
+		case "one.vmpool.info":
+			return []interface{}{true, `<VM_POOL>
+  <VM><ID>41</ID><NAME>web</NAME><UNAME>someone</UNAME></VM>
+  <VM><ID>42</ID><NAME>db</NAME></VM>
+  <VM><ID>43</ID><NAME>db</NAME></VM>
+</VM_POOL>`, int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	cases := []struct {
+		id       string
+		name     string
+		expected string
+		err      string
+	}{
+		{"40", "", "40", ""},
+		{"dns", "", "", "expected an integer"},
+		{"", "web", "41", ""},
+		{"", "db", "", "42, 43"},
+		{"", "missing", "", "Could not find"},
+		{"", "", "", "must be set"},
+	}
+
+	for _, c := range cases {
+		vm, err := dataVmLookup(client, c.id, c.name)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q/%q: Expected an error with %q, got %v", c.id, c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil || vm.Id != c.expected {
+			t.Errorf("%q/%q: Expected VM %s, got %v (err: %v)", c.id, c.name, c.expected, vm, err)
+		}
+	}
+}
+
+func TestVmIPs(t *testing.T) {
+	nics := []VirtualMachineNIC{
+		{NIC_ID: 1, IP: "10.0.0.2"},
+		{NIC_ID: 2, IP6_Global: "2001:db8::1"},
+		{NIC_ID: 0, IP: "192.168.0.2"},
+	}
+
+	expected := []string{"192.168.0.2", "10.0.0.2"}
+	if ips := vmIPs(nics); !reflect.DeepEqual(ips, expected) {
+		t.Errorf("Expected %v, got %v", expected, ips)
+	}
+}
+
+var testAccDataVmConfig = `
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-data-vm"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+}
+
+data "opennebula_vm" "by_name" {
+  name = "${opennebula_vm.test.name}"
+}
+
+data "opennebula_vm" "by_id" {
+  id = "${opennebula_vm.test.id}"
+}
+`
@@ -0,0 +1,187 @@
+package opennebula
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func dataVms() *schema.Resource {
+	return &schema.Resource{
+		Read: dataVmsRead,
+
+		Schema: map[string]*schema.Schema{
+			"tags": {
+				Type:        schema.TypeMap,
+				Required:    true,
+				Description: "Attributes the user template of the VMs must have, e.g. ROLE = \"worker\"",
+			},
+			"page_size": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     500,
+				Description: "Number of VMs fetched per call to OpenNebula, at least 2",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					// a page size of 1 would be sent as -1, which lists the whole pool
+					if v.(int) < 2 {
+						errors = append(errors, fmt.Errorf("%q must be at least 2", k))
+					}
+					return
+				},
+			},
+			"ids": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "IDs of the matching VMs",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+			},
+			"names": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Names of the matching VMs",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"ips": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Primary IP addresses of the matching VMs, empty for VMs without one",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"vms": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Matching VMs, by ID",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ip": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"state": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"lcmstate": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataVmsRead(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+	tags := d.Get("tags").(map[string]interface{})
+
+	vms, err := vmPool(client, d.Get("page_size").(int))
+	if err != nil {
+		return err
+	}
+
+	ids := make([]int, 0)
+	names := make([]string, 0)
+	ips := make([]string, 0)
+	flattened := make([]interface{}, 0)
+	for _, vm := range vmsWithTags(vms, tags) {
+		ip := vmPrimaryIP(vm.VmTemplate.NICs)
+
+		ids = append(ids, intId(vm.Id))
+		names = append(names, vm.Name)
+		ips = append(ips, ip)
+		flattened = append(flattened, map[string]interface{}{
+			"id":       intId(vm.Id),
+			"name":     vm.Name,
+			"ip":       ip,
+			"state":    vm.State,
+			"lcmstate": vm.LcmState,
+		})
+	}
+
+	d.SetId(fmt.Sprint(hashcode.String(vmTagsString(tags))))
+	d.Set("ids", ids)
+	d.Set("names", names)
+	d.Set("ips", ips)
+	if err = d.Set("vms", flattened); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// vmPool lists the VMs visible to the user, pageSize at a time: a negative
+// end ID is the size of the page starting at the offset given as start ID.
+func vmPool(client *Client, pageSize int) ([]*UserVm, error) {
+	var all []*UserVm
+
+	for offset := 0; ; offset += pageSize {
+		var vms *UserVms
+
+		resp, err := client.VmPoolInfo(-2, offset, -pageSize, -1)
+		if err != nil {
+			return nil, err
+		}
+		if err = client.Decode(resp, &vms); err != nil {
+			return nil, err
+		}
+
+		all = append(all, vms.UserVm...)
+		if len(vms.UserVm) < pageSize {
+			return all, nil
+		}
+	}
+}
+
+// vmsWithTags returns the VMs whose user template has all the given
+// attributes, sorted by ID. OpenNebula upper-cases attribute names, so tags
+// match case-insensitively on the name only.
+func vmsWithTags(vms []*UserVm, tags map[string]interface{}) []*UserVm {
+	var found []*UserVm
+	for _, vm := range vms {
+		match := true
+		for key, value := range tags {
+			if vm.VmUserTemplate.Get(strings.ToUpper(key)) != fmt.Sprint(value) {
+				match = false
+				break
+			}
+		}
+		if match {
+			found = append(found, vm)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return intId(found[i].Id) < intId(found[j].Id)
+	})
+	return found
+}
+
+// vmTagsString renders tags in a stable order, to build the ID of the data
+// source.
+func vmTagsString(tags map[string]interface{}) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", strings.ToUpper(key), value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
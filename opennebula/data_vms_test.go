@@ -0,0 +1,102 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"testing"
+)
+
+func TestAccDataVms(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				// the VMs are tagged after creation, so the data source is read
+				// in a second step
+				Config: testAccDataVmsConfig,
+			},
+			{
+				Config: testAccDataVmsConfig + testAccDataVmsConfigData,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.opennebula_vms.workers", "ids.#", "2"),
+					resource.TestCheckResourceAttr("data.opennebula_vms.workers", "names.#", "2"),
+					resource.TestCheckResourceAttr("data.opennebula_vms.workers", "vms.#", "2"),
+				),
+			},
+		},
+	})
+}
+
+func TestVmPool(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.vmpool.info" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		// 5 VMs, by pages of 2
+		pool := "<VM_POOL>"
+		for id := args[1].(int); id < 5 && id < args[1].(int)-args[2].(int); id++ {
+			pool += fmt.Sprintf("<VM><ID>%d</ID></VM>", id)
+		}
+		return []interface{}{true, pool + "</VM_POOL>", int64(0)}, nil
+	})
+
+	vms, err := vmPool(client, 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(vms) != 5 || vms[4].Id != "4" {
+		t.Fatalf("Expected the 5 VMs, got %v", vms)
+	}
+
+	calls := caller.callsTo("one.vmpool.info")
+	if len(calls) != 3 {
+		t.Fatalf("Expected 3 pages, got %d", len(calls))
+	}
+	if calls[2].Args[1] != 4 || calls[2].Args[2] != -2 {
+		t.Errorf("Expected the last page to start at offset 4, got %v", calls[2].Args)
+	}
+}
+
+func TestVmsWithTags(t *testing.T) {
+	var pool UserVms
+	client, _ := testClient(nil)
+	err := client.Decode(`<VM_POOL>
+  <VM><ID>12</ID><NAME>worker-2</NAME><USER_TEMPLATE><ROLE>worker</ROLE><ENV>prod</ENV></USER_TEMPLATE></VM>
+  <VM><ID>3</ID><NAME>worker-1</NAME><USER_TEMPLATE><ROLE>worker</ROLE><ENV>prod</ENV></USER_TEMPLATE></VM>
+  <VM><ID>7</ID><NAME>worker-staging</NAME><USER_TEMPLATE><ROLE>worker</ROLE><ENV>staging</ENV></USER_TEMPLATE></VM>
+  <VM><ID>8</ID><NAME>lb</NAME><USER_TEMPLATE><ROLE>lb</ROLE></USER_TEMPLATE></VM>
+</VM_POOL>`, &pool)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	vms := vmsWithTags(pool.UserVm, map[string]interface{}{"role": "worker", "ENV": "prod"})
+	if len(vms) != 2 || vms[0].Name != "worker-1" || vms[1].Name != "worker-2" {
+		t.Fatalf("Expected worker-1 and worker-2, got %v", vms)
+	}
+
+	if s := vmTagsString(map[string]interface{}{"role": "worker", "ENV": "prod"}); s != "ENV=prod,ROLE=worker" {
+		t.Errorf("Expected the tags in a stable order, got %s", s)
+	}
+}
+
+var testAccDataVmsConfig = `
+resource "opennebula_vm" "worker" {
+  count = 2
+  name = "tf-acc-test-data-vms-${count.index}"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+  description = "tf-acc-test worker"
+}
+`
+
+var testAccDataVmsConfigData = `
+data "opennebula_vms" "workers" {
+  tags {
+    DESCRIPTION = "tf-acc-test worker"
+  }
+}
+`
@@ -1,19 +1,288 @@
 package opennebula
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func dataVnet() *schema.Resource {
 	return &schema.Resource{
-		Read:   resourceVnetRead,
+		Read: dataVnetRead,
 
 		Schema: map[string]*schema.Schema{
+			"id": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ConflictsWith: []string{"name", "filter"},
+				Description:   "ID of the vnet. One of 'id', 'name' or 'filter' is required",
+			},
 			"name": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ConflictsWith: []string{"id"},
+				Description:   "Name of the vnet, which must be unique among the vnets visible to the user matching filter",
+			},
+			"filter": {
+				Type:          schema.TypeMap,
+				Optional:      true,
+				ConflictsWith: []string{"id"},
+				Description:   "Custom attributes the template of the vnet must have, e.g. TIER = \"public\"",
+			},
+			"uid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the user owning the vnet",
+			},
+			"gid": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the group owning the vnet",
+			},
+			"uname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the user owning the vnet",
+			},
+			"gname": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the group owning the vnet",
+			},
+			"bridge": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the bridge interface of the vnet",
+			},
+			"vn_mad": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "VN driver of the vnet",
+			},
+			"phydev": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the physical device of the vnet",
+			},
+			"vlan_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the vlan of the vnet, the VNI of vxlan networks",
+			},
+			"gateway": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "CONTEXT: Gateway IP",
+			},
+			"networkmask": {
 				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Name of the vnet",
+				Computed:    true,
+				Description: "CONTEXT: Network mask",
+			},
+			"dns": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "CONTEXT: Space separated list of dns IPs",
+			},
+			"security_groups": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "IDs of the Security Groups applied to the vnet",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+			},
+			"ar": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Address ranges of the vnet",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"ar_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"type": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"ip": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"mac": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"size": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"used_leases": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+					},
+				},
+			},
+			"max_leases": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     1024,
+				Description: "Maximum number of leases kept in the leases attribute",
+			},
+			"leases": vnetLeasesSchema(),
+			"used_leases": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Number of addresses of the vnet in use",
+			},
+			"total_leases": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Number of addresses of the address ranges of the vnet",
 			},
 		},
 	}
 }
+
+func dataVnetRead(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	vn, err := dataVnetLookup(client, d.Get("id").(string), d.Get("name").(string), d.Get("filter").(map[string]interface{}))
+	if err != nil {
+		return err
+	}
+
+	secgroups, err := vnetSecurityGroups(vn.Template.Security_Groups)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(strconv.Itoa(vn.Id))
+	d.Set("name", vn.Name)
+	d.Set("uid", vn.Uid)
+	d.Set("gid", vn.Gid)
+	d.Set("uname", vn.Uname)
+	d.Set("gname", vn.Gname)
+	d.Set("bridge", vn.Bridge)
+	d.Set("vn_mad", vn.Template.Vn_Mad)
+	d.Set("phydev", vn.Template.Phydev)
+	d.Set("vlan_id", vnetVlanID(vn))
+	d.Set("gateway", vn.Template.Gateway)
+	d.Set("networkmask", vn.Template.NetworkMask)
+	d.Set("dns", vn.Template.Dns)
+	d.Set("used_leases", vn.UsedLeases)
+	d.Set("total_leases", vnetTotalLeases(vn.ARs))
+	if err = d.Set("security_groups", secgroups); err != nil {
+		return err
+	}
+	if err = d.Set("ar", flattenVnetARs(vn.ARs)); err != nil {
+		return err
+	}
+	if err = d.Set("leases", flattenVnetLeases(vn.ARs, d.Get("max_leases").(int))); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// dataVnetLookup returns the vnet with the given ID or else the one with the
+// given name and custom attributes of filter. Several matching vnets are an
+// error rather than a guess.
+func dataVnetLookup(client *Client, id, name string, filter map[string]interface{}) (*UserVnet, error) {
+	var vn *UserVnet
+	var vns *UserVnets
+
+	if id == "" {
+		if name == "" && len(filter) == 0 {
+			return nil, fmt.Errorf("One of id, name or filter must be set")
+		}
+
+		resp, err := client.VnetPoolInfo(-2, -1, -1)
+		if err != nil {
+			return nil, err
+		}
+		if err = client.Decode(resp, &vns); err != nil {
+			return nil, err
+		}
+
+		var ids []string
+		for _, v := range vns.UserVnet {
+			if (name == "" || v.Name == name) && vnetMatches(v, filter) {
+				ids = append(ids, strconv.Itoa(v.Id))
+			}
+		}
+
+		switch len(ids) {
+		case 0:
+			return nil, fmt.Errorf("Could not find a vnet %s", vnetLookupString(name, filter))
+		case 1:
+			id = ids[0]
+		default:
+			return nil, fmt.Errorf("Vnets %s match %s, look one of them up by id", strings.Join(ids, ", "), vnetLookupString(name, filter))
+		}
+	}
+
+	// The pool does not list the leases of the address ranges
+	vnetID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, fmt.Errorf("Unexpected vnet ID %q, expected an integer", id)
+	}
+	resp, err := client.VnetInfo(vnetID)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find vnet %s: %s", id, err)
+	}
+	if err = client.Decode(resp, &vn); err != nil {
+		return nil, err
+	}
+	if vn.Template == nil {
+		vn.Template = &VnetTemplate{}
+	}
+
+	return vn, nil
+}
+
+// vnetMatches returns whether the template of vn has the custom attributes
+// of filter.
+func vnetMatches(vn *UserVnet, filter map[string]interface{}) bool {
+	for key, value := range filter {
+		if vn.Template == nil || vn.Template.Get(strings.ToUpper(key)) != fmt.Sprint(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// vnetLookupString describes the vnets looked up by name and filter, for
+// errors.
+func vnetLookupString(name string, filter map[string]interface{}) string {
+	var desc []string
+	if name != "" {
+		desc = append(desc, fmt.Sprintf("named %q", name))
+	}
+	if len(filter) > 0 {
+		desc = append(desc, fmt.Sprintf("with %s", vmTagsString(filter)))
+	}
+	return strings.Join(desc, " and ")
+}
+
+// flattenVnetARs returns the address ranges of a vnet as set in state.
+func flattenVnetARs(ars []VnetAR) []interface{} {
+	flat := make([]interface{}, 0, len(ars))
+	for _, ar := range ars {
+		flat = append(flat, map[string]interface{}{
+			"ar_id":       ar.Id,
+			"type":        ar.Type,
+			"ip":          ar.IP,
+			"mac":         ar.MAC,
+			"size":        ar.Size,
+			"used_leases": ar.UsedLeases,
+		})
+	}
+	return flat
+}
@@ -0,0 +1,124 @@
+package opennebula
+
+import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAccDataVnet(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccDataVnetConfig,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair("data.opennebula_vnet.by_name", "id", "opennebula_vnet.test", "id"),
+					resource.TestCheckResourceAttrPair("data.opennebula_vnet.by_id", "name", "opennebula_vnet.test", "name"),
+					resource.TestCheckResourceAttr("data.opennebula_vnet.by_id", "bridge", "br-test"),
+					resource.TestCheckResourceAttr("data.opennebula_vnet.by_id", "gateway", "192.168.7.254"),
+					resource.TestCheckResourceAttr("data.opennebula_vnet.by_id", "ar.#", "1"),
+					resource.TestCheckResourceAttr("data.opennebula_vnet.by_id", "ar.0.ip", "192.168.7.1"),
+					resource.TestCheckResourceAttr("data.opennebula_vnet.by_id", "ar.0.size", "10"),
+					resource.TestCheckResourceAttr("data.opennebula_vnet.by_id", "total_leases", "10"),
+					resource.TestCheckResourceAttrPair("data.opennebula_vnet.by_filter", "id", "opennebula_vnet.test", "id"),
+				),
+			},
+		},
+	})
+}
+
+func TestDataVnetLookup(t *testing.T) {
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.vn.info":
+			return []interface{}{true, fmt.Sprintf("<VNET><ID>%d</ID><NAME>vnet</NAME></VNET>", args[0].(int)), int64(0)}, nil
+		case "one.vnpool.info":
+			return []interface{}{true, `<VNET_POOL>
+  <VNET><ID>41</ID><NAME>public</NAME><TEMPLATE><TIER>public</TIER></TEMPLATE></VNET>
+  <VNET><ID>42</ID><NAME>private</NAME><TEMPLATE><TIER>private</TIER><ZONE>dmz</ZONE></TEMPLATE></VNET>
+  <VNET><ID>43</ID><NAME>private</NAME><TEMPLATE><TIER>private</TIER></TEMPLATE></VNET>
+</VNET_POOL>`, int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	cases := []struct {
+		id       string
+		name     string
+		filter   map[string]interface{}
+		expected int
+		err      string
+	}{
+		{"40", "", nil, 40, ""},
+		{"vnet", "", nil, 0, "expected an integer"},
+		{"", "public", nil, 41, ""},
+		{"", "private", nil, 0, "42, 43"},
+		{"", "missing", nil, 0, "Could not find"},
+		{"", "", nil, 0, "must be set"},
+		{"", "", map[string]interface{}{"tier": "public"}, 41, ""},
+		{"", "", map[string]interface{}{"TIER": "private", "ZONE": "dmz"}, 42, ""},
+		{"", "private", map[string]interface{}{"ZONE": "dmz"}, 42, ""},
+		{"", "", map[string]interface{}{"TIER": "private"}, 0, "42, 43"},
+		{"", "public", map[string]interface{}{"ZONE": "dmz"}, 0, "Could not find"},
+	}
+
+	for _, c := range cases {
+		vn, err := dataVnetLookup(client, c.id, c.name, c.filter)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q/%q: Expected an error with %q, got %v", c.id, c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil || vn.Id != c.expected || vn.Template == nil {
+			t.Errorf("%q/%q: Expected vnet %d, got %v (err: %v)", c.id, c.name, c.expected, vn, err)
+		}
+	}
+}
+
+func TestFlattenVnetARs(t *testing.T) {
+	ars := []VnetAR{
+		{Id: 0, Type: "IP4", IP: "10.0.0.1", MAC: "02:00:0a:00:00:01", Size: 10, UsedLeases: 3},
+		{Id: 1, Type: "ETHER", MAC: "02:00:00:00:01:00", Size: 5},
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{"ar_id": 0, "type": "IP4", "ip": "10.0.0.1", "mac": "02:00:0a:00:00:01", "size": 10, "used_leases": 3},
+		map[string]interface{}{"ar_id": 1, "type": "ETHER", "ip": "", "mac": "02:00:00:00:01:00", "size": 5, "used_leases": 0},
+	}
+	if flat := flattenVnetARs(ars); !reflect.DeepEqual(flat, expected) {
+		t.Fatalf("Expected %v, got %v", expected, flat)
+	}
+}
+
+var testAccDataVnetConfig = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-data-vnet"
+  bridge = "br-test"
+  ip_start = "192.168.7.1"
+  ip_size = 10
+  gateway = "192.168.7.254"
+  custom_attributes = {
+    TF_ACC_TEST = "data-vnet"
+  }
+}
+
+data "opennebula_vnet" "by_name" {
+  name = "${opennebula_vnet.test.name}"
+}
+
+data "opennebula_vnet" "by_id" {
+  id = "${opennebula_vnet.test.id}"
+}
+
+data "opennebula_vnet" "by_filter" {
+  filter = {
+    TF_ACC_TEST = "${opennebula_vnet.test.custom_attributes["TF_ACC_TEST"]}"
+  }
+}
+`
@@ -0,0 +1,244 @@
+package opennebula
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Decode unmarshals an XML response from OpenNebula into v. When the
+// provider is configured with strict_decoding, the response is also walked
+// against the element inventory of v and every element the structs do not
+// know about is logged, grouped per object type. Unknown elements are never
+// an error: they only help spotting fields added or renamed by newer
+// OpenNebula versions that would otherwise be silently dropped.
+func (c *Client) Decode(resp string, v interface{}) error {
+	if err := xml.Unmarshal([]byte(resp), v); err != nil {
+		return err
+	}
+
+	if !c.StrictDecoding {
+		return nil
+	}
+
+	unknown, err := unknownElements(resp, reflect.TypeOf(v))
+	if err != nil {
+		log.Printf("[WARN] Could not check the XML response for unknown fields: %s", err)
+		return nil
+	}
+
+	types := make([]string, 0, len(unknown))
+	for t := range unknown {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	for _, t := range types {
+		log.Printf("[WARN] Unknown fields in %s: %s", t, strings.Join(unknown[t], ", "))
+	}
+
+	return nil
+}
+
+// xmlElement is a bare element tree of an XML document, only keeping the
+// element names.
+type xmlElement struct {
+	Name     string
+	Children []*xmlElement
+}
+
+func parseElements(data string) (*xmlElement, error) {
+	root := &xmlElement{}
+	stack := []*xmlElement{root}
+
+	dec := xml.NewDecoder(bytes.NewBufferString(data))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			e := &xmlElement{Name: t.Name.Local}
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, e)
+			stack = append(stack, e)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if len(root.Children) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return root.Children[0], nil
+}
+
+// xmlField is an entry of a struct element inventory: the path of element
+// names a field is read from (several names for "A>B" tags) and its type.
+type xmlField struct {
+	Path []string
+	Type reflect.Type
+}
+
+// xmlInventory lists the child elements a struct type decodes. Open
+// inventories accept any child, for structs capturing raw XML.
+type xmlInventory struct {
+	Fields []xmlField
+	Open   bool
+}
+
+var (
+	xmlInventories     = map[reflect.Type]*xmlInventory{}
+	xmlInventoriesLock sync.Mutex
+)
+
+var xmlUnmarshalerType = reflect.TypeOf((*xml.Unmarshaler)(nil)).Elem()
+
+// elementType strips pointers and slices off t, leaving the type a single
+// XML element is decoded into.
+func elementType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr || (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) {
+		t = t.Elem()
+	}
+	return t
+}
+
+// inventory returns the element inventory of the struct type t, building
+// and caching it on first use.
+func inventory(t reflect.Type) *xmlInventory {
+	xmlInventoriesLock.Lock()
+	defer xmlInventoriesLock.Unlock()
+
+	return buildInventory(t)
+}
+
+func buildInventory(t reflect.Type) *xmlInventory {
+	if inv, ok := xmlInventories[t]; ok {
+		return inv
+	}
+
+	inv := &xmlInventory{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name == "XMLName" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+
+		tag := f.Tag.Get("xml")
+		if tag == "-" {
+			continue
+		}
+
+		parts := strings.Split(tag, ",")
+		name, flags := parts[0], parts[1:]
+
+		if in_array("innerxml", flags) || in_array("any", flags) {
+			inv.Open = true
+			continue
+		}
+		if in_array("attr", flags) || in_array("chardata", flags) || in_array("cdata", flags) || in_array("comment", flags) {
+			continue
+		}
+
+		if f.Anonymous && name == "" && elementType(f.Type).Kind() == reflect.Struct {
+			embedded := buildInventory(elementType(f.Type))
+			inv.Fields = append(inv.Fields, embedded.Fields...)
+			inv.Open = inv.Open || embedded.Open
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		inv.Fields = append(inv.Fields, xmlField{Path: strings.Split(name, ">"), Type: f.Type})
+	}
+
+	xmlInventories[t] = inv
+	return inv
+}
+
+// unknownElements decodes data as an element tree and returns, per struct
+// type name, the paths of the elements t has no field for.
+func unknownElements(data string, t reflect.Type) (map[string][]string, error) {
+	root, err := parseElements(data)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]map[string]bool{}
+	checkElement(root, elementType(t), "", found)
+
+	unknown := map[string][]string{}
+	for name, paths := range found {
+		for p := range paths {
+			unknown[name] = append(unknown[name], p)
+		}
+		sort.Strings(unknown[name])
+	}
+
+	return unknown, nil
+}
+
+// checkElement walks the children of e, decoded into a value of type t,
+// and records the ones t does not declare under the name of t.
+func checkElement(e *xmlElement, t reflect.Type, path string, found map[string]map[string]bool) {
+	if t.Kind() != reflect.Struct || reflect.PtrTo(t).Implements(xmlUnmarshalerType) {
+		return
+	}
+
+	inv := inventory(t)
+	if inv.Open {
+		return
+	}
+
+	checkChildren(e, t.Name(), inv.Fields, path, found)
+}
+
+func checkChildren(e *xmlElement, owner string, fields []xmlField, path string, found map[string]map[string]bool) {
+	for _, child := range e.Children {
+		var nested []xmlField
+		known := false
+
+		for _, f := range fields {
+			if f.Path[0] != child.Name {
+				continue
+			}
+
+			known = true
+			if len(f.Path) == 1 {
+				checkElement(child, elementType(f.Type), "", found)
+			} else {
+				nested = append(nested, xmlField{Path: f.Path[1:], Type: f.Type})
+			}
+		}
+
+		childPath := child.Name
+		if path != "" {
+			childPath = path + ">" + child.Name
+		}
+
+		if !known {
+			if found[owner] == nil {
+				found[owner] = map[string]bool{}
+			}
+			found[owner][childPath] = true
+			continue
+		}
+
+		if len(nested) > 0 {
+			checkChildren(child, owner, nested, childPath, found)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package opennebula
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnknownElements(t *testing.T) {
+	unknown, err := unknownElements(testDecodeVmInfo, reflect.TypeOf(&UserVm{}))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string][]string{
+		"UserVm":            {"LOCK"},
+		"VmTemplate":        {"AUTOMATIC_REQUIREMENTS"},
+		"VirtualMachineNIC": {"VN_MAD"},
+	}
+	if !reflect.DeepEqual(unknown, expected) {
+		t.Fatalf("Expected unknown fields %v, got %v", expected, unknown)
+	}
+}
+
+func TestUnknownElementsPaths(t *testing.T) {
+	unknown, err := unknownElements(testDecodeSecurityGroupInfo, reflect.TypeOf(&SecurityGroup{}))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string][]string{
+		"SecurityGroup": {"UPDATED_VMS>VM"},
+	}
+	if !reflect.DeepEqual(unknown, expected) {
+		t.Fatalf("Expected unknown fields %v, got %v", expected, unknown)
+	}
+}
+
+func TestClientDecode(t *testing.T) {
+	client, _ := testClient(nil)
+	client.StrictDecoding = true
+
+	var vm UserVm
+	if err := client.Decode(testDecodeVmInfo, &vm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if vm.Name != "test-vm" || len(vm.VmTemplate.NICs) != 1 || vm.VmTemplate.ContextVars["NETWORK"] != "YES" {
+		t.Fatalf("Unexpected VM decoded: %+v", vm)
+	}
+
+	if err := client.Decode("<VM><ID>", &vm); err == nil {
+		t.Fatalf("Expected an error on invalid XML")
+	}
+}
+
+var testDecodeVmInfo = `
+<VM>
+  <ID>42</ID>
+  <UID>0</UID>
+  <GID>0</GID>
+  <UNAME>oneadmin</UNAME>
+  <GNAME>oneadmin</GNAME>
+  <NAME>test-vm</NAME>
+  <STATE>3</STATE>
+  <LCM_STATE>3</LCM_STATE>
+  <LOCK>
+    <LOCKED>1</LOCKED>
+  </LOCK>
+  <TEMPLATE>
+    <CPU><![CDATA[1]]></CPU>
+    <MEMORY><![CDATA[128]]></MEMORY>
+    <CONTEXT>
+      <NETWORK><![CDATA[YES]]></NETWORK>
+      <SSH_PUBLIC_KEY><![CDATA[]]></SSH_PUBLIC_KEY>
+    </CONTEXT>
+    <NIC>
+      <IP><![CDATA[10.0.0.5]]></IP>
+      <NETWORK_ID><![CDATA[3]]></NETWORK_ID>
+      <NIC_ID><![CDATA[0]]></NIC_ID>
+      <VN_MAD><![CDATA[bridge]]></VN_MAD>
+    </NIC>
+    <AUTOMATIC_REQUIREMENTS><![CDATA[!(PUBLIC_CLOUD = YES)]]></AUTOMATIC_REQUIREMENTS>
+  </TEMPLATE>
+  <USER_TEMPLATE>
+    <READY><![CDATA[YES]]></READY>
+  </USER_TEMPLATE>
+</VM>
+`
+
+var testDecodeSecurityGroupInfo = `
+<SECURITY_GROUP>
+  <ID>100</ID>
+  <NAME>test-secgroup</NAME>
+  <UPDATED_VMS>
+    <ID>10</ID>
+    <VM>11</VM>
+  </UPDATED_VMS>
+  <TEMPLATE>
+    <DESCRIPTION><![CDATA[Test group]]></DESCRIPTION>
+  </TEMPLATE>
+</SECURITY_GROUP>
+`
@@ -0,0 +1,120 @@
+package opennebula
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MarketApp is the part of a marketplace app images are exported from.
+type MarketApp struct {
+	Id            int    `xml:"ID"`
+	Name          string `xml:"NAME"`
+	Type          int    `xml:"TYPE"`
+	State         int    `xml:"STATE"`
+	MarketplaceId int    `xml:"MARKETPLACE_ID"`
+	Marketplace   string `xml:"MARKETPLACE"`
+	// AppTemplate64 is the base64 encoded template of the images exported
+	// from the app
+	AppTemplate64 string `xml:"TEMPLATE>APPTEMPLATE64"`
+}
+
+type MarketApps struct {
+	MarketApp []*MarketApp `xml:"MARKETPLACEAPP"`
+}
+
+// TYPE and STATE of the marketplace apps images can be exported from.
+const (
+	marketAppImage = 1
+	marketAppReady = 1
+)
+
+// marketAppLookup returns the marketplace app with the given ID, or else,
+// if id is negative, the one with the given name. Apps of the same name in
+// several marketplaces are an error rather than a guess.
+func marketAppLookup(client *Client, id int, name string) (*MarketApp, error) {
+	var app *MarketApp
+	var apps *MarketApps
+
+	if id < 0 {
+		resp, err := client.MarketAppPoolInfo(-2, -1, -1)
+		if err != nil {
+			return nil, err
+		}
+		if err = client.Decode(resp, &apps); err != nil {
+			return nil, err
+		}
+
+		var found []*MarketApp
+		for _, a := range apps.MarketApp {
+			if a.Name == name {
+				found = append(found, a)
+			}
+		}
+
+		switch len(found) {
+		case 0:
+			return nil, fmt.Errorf("Could not find a marketplace app named %q", name)
+		case 1:
+			id = found[0].Id
+		default:
+			ids := make([]string, 0, len(found))
+			for _, a := range found {
+				ids = append(ids, fmt.Sprintf("%d (%s)", a.Id, a.Marketplace))
+			}
+			return nil, fmt.Errorf("Marketplace apps %s are named %q, set marketplace_app_id instead", strings.Join(ids, ", "), name)
+		}
+	}
+
+	resp, err := client.MarketAppInfo(id)
+	if err != nil {
+		return nil, fmt.Errorf("Could not find marketplace app %d: %s", id, err)
+	}
+	if err = client.Decode(resp, &app); err != nil {
+		return nil, err
+	}
+
+	if app.Type != marketAppImage {
+		return nil, fmt.Errorf("Marketplace app %d (%s) is not an image", app.Id, app.Name)
+	}
+	if app.State != marketAppReady {
+		return nil, fmt.Errorf("Marketplace app %d (%s) is not READY", app.Id, app.Name)
+	}
+
+	return app, nil
+}
+
+// marketAppImageTemplate returns the template allocating the image name
+// from the app, as onemarketapp export does: FROM_APP makes OpenNebula
+// download the app into the datastore. The attributes of set override
+// those of the template of the app.
+func marketAppImageTemplate(app *MarketApp, name string, set map[string]string) (string, error) {
+	apptmpl, err := base64.StdEncoding.DecodeString(app.AppTemplate64)
+	if err != nil {
+		return "", fmt.Errorf("Could not decode the template of marketplace app %d: %s", app.Id, err)
+	}
+
+	tmpl := []string{
+		fmt.Sprintf("NAME=\"%s\"", name),
+		fmt.Sprintf("FROM_APP=\"%d\"", app.Id),
+	}
+	for _, line := range strings.Split(string(apptmpl), "\n") {
+		key := strings.ToUpper(strings.TrimSpace(strings.SplitN(line, "=", 2)[0]))
+		if _, ok := set[key]; ok || key == "" || key == "NAME" || key == "FROM_APP" {
+			continue
+		}
+		tmpl = append(tmpl, strings.TrimSpace(line))
+	}
+
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		tmpl = append(tmpl, fmt.Sprintf("%s=\"%s\"", key, set[key]))
+	}
+
+	return strings.Join(tmpl, "\n"), nil
+}
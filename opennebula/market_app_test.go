@@ -0,0 +1,77 @@
+package opennebula
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMarketAppLookup(t *testing.T) {
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.marketapp.info":
+			switch args[0].(int) {
+			case 40:
+				return []interface{}{true, "<MARKETPLACEAPP><ID>40</ID><NAME>service</NAME><TYPE>3</TYPE><STATE>1</STATE></MARKETPLACEAPP>", int64(0)}, nil
+			case 44:
+				return []interface{}{true, "<MARKETPLACEAPP><ID>44</ID><NAME>locked</NAME><TYPE>1</TYPE><STATE>2</STATE></MARKETPLACEAPP>", int64(0)}, nil
+			}
+			return []interface{}{true, fmt.Sprintf("<MARKETPLACEAPP><ID>%d</ID><NAME>app</NAME><TYPE>1</TYPE><STATE>1</STATE></MARKETPLACEAPP>", args[0].(int)), int64(0)}, nil
+		case "one.marketapppool.info":
+			return []interface{}{true, `<MARKETPLACEAPP_POOL>
+  <MARKETPLACEAPP><ID>41</ID><NAME>Ubuntu 22.04</NAME><MARKETPLACE>OpenNebula Public</MARKETPLACE></MARKETPLACEAPP>
+  <MARKETPLACEAPP><ID>42</ID><NAME>Debian 11</NAME><MARKETPLACE>OpenNebula Public</MARKETPLACE></MARKETPLACEAPP>
+  <MARKETPLACEAPP><ID>43</ID><NAME>Debian 11</NAME><MARKETPLACE>Private</MARKETPLACE></MARKETPLACEAPP>
+</MARKETPLACEAPP_POOL>`, int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	cases := []struct {
+		id       int
+		name     string
+		expected int
+		err      string
+	}{
+		{39, "", 39, ""},
+		{-1, "Ubuntu 22.04", 41, ""},
+		{-1, "Debian 11", 0, "42 (OpenNebula Public), 43 (Private)"},
+		{-1, "CentOS 7", 0, "Could not find"},
+		{40, "", 0, "not an image"},
+		{44, "", 0, "not READY"},
+	}
+
+	for _, c := range cases {
+		app, err := marketAppLookup(client, c.id, c.name)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%d/%q: Expected an error with %q, got %v", c.id, c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil || app.Id != c.expected {
+			t.Errorf("%d/%q: Expected app %d, got %v (err: %v)", c.id, c.name, c.expected, app, err)
+		}
+	}
+}
+
+func TestMarketAppImageTemplate(t *testing.T) {
+	app := &MarketApp{
+		Id:            41,
+		AppTemplate64: base64.StdEncoding.EncodeToString([]byte("DEV_PREFIX=\"vd\"\nDRIVER = \"qcow2\"\nTYPE=\"OS\"\n")),
+	}
+
+	tmpl, err := marketAppImageTemplate(app, "ubuntu", map[string]string{"DRIVER": "raw", "PERSISTENT": "YES"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	expected := "NAME=\"ubuntu\"\nFROM_APP=\"41\"\nDEV_PREFIX=\"vd\"\nTYPE=\"OS\"\nDRIVER=\"raw\"\nPERSISTENT=\"YES\""
+	if tmpl != expected {
+		t.Fatalf("Expected the template\n%s\ngot\n%s", expected, tmpl)
+	}
+
+	if _, err = marketAppImageTemplate(&MarketApp{Id: 42, AppTemplate64: "not base64"}, "debian", nil); err == nil {
+		t.Fatalf("Expected an invalid app template to be rejected")
+	}
+}
@@ -1,8 +1,11 @@
 package opennebula
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
 )
 
 type Permissions struct {
@@ -17,18 +20,37 @@ type Permissions struct {
 	Other_A int `xml:"OTHER_A"`
 }
 
+// permissionString renders permissions in Unix format, e.g. "640", keeping
+// the leading zeros of "006" or "000".
 func permissionString(p *Permissions) string {
+	if p == nil {
+		return ""
+	}
 	owner := p.Owner_U<<2 | p.Owner_M<<1 | p.Owner_A
 	group := p.Group_U<<2 | p.Group_M<<1 | p.Group_A
 	other := p.Other_U<<2 | p.Other_M<<1 | p.Other_A
-	return strconv.Itoa(owner*100 + group*10 + other)
+	return fmt.Sprintf("%d%d%d", owner, group, other)
 }
 
+// permission parses permissions in Unix format, or returns nil for anything
+// else than 3 octal digits.
 func permission(p string) *Permissions {
 	perms := strings.Split(p, "")
-	owner, _ := strconv.Atoi(perms[0])
-	group, _ := strconv.Atoi(perms[1])
-	other, _ := strconv.Atoi(perms[2])
+	if len(perms) != 3 {
+		return nil
+	}
+	owner, err := strconv.Atoi(perms[0])
+	if err != nil {
+		return nil
+	}
+	group, err := strconv.Atoi(perms[1])
+	if err != nil {
+		return nil
+	}
+	other, err := strconv.Atoi(perms[2])
+	if err != nil {
+		return nil
+	}
 
 	return &Permissions{
 		Owner_U: owner & 4 >> 2,
@@ -43,9 +65,40 @@ func permission(p string) *Permissions {
 	}
 }
 
-func changePermissions(id int, p *Permissions, client *Client, call string) (string, error) {
-  return client.Call(
-    call,
+// validatePermissions checks permissions are 3 octal digits in Unix format,
+// owner-group-other, each one use-manage-admin.
+func validatePermissions(v interface{}, k string) (ws []string, errors []error) {
+	value := v.(string)
+
+	if len(value) != 3 {
+		errors = append(errors, fmt.Errorf("%q has to specify 3 permission sets: owner-group-other, got %q", k, value))
+	}
+
+	for _, c := range value {
+		if c < '0' || c > '7' {
+			errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7, got %q", k, value))
+			break
+		}
+	}
+
+	return
+}
+
+// changedPermissions returns the permissions to change an object to on
+// update, or nil when they didn't change. permissions is computed, so an
+// object whose permissions are removed from the configuration keeps them
+// without showing a change.
+func changedPermissions(d *schema.ResourceData) *Permissions {
+	if !d.HasChange("permissions") {
+		return nil
+	}
+	return permission(d.Get("permissions").(string))
+}
+
+// changePermissions sends the chmod call of an object. Only templates take
+// an extra argument, to also change the permissions of their images.
+func changePermissions(id int, p *Permissions, client *Client, call string, extra ...interface{}) (string, error) {
+  args := []interface{}{
     id,
     p.Owner_U,
     p.Owner_M,
@@ -56,7 +109,7 @@ func changePermissions(id int, p *Permissions, client *Client, call string) (str
     p.Other_U,
     p.Other_M,
     p.Other_A,
-    false, // recursive (do not change the associated images' permissions)
-  )
+  }
+  return client.Call(call, append(args, extra...)...)
 }
 
@@ -0,0 +1,49 @@
+package opennebula
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPermissionRoundTrip(t *testing.T) {
+	for _, p := range []string{"000", "006", "044", "600", "640", "777"} {
+		perms := permission(p)
+		if perms == nil {
+			t.Fatalf("Expected %q to parse", p)
+		}
+		if s := permissionString(perms); s != p {
+			t.Errorf("Expected %q to render back as itself, got %q", p, s)
+		}
+	}
+
+	expected := &Permissions{Owner_U: 1, Owner_M: 1, Group_U: 1, Other_A: 1}
+	if perms := permission("641"); !reflect.DeepEqual(perms, expected) {
+		t.Fatalf("Expected 641 to be %+v, got %+v", expected, perms)
+	}
+}
+
+func TestPermissionNil(t *testing.T) {
+	if s := permissionString(nil); s != "" {
+		t.Fatalf("Expected no permissions to render as an empty string, got %q", s)
+	}
+
+	for _, p := range []string{"", "6", "60", "6400", "6a0"} {
+		if perms := permission(p); perms != nil {
+			t.Errorf("Expected %q not to parse, got %+v", p, perms)
+		}
+	}
+}
+
+func TestValidatePermissions(t *testing.T) {
+	for _, p := range []string{"000", "640", "777"} {
+		if _, errs := validatePermissions(p, "permissions"); len(errs) > 0 {
+			t.Errorf("Expected %q to be valid, got: %v", p, errs)
+		}
+	}
+
+	for _, p := range []string{"", "77", "7777", "77a", "680", "-10"} {
+		if _, errs := validatePermissions(p, "permissions"); len(errs) == 0 {
+			t.Errorf("Expected %q to be rejected", p)
+		}
+	}
+}
@@ -26,14 +26,35 @@ func Provider() terraform.ResourceProvider {
 				Description: "The password for the user",
 				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_PASSWORD", nil),
 			},
+			"strict_decoding": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Log a warning for each field of OpenNebula responses unknown to the provider",
+				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_STRICT_DECODING", false),
+			},
+			"default_nic_model": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Model of the VM NICs declared without one, e.g. virtio",
+				DefaultFunc: schema.EnvDefaultFunc("OPENNEBULA_DEFAULT_NIC_MODEL", ""),
+			},
+			"skip_nic_model_validation": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Accept NIC models unknown to KVM, for exotic drivers",
+			},
 		},
 
 		DataSourcesMap: map[string]*schema.Resource{
 			"opennebula_image": dataImage(),
+			"opennebula_images": dataImages(),
 			"opennebula_vnet":  dataVnet(),
 			"opennebula_secgroup": dataSecurityGroup(),
 			"opennebula_user": dataUser(),
 			"opennebula_group": dataGroup(),
+			"opennebula_vm": dataVm(),
+			"opennebula_vms": dataVms(),
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
@@ -42,6 +63,8 @@ func Provider() terraform.ResourceProvider {
 			"opennebula_vm":       resourceVm(),
 			"opennebula_image":    resourceImage(),
 			"opennebula_secgroup": resourceSecurityGroup(),
+			"opennebula_vm_snapshot": resourceVmSnapshot(),
+			"opennebula_vm_disk_snapshot": resourceVmDiskSnapshot(),
 		},
 
 		ConfigureFunc: providerConfigure,
@@ -49,9 +72,24 @@ func Provider() terraform.ResourceProvider {
 }
 
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
-	return NewClient(
+	client, err := NewClient(
 		d.Get("endpoint").(string),
 		d.Get("username").(string),
 		d.Get("password").(string),
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	client.StrictDecoding = d.Get("strict_decoding").(bool)
+	client.DefaultNicModel = d.Get("default_nic_model").(string)
+	client.SkipNicModelValidation = d.Get("skip_nic_model_validation").(bool)
+	if !client.SkipNicModelValidation {
+		if err := validateNicModel(client.DefaultNicModel); err != nil {
+			return nil, err
+		}
+	}
+	client.detectVersion()
+
+	return client, nil
 }
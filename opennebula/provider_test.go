@@ -1,12 +1,23 @@
 package opennebula
 
 import (
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
 	"os"
+	"strconv"
 	"testing"
 )
 
+// Objects created by acceptance tests are named with this prefix so the
+// sweepers can find and remove the ones leaked by failed runs.
+const testAccPrefix = "tf-acc-test-"
+
+func TestMain(m *testing.M) {
+	resource.TestMain(m)
+}
+
 func TestProvider(t *testing.T) {
 	if err := Provider().(*schema.Provider).InternalValidate(); err != nil {
 		t.Fatalf("err: %s", err)
@@ -38,3 +49,59 @@ func testEnvIsSet(k string, t *testing.T) {
 		t.Fatalf("%s must be set for acceptance tests", k)
 	}
 }
+
+// testAccEnvInt returns the integer stored in the environment variable k,
+// failing the test when it is missing or not a number. Configurations are
+// built before resource.Test skips acceptance tests, so nothing is required
+// unless TF_ACC is set.
+func testAccEnvInt(k string, t *testing.T) int {
+	if os.Getenv("TF_ACC") == "" {
+		return 0
+	}
+	testEnvIsSet(k, t)
+
+	v, err := strconv.Atoi(os.Getenv(k))
+	if err != nil {
+		t.Fatalf("%s must be an integer, got %q", k, os.Getenv(k))
+	}
+
+	return v
+}
+
+// testAccDatastoreID is the image datastore acceptance tests store images in.
+func testAccDatastoreID(t *testing.T) int {
+	return testAccEnvInt("OPENNEBULA_DATASTORE_ID", t)
+}
+
+// testAccFilesDatastoreID is the files datastore acceptance tests store
+// CONTEXT images in.
+func testAccFilesDatastoreID(t *testing.T) int {
+	return testAccEnvInt("OPENNEBULA_FILES_DATASTORE_ID", t)
+}
+
+// testAccVnetID is the existing vnet acceptance tests attach VMs to.
+func testAccVnetID(t *testing.T) int {
+	return testAccEnvInt("OPENNEBULA_VNET_ID", t)
+}
+
+// testAccVmGroupID is the existing VM group, with a tf-acc-test role,
+// acceptance tests add VMs to.
+func testAccVmGroupID(t *testing.T) int {
+	return testAccEnvInt("OPENNEBULA_VMGROUP_ID", t)
+}
+
+// sharedClient builds a client from the environment for use by sweepers,
+// which run outside of a configured provider.
+func sharedClient() (*Client, error) {
+	for _, k := range []string{"OPENNEBULA_ENDPOINT", "OPENNEBULA_USERNAME", "OPENNEBULA_PASSWORD"} {
+		if os.Getenv(k) == "" {
+			return nil, fmt.Errorf("%s must be set for sweepers", k)
+		}
+	}
+
+	return NewClient(
+		os.Getenv("OPENNEBULA_ENDPOINT"),
+		os.Getenv("OPENNEBULA_USERNAME"),
+		os.Getenv("OPENNEBULA_PASSWORD"),
+	)
+}
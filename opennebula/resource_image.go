@@ -7,6 +7,7 @@ import (
 	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -37,22 +38,55 @@ type Image struct {
 	Target		string			`xml:"TARGET,omitempty"`  //For image creation
 	Driver		string			`xml:"DRIVER,omitempty"` //For image creation
 	Format		string			`xml:"FORMAT,omitempty"` //For image creation
+	Fs			string			`xml:"FS,omitempty"` //For image creation
 	MD5			string			`xml:"MD5,omitempty"` //For image creation
 	SHA1		string			`xml:"SHA1,omitempty"`	 //For image creation
 	Template	*ImageTemplate	`xml:"TEMPLATE,omitempty"`
+	RunningVms	int				`xml:"RUNNING_VMS,omitempty"`
+	Lock		int				`xml:"LOCK>LOCKED,omitempty"`
+	Vms			[]int			`xml:"VMS>ID,omitempty"`
+	Custom		[]vmTemplateAttribute	`xml:",any"` //For image creation
 }
 
 type Images struct {
 	Image		[]*Image `xml:"IMAGE"`
 }
 
+// Datastore is the part of a datastore definition the provider checks
+// images against.
+type Datastore struct {
+	Id			int				`xml:"ID"`
+	Name		string			`xml:"NAME"`
+	Type		int				`xml:"TYPE"`
+}
+
+// imageFileTypes are the types of images holding a file handed to VMs
+// rather than a disk. They live in a files datastore (FILE_DS).
+var imageFileTypes = []string{"KERNEL", "RAMDISK", "CONTEXT"}
+
+// diskImageArguments only apply to disk images.
+var diskImageArguments = []string{"size", "dev_prefix", "driver", "target", "format"}
+
 type ImageTemplate struct {
 	DevPrefix	string		`xml:"DEV_PREFIX,omitempty"`
 	Driver		string	   `xml:"DRIVER,omitempty"`
 	Format		string	   `xml:"FORMAT,omitempty"`
 	MD5			string	   `xml:"MD5,omitempty"`
-	SHA1		string	   `xml:"SHA1.omitempty"`
+	SHA1		string	   `xml:"SHA1,omitempty"`
+	Error		string	   `xml:"ERROR,omitempty"`
+	Custom		[]vmTemplateAttribute	`xml:",any"`
+}
 
+// Get returns the value of the custom attribute key of the template.
+func (t *ImageTemplate) Get(key string) string {
+	return (&VmUserTemplate{Attributes: t.Custom}).Get(key)
+}
+
+// imageTemplateAttributes are the attributes of the image template set by
+// the fixed arguments, which take precedence over template_attributes.
+var imageTemplateAttributes = []string{
+	"NAME", "DESCRIPTION", "TYPE", "PERSISTENT", "SIZE", "PATH", "SOURCE", "DEV_PREFIX",
+	"TARGET", "DRIVER", "FORMAT", "FSTYPE", "DISK_TYPE", "MD5", "SHA1",
 }
 
 func resourceImage() *schema.Resource {
@@ -62,9 +96,14 @@ func resourceImage() *schema.Resource {
 		Exists: resourceImageExists,
 		Update: resourceImageUpdate,
 		Delete: resourceImageDelete,
+		CustomizeDiff: resourceImageCustomizeDiff,
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(imageTimeout),
+			Delete: schema.DefaultTimeout(imageTimeout),
+		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -82,35 +121,20 @@ func resourceImage() *schema.Resource {
 				Optional:		true,
 				Computed:		true,
 				Description:	"Permissions for the Image (in Unix format, owner-group-other, use-manage-admin)",
-				ValidateFunc: 	func(v interface{}, k string) (ws []string, errors []error) {
-					value := v.(string)
-
-					if len(value) != 3 {
-						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
-					}
-
-					all := true
-					for _, c := range strings.Split(value, "") {
-						if c < "0" || c > "7" {
-							all = false
-						}
-					}
-					if !all {
-						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
-					}
-
-					return
-				},
+				ValidateFunc: 	validatePermissions,
 			},
 
 			"uid": {
 				Type:			schema.TypeInt,
+				Optional:		true,
 				Computed:		true,
 				Description:	"ID of the user that will own the Image",
 			},
 			"gid": {
 				Type:			schema.TypeInt,
+				Optional:		true,
 				Computed:		true,
+				ConflictsWith:	[]string{"group"},
 				Description:	"ID of the group that will own the Image",
 			},
 			"uname": {
@@ -123,6 +147,12 @@ func resourceImage() *schema.Resource {
 				Computed:		true,
 				Description:	"Name of the group that will own the Image",
 			},
+			"group": {
+				Type:			schema.TypeString,
+				Optional:		true,
+				ConflictsWith:	[]string{"gid"},
+				Description:	"Name of the group that will own the Image",
+			},
 			"clone_from_image": {
 				Type:			schema.TypeString,
 				Optional:		true,
@@ -140,8 +170,7 @@ func resourceImage() *schema.Resource {
 				Type:			schema.TypeBool,
 				Optional:		true,
 				Default:		false,
-				ForceNew:		true,
-				Description:	"Flag which indicates if the Image has to be persistent",
+				Description:	"Flag which indicates if the Image has to be persistent, which can only change while no VM uses it",
 			},
 			"path": {
 				Type:			schema.TypeString,
@@ -149,7 +178,22 @@ func resourceImage() *schema.Resource {
 				Computed:		true,
 				ForceNew:		true,
 				Description:	"Path to the new image (local path on the OpenNebula server or URL)",
-				ConflictsWith:	[]string{"clone_from_image"},
+				ConflictsWith:	[]string{"clone_from_image", "marketplace_app_id", "marketplace_app_name"},
+			},
+			"marketplace_app_id": {
+				Type:			schema.TypeInt,
+				Optional:		true,
+				Computed:		true,
+				ForceNew:		true,
+				Description:	"ID of the marketplace app the image is exported from",
+				ConflictsWith:	[]string{"clone_from_image", "marketplace_app_name", "size"},
+			},
+			"marketplace_app_name": {
+				Type:			schema.TypeString,
+				Optional:		true,
+				ForceNew:		true,
+				Description:	"Name of the marketplace app the image is exported from, which must be unique among the marketplaces",
+				ConflictsWith:	[]string{"clone_from_image", "marketplace_app_id", "size"},
 			},
 			"type": {
 				Type:			schema.TypeString,
@@ -173,7 +217,7 @@ func resourceImage() *schema.Resource {
 				ForceNew:		true,
 				Optional:		true,
 				Computed:		true,
-				Description:	"Size of the new image in MB",
+				Description:	"Size of the new image in MB. Images are not resized: OpenNebula has no call for it, so a larger size replaces the image with a new empty one. Smaller sizes, persistent images and images in use are refused at plan time",
 			},
 			"dev_prefix": {
 				Type:			schema.TypeString,
@@ -189,19 +233,117 @@ func resourceImage() *schema.Resource {
 				Computed:		true,
 				Description:	"Driver to use, normally 'raw' or 'qcow2'",
 			},
+			"enabled": {
+				Type:			schema.TypeBool,
+				Optional:		true,
+				Default:		true,
+				Description:	"Whether new VMs can use the image, disable it to retire it without deleting it",
+			},
+			"lock": {
+				Type:			schema.TypeString,
+				Optional:		true,
+				Description:	"Lock level of the image: use, manage, admin or all. Locked images can't be deleted by mistake, the lock is lifted on destroy",
+				ValidateFunc: func (v interface{}, k string) (ws []string, errors []error) {
+					if _, ok := imageLockLevel(v.(string)); !ok || v.(string) == "" {
+						errors = append(errors, fmt.Errorf("%q must be one of: %s", k, strings.Join(imageLockLevels[1:], ",")))
+					}
+					return
+				},
+			},
+			"template_attributes": {
+				Type:			schema.TypeMap,
+				Optional:		true,
+				Description:	"Custom attributes of the image template, e.g. OS_VERSION = \"10\". The ones set by other arguments are ignored",
+				ValidateFunc:	validateCustomAttributes(imageTemplateAttributes),
+			},
+			"rollback_on_failure": {
+				Type:			schema.TypeBool,
+				Optional:		true,
+				Default:		true,
+				Description:	"Delete the Image when its configuration fails after it was created or cloned, instead of leaving it tainted",
+			},
+			"wait_for_unused": {
+				Type:			schema.TypeBool,
+				Optional:		true,
+				Default:		false,
+				Description:	"Wait, up to the delete timeout, for the VMs using the image to release it on delete instead of failing",
+			},
+			"format": {
+				Type:			schema.TypeString,
+				ForceNew:		true,
+				Optional:		true,
+				Description:	"Format of the image file, e.g. raw or qcow2",
+			},
+			"fs": {
+				Type:			schema.TypeString,
+				ForceNew:		true,
+				Optional:		true,
+				Description:	"Filesystem an empty DATABLOCK is formatted with, e.g. ext4, along with format = \"raw\"",
+			},
+			"target": {
+				Type:			schema.TypeString,
+				ForceNew:		true,
+				Optional:		true,
+				Description:	"Device the image is mapped to in the VMs, e.g. vdb",
+			},
+			"md5": {
+				Type:			schema.TypeString,
+				ForceNew:		true,
+				Optional:		true,
+				Description:	"MD5 checksum the file at path must match, the image goes into ERROR otherwise",
+				ConflictsWith:	[]string{"clone_from_image"},
+			},
+			"sha1": {
+				Type:			schema.TypeString,
+				ForceNew:		true,
+				Optional:		true,
+				Description:	"SHA1 checksum the file at path must match, the image goes into ERROR otherwise",
+				ConflictsWith:	[]string{"clone_from_image"},
+			},
 		},
 	}
 }
 
 func resourceImageCreate(d *schema.ResourceData, meta interface{}) error {
+	err := createImage(d, meta)
+	if err != nil {
+		if d.Id() == "" || !d.Get("rollback_on_failure").(bool) {
+			return err
+		}
+		deleted, rollbackErr := rollbackImage(meta.(*Client), intId(d.Id()), err)
+		if deleted {
+			d.SetId("")
+		}
+		return rollbackErr
+	}
+
+	return resourceImageRead(d, meta)
+}
+
+// createImage allocates or clones the image and configures it. The image
+// is not read back, so that a failed configuration can be rolled back.
+func createImage(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
 
+	if imgtype, ok := d.GetOk("type"); ok && in_array(imgtype.(string), imageFileTypes) {
+		if err := checkFilesDatastore(client, d.Get("datastore_id").(int), imgtype.(string)); err != nil {
+			return err
+		}
+	}
+
 	// Check if Image ID for cloning is set
-	if len(d.Get("clone_from_image").(string)) > 0 {
-		return resourceImageClone(d, meta)
+	cloned := len(d.Get("clone_from_image").(string)) > 0
+	_, appID := d.GetOkExists("marketplace_app_id")
+	_, appName := d.GetOk("marketplace_app_name")
+	if cloned {
+		if err := resourceImageClone(d, meta); err != nil {
+			return err
+		}
+	} else if appID || appName {
+		if err := exportMarketApp(d, meta); err != nil {
+			return err
+		}
 	} else { //Otherwise allocate a new image
-		client := meta.(*Client)
-
 		var resp string
 		var err error
 
@@ -210,10 +352,9 @@ func resourceImageCreate(d *schema.ResourceData, meta interface{}) error {
 			return xmlerr
 		}
 
-		resp, err = client.Call(
-			"one.image.allocate",
+		resp, err = client.ImageAllocate(
 			imagexml,
-			d.Get("datastore_id"),
+			d.Get("datastore_id").(int),
 		)
 
 		if err != nil {
@@ -221,24 +362,51 @@ func resourceImageCreate(d *schema.ResourceData, meta interface{}) error {
 		}
 
 		d.SetId(resp)
-	}
 
-	_, err := waitForImageState(d, meta, "ready")
-	if err != nil {
-		return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s", d.Id(), err)
+		_, err = waitForImageState(d, meta, "ready", d.Timeout(schema.TimeoutCreate))
+		if err != nil {
+			return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s", d.Id(), err)
+		}
 	}
 
 	// update permisions
 	if _, ok := d.GetOk("permissions"); ok {
-		if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.image.chmod"); err != nil {
+		if _, err := client.ImageChmod(intId(d.Id()), permission(d.Get("permissions").(string))); err != nil {
 			return err
 		}
 	}
 
-	return resourceImageRead(d, meta)
+	if err := changeImageOwner(d, client, false); err != nil {
+		return err
+	}
+
+	// set persistency if needed, allocated images have it in their template
+	if cloned {
+		if err := setImagePersistent(client, intId(d.Id()), d.Get("persistent").(bool)); err != nil {
+			return err
+		}
+	}
+
+	if !d.Get("enabled").(bool) {
+		if _, err := client.ImageEnable(intId(d.Id()), false); err != nil {
+			return err
+		}
+	}
+
+	if lock, ok := d.GetOk("lock"); ok {
+		if err := lockImage(client, intId(d.Id()), lock.(string)); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
+// resourceImageClone clones the image and sets the template attributes
+// given for the clone once it is READY.
 func resourceImageClone(d *schema.ResourceData, meta interface{}) error {
+	var src *Image
+
 	client := meta.(*Client)
 	var imageId int
 
@@ -252,12 +420,26 @@ func resourceImageClone(d *schema.ResourceData, meta interface{}) error {
 		}
 	}
 
+	// OpenNebula has no call resizing images, the disks of the VMs using
+	// them are resized instead
+	if size, ok := d.GetOk("size"); ok {
+		resp, err := client.ImageInfo(imageId)
+		if err != nil {
+			return err
+		}
+		if err = client.Decode(resp, &src); err != nil {
+			return err
+		}
+		if size.(int) != src.Size {
+			return fmt.Errorf("Clones have the size of their image, %d MB for image %d. OpenNebula can't resize images: resize the disks of the VMs using the clone instead", src.Size, imageId)
+		}
+	}
+
 	// Clone Image from given ID
-	resp, err := client.Call(
-		"one.image.clone",
+	resp, err := client.ImageClone(
 		imageId,
-		d.Get("name"),
-		d.Get("datastore_id"),
+		d.Get("name").(string),
+		d.Get("datastore_id").(int),
 	)
 	if err != nil {
 		return err
@@ -265,48 +447,126 @@ func resourceImageClone(d *schema.ResourceData, meta interface{}) error {
 
 	d.SetId(resp)
 
-	_, err = waitForImageState(d, meta, "ready")
+	_, err = waitForImageState(d, meta, "ready", d.Timeout(schema.TimeoutCreate))
 	if err != nil {
 		return fmt.Errorf("Error waiting for Image (%s) to be in state READY: %s", d.Id(), err)
 	}
 
-	// update permisions
-	if _, ok := d.GetOk("permissions"); ok {
-		if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.image.chmod"); err != nil {
-			return err
+	if tmpl := cloneImageTemplate(d); tmpl != "" {
+		if _, err = client.ImageUpdate(intId(d.Id()), tmpl, 1); err != nil {
+			return fmt.Errorf("Error updating the template of Image %s cloned from image %d: %s", d.Id(), imageId, err)
 		}
 	}
 
-	// set persistency if needed
-	resp, err = client.Call(
-		"one.image.persistent",
-		intId(d.Id()),
-		d.Get("persistent"),
-	)
+	return nil
+}
+
+// exportMarketApp allocates the image from the marketplace app given by
+// marketplace_app_id or marketplace_app_name, and waits for OpenNebula to
+// download it.
+func exportMarketApp(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	id := -1
+	if v, ok := d.GetOkExists("marketplace_app_id"); ok {
+		id = v.(int)
+	}
+	app, err := marketAppLookup(client, id, d.Get("marketplace_app_name").(string))
 	if err != nil {
 		return err
 	}
 
-	return resourceImageRead(d, meta)
+	set := make(map[string]string)
+	for _, attr := range [][2]string{{"description", "DESCRIPTION"}, {"type", "TYPE"}, {"dev_prefix", "DEV_PREFIX"}, {"driver", "DRIVER"}, {"target", "TARGET"}} {
+		if v, ok := d.GetOk(attr[0]); ok {
+			set[attr[1]] = v.(string)
+		}
+	}
+	if d.Get("persistent").(bool) {
+		set["PERSISTENT"] = "YES"
+	}
+	if attrs, ok := d.GetOk("template_attributes"); ok {
+		for key, value := range attrs.(map[string]interface{}) {
+			if !in_array(key, imageTemplateAttributes) {
+				set[key] = value.(string)
+			}
+		}
+	}
+
+	tmpl, err := marketAppImageTemplate(app, d.Get("name").(string), set)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ImageAllocate(tmpl, d.Get("datastore_id").(int))
+	if err != nil {
+		return fmt.Errorf("Error exporting marketplace app %d (%s): %s", app.Id, app.Name, err)
+	}
+
+	d.SetId(resp)
+	d.Set("marketplace_app_id", app.Id)
+
+	_, err = waitForImageState(d, meta, "ready", d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		return fmt.Errorf("Error waiting for Image (%s) exported from marketplace app %d to be in state READY: %s", d.Id(), app.Id, err)
+	}
+
+	return nil
 }
 
-func waitForImageState(d *schema.ResourceData, meta interface{}, state string) (interface{}, error) {
+// cloneImageTemplate returns the template attributes given for a clone, to
+// be merged with those copied from its image.
+func cloneImageTemplate(d *schema.ResourceData) string {
+	var tmpl []string
+	for _, attr := range [][2]string{{"description", "DESCRIPTION"}, {"driver", "DRIVER"}, {"dev_prefix", "DEV_PREFIX"}} {
+		if v, ok := d.GetOk(attr[0]); ok {
+			tmpl = append(tmpl, fmt.Sprintf("%s=\"%s\"", attr[1], v.(string)))
+		}
+	}
+	if attrs, ok := d.GetOk("template_attributes"); ok {
+		if custom := customAttributes(nil, attrs.(map[string]interface{}), imageTemplateAttributes); custom != "" {
+			tmpl = append(tmpl, strings.TrimPrefix(custom, "\n"))
+		}
+	}
+	return strings.Join(tmpl, "\n")
+}
+
+// rollbackImage deletes the image id whose creation failed with cause, and
+// returns whether it was deleted along with the error to report.
+func rollbackImage(client *Client, id int, cause error) (bool, error) {
+	log.Printf("[WARN] Deleting Image %d, its creation failed: %s", id, cause)
+	if _, err := client.ImageDelete(id); err != nil {
+		return false, fmt.Errorf("%s. Image %d could not be deleted either: %s", cause, id, err)
+	}
+	return true, fmt.Errorf("%s. Image %d was deleted", cause, id)
+}
+
+func waitForImageState(d *schema.ResourceData, meta interface{}, state string, timeout time.Duration) (interface{}, error) {
+	return waitForImageIdState(meta.(*Client), d.Id(), state, timeout)
+}
+
+// waitForImageIdState waits for the image id to be in state, for images
+// created by other resources.
+func waitForImageIdState(client *Client, id string, state string, timeout time.Duration) (interface{}, error) {
 	var img *Image
-	client := meta.(*Client)
 
 	stateConf := &resource.StateChangeConf{
-		Pending: []string{"anythingelse"},
+		Pending: []string{"anythingelse", "locked"},
 		Target:  []string{state},
 		Refresh: func() (interface{}, string, error) {
 			log.Println("Refreshing Image state...")
-			if d.Id() != "" {
-				resp, err := client.Call("one.image.info", intId(d.Id()))
+			if id != "" {
+				resp, err := retryTransport(func() (string, error) {
+					return client.ImageInfo(intId(id))
+				})
 				if err == nil {
-					if err = xml.Unmarshal([]byte(resp), &img); err != nil {
+					if err = client.Decode(resp, &img); err != nil {
 						return nil, "", fmt.Errorf("Couldn't fetch Image state: %s", err)
 					}
+				} else if !isNotFound(err) {
+					return nil, "", fmt.Errorf("Couldn't fetch Image state: %s", err)
 				} else {
-					log.Printf("Image %v was not found", d.Id())
+					log.Printf("Image %v was not found", id)
 					//We can't return nil or Terraform will keep waiting
 					//forever, so return an empty struct
 					img := &Image{}
@@ -316,13 +576,19 @@ func waitForImageState(d *schema.ResourceData, meta interface{}, state string) (
 			log.Printf("Image %v is currently in state %v", img.Id, img.State)
 			if img.State == 1 {
 				return img, "ready", nil
+			} else if img.State == imageLocked {
+				log.Printf("[DEBUG] Image %v is LOCKED while its data is copied", img.Id)
+				return img, "locked", nil
 			} else if img.State == 5 {
-				return img, "error", fmt.Errorf("Image ID %v entered error state.", d.Id())
+				if img.Template != nil && img.Template.Error != "" {
+					return img, "error", fmt.Errorf("Image ID %v entered error state: %s", id, img.Template.Error)
+				}
+				return img, "error", fmt.Errorf("Image ID %v entered error state.", id)
 			} else {
 				return img, "anythingelse", nil
 			}
 		},
-		Timeout:	10 * time.Minute,
+		Timeout:	timeout,
 		Delay:		10 * time.Second,
 		MinTimeout:	3 * time.Second,
 	}
@@ -330,30 +596,155 @@ func waitForImageState(d *schema.ResourceData, meta interface{}, state string) (
 	return stateConf.WaitForState()
 }
 
+func resourceImageCustomizeDiff(diff *schema.ResourceDiff, v interface{}) error {
+	if err := checkResourceVersions(diff, v, "opennebula_image"); err != nil {
+		return err
+	}
+
+	// Only the configuration of new images is checked, as the size of an
+	// existing file image is read back from OpenNebula
+	if diff.Id() == "" {
+		if err := validateFileImage(diff.Get("type").(string), diff.GetOk); err != nil {
+			return err
+		}
+		if err := validateFsImage(diff.Get("type").(string), diff.GetOk); err != nil {
+			return err
+		}
+	}
+
+	// OpenNebula can't resize images, a new size replaces the image with a
+	// new one
+	if diff.Id() != "" && diff.HasChange("size") {
+		var img *Image
+
+		client, ok := v.(*Client)
+		if !ok {
+			return nil
+		}
+		resp, err := client.ImageInfo(intId(diff.Id()))
+		if err != nil {
+			return err
+		}
+		if err = client.Decode(resp, &img); err != nil {
+			return err
+		}
+
+		oldsize, newsize := diff.GetChange("size")
+		persistent, _ := imagePersistent(img.Persistent)
+		if err = checkImageReplace(img.Id, oldsize.(int), newsize.(int), persistent, img.Vms); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkImageReplace returns an error unless image id, whose size changes,
+// can be replaced by a new larger one of newsize MB. Images used by VMs
+// can't be deleted, and replacing a persistent image would lose the data
+// written to it.
+func checkImageReplace(id, oldsize, newsize int, persistent bool, vms []int) error {
+	if newsize < oldsize {
+		return fmt.Errorf("Image %d can't be replaced by a smaller one of %d MB, it has %d MB", id, newsize, oldsize)
+	}
+	if len(vms) > 0 {
+		return fmt.Errorf("Changing the size of image %d replaces it, which VMs %s using it prevent", id, imageVmsString(&Image{Vms: vms}))
+	}
+	if persistent {
+		return fmt.Errorf("Changing the size of persistent image %d would replace it and lose its data, as OpenNebula can't resize images: grow the size of the disk of a VM using it instead, which grows the image along", id)
+	}
+	return nil
+}
+
+// validateFileImage checks the arguments of a KERNEL, RAMDISK or CONTEXT
+// image, read with getOk. Such an image is registered from a file, can't be
+// persistent and has none of the disk arguments.
+func validateFileImage(imgtype string, getOk func(string) (interface{}, bool)) error {
+	if !in_array(imgtype, imageFileTypes) {
+		return nil
+	}
+
+	_, path := getOk("path")
+	_, clone := getOk("clone_from_image")
+	_, appID := getOk("marketplace_app_id")
+	_, appName := getOk("marketplace_app_name")
+	if !path && !clone && !appID && !appName {
+		return fmt.Errorf("Images of type %s are registered from a file, set path", imgtype)
+	}
+
+	if _, persistent := getOk("persistent"); persistent {
+		return fmt.Errorf("Images of type %s can't be persistent", imgtype)
+	}
+
+	for _, arg := range diskImageArguments {
+		if _, ok := getOk(arg); ok {
+			return fmt.Errorf("%q only applies to disk images, not to images of type %s", arg, imgtype)
+		}
+	}
+
+	return nil
+}
+
+// validateFsImage checks fs, read with getOk along with the other
+// arguments, is only set for empty DATABLOCK images.
+func validateFsImage(imgtype string, getOk func(string) (interface{}, bool)) error {
+	if _, ok := getOk("fs"); !ok {
+		return nil
+	}
+
+	if imgtype != "DATABLOCK" {
+		return fmt.Errorf("\"fs\" only applies to images of type DATABLOCK")
+	}
+	for _, arg := range []string{"path", "clone_from_image"} {
+		if _, ok := getOk(arg); ok {
+			return fmt.Errorf("\"fs\" formats empty DATABLOCK images, it can't be set with %q", arg)
+		}
+	}
+
+	return nil
+}
+
+// checkFilesDatastore returns an error unless the datastore id is a files
+// datastore, the only one file images of type imgtype can be stored in.
+func checkFilesDatastore(client *Client, id int, imgtype string) error {
+	var ds *Datastore
+
+	resp, err := client.DatastoreInfo(id)
+	if err != nil {
+		return err
+	}
+	if err = client.Decode(resp, &ds); err != nil {
+		return err
+	}
+
+	// Datastore types: 0 IMAGE_DS, 1 SYSTEM_DS, 2 FILE_DS
+	if ds.Type != 2 {
+		return fmt.Errorf("Images of type %s must be stored in a files datastore, datastore %d (%s) is not one", imgtype, id, ds.Name)
+	}
+
+	return nil
+}
+
 func resourceImageRead(d *schema.ResourceData, meta interface{}) error {
 	var img *Image
 	var imgs *Images
 
-	image_type_id_name := map[int]string {
-		0: "OS",
-		1: "CDROM",
-		2: "DATABLOCK",
-		3: "KERNEL",
-		4: "RAMDISK",
-		5: "CONTEXT",
-	}
-
 	client := meta.(*Client)
 	found := false
 
 	// Try to find the Image by ID, if specified
 	if d.Id() != "" {
-		resp, err := client.Call("one.image.info", intId(d.Id()), false)
+		resp, err := retryTransport(func() (string, error) {
+			return client.ImageInfo(intId(d.Id()))
+		})
 		if err == nil {
 			found = true
-			if err = xml.Unmarshal([]byte(resp), &img); err != nil {
+			if err = client.Decode(resp, &img); err != nil {
 				return err
 			}
+		} else if _, ok := err.(*ResponseError); !ok {
+			// Only an image OpenNebula reports missing is looked up by name
+			return err
 		} else {
 			log.Printf("Could not find Image by ID %s", d.Id())
 		}
@@ -361,12 +752,12 @@ func resourceImageRead(d *schema.ResourceData, meta interface{}) error {
 
 	// Otherwise, try to find the Image by (user, name) as the de facto compound primary key
 	if d.Id() == "" || !found {
-		resp, err := client.Call("one.imagepool.info", -2, -1, -1)
+		resp, err := client.ImagePoolInfo(-2, -1, -1)
 		if err != nil {
 			return err
 		}
 
-		if err = xml.Unmarshal([]byte(resp), &imgs); err != nil {
+		if err = client.Decode(resp, &imgs); err != nil {
 			return err
 		}
 
@@ -391,23 +782,140 @@ func resourceImageRead(d *schema.ResourceData, meta interface{}) error {
 	d.Set("gid", img.Gid)
 	d.Set("uname", img.Uname)
 	d.Set("gname", img.Gname)
+	if group, ok := d.Get("group").(string); ok && group != "" {
+		d.Set("group", img.Gname)
+	}
 	d.Set("permissions", permissionString(img.Permissions))
-	d.Set("persistent", img.Persistent)
+	if persistent, err := imagePersistent(img.Persistent); err == nil {
+		d.Set("persistent", persistent)
+	} else {
+		log.Printf("[WARN] %s", err)
+	}
 	d.Set("path", img.Path)
+	d.Set("enabled", img.State != imageDisabled)
+	if lock, ok := imageLockName(img.Lock); ok {
+		d.Set("lock", lock)
+	} else {
+		log.Printf("[WARN] Unexpected LOCK level %d of Image %d", img.Lock, img.Id)
+	}
 
-	if imgtypeint, err := strconv.Atoi(img.Type); err == nil {
-		if val, ok := image_type_id_name[imgtypeint]; ok {
-			d.Set("type", val)
-		}
+	if imgtype, err := imageType(img.Type); err == nil {
+		d.Set("type", imgtype)
+	} else {
+		log.Printf("[WARN] %s", err)
 	}
 
 	d.Set("size", img.Size)
+
+	// Only the template attributes managed here are read, the others are
+	// left to whoever set them
+	if attrs, ok := d.Get("template_attributes").(map[string]interface{}); ok && len(attrs) > 0 {
+		custom := make(map[string]interface{}, len(attrs))
+		for key := range attrs {
+			if value := img.Template.Get(key); value != "" {
+				custom[key] = value
+			}
+		}
+		d.Set("template_attributes", custom)
+	}
+
+	if app, err := strconv.Atoi(img.Template.Get("FROM_APP")); err == nil {
+		d.Set("marketplace_app_id", app)
+	}
+
 	d.Set("dev_prefix", img.Template.DevPrefix)
 	d.Set("driver", img.Template.Driver)
 
 	return nil
 }
 
+// imageLockLevels are the lock levels of images, by the LOCKED value
+// OpenNebula sets, 0 being unlocked.
+var imageLockLevels = []string{"", "use", "manage", "admin", "all"}
+
+// imageLockLevel returns the LOCKED value of the lock level name.
+func imageLockLevel(name string) (int, bool) {
+	for level, n := range imageLockLevels {
+		if n == name {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// imageLockName returns the name of the LOCKED value level.
+func imageLockName(level int) (string, bool) {
+	if level < 0 || level >= len(imageLockLevels) {
+		return "", false
+	}
+	return imageLockLevels[level], true
+}
+
+// lockImage locks the image at the level named lock, or unlocks it if lock
+// is empty.
+func lockImage(client *Client, id int, lock string) error {
+	level, ok := imageLockLevel(lock)
+	if !ok {
+		return fmt.Errorf("Unknown lock level %q", lock)
+	}
+	if level == 0 {
+		_, err := client.ImageUnlock(id)
+		return err
+	}
+	_, err := client.ImageLock(id, level)
+	return err
+}
+
+// imageDisabled and imageLocked are the STATE of disabled images and of
+// images whose data is being copied.
+const (
+	imageDisabled = 3
+	imageLocked   = 4
+)
+
+// imageTimeout is the default time images are waited for.
+const imageTimeout = 10 * time.Minute
+
+// image_type_id_name are the names of the image types by their ID.
+var image_type_id_name = map[int]string {
+	0: "OS",
+	1: "CDROM",
+	2: "DATABLOCK",
+	3: "KERNEL",
+	4: "RAMDISK",
+	5: "CONTEXT",
+}
+
+// imagePersistent parses the PERSISTENT attribute of an image, "0" or "1"
+// in its info and YES or NO in its template.
+func imagePersistent(value string) (bool, error) {
+	switch strings.ToUpper(strings.TrimSpace(value)) {
+	case "1", "YES":
+		return true, nil
+	case "0", "NO", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("Unexpected PERSISTENT value %q of image", value)
+}
+
+// imageType returns the name of the TYPE of an image, given either as the
+// ID of the type or as its name.
+func imageType(value string) (string, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if id, err := strconv.Atoi(value); err == nil {
+		if name, ok := image_type_id_name[id]; ok {
+			return name, nil
+		}
+	} else {
+		for _, name := range image_type_id_name {
+			if name == value {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("Unexpected TYPE value %q of image", value)
+}
+
 func getImageIdByName(d *schema.ResourceData, meta interface{}) (int, error) {
 	var img *Image
 	var imgs *Images
@@ -415,12 +923,12 @@ func getImageIdByName(d *schema.ResourceData, meta interface{}) (int, error) {
 	client := meta.(*Client)
 	found := false
 
-	resp, err := client.Call("one.imagepool.info", -3, -1, -1)
+	resp, err := client.ImagePoolInfo(-3, -1, -1)
 	if err != nil {
 		return 0, err
 	}
 
-	if err = xml.Unmarshal([]byte(resp), &imgs); err != nil {
+	if err = client.Decode(resp, &imgs); err != nil {
 		return 0, err
 	}
 
@@ -453,21 +961,24 @@ func resourceImageExists(d *schema.ResourceData, meta interface{}) (bool, error)
 func resourceImageUpdate(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
 
-	if d.HasChange("description") {
-		_, err := client.Call(
-			"one.image.update",
-			intId(d.Id()),
-			d.Get("description").(string),
-			0, // replace the whole image instead of merging it with the existing one
-		)
+	// The lock would get in the way of the other changes, it is set again
+	// once they are done
+	if oldlock, _ := d.GetChange("lock"); oldlock.(string) != "" {
+		if err := lockImage(client, intId(d.Id()), ""); err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("description") || d.HasChange("template_attributes") {
+		oldattrs, newattrs := d.GetChange("template_attributes")
+		err := updateImageTemplate(client, intId(d.Id()), d.Get("description").(string), oldattrs.(map[string]interface{}), newattrs.(map[string]interface{}))
 		if err != nil {
 			return err
 		}
 	}
 
 	if d.HasChange("name") {
-		resp, err := client.Call(
-			"one.image.rename",
+		resp, err := client.ImageRename(
 			intId(d.Id()),
 			d.Get("name").(string),
 		)
@@ -477,14 +988,141 @@ func resourceImageUpdate(d *schema.ResourceData, meta interface{}) error {
 		log.Printf("[INFO] Successfully updated name for Image %s\n", resp)
 	}
 
-	if d.HasChange("permissions") {
-		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.image.chmod")
+	if perms := changedPermissions(d); perms != nil {
+		resp, err := client.ImageChmod(intId(d.Id()), perms)
 		if err != nil {
 			return err
 		}
 		log.Printf("[INFO] Successfully updated Image %s\n", resp)
 	}
 
+	if err := changeImageOwner(d, client, true); err != nil {
+		return err
+	}
+
+	if d.HasChange("persistent") {
+		if err := setImagePersistent(client, intId(d.Id()), d.Get("persistent").(bool)); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully changed the persistency of Image %s\n", d.Id())
+	}
+
+	if d.HasChange("enabled") {
+		if _, err := client.ImageEnable(intId(d.Id()), d.Get("enabled").(bool)); err != nil {
+			return err
+		}
+		log.Printf("[INFO] Successfully changed Image %s to enabled=%t\n", d.Id(), d.Get("enabled").(bool))
+	}
+
+	if lock, ok := d.GetOk("lock"); ok {
+		if err := lockImage(client, intId(d.Id()), lock.(string)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setImagePersistent makes the image persistent or not. OpenNebula refuses
+// to while VMs use the image, which are then listed in the error.
+func setImagePersistent(client *Client, id int, persistent bool) error {
+	_, err := client.ImagePersistent(id, persistent)
+	if _, ok := err.(*ResponseError); !ok {
+		return err
+	}
+
+	var img *Image
+	resp, infoErr := client.ImageInfo(id)
+	if infoErr != nil || client.Decode(resp, &img) != nil || len(img.Vms) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("Image %d is used by VMs %s, detach it from them to change its persistency: %s", id, imageVmsString(img), err)
+}
+
+// imageVmsString returns the IDs of the VMs using an image, for errors.
+func imageVmsString(img *Image) string {
+	vms := make([]string, 0, len(img.Vms))
+	for _, vm := range img.Vms {
+		vms = append(vms, strconv.Itoa(vm))
+	}
+	return strings.Join(vms, ", ")
+}
+
+// waitForImageUnused checks no VM uses the image, waiting for up to timeout
+// for the VMs to release it if wait is set and failing right away with
+// their IDs otherwise.
+func waitForImageUnused(client *Client, id int, wait bool, timeout time.Duration) error {
+	var img *Image
+
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"used"},
+		Target:  []string{"unused"},
+		Refresh: func() (interface{}, string, error) {
+			resp, err := retryTransport(func() (string, error) {
+				return client.ImageInfo(id)
+			})
+			if err != nil {
+				return nil, "", err
+			}
+			img = nil
+			if err = client.Decode(resp, &img); err != nil {
+				return nil, "", err
+			}
+			if len(img.Vms) == 0 && img.RunningVms == 0 {
+				return img, "unused", nil
+			}
+			if !wait {
+				return img, "used", fmt.Errorf("Image %d is used by VMs %s, detach it from them before deleting it or set wait_for_unused", id, imageVmsString(img))
+			}
+			log.Printf("[DEBUG] Image %d is used by VMs %s, waiting for them to release it", id, imageVmsString(img))
+			return img, "used", nil
+		},
+		Timeout:	timeout,
+		MinTimeout:	3 * time.Second,
+	}
+
+	_, err := stateConf.WaitForState()
+	if _, ok := err.(*resource.TimeoutError); ok && img != nil {
+		return fmt.Errorf("Image %d is still used by VMs %s after %s", id, imageVmsString(img), timeout)
+	}
+	return err
+}
+
+// updateImageTemplate sets the description and custom attributes of the
+// image, merging them with the rest of its template.
+func updateImageTemplate(client *Client, id int, description string, old, attrs map[string]interface{}) error {
+	tmpl := fmt.Sprintf("DESCRIPTION=\"%s\"%s", description, customAttributes(old, attrs, imageTemplateAttributes))
+	resp, err := client.ImageUpdate(id, tmpl, 1)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Successfully updated the template of Image %s\n", resp)
+	return nil
+}
+
+// changeImageOwner hands the image over to the user and group set by the
+// uid, gid and group arguments, or only to those changed on update.
+func changeImageOwner(d *schema.ResourceData, client *Client, update bool) error {
+	uid, gid := -1, -1
+	if v, ok := d.GetOkExists("uid"); ok && (!update || d.HasChange("uid")) {
+		uid = v.(int)
+	}
+	if !update || d.HasChange("group") || d.HasChange("gid") {
+		g, _, err := ownerGroup(d, client)
+		if err != nil {
+			return err
+		}
+		gid = g
+	}
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+
+	if _, err := client.ImageChown(intId(d.Id()), uid, gid); err != nil {
+		return err
+	}
+	log.Printf("[INFO] Successfully changed the owner of Image %s to uid %d and gid %d\n", d.Id(), uid, gid)
 	return nil
 }
 
@@ -496,14 +1134,25 @@ func resourceImageDelete(d *schema.ResourceData, meta interface{}) error {
 
 	client := meta.(*Client)
 
-	resp, err := client.Call("one.image.delete", intId(d.Id()), false)
+	err = waitForImageUnused(client, intId(d.Id()), d.Get("wait_for_unused").(bool), d.Timeout(schema.TimeoutDelete))
+	if err != nil {
+		return err
+	}
+
+	if d.Get("lock").(string) != "" {
+		if err = lockImage(client, intId(d.Id()), ""); err != nil {
+			return err
+		}
+	}
+
+	resp, err := client.ImageDelete(intId(d.Id()))
 	if err != nil {
 		return err
 	}
 
 	log.Printf("[INFO] Successfully deleted Image %s\n", resp)
 
-	_, err = waitForImageState(d, meta, "notfound")
+	_, err = waitForImageState(d, meta, "notfound", d.Timeout(schema.TimeoutDelete))
 	if err != nil {
 		return fmt.Errorf("Error waiting for Image (%s) to be in state NOTFOUND: %s", d.Id(), err)
 	}
@@ -526,6 +1175,8 @@ func generateImageXML(d *schema.ResourceData) (string, error) {
 	//var imagedisktype string
 	var imagemd5 string
 	var imagesha1 string
+	var imageformat string
+	var imagefs string
 
 	imagename := d.Get("name").(string)
 
@@ -564,6 +1215,14 @@ func generateImageXML(d *schema.ResourceData) (string, error) {
 		imagepath = val.(string)
 	}
 
+	if val, ok := d.GetOk("format"); ok {
+		imageformat = val.(string)
+	}
+
+	if val, ok := d.GetOk("fs"); ok {
+		imagefs = val.(string)
+	}
+
 	if val, ok := d.GetOk("md5"); ok {
 		imagemd5 = val.(string)
 	}
@@ -584,10 +1243,37 @@ func generateImageXML(d *schema.ResourceData) (string, error) {
 		Path:				imagepath,
 		MD5:				imagemd5,
 		SHA1:				imagesha1,
+		Format:				imageformat,
+		Fs:					imagefs,
 	}
 
 	imagetpl.XMLName.Local = "IMAGE"
 
+	if val, ok := d.GetOk("template_attributes"); ok {
+		attrs := val.(map[string]interface{})
+		keys := make([]string, 0, len(attrs))
+		for key := range attrs {
+			if !in_array(key, imageTemplateAttributes) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			imagetpl.Custom = append(imagetpl.Custom, vmTemplateValue(key, attrs[key].(string)))
+		}
+	}
+
+	// Files are handed to the VMs as they are, none of the disk attributes
+	// apply to them
+	if in_array(imagetype, imageFileTypes) {
+		imagetpl.Size = 0
+		imagetpl.Persistent = ""
+		imagetpl.DevPrefix = ""
+		imagetpl.Target = ""
+		imagetpl.Driver = ""
+		imagetpl.Format = ""
+	}
+
 	w := &bytes.Buffer{}
 
 	//Encode the Security Group template schema to XML
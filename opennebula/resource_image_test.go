@@ -0,0 +1,681 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	resource.AddTestSweepers("opennebula_image", &resource.Sweeper{
+		Name:         "opennebula_image",
+		Dependencies: []string{"opennebula_vm"},
+		F:            testSweepImages,
+	})
+}
+
+func testSweepImages(region string) error {
+	client, err := sharedClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ImagePoolInfo(-3, -1, -1)
+	if err != nil {
+		return err
+	}
+
+	var imgs Images
+	if err = xml.Unmarshal([]byte(resp), &imgs); err != nil {
+		return err
+	}
+
+	for _, img := range imgs.Image {
+		if !strings.HasPrefix(img.Name, testAccPrefix) {
+			continue
+		}
+
+		log.Printf("[INFO] Sweeping Image %s (%d)", img.Name, img.Id)
+		if _, err := client.ImageDelete(img.Id); err != nil {
+			log.Printf("[ERROR] Failed to sweep Image %d: %s", img.Id, err)
+		}
+	}
+
+	return nil
+}
+
+func TestAccImage(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckImageDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccImageConfigBasic, testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_image.test", "name", "tf-acc-test-image"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "type", "DATABLOCK"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "size", "16"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "persistent", "false"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "enabled", "true"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "permissions", "642"),
+					resource.TestCheckResourceAttrSet("opennebula_image.test", "uid"),
+					resource.TestCheckResourceAttrSet("opennebula_image.test", "gid"),
+				),
+			},
+			{
+				ResourceName:            "opennebula_image.test",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"description", "datastore_id", "wait_for_unused", "rollback_on_failure"},
+			},
+			{
+				Config: fmt.Sprintf(testAccImageConfigUpdate, testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_image.test", "name", "tf-acc-test-image-renamed"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "permissions", "600"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "group", "users"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "gname", "users"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "persistent", "true"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "enabled", "false"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "lock", "all"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "template_attributes.OS_VERSION", "10"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccImageConfigOwner, testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_image.test", "gid", "0"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "gname", "oneadmin"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "uid", "0"),
+				),
+			},
+			{
+				// the owner change must converge
+				Config:   fmt.Sprintf(testAccImageConfigOwner, testAccDatastoreID(t)),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+// Updating the description used to replace the image template with it,
+// wiping the driver.
+func TestAccImageDescription(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckImageDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccImageConfigDescription, "Created by the acceptance tests", testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_image.test", "driver", "qcow2"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "dev_prefix", "vd"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccImageConfigDescription, "Updated by the acceptance tests", testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_image.test", "driver", "qcow2"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "dev_prefix", "vd"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "template_attributes.OS_VERSION", "10"),
+				),
+			},
+			{
+				Config:   fmt.Sprintf(testAccImageConfigDescription, "Updated by the acceptance tests", testAccDatastoreID(t)),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccImageFs(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckImageDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccImageConfigFs, testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_image.test", "type", "DATABLOCK"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "fs", "ext4"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "size", "64"),
+				),
+			},
+			{
+				Config:   fmt.Sprintf(testAccImageConfigFs, testAccDatastoreID(t)),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+// The marketplace must be reachable from the frontend running the tests.
+func TestAccImageMarketApp(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckImageDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccImageConfigMarketApp, testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrSet("opennebula_image.test", "marketplace_app_id"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "type", "OS"),
+					resource.TestCheckResourceAttr("opennebula_image.test", "dev_prefix", "sd"),
+				),
+			},
+			{
+				Config:   fmt.Sprintf(testAccImageConfigMarketApp, testAccDatastoreID(t)),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccImageClone(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckImageDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccImageConfigClone, testAccDatastoreID(t), ""),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_image.clone", "size", "16"),
+					resource.TestCheckResourceAttr("opennebula_image.clone", "driver", "raw"),
+					resource.TestCheckResourceAttr("opennebula_image.clone", "dev_prefix", "sd"),
+					resource.TestCheckResourceAttr("opennebula_image.clone", "persistent", "true"),
+					resource.TestCheckResourceAttr("opennebula_image.clone", "template_attributes.OS_VERSION", "10"),
+				),
+			},
+			{
+				Config:   fmt.Sprintf(testAccImageConfigClone, testAccDatastoreID(t), ""),
+				PlanOnly: true,
+			},
+			{
+				Config:      fmt.Sprintf(testAccImageConfigClone, testAccDatastoreID(t), "size = 32"),
+				ExpectError: regexp.MustCompile("can't resize images"),
+			},
+		},
+	})
+}
+
+func TestRollbackImage(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.image.delete" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		if args[0].(int) == 2 {
+			return []interface{}{false, "[one.image.delete] Not authorized", int64(0x0200)}, nil
+		}
+		return []interface{}{true, int64(args[0].(int)), int64(0)}, nil
+	})
+
+	cause := fmt.Errorf("Error updating the template of Image 1 cloned from image 7")
+	deleted, err := rollbackImage(client, 1, cause)
+	if !deleted || err == nil || err.Error() != "Error updating the template of Image 1 cloned from image 7. Image 1 was deleted" {
+		t.Fatalf("Expected image 1 to be deleted, got %t: %v", deleted, err)
+	}
+
+	deleted, err = rollbackImage(client, 2, cause)
+	if deleted || err == nil || !strings.Contains(err.Error(), "could not be deleted either: [one.image.delete] Not authorized") {
+		t.Fatalf("Expected the failed deletion of image 2 to be reported, got %t: %v", deleted, err)
+	}
+	if calls := caller.callsTo("one.image.delete"); len(calls) != 2 {
+		t.Fatalf("Expected 2 deletions, got %v", calls)
+	}
+}
+
+// The image datastore must be on the frontend running the tests, which
+// registers the fixture from its local path.
+func TestAccImageChecksum(t *testing.T) {
+	path, err := filepath.Abs("test-fixtures/context-script.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckImageDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config:      fmt.Sprintf(testAccImageConfigChecksum, testAccDatastoreID(t), path),
+				ExpectError: regexp.MustCompile("entered error state"),
+			},
+		},
+	})
+}
+
+func TestValidateFileImage(t *testing.T) {
+	cases := []struct {
+		imgtype string
+		set     map[string]interface{}
+		valid   bool
+	}{
+		{"DATABLOCK", map[string]interface{}{"size": 16}, true},
+		{"CONTEXT", map[string]interface{}{"path": "/var/tmp/init.sh"}, true},
+		{"KERNEL", map[string]interface{}{"clone_from_image": "vmlinuz"}, true},
+		{"CONTEXT", map[string]interface{}{}, false},
+		{"CONTEXT", map[string]interface{}{"path": "/var/tmp/init.sh", "persistent": true}, false},
+		{"RAMDISK", map[string]interface{}{"path": "/var/tmp/initrd", "driver": "raw"}, false},
+		{"KERNEL", map[string]interface{}{"path": "/var/tmp/vmlinuz", "target": "hda"}, false},
+	}
+
+	for i, c := range cases {
+		getOk := func(k string) (interface{}, bool) {
+			v, ok := c.set[k]
+			return v, ok
+		}
+		err := validateFileImage(c.imgtype, getOk)
+		if c.valid && err != nil {
+			t.Errorf("%d: Expected %s image %v to be valid, got: %s", i, c.imgtype, c.set, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%d: Expected %s image %v to be rejected", i, c.imgtype, c.set)
+		}
+	}
+}
+
+func TestValidateFsImage(t *testing.T) {
+	cases := []struct {
+		imgtype string
+		set     map[string]interface{}
+		valid   bool
+	}{
+		{"DATABLOCK", map[string]interface{}{"size": 1024, "format": "raw", "fs": "ext4"}, true},
+		{"OS", map[string]interface{}{"path": "/var/tmp/debian.qcow2", "format": "qcow2"}, true},
+		{"OS", map[string]interface{}{"size": 1024, "fs": "ext4"}, false},
+		{"DATABLOCK", map[string]interface{}{"path": "/var/tmp/data.img", "fs": "ext4"}, false},
+		{"DATABLOCK", map[string]interface{}{"clone_from_image": "data", "fs": "xfs"}, false},
+	}
+
+	for i, c := range cases {
+		getOk := func(k string) (interface{}, bool) {
+			v, ok := c.set[k]
+			return v, ok
+		}
+		err := validateFsImage(c.imgtype, getOk)
+		if c.valid && err != nil {
+			t.Errorf("%d: Expected %s image %v to be valid, got: %s", i, c.imgtype, c.set, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%d: Expected %s image %v to be rejected", i, c.imgtype, c.set)
+		}
+	}
+}
+
+func TestCheckImageReplace(t *testing.T) {
+	cases := []struct {
+		oldsize    int
+		newsize    int
+		persistent bool
+		vms        []int
+		err        string
+	}{
+		{16, 32, false, nil, ""},
+		{32, 16, false, nil, "smaller one"},
+		{16, 32, false, []int{12, 15}, "VMs 12, 15"},
+		{16, 32, true, nil, "persistent"},
+	}
+
+	for i, c := range cases {
+		err := checkImageReplace(42, c.oldsize, c.newsize, c.persistent, c.vms)
+		if c.err == "" && err != nil {
+			t.Errorf("%d: Expected image 42 to be replaced, got: %s", i, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%d: Expected an error with %q, got: %v", i, c.err, err)
+		}
+	}
+}
+
+func TestCheckFilesDatastore(t *testing.T) {
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.datastore.info" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		if args[0] == 2 {
+			return []interface{}{true, "<DATASTORE><ID>2</ID><NAME>files</NAME><TYPE>2</TYPE></DATASTORE>", int64(0)}, nil
+		}
+		return []interface{}{true, "<DATASTORE><ID>1</ID><NAME>default</NAME><TYPE>0</TYPE></DATASTORE>", int64(0)}, nil
+	})
+
+	if err := checkFilesDatastore(client, 2, "CONTEXT"); err != nil {
+		t.Fatalf("Expected the files datastore to be accepted, got: %s", err)
+	}
+	if err := checkFilesDatastore(client, 1, "CONTEXT"); err == nil || !strings.Contains(err.Error(), "default") {
+		t.Fatalf("Expected the image datastore to be rejected, got: %v", err)
+	}
+}
+
+func TestSetImagePersistent(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.image.persistent":
+			if args[0] == 42 {
+				return []interface{}{false, "[one.image.persistent] Cannot change persistent state for images in use", int64(0x0800)}, nil
+			}
+			return []interface{}{true, "7", int64(0)}, nil
+		case "one.image.info":
+			return []interface{}{true, "<IMAGE><ID>42</ID><NAME>debian</NAME><VMS><ID>12</ID><ID>15</ID></VMS></IMAGE>", int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	if err := setImagePersistent(client, 7, true); err != nil {
+		t.Fatalf("Expected image 7 to be made persistent, got: %s", err)
+	}
+	if calls := caller.callsTo("one.image.persistent"); len(calls) != 1 || calls[0].Args[1] != true {
+		t.Fatalf("Expected image 7 to be made persistent, got calls %v", calls)
+	}
+
+	err := setImagePersistent(client, 42, false)
+	if err == nil || !strings.Contains(err.Error(), "VMs 12, 15") || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("Expected an error listing VMs 12 and 15, got: %v", err)
+	}
+}
+
+func TestImageInfoReadBack(t *testing.T) {
+	cases := []struct {
+		fixture    string
+		persistent bool
+		imgtype    string
+		vms        []int
+	}{
+		{"test-fixtures/image-info-5.8.xml", false, "OS", nil},
+		{"test-fixtures/image-info-5.12.xml", true, "DATABLOCK", []int{35}},
+	}
+
+	for _, c := range cases {
+		info, err := ioutil.ReadFile(c.fixture)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		var img Image
+		if err = xml.Unmarshal(info, &img); err != nil {
+			t.Fatalf("%s: err: %s", c.fixture, err)
+		}
+
+		persistent, err := imagePersistent(img.Persistent)
+		if err != nil || persistent != c.persistent {
+			t.Errorf("%s: Expected persistent to be %t, got %t (%v)", c.fixture, c.persistent, persistent, err)
+		}
+		imgtype, err := imageType(img.Type)
+		if err != nil || imgtype != c.imgtype {
+			t.Errorf("%s: Expected type %s, got %q (%v)", c.fixture, c.imgtype, imgtype, err)
+		}
+		if fmt.Sprint(img.Vms) != fmt.Sprint(c.vms) {
+			t.Errorf("%s: Expected VMs %v, got %v", c.fixture, c.vms, img.Vms)
+		}
+	}
+}
+
+func TestImagePersistentAndType(t *testing.T) {
+	for value, expected := range map[string]bool{"0": false, "1": true, "YES": true, "no": false, "": false} {
+		if persistent, err := imagePersistent(value); err != nil || persistent != expected {
+			t.Errorf("Expected PERSISTENT %q to be %t, got %t (%v)", value, expected, persistent, err)
+		}
+	}
+	if _, err := imagePersistent("2"); err == nil {
+		t.Errorf("Expected PERSISTENT \"2\" to be rejected")
+	}
+
+	for value, expected := range map[string]string{"0": "OS", "2": "DATABLOCK", "5": "CONTEXT", "CDROM": "CDROM", "kernel": "KERNEL"} {
+		if imgtype, err := imageType(value); err != nil || imgtype != expected {
+			t.Errorf("Expected TYPE %q to be %s, got %q (%v)", value, expected, imgtype, err)
+		}
+	}
+	for _, value := range []string{"6", "-1", "FILE"} {
+		if _, err := imageType(value); err == nil {
+			t.Errorf("Expected TYPE %q to be rejected", value)
+		}
+	}
+}
+
+func TestWaitForImageUnused(t *testing.T) {
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.image.info" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		if args[0] == 42 {
+			return []interface{}{true, "<IMAGE><ID>42</ID><RUNNING_VMS>2</RUNNING_VMS><VMS><ID>12</ID><ID>15</ID></VMS></IMAGE>", int64(0)}, nil
+		}
+		return []interface{}{true, "<IMAGE><ID>7</ID><RUNNING_VMS>0</RUNNING_VMS><VMS/></IMAGE>", int64(0)}, nil
+	})
+
+	if err := waitForImageUnused(client, 7, false, time.Minute); err != nil {
+		t.Fatalf("Expected image 7 to be unused, got: %s", err)
+	}
+	err := waitForImageUnused(client, 42, false, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "VMs 12, 15") {
+		t.Fatalf("Expected an error listing VMs 12 and 15, got: %v", err)
+	}
+}
+
+func TestLockImage(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		return []interface{}{true, "42", int64(0)}, nil
+	})
+
+	if err := lockImage(client, 42, "manage"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if calls := caller.callsTo("one.image.lock"); len(calls) != 1 || calls[0].Args[1] != 2 {
+		t.Fatalf("Expected the image to be locked at level 2, got calls %v", calls)
+	}
+	if err := lockImage(client, 42, ""); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if calls := caller.callsTo("one.image.unlock"); len(calls) != 1 {
+		t.Fatalf("Expected the image to be unlocked, got calls %v", calls)
+	}
+	if err := lockImage(client, 42, "everything"); err == nil {
+		t.Fatalf("Expected an unknown lock level to be rejected")
+	}
+
+	var img Image
+	if err := xml.Unmarshal([]byte("<IMAGE><ID>42</ID><LOCK><LOCKED>4</LOCKED><OWNER>0</OWNER><TIME>1601976427</TIME><REQ_ID>-1</REQ_ID></LOCK></IMAGE>"), &img); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if lock, ok := imageLockName(img.Lock); !ok || lock != "all" {
+		t.Fatalf("Expected the LOCK of the image to be read as all, got %q", lock)
+	}
+	if lock, ok := imageLockName(0); !ok || lock != "" {
+		t.Fatalf("Expected no LOCK to be read as unlocked, got %q", lock)
+	}
+}
+
+func TestUpdateImageTemplate(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		return []interface{}{true, "42", int64(0)}, nil
+	})
+
+	old := map[string]interface{}{"OS_VERSION": "9", "BUILD_DATE": "2020-01-01"}
+	attrs := map[string]interface{}{"OS_VERSION": "10", "DRIVER": "raw"}
+	if err := updateImageTemplate(client, 42, "Debian", old, attrs); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	calls := caller.callsTo("one.image.update")
+	if len(calls) != 1 {
+		t.Fatalf("Expected 1 update, got %d", len(calls))
+	}
+	// Replacing the template would wipe DEV_PREFIX, DRIVER and the rest of it
+	if calls[0].Args[2] != 1 {
+		t.Fatalf("Expected the template to be merged, got update type %v", calls[0].Args[2])
+	}
+	if tmpl := calls[0].Args[1]; tmpl != "DESCRIPTION=\"Debian\"\nBUILD_DATE=\"\"\nOS_VERSION=\"10\"" {
+		t.Fatalf("Expected the description and custom attributes, without DRIVER, got %q", tmpl)
+	}
+}
+
+func TestImageTemplateAttributes(t *testing.T) {
+	img := &Image{Name: "debian", Custom: []vmTemplateAttribute{vmTemplateValue("OS_VERSION", "10"), vmTemplateValue("NOTE", "a<b")}}
+	img.XMLName.Local = "IMAGE"
+	tmpl, err := xml.Marshal(img)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(string(tmpl), "<OS_VERSION>10</OS_VERSION><NOTE>a&lt;b</NOTE></IMAGE>") {
+		t.Fatalf("Expected the custom attributes in the image template, got %s", tmpl)
+	}
+
+	var read Image
+	if err = xml.Unmarshal([]byte("<IMAGE><ID>42</ID><TEMPLATE><DRIVER>qcow2</DRIVER><OS_VERSION><![CDATA[10]]></OS_VERSION></TEMPLATE></IMAGE>"), &read); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if read.Template.Driver != "qcow2" || read.Template.Get("OS_VERSION") != "10" || read.Template.Get("DRIVER") != "" {
+		t.Fatalf("Expected OS_VERSION to be the only custom attribute, got %+v", read.Template)
+	}
+}
+
+func testAccCheckImageDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_image" {
+			continue
+		}
+
+		_, err := client.ImageInfo(intId(rs.Primary.ID))
+		if err == nil {
+			return fmt.Errorf("Expected Image %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+var testAccImageConfigBasic = `
+resource "opennebula_image" "test" {
+  name = "tf-acc-test-image"
+  description = "Created by the acceptance tests"
+  datastore_id = %d
+  type = "DATABLOCK"
+  size = 16
+  persistent = false
+  permissions = "642"
+}
+`
+
+var testAccImageConfigUpdate = `
+resource "opennebula_image" "test" {
+  name = "tf-acc-test-image-renamed"
+  description = "Updated by the acceptance tests"
+  datastore_id = %d
+  type = "DATABLOCK"
+  size = 16
+  persistent = true
+  enabled = false
+  lock = "all"
+  permissions = "600"
+  group = "users"
+  template_attributes = {
+    OS_VERSION = "10"
+  }
+}
+`
+
+var testAccImageConfigOwner = `
+resource "opennebula_image" "test" {
+  name = "tf-acc-test-image-renamed"
+  description = "Updated by the acceptance tests"
+  datastore_id = %d
+  type = "DATABLOCK"
+  size = 16
+  persistent = true
+  enabled = false
+  lock = "all"
+  permissions = "600"
+  uid = 0
+  gid = 0
+}
+`
+
+var testAccImageConfigDescription = `
+resource "opennebula_image" "test" {
+  name = "tf-acc-test-image-description"
+  description = "%s"
+  datastore_id = %d
+  type = "DATABLOCK"
+  size = 16
+  driver = "qcow2"
+  dev_prefix = "vd"
+  template_attributes = {
+    OS_VERSION = "10"
+  }
+
+  timeouts {
+    create = "20m"
+    delete = "20m"
+  }
+}
+`
+
+var testAccImageConfigClone = `
+resource "opennebula_image" "source" {
+  name = "tf-acc-test-image-source"
+  datastore_id = %[1]d
+  type = "DATABLOCK"
+  size = 16
+}
+
+resource "opennebula_image" "clone" {
+  name = "tf-acc-test-image-clone"
+  clone_from_image = "${opennebula_image.source.id}"
+  datastore_id = %[1]d
+  persistent = true
+  driver = "raw"
+  dev_prefix = "sd"
+  template_attributes = {
+    OS_VERSION = "10"
+  }
+  %[2]s
+}
+`
+
+var testAccImageConfigFs = `
+resource "opennebula_image" "test" {
+  name = "tf-acc-test-image-fs"
+  datastore_id = %d
+  type = "DATABLOCK"
+  size = 64
+  format = "raw"
+  fs = "ext4"
+}
+`
+
+var testAccImageConfigMarketApp = `
+resource "opennebula_image" "test" {
+  name = "tf-acc-test-image-market"
+  datastore_id = %d
+  marketplace_app_name = "Ttylinux - KVM"
+  dev_prefix = "sd"
+  timeouts {
+    create = "30m"
+  }
+}
+`
+
+var testAccImageConfigChecksum = `
+resource "opennebula_image" "test" {
+  name = "tf-acc-test-image-checksum"
+  datastore_id = %d
+  type = "OS"
+  path = "%s"
+  target = "vdb"
+  md5 = "00000000000000000000000000000000"
+}
+`
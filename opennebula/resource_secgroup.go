@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"github.com/hashicorp/terraform/helper/schema"
 	"log"
+	"net"
 	"strings"
 	"bytes"
-	"github.com/fatih/structs"
 	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/resource"
 )
 
 
@@ -21,11 +24,15 @@ type SecurityGroup struct {
 	XMLName         xml.Name     `xml:"SECURITY_GROUP"`
 	Id              string       `xml:"ID"`
 	Name            string       `xml:"NAME"`
-	Uid             string       `xml:"UID"`
-	Gid             string       `xml:"GID"`
+	Uid             int          `xml:"UID"`
+	Gid             int          `xml:"GID"`
 	Uname           string       `xml:"UNAME"`
 	Gname           string       `xml:"GNAME"`
 	Permissions     *Permissions `xml:"PERMISSIONS"`
+	UpdatedVms      []int        `xml:"UPDATED_VMS>ID"`
+	OutdatedVms     []int        `xml:"OUTDATED_VMS>ID"`
+	UpdatingVms     []int        `xml:"UPDATING_VMS>ID"`
+	ErrorVms        []int        `xml:"ERROR_VMS>ID"`
 	SecurityGroupTemplate *SecurityGroupTemplate `xml:"TEMPLATE"`
 }
 
@@ -37,13 +44,14 @@ type SecurityGroupTemplate struct {
 }
 
 type SecurityGroupRule struct {
-	Protocol        string       `xml:"PROTOCOL"             structs:"protocol"`
-	Range           string       `xml:"RANGE,omitempty"      structs:"range,omitempty"`
-	RuleType        string       `xml:"RULE_TYPE"            structs:"rule_type,omitempty"`
-	IP              string       `xml:"IP,omitempty"         structs:"ip,omitempty"`
-	Size            string       `xml:"SIZE,omitempty"       structs:"size,omitempty"`
-	NetworkId       string       `xml:"NETWORK_ID,omitempty" structs:"network_id,omitempty"`
-	IcmpType        string       `xml:"ICMP_TYPE,omitempty"  structs:"icmp_type,omitempty"`
+	Protocol        string       `xml:"PROTOCOL"`
+	Range           string       `xml:"RANGE,omitempty"`
+	RuleType        string       `xml:"RULE_TYPE"`
+	IP              string       `xml:"IP,omitempty"`
+	Size            string       `xml:"SIZE,omitempty"`
+	NetworkId       string       `xml:"NETWORK_ID,omitempty"`
+	IcmpType        string       `xml:"ICMP_TYPE,omitempty"`
+	Icmpv6Type      string       `xml:"ICMPV6_TYPE,omitempty"`
 }
 
 
@@ -54,16 +62,18 @@ func resourceSecurityGroup() *schema.Resource {
 		Exists: resourceSecurityGroupExists,
 		Update: resourceSecurityGroupUpdate,
 		Delete: resourceSecurityGroupDelete,
+		CustomizeDiff: resourceSecurityGroupCustomizeDiff,
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
+		Timeouts: &schema.ResourceTimeout{
+			Update: schema.DefaultTimeout(secgroupCommitTimeout),
+		},
 		Schema: map[string]*schema.Schema {
 			"name": {
 				Type:			schema.TypeString,
 				Required:		true,
-				ForceNew:		true,
 				Description:	"Name of the Security Group",
-
 			},
 			"description": {
 				Type:			schema.TypeString,
@@ -75,37 +85,28 @@ func resourceSecurityGroup() *schema.Resource {
 				Optional:    true,
 				Computed:    true,
 				Description: "Permissions for the Security Group (in Unix format, owner-group-other, use-manage-admin)",
-				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
-					value := v.(string)
-
-					if len(value) != 3 {
-						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
-					}
-
-					all := true
-					for _, c := range strings.Split(value, "") {
-						if c < "0" || c > "7" {
-							all = false
-						}
-					}
-					if !all {
-						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
-					}
-
-					return
-				},
+				ValidateFunc: validatePermissions,
 			},
 
 			"uid": {
 				Type:			schema.TypeInt,
+				Optional:		true,
 				Computed:		true,
 				Description:	"ID of the user that will own the Security Group",
 			},
 			"gid": {
 				Type:			schema.TypeInt,
+				Optional:		true,
 				Computed:		true,
+				ConflictsWith:	[]string{"group"},
 				Description:	"ID of the group that will own the Security Group",
 			},
+			"group": {
+				Type:			schema.TypeString,
+				Optional:		true,
+				ConflictsWith:	[]string{"gid"},
+				Description:	"Name of the group that will own the Security Group",
+			},
 			"uname": {
 				Type:			schema.TypeString,
 				Computed:		true,
@@ -117,18 +118,18 @@ func resourceSecurityGroup() *schema.Resource {
 				Description:	"Name of the group that will own the Security Group",
 			},
 			"rule": {
-				Type:			schema.TypeSet,
+				Type:			schema.TypeList,
 				Required:		true,
 				MinItems:		1,
-				Description:	"List of rules to be in the Security Group",
+				Description:	"List of rules to be in the Security Group, in the order OpenNebula keeps them",
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema {
 						"protocol": {
 							Type:			schema.TypeString,
-							Description:	"Protocol for the rule, must be one of: ALL, TCP, UDP, ICMP or IPSEC",
+							Description:	"Protocol for the rule, must be one of: ALL, TCP, UDP, ICMP, ICMPV6 or IPSEC",
 							Required:		true,
 							ValidateFunc: func (v interface{}, k string) (ws []string, errors []error) {
-								validprotos := []string{"ALL", "TCP", "UDP", "ICMP", "IPSEC"}
+								validprotos := []string{"ALL", "TCP", "UDP", "ICMP", "ICMPV6", "IPSEC"}
 								value := v.(string)
 
 								if ! in_array(value, validprotos) {
@@ -158,25 +159,40 @@ func resourceSecurityGroup() *schema.Resource {
 							Type:			schema.TypeString,
 							Description: 	"IP (or starting IP if used with 'size') to apply the rule to",
 							Optional:		true,
+							ValidateFunc:	validateSecurityGroupIP,
 						},
 						"size": {
 							Type:			schema.TypeString,
 							Description:	"Number of IPs to apply the rule from, starting with 'ip'",
 							Optional:		true,
+							ValidateFunc:	validateSecurityGroupNumber(1),
 						},
 						"range": {
 							Type:			schema.TypeString,
-							Description:	"Comma separated list of ports and port ranges",
+							Description:	"Comma separated list of ports and port ranges, e.g. 22,80:81",
 							Optional:		true,
+							ValidateFunc:	validateSecurityGroupRange,
 						},
 						"icmp_type": {
 							Type:			schema.TypeString,
 							Description:	"Type of ICMP traffic to apply to when 'protocol' is ICMP",
 							Optional:		true,
 						},
+						"icmpv6_type": {
+							Type:			schema.TypeString,
+							Description:	"Type of ICMPv6 traffic to apply to when 'protocol' is ICMPV6",
+							Optional:		true,
+						},
 						"network_id": {
 							Type:			schema.TypeString,
-							Description:	"VNET ID to be used as the source/destination IP addresses",
+							Description:	"VNET ID to be used as the source/destination IP addresses, set from 'network_name' when that is used instead",
+							Optional:		true,
+							Computed:		true,
+							ValidateFunc:	validateSecurityGroupNumber(0),
+						},
+						"network_name": {
+							Type:			schema.TypeString,
+							Description:	"Name of the VNET to be used as the source/destination IP addresses, resolved to its ID on apply",
 							Optional:		true,
 						},
 					},
@@ -188,11 +204,108 @@ func resourceSecurityGroup() *schema.Resource {
 				Optional: 		true,
 				Default:    	true,
 			},
+			"commit_all": {
+				Type:			schema.TypeBool,
+				Description: 	"Should commits apply the rules to all the Virtual Machines using the group, rather than only to the outdated and errored ones?",
+				Optional: 		true,
+				Default:    	true,
+			},
+			"wait_for_commit": {
+				Type:			schema.TypeBool,
+				Description: 	"Should updates wait for the committed rules to be applied to the Virtual Machines, failing if some of them could not be updated?",
+				Optional: 		true,
+				Default:    	false,
+			},
+			"updated_vms": {
+				Type:			schema.TypeList,
+				Computed:		true,
+				Description:	"IDs of the Virtual Machines the latest rules have been applied to",
+				Elem: &schema.Schema{
+					Type:	schema.TypeInt,
+				},
+			},
+			"outdated_vms": {
+				Type:			schema.TypeList,
+				Computed:		true,
+				Description:	"IDs of the Virtual Machines still waiting for the latest rules to be applied",
+				Elem: &schema.Schema{
+					Type:	schema.TypeInt,
+				},
+			},
+			"updating_vms": {
+				Type:			schema.TypeList,
+				Computed:		true,
+				Description:	"IDs of the Virtual Machines the latest rules are being applied to",
+				Elem: &schema.Schema{
+					Type:	schema.TypeInt,
+				},
+			},
+			"error_vms": {
+				Type:			schema.TypeList,
+				Computed:		true,
+				Description:	"IDs of the Virtual Machines that failed to apply the latest rules",
+				Elem: &schema.Schema{
+					Type:	schema.TypeInt,
+				},
+			},
 		},
 	}
 }
 
 
+// validateSecurityGroupRange checks a rule range is a comma separated list
+// of ports and first:last port ranges, as taken by OpenNebula.
+func validateSecurityGroupRange(v interface{}, k string) (ws []string, errors []error) {
+	value := v.(string)
+
+	for _, r := range strings.Split(value, ",") {
+		ports := strings.Split(r, ":")
+		if len(ports) > 2 {
+			errors = append(errors, fmt.Errorf("%q: %q is not a port or a first:last port range", k, r))
+			continue
+		}
+
+		valid := true
+		first, last := 0, 0
+		for i, port := range ports {
+			n, err := strconv.Atoi(port)
+			if err != nil || n < 1 || n > 65535 {
+				errors = append(errors, fmt.Errorf("%q: port %q in %q must be a number from 1 to 65535", k, port, value))
+				valid = false
+				break
+			}
+			if i == 0 {
+				first = n
+			}
+			last = n
+		}
+		if valid && first > last {
+			errors = append(errors, fmt.Errorf("%q: port range %q must not end before it starts", k, r))
+		}
+	}
+
+	return
+}
+
+// validateSecurityGroupIP checks a rule IP is an IPv4 or IPv6 address.
+func validateSecurityGroupIP(v interface{}, k string) (ws []string, errors []error) {
+	if net.ParseIP(v.(string)) == nil {
+		errors = append(errors, fmt.Errorf("%q must be an IPv4 or IPv6 address, got %q", k, v.(string)))
+	}
+	return
+}
+
+// validateSecurityGroupNumber returns a ValidateFunc checking a rule attribute
+// is a number of at least min.
+func validateSecurityGroupNumber(min int) schema.SchemaValidateFunc {
+	return func(v interface{}, k string) (ws []string, errors []error) {
+		if n, err := strconv.Atoi(v.(string)); err != nil || n < min {
+			errors = append(errors, fmt.Errorf("%q must be a number of at least %d, got %q", k, min, v.(string)))
+		}
+		return
+	}
+}
+
 func in_array(val string, array []string) (ok bool) {
     for i := range array {
         if ok = array[i] == val; ok {
@@ -211,27 +324,33 @@ func resourceSecurityGroupRead(d *schema.ResourceData, meta interface{}) error {
 	found := false
 	name := d.Get("name").(string)
 
-	// Try to find the Security Group by ID, if specified
+	// Try to find the Security Group by ID, if specified. A group missing by
+	// ID is gone: another group with its name must not be adopted, and
+	// renamed by Update
 	if d.Id() != "" {
-		resp, err := client.Call("one.secgroup.info", intId(d.Id()))
-		if err == nil {
-			found = true
-			if err = xml.Unmarshal([]byte(resp), &secgroup); err != nil {
-				return err
-			}
-		} else {
-			log.Printf("Could not find Security Group by ID %s", d.Id())
+		resp, err := client.SecurityGroupInfo(intId(d.Id()))
+		if isNotFound(err) {
+			log.Printf("[WARN] Could not find Security Group by ID %s, removing it from state", d.Id())
+			d.SetId("")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		if err = client.Decode(resp, &secgroup); err != nil {
+			return err
 		}
 	}
 
 	// Otherwise, try to find the vm by (user, name) as the de facto compound primary key
-	if d.Id() == "" || !found {
-		resp, err := client.Call("one.secgrouppool.info", -2, -1, -1)
+	if !found {
+		resp, err := client.SecurityGroupPoolInfo(-2, -1, -1)
 		if err != nil {
 			return err
 		}
 
-		if err = xml.Unmarshal([]byte(resp), &secgroups); err != nil {
+		if err = client.Decode(resp, &secgroups); err != nil {
 			return err
 		}
 
@@ -251,29 +370,97 @@ func resourceSecurityGroupRead(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	d.SetId(secgroup.Id)
+	d.Set("name", secgroup.Name)
 	d.Set("uid", secgroup.Uid)
 	d.Set("gid", secgroup.Gid)
 	d.Set("uname", secgroup.Uname)
 	d.Set("gname", secgroup.Gname)
+	if group, ok := d.Get("group").(string); ok && group != "" {
+		d.Set("group", secgroup.Gname)
+	}
 	d.Set("permissions", permissionString(secgroup.Permissions))
 	d.Set("description", secgroup.SecurityGroupTemplate.Description)
-
-	if err := d.Set("rule", generateSecurityGroupMapFromStructs(secgroup.SecurityGroupTemplate.SecurityGroupRules)); err != nil {
+	d.Set("updated_vms", secgroup.UpdatedVms)
+	d.Set("outdated_vms", secgroup.OutdatedVms)
+	d.Set("updating_vms", secgroup.UpdatingVms)
+	d.Set("error_vms", secgroup.ErrorVms)
+
+	rules := flattenSecurityGroupRules(secgroup.SecurityGroupTemplate.SecurityGroupRules)
+	keepSecurityGroupNetworkNames(rules, d.Get("rule").([]interface{}))
+	if err := d.Set("rule", rules); err != nil {
 		log.Printf("[WARN] Error setting rule for Security Group %s, error: %s", secgroup.Id, err)
 	}
 
 	return nil
 }
 
-func generateSecurityGroupMapFromStructs(slice []SecurityGroupRule) ([]map[string]interface{}){
+// flattenSecurityGroupRules returns the rules of a Security Group as set
+// in the rule attribute. OpenNebula hands the protocol and type back in
+// lower case, they are upper cased to match the configuration.
+func flattenSecurityGroupRules(rules []SecurityGroupRule) []map[string]interface{} {
+	flattened := make([]map[string]interface{}, 0, len(rules))
+
+	for _, rule := range rules {
+		flattened = append(flattened, map[string]interface{}{
+			"protocol":    strings.ToUpper(rule.Protocol),
+			"rule_type":   strings.ToUpper(rule.RuleType),
+			"ip":          rule.IP,
+			"size":        rule.Size,
+			"range":       rule.Range,
+			"icmp_type":   rule.IcmpType,
+			"icmpv6_type": rule.Icmpv6Type,
+			"network_id":  rule.NetworkId,
+		})
+	}
 
-	secrulemap := make([]map[string]interface{}, 0)
+	return flattened
+}
 
-	for i := 0; i < len(slice); i++ {
-		secrulemap = append(secrulemap, structs.Map(slice[i]))
+// keepSecurityGroupNetworkNames copies the network_name of the configured
+// rules to the rules read back, OpenNebula only knowing their NETWORK_ID.
+func keepSecurityGroupNetworkNames(rules []map[string]interface{}, configured []interface{}) {
+	for i, rule := range rules {
+		if i >= len(configured) || configured[i] == nil || rule["network_id"] == "" {
+			continue
+		}
+		if name, ok := configured[i].(map[string]interface{})["network_name"].(string); ok && name != "" {
+			rule["network_name"] = name
+		}
+	}
+}
+
+func resourceSecurityGroupCustomizeDiff(diff *schema.ResourceDiff, v interface{}) error {
+	if err := checkResourceVersions(diff, v, "opennebula_secgroup"); err != nil {
+		return err
 	}
 
-	return secrulemap
+	o, n := diff.GetChange("rule")
+	return checkSecurityGroupNetworks(o.([]interface{}), n.([]interface{}))
+}
+
+// checkSecurityGroupNetworks rejects rules setting both network_id and
+// network_name. network_id being computed from network_name, a rule with a
+// network_name only conflicts when its network_id differs from the one in
+// state.
+func checkSecurityGroupNetworks(old, new []interface{}) error {
+	for i, r := range new {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := rule["network_name"].(string)
+		id, _ := rule["network_id"].(string)
+		if name == "" || id == "" {
+			continue
+		}
+		if i < len(old) {
+			if oldrule, ok := old[i].(map[string]interface{}); ok && oldrule["network_id"] == id {
+				continue
+			}
+		}
+		return fmt.Errorf("Security Group rule %d sets both network_id and network_name, only one of them can be set", i)
+	}
+	return nil
 }
 
 func resourceSecurityGroupExists(d *schema.ResourceData, meta interface{}) (bool, error) {
@@ -292,13 +479,12 @@ func resourceSecurityGroupCreate(d *schema.ResourceData, meta interface{}) error
 	var resp string
 	var err error
 
-	secgroupxml, xmlerr := generateSecurityGroupXML(d)
+	secgroupxml, xmlerr := generateSecurityGroupXML(d, client)
 	if xmlerr != nil {
 		return xmlerr	
 	}
 
-	resp, err = client.Call(
-		"one.secgroup.allocate",
+	resp, err = client.SecurityGroupAllocate(
 		secgroupxml,
 	)
 
@@ -308,6 +494,21 @@ func resourceSecurityGroupCreate(d *schema.ResourceData, meta interface{}) error
 	
 	d.SetId(resp)
 
+	//Hand the Security Group over to the user and group if they were defined
+	uid := -1
+	if v, ok := d.GetOkExists("uid"); ok {
+		uid = v.(int)
+	}
+	gid, _, err := ownerGroup(d, client)
+	if err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if _, err = client.SecurityGroupChown(intId(d.Id()), uid, gid); err != nil {
+			return err
+		}
+	}
+
 	return resourceSecurityGroupRead(d, meta)
 }
 
@@ -318,8 +519,42 @@ func resourceSecurityGroupUpdate(d *schema.ResourceData, meta interface{}) error
 
 	client := meta.(*Client)
 
-	if d.HasChange("permissions") && d.Get("permissions") != "" {
-		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.secgroup.chmod")
+	if d.HasChange("name") {
+		oldname, newname := d.GetChange("name")
+		if err := renameSecurityGroup(client, intId(d.Id()), oldname.(string), newname.(string)); err != nil {
+			return err
+		}
+		d.SetPartial("name")
+	}
+
+	var change_own bool = false
+	var newuid int = -1
+	var newgid int = -1
+	if d.HasChange("uid") {
+		change_own = true
+		newuid = d.Get("uid").(int)
+	}
+	if d.HasChange("group") || d.HasChange("gid") {
+		gid, set, err := ownerGroup(d, client)
+		if err != nil {
+			return err
+		}
+		change_own = change_own || set
+		newgid = gid
+	}
+	if change_own {
+		resp, err := client.SecurityGroupChown(intId(d.Id()), newuid, newgid)
+		if err != nil {
+			return err
+		}
+		d.SetPartial("uid")
+		d.SetPartial("gid")
+		d.SetPartial("group")
+		log.Printf("[INFO] Successfully updated owner uid and gid for Security Group %s\n", resp)
+	}
+
+	if perms := changedPermissions(d); perms != nil {
+		resp, err := client.SecurityGroupChmod(intId(d.Id()), perms)
 		if err != nil {
 			return err
 		}
@@ -330,10 +565,7 @@ func resourceSecurityGroupUpdate(d *schema.ResourceData, meta interface{}) error
 	if d.HasChange("rule") && d.Get("rule") != "" {
 		client := meta.(*Client)
 
-		var resp string
-		var err error
-
-		secgroupxml, xmlerr := generateSecurityGroupXML(d)
+		secgroupxml, xmlerr := generateSecurityGroupXML(d, client)
 		if xmlerr != nil {
 			return xmlerr
 		}
@@ -344,42 +576,171 @@ func resourceSecurityGroupUpdate(d *schema.ResourceData, meta interface{}) error
 			return err
 		}
 
-		resp, err = client.Call(
-			"one.secgroup.update",
-			objid,
-			secgroupxml,
-			0,
-		)
+		if err = updateSecurityGroupRules(client, objid, secgroupxml, d.Get("commit") == true, d.Get("commit_all") == true); err != nil {
+			return err
+		}
+		if d.Get("commit") == true && d.Get("wait_for_commit") == true {
+			if err = waitForSecurityGroupCommit(client, objid, d.Timeout(schema.TimeoutUpdate)); err != nil {
+				return err
+			}
+		}
+		d.SetPartial("rule")
+	}
+	
+	// We succeeded, disable partial mode. This causes Terraform to save
+	// save all fields again.
+	d.Partial(false)
+
+	return nil
+}
+
+// renameSecurityGroup renames the Security Group id from oldname to
+// newname, after checking that id is still the group named oldname.
+func renameSecurityGroup(client *Client, id int, oldname, newname string) error {
+	var secgroup *SecurityGroup
+
+	resp, err := client.SecurityGroupInfo(id)
+	if err != nil {
+		return fmt.Errorf("Error renaming Security Group %d from %s to %s, it could not be found by ID: %s", id, oldname, newname, err)
+	}
+	if err = client.Decode(resp, &secgroup); err != nil {
+		return err
+	}
+	if secgroup.Name != oldname {
+		return fmt.Errorf("Error renaming Security Group %d from %s to %s: it is named %s", id, oldname, newname, secgroup.Name)
+	}
+
+	if _, err = client.SecurityGroupRename(id, newname); err != nil {
+		return fmt.Errorf("Error renaming Security Group %d from %s to %s: %s", id, oldname, newname, err)
+	}
+	log.Printf("[INFO] Successfully renamed Security Group %d from %s to %s\n", id, oldname, newname)
+	return nil
+}
+
+// updateSecurityGroupRules replaces the template of the Security Group id,
+// then commits it to the VMs using the group if commit is set: to all of
+// them if all is set, otherwise only to the outdated and errored ones. When
+// either call fails, the rules are not left half applied: the previous
+// template is restored (and committed again) and the error reports how that
+// went.
+func updateSecurityGroupRules(client *Client, id int, tpl string, commit, all bool) error {
+	previous, err := securityGroupTemplateXML(client, id)
+	if err != nil {
+		return fmt.Errorf("Could not save the rules of Security Group %d before updating them: %s", id, err)
+	}
+
+	resp, err := client.SecurityGroupUpdate(id, tpl, 0)
+	if err != nil {
+		return rollbackSecurityGroupRules(client, id, previous, false, all, fmt.Errorf("Error updating the rules of Security Group %d: %s", id, err))
+	}
+	log.Printf("[INFO] Successfully updated Security Group template %s\n", resp)
 
+	//Commit changes to running VMs if desired
+	if commit {
+		resp, err = client.SecurityGroupCommit(
+			id,
+			!all, //Recovery mode only updates outdated and errored VMs
+		)
 		if err != nil {
-			return err
+			return rollbackSecurityGroupRules(client, id, previous, true, all, fmt.Errorf("Error committing the rules of Security Group %d: %s", id, err))
+		}
+		if all {
+			log.Printf("[INFO] Successfully commited Security Group %s changes to all Virtual Machines\n", resp)
+		} else {
+			log.Printf("[INFO] Successfully commited Security Group %s changes to outdated Virtual Machines\n", resp)
 		}
+	}
 
-		log.Printf("[INFO] Successfully updated Security Group template %s\n", resp)
+	return nil
+}
 
+// secgroupCommitTimeout is the default time commits are waited for.
+const secgroupCommitTimeout = 10 * time.Minute
 
-		//Commit changes to running VMs if desired
-		if d.Get("commit") == true {
-			resp, err = client.Call(
-				"one.secgroup.commit",
-				objid,
-				false, //Only update outdated VMs not all
-			)
+// waitForSecurityGroupCommit waits for up to timeout for the Security Group
+// id to have no outdated or updating VMs left, failing with the IDs of the
+// VMs the rules could not be applied to.
+func waitForSecurityGroupCommit(client *Client, id int, timeout time.Duration) error {
+	var secgroup *SecurityGroup
 
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"updating"},
+		Target:  []string{"updated"},
+		Refresh: func() (interface{}, string, error) {
+			resp, err := client.SecurityGroupInfo(id)
 			if err != nil {
-				return err
+				return nil, "", err
+			}
+			secgroup = nil
+			if err = client.Decode(resp, &secgroup); err != nil {
+				return nil, "", err
+			}
+			if len(secgroup.ErrorVms) > 0 {
+				return secgroup, "error", fmt.Errorf("Security Group %d rules could not be applied to VMs %s", id, secgroupVmsString(secgroup.ErrorVms))
 			}
+			if pending := append(secgroup.OutdatedVms, secgroup.UpdatingVms...); len(pending) > 0 {
+				log.Printf("[DEBUG] Security Group %d rules are being applied to VMs %s", id, secgroupVmsString(pending))
+				return secgroup, "updating", nil
+			}
+			return secgroup, "updated", nil
+		},
+		Timeout:	timeout,
+		MinTimeout:	3 * time.Second,
+	}
 
-			log.Printf("[INFO] Successfully commited Security Group %s changes to outdated Virtual Machines\n", resp)
+	_, err := stateConf.WaitForState()
+	if _, ok := err.(*resource.TimeoutError); ok && secgroup != nil {
+		return fmt.Errorf("Security Group %d rules are still being applied to VMs %s after %s", id, secgroupVmsString(append(secgroup.OutdatedVms, secgroup.UpdatingVms...)), timeout)
+	}
+	return err
+}
+
+// secgroupVmsString returns the VM IDs ids as a comma separated list.
+func secgroupVmsString(ids []int) string {
+	vms := make([]string, 0, len(ids))
+	for _, id := range ids {
+		vms = append(vms, strconv.Itoa(id))
+	}
+	return strings.Join(vms, ", ")
+}
+
+// rollbackSecurityGroupRules restores the template previous of a Security
+// Group after cause made an update fail, and commits it again if needed, to
+// the same Virtual Machines as the failed commit.
+func rollbackSecurityGroupRules(client *Client, id int, previous string, commit, all bool, cause error) error {
+	log.Printf("[WARN] %s, restoring the previous rules", cause)
+
+	if _, err := client.SecurityGroupUpdate(id, previous, 0); err != nil {
+		return fmt.Errorf("%s. Restoring the previous rules failed too, the group may be left with the new or no rules: %s", cause, err)
+	}
+
+	if commit {
+		if _, err := client.SecurityGroupCommit(id, !all); err != nil {
+			return fmt.Errorf("%s. The previous rules were restored but could not be committed to the VMs: %s", cause, err)
 		}
+	}
 
+	return fmt.Errorf("%s. The previous rules were restored", cause)
+}
+
+// securityGroupTemplateXML returns the template of a Security Group as
+// given back to one.secgroup.update.
+func securityGroupTemplateXML(client *Client, id int) (string, error) {
+	var secgroup struct {
+		Template struct {
+			Content string `xml:",innerxml"`
+		} `xml:"TEMPLATE"`
 	}
-	
-	// We succeeded, disable partial mode. This causes Terraform to save
-	// save all fields again.
-	d.Partial(false)
 
-	return nil
+	resp, err := client.SecurityGroupInfo(id)
+	if err != nil {
+		return "", err
+	}
+	if err = client.Decode(resp, &secgroup); err != nil {
+		return "", err
+	}
+
+	return "<TEMPLATE>" + secgroup.Template.Content + "</TEMPLATE>", nil
 }
 
 func resourceSecurityGroupDelete(d *schema.ResourceData, meta interface{}) error {
@@ -389,7 +750,7 @@ func resourceSecurityGroupDelete(d *schema.ResourceData, meta interface{}) error
 	}
 
 	client := meta.(*Client)
-	resp, err := client.Call("one.secgroup.delete", intId(d.Id()))
+	resp, err := client.SecurityGroupDelete(intId(d.Id()))
 	if err != nil {
 		return err
 	}
@@ -398,10 +759,10 @@ func resourceSecurityGroupDelete(d *schema.ResourceData, meta interface{}) error
 	return nil
 }
 
-func generateSecurityGroupXML(d *schema.ResourceData) (string, error) {
+func generateSecurityGroupXML(d *schema.ResourceData, client *Client) (string, error) {
 
 	//Generate rules definition
-	rules := d.Get("rule").(*schema.Set).List()
+	rules := d.Get("rule").([]interface{})
 	log.Printf("Number of Security Group rules: %d", len(rules))
 	secgrouprules := make([]SecurityGroupRule, len(rules))
 
@@ -414,6 +775,7 @@ func generateSecurityGroupXML(d *schema.ResourceData) (string, error) {
 		var rulesize string
 		var rulerange string
 		var ruleicmptype string
+		var ruleicmpv6type string
 		var rulenetworkid string
 
 		
@@ -441,10 +803,23 @@ func generateSecurityGroupXML(d *schema.ResourceData) (string, error) {
 			ruleicmptype = ruleconfig["icmp_type"].(string)
 		}
 
+		if ruleconfig["icmpv6_type"] != nil {
+			ruleicmpv6type = ruleconfig["icmpv6_type"].(string)
+		}
+
 		if ruleconfig["network_id"] != nil {
 			rulenetworkid = ruleconfig["network_id"].(string)
 		}
 
+		//Resolve the VNET name to the ID OpenNebula expects
+		if name, ok := ruleconfig["network_name"].(string); ok && name != "" {
+			vn, err := dataVnetLookup(client, "", name, nil)
+			if err != nil {
+				return "", fmt.Errorf("Error resolving the network_name of Security Group rule %d: %s", i, err)
+			}
+			rulenetworkid = strconv.Itoa(vn.Id)
+		}
+
 		secgrouprule := SecurityGroupRule {
 			Protocol:		ruleprotocol,
 			RuleType:		ruletype,
@@ -452,6 +827,7 @@ func generateSecurityGroupXML(d *schema.ResourceData) (string, error) {
 			Size:			rulesize,
 			Range:			rulerange,
 			IcmpType:		ruleicmptype,
+			Icmpv6Type:		ruleicmpv6type,
 			NetworkId:		rulenetworkid,
 		}
 
@@ -0,0 +1,641 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	resource.AddTestSweepers("opennebula_secgroup", &resource.Sweeper{
+		Name:         "opennebula_secgroup",
+		Dependencies: []string{"opennebula_vm", "opennebula_vnet"},
+		F:            testSweepSecurityGroups,
+	})
+}
+
+func testSweepSecurityGroups(region string) error {
+	client, err := sharedClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.SecurityGroupPoolInfo(-3, -1, -1)
+	if err != nil {
+		return err
+	}
+
+	var secgroups SecurityGroups
+	if err = xml.Unmarshal([]byte(resp), &secgroups); err != nil {
+		return err
+	}
+
+	for _, s := range secgroups.SecurityGroup {
+		if !strings.HasPrefix(s.Name, testAccPrefix) {
+			continue
+		}
+
+		log.Printf("[INFO] Sweeping Security Group %s (%s)", s.Name, s.Id)
+		if _, err := client.SecurityGroupDelete(intId(s.Id)); err != nil {
+			log.Printf("[ERROR] Failed to sweep Security Group %s: %s", s.Id, err)
+		}
+	}
+
+	return nil
+}
+
+func TestAccSecurityGroup(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckSecurityGroupDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccSecurityGroupConfigBasic,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "name", "tf-acc-test-secgroup"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "permissions", "642"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "rule.#", "2"),
+					resource.TestCheckResourceAttrSet("opennebula_secgroup.test", "uid"),
+					resource.TestCheckResourceAttrSet("opennebula_secgroup.test", "gid"),
+				),
+			},
+			{
+				// The rules read back must match the configuration
+				Config:   testAccSecurityGroupConfigBasic,
+				PlanOnly: true,
+			},
+			{
+				ResourceName:            "opennebula_secgroup.test",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"commit", "commit_all", "wait_for_commit"},
+			},
+			{
+				Config: testAccSecurityGroupConfigUpdate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "name", "tf-acc-test-secgroup-renamed"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "gname", "users"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "permissions", "600"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "description", "Updated by the acceptance tests"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "rule.#", "4"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "rule.2.protocol", "ICMP"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "rule.3.icmpv6_type", "135"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "outdated_vms.#", "0"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.test", "updated_vms.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccSecurityGroupRuleOrder(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckSecurityGroupDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccSecurityGroupConfigMixed,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_secgroup.mixed", "rule.#", "5"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.mixed", "rule.0.protocol", "TCP"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.mixed", "rule.1.range", "53"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.mixed", "rule.2.icmp_type", "8"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.mixed", "rule.3.size", "256"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.mixed", "rule.4.rule_type", "OUTBOUND"),
+				),
+			},
+			{
+				Config:   testAccSecurityGroupConfigMixed,
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccSecurityGroupNetworkName(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckSecurityGroupDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccSecurityGroupConfigNetworkName,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair("opennebula_secgroup.network", "rule.0.network_id", "opennebula_vnet.test", "id"),
+					resource.TestCheckResourceAttr("opennebula_secgroup.network", "rule.0.network_name", "tf-acc-test-secgroup-vnet"),
+				),
+			},
+			{
+				Config:   testAccSecurityGroupConfigNetworkName,
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func testAccCheckSecurityGroupDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_secgroup" {
+			continue
+		}
+
+		_, err := client.SecurityGroupInfo(intId(rs.Primary.ID))
+		if err == nil {
+			return fmt.Errorf("Expected Security Group %s to have been destroyed", rs.Primary.ID)
+		}
+	}
+
+	return nil
+}
+
+func TestSecurityGroupVmLists(t *testing.T) {
+	var secgroup SecurityGroup
+	if err := xml.Unmarshal([]byte(testSecurityGroupInfo), &secgroup); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	lists := map[string][]int{
+		"UPDATED_VMS":  secgroup.UpdatedVms,
+		"OUTDATED_VMS": secgroup.OutdatedVms,
+		"UPDATING_VMS": secgroup.UpdatingVms,
+		"ERROR_VMS":    secgroup.ErrorVms,
+	}
+	expected := map[string][]int{
+		"UPDATED_VMS":  {10, 11},
+		"OUTDATED_VMS": {12},
+		"UPDATING_VMS": nil,
+		"ERROR_VMS":    {13, 14},
+	}
+
+	for k, v := range expected {
+		if !reflect.DeepEqual(lists[k], v) {
+			t.Errorf("Expected %s to be %v, got %v", k, v, lists[k])
+		}
+	}
+}
+
+func TestFlattenSecurityGroupRules(t *testing.T) {
+	var secgroup SecurityGroup
+	if err := xml.Unmarshal([]byte(testSecurityGroupInfo), &secgroup); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []map[string]interface{}{
+		{
+			"protocol":    "TCP",
+			"rule_type":   "INBOUND",
+			"ip":          "",
+			"size":        "",
+			"range":       "22",
+			"icmp_type":   "",
+			"icmpv6_type": "",
+			"network_id":  "",
+		},
+		{
+			"protocol":    "ICMPV6",
+			"rule_type":   "INBOUND",
+			"ip":          "",
+			"size":        "",
+			"range":       "",
+			"icmp_type":   "",
+			"icmpv6_type": "135",
+			"network_id":  "",
+		},
+	}
+
+	if rules := flattenSecurityGroupRules(secgroup.SecurityGroupTemplate.SecurityGroupRules); !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("Expected %v, got %v", expected, rules)
+	}
+}
+
+func TestRenameSecurityGroup(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.secgroup.info":
+			return []interface{}{true, "<SECURITY_GROUP><ID>100</ID><NAME>tf-acc-test-secgroup</NAME></SECURITY_GROUP>", int64(0)}, nil
+		case "one.secgroup.rename":
+			return []interface{}{true, int64(args[0].(int)), int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	err := renameSecurityGroup(client, 100, "tf-acc-test-other", "tf-acc-test-secgroup-renamed")
+	if err == nil || !strings.Contains(err.Error(), "it is named tf-acc-test-secgroup") {
+		t.Fatalf("Expected renaming another Security Group to fail, got: %v", err)
+	}
+	if calls := caller.callsTo("one.secgroup.rename"); len(calls) != 0 {
+		t.Fatalf("Expected no Security Group to be renamed, got %v", calls)
+	}
+
+	if err = renameSecurityGroup(client, 100, "tf-acc-test-secgroup", "tf-acc-test-secgroup-renamed"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if calls := caller.callsTo("one.secgroup.rename"); len(calls) != 1 || calls[0].Args[1] != "tf-acc-test-secgroup-renamed" {
+		t.Fatalf("Expected Security Group 100 to be renamed, got %v", calls)
+	}
+}
+
+func TestValidateSecurityGroupRule(t *testing.T) {
+	cases := []struct {
+		validate func(interface{}, string) ([]string, []error)
+		value    string
+		valid    bool
+	}{
+		{validateSecurityGroupRange, "22", true},
+		{validateSecurityGroupRange, "22,80:81,1024:65535", true},
+		{validateSecurityGroupRange, "80,443-", false},
+		{validateSecurityGroupRange, "80,", false},
+		{validateSecurityGroupRange, "0", false},
+		{validateSecurityGroupRange, "65536", false},
+		{validateSecurityGroupRange, "81:80", false},
+		{validateSecurityGroupRange, "1:2:3", false},
+		{validateSecurityGroupIP, "10.0.0.1", true},
+		{validateSecurityGroupIP, "2001:db8::1", true},
+		{validateSecurityGroupIP, "10.0.0", false},
+		{validateSecurityGroupNumber(1), "256", true},
+		{validateSecurityGroupNumber(1), "0", false},
+		{validateSecurityGroupNumber(1), "many", false},
+		{validateSecurityGroupNumber(0), "0", true},
+		{validateSecurityGroupNumber(0), "-1", false},
+	}
+
+	for _, c := range cases {
+		_, errors := c.validate(c.value, "rule.0.attr")
+		if valid := len(errors) == 0; valid != c.valid {
+			t.Errorf("Expected %q to be valid: %t, got errors %v", c.value, c.valid, errors)
+		}
+	}
+}
+
+func TestKeepSecurityGroupNetworkNames(t *testing.T) {
+	rules := []map[string]interface{}{
+		{"protocol": "ALL", "network_id": "5"},
+		{"protocol": "TCP", "network_id": "6"},
+		{"protocol": "UDP", "network_id": ""},
+	}
+	configured := []interface{}{
+		map[string]interface{}{"protocol": "ALL", "network_name": "private"},
+		map[string]interface{}{"protocol": "TCP", "network_id": "6", "network_name": ""},
+		map[string]interface{}{"protocol": "UDP", "network_name": "public"},
+	}
+
+	keepSecurityGroupNetworkNames(rules, configured)
+
+	expected := []interface{}{"private", nil, nil}
+	for i, name := range expected {
+		if rules[i]["network_name"] != name {
+			t.Errorf("Expected rule %d to have network_name %v, got %v", i, name, rules[i]["network_name"])
+		}
+	}
+}
+
+func TestCheckSecurityGroupNetworks(t *testing.T) {
+	rule := func(id, name string) interface{} {
+		return map[string]interface{}{"protocol": "ALL", "network_id": id, "network_name": name}
+	}
+
+	cases := []struct {
+		old   []interface{}
+		new   []interface{}
+		valid bool
+	}{
+		{nil, []interface{}{rule("5", "")}, true},
+		{nil, []interface{}{rule("", "private")}, true},
+		{nil, []interface{}{rule("5", "private")}, false},
+		// network_id resolved from network_name on the previous apply
+		{[]interface{}{rule("5", "private")}, []interface{}{rule("5", "private")}, true},
+		{[]interface{}{rule("5", "private")}, []interface{}{rule("5", "public")}, true},
+		{[]interface{}{rule("5", "private")}, []interface{}{rule("6", "private")}, false},
+		{[]interface{}{rule("5", "private")}, []interface{}{rule("5", "private"), rule("7", "public")}, false},
+	}
+
+	for i, c := range cases {
+		err := checkSecurityGroupNetworks(c.old, c.new)
+		if c.valid && err != nil {
+			t.Errorf("%d: Expected no error, got: %s", i, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%d: Expected an error", i)
+		}
+	}
+}
+
+func TestResourceSecurityGroupReadMissing(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.secgroup.info":
+			return []interface{}{false, "[one.secgroup.info] Error getting security group", int64(0x0400)}, nil
+		case "one.secgrouppool.info":
+			return []interface{}{true, "<SECURITY_GROUP_POOL><SECURITY_GROUP><ID>100</ID><NAME>tf-acc-test-secgroup</NAME></SECURITY_GROUP></SECURITY_GROUP_POOL>", int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	// Group 101 was deleted, group 100 has its name: it must not be
+	// adopted, or the next rename would apply to it
+	d := schema.TestResourceDataRaw(t, resourceSecurityGroup().Schema, map[string]interface{}{
+		"name": "tf-acc-test-secgroup",
+	})
+	d.SetId("101")
+
+	if err := resourceSecurityGroupRead(d, client); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if d.Id() != "" {
+		t.Fatalf("Expected the Security Group to be removed from state, got ID %s", d.Id())
+	}
+	if calls := caller.callsTo("one.secgrouppool.info"); len(calls) != 0 {
+		t.Fatalf("Expected no lookup by name, got %v", calls)
+	}
+}
+
+func TestWaitForSecurityGroupCommit(t *testing.T) {
+	infos := 0
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.secgroup.info" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		if args[0] == 101 {
+			return []interface{}{true, "<SECURITY_GROUP><ID>101</ID><UPDATED_VMS><ID>10</ID></UPDATED_VMS><ERROR_VMS><ID>13</ID><ID>14</ID></ERROR_VMS></SECURITY_GROUP>", int64(0)}, nil
+		}
+		infos++
+		if infos < 3 {
+			return []interface{}{true, "<SECURITY_GROUP><ID>100</ID><OUTDATED_VMS><ID>12</ID></OUTDATED_VMS><UPDATING_VMS><ID>11</ID></UPDATING_VMS></SECURITY_GROUP>", int64(0)}, nil
+		}
+		return []interface{}{true, "<SECURITY_GROUP><ID>100</ID><UPDATED_VMS><ID>11</ID><ID>12</ID></UPDATED_VMS><OUTDATED_VMS/><UPDATING_VMS/></SECURITY_GROUP>", int64(0)}, nil
+	})
+
+	if err := waitForSecurityGroupCommit(client, 100, time.Minute); err != nil {
+		t.Fatalf("Expected the rules of Security Group 100 to be applied, got: %s", err)
+	}
+	if infos != 3 {
+		t.Fatalf("Expected to wait for the outdated and updating VMs, got %d infos", infos)
+	}
+
+	err := waitForSecurityGroupCommit(client, 101, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "VMs 13, 14") {
+		t.Fatalf("Expected an error listing VMs 13 and 14, got: %v", err)
+	}
+}
+
+func TestUpdateSecurityGroupRulesRollback(t *testing.T) {
+	original := "<TEMPLATE><DESCRIPTION><![CDATA[Test group]]></DESCRIPTION><RULE><PROTOCOL><![CDATA[TCP]]></PROTOCOL><RANGE><![CDATA[22]]></RANGE><RULE_TYPE><![CDATA[inbound]]></RULE_TYPE></RULE></TEMPLATE>"
+	current := original
+	failCommit := false
+
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.secgroup.info":
+			return []interface{}{true, "<SECURITY_GROUP><ID>100</ID>" + current + "</SECURITY_GROUP>", int64(0)}, nil
+		case "one.secgroup.update":
+			// Rules with an invalid range are rejected, as done by oned
+			if strings.Contains(args[1].(string), "<RANGE>bad</RANGE>") {
+				return []interface{}{false, "[one.secgroup.update] Invalid RANGE", int64(0x1000)}, nil
+			}
+			current = args[1].(string)
+			return []interface{}{true, int64(100), int64(0)}, nil
+		case "one.secgroup.commit":
+			if failCommit {
+				return []interface{}{false, "[one.secgroup.commit] Cannot commit", int64(0x1000)}, nil
+			}
+			return []interface{}{true, int64(100), int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	rules := func() []SecurityGroupRule {
+		var secgroup SecurityGroup
+		if err := xml.Unmarshal([]byte("<SECURITY_GROUP>"+current+"</SECURITY_GROUP>"), &secgroup); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		return secgroup.SecurityGroupTemplate.SecurityGroupRules
+	}
+	expected := []SecurityGroupRule{{Protocol: "TCP", Range: "22", RuleType: "inbound"}}
+
+	bad := "<TEMPLATE><RULE><PROTOCOL>TCP</PROTOCOL><RANGE>bad</RANGE><RULE_TYPE>inbound</RULE_TYPE></RULE></TEMPLATE>"
+	err := updateSecurityGroupRules(client, 100, bad, false, true)
+	if err == nil || !strings.Contains(err.Error(), "Invalid RANGE") || !strings.Contains(err.Error(), "previous rules were restored") {
+		t.Fatalf("Expected the update error and the rollback outcome, got: %v", err)
+	}
+	if current != original || !reflect.DeepEqual(rules(), expected) {
+		t.Fatalf("Expected the original rules to be restored, got %s", current)
+	}
+
+	failCommit = true
+	good := "<TEMPLATE><RULE><PROTOCOL>UDP</PROTOCOL><RANGE>53</RANGE><RULE_TYPE>inbound</RULE_TYPE></RULE></TEMPLATE>"
+	err = updateSecurityGroupRules(client, 100, good, true, true)
+	if err == nil || !strings.Contains(err.Error(), "Cannot commit") || !strings.Contains(err.Error(), "could not be committed") {
+		t.Fatalf("Expected the commit error and the rollback outcome, got: %v", err)
+	}
+	if !reflect.DeepEqual(rules(), expected) {
+		t.Fatalf("Expected the original rules to be restored, got %s", current)
+	}
+
+	failCommit = false
+	if err = updateSecurityGroupRules(client, 100, good, true, true); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if r := rules(); len(r) != 1 || r[0].Protocol != "UDP" {
+		t.Fatalf("Expected the new rules, got %s", current)
+	}
+	if calls := caller.callsTo("one.secgroup.commit"); len(calls) != 3 {
+		t.Fatalf("Expected 3 commits, got %d", len(calls))
+	}
+}
+
+func TestUpdateSecurityGroupRulesCommitAll(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.secgroup.info":
+			return []interface{}{true, "<SECURITY_GROUP><ID>100</ID><TEMPLATE></TEMPLATE></SECURITY_GROUP>", int64(0)}, nil
+		case "one.secgroup.update", "one.secgroup.commit":
+			return []interface{}{true, int64(100), int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	tpl := "<TEMPLATE><RULE><PROTOCOL>ALL</PROTOCOL><RULE_TYPE>outbound</RULE_TYPE></RULE></TEMPLATE>"
+	for _, all := range []bool{true, false} {
+		if err := updateSecurityGroupRules(client, 100, tpl, true, all); err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+	}
+	if err := updateSecurityGroupRules(client, 100, tpl, false, true); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	calls := caller.callsTo("one.secgroup.commit")
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 commits, got %d", len(calls))
+	}
+	// The recovery argument restricts the commit to outdated and errored VMs
+	for i, recovery := range []bool{false, true} {
+		if calls[i].Args[1] != recovery {
+			t.Errorf("Expected commit %d to have recovery %t, got %v", i, recovery, calls[i].Args[1])
+		}
+	}
+}
+
+var testSecurityGroupInfo = `
+<SECURITY_GROUP>
+  <ID>100</ID>
+  <UID>0</UID>
+  <GID>0</GID>
+  <UNAME>oneadmin</UNAME>
+  <GNAME>oneadmin</GNAME>
+  <NAME>test-secgroup</NAME>
+  <PERMISSIONS>
+    <OWNER_U>1</OWNER_U>
+    <OWNER_M>1</OWNER_M>
+    <OWNER_A>0</OWNER_A>
+    <GROUP_U>0</GROUP_U>
+    <GROUP_M>0</GROUP_M>
+    <GROUP_A>0</GROUP_A>
+    <OTHER_U>0</OTHER_U>
+    <OTHER_M>0</OTHER_M>
+    <OTHER_A>0</OTHER_A>
+  </PERMISSIONS>
+  <UPDATED_VMS>
+    <ID>10</ID>
+    <ID>11</ID>
+  </UPDATED_VMS>
+  <OUTDATED_VMS>
+    <ID>12</ID>
+  </OUTDATED_VMS>
+  <UPDATING_VMS/>
+  <ERROR_VMS>
+    <ID>13</ID>
+    <ID>14</ID>
+  </ERROR_VMS>
+  <TEMPLATE>
+    <DESCRIPTION><![CDATA[Test group]]></DESCRIPTION>
+    <RULE>
+      <PROTOCOL><![CDATA[TCP]]></PROTOCOL>
+      <RANGE><![CDATA[22]]></RANGE>
+      <RULE_TYPE><![CDATA[inbound]]></RULE_TYPE>
+    </RULE>
+    <RULE>
+      <PROTOCOL><![CDATA[ICMPV6]]></PROTOCOL>
+      <ICMPV6_TYPE><![CDATA[135]]></ICMPV6_TYPE>
+      <RULE_TYPE><![CDATA[inbound]]></RULE_TYPE>
+    </RULE>
+  </TEMPLATE>
+</SECURITY_GROUP>
+`
+
+var testAccSecurityGroupConfigBasic = `
+resource "opennebula_secgroup" "test" {
+  name = "tf-acc-test-secgroup"
+  description = "Created by the acceptance tests"
+  permissions = "642"
+
+  rule {
+    protocol = "ALL"
+    rule_type = "OUTBOUND"
+  }
+
+  rule {
+    protocol = "TCP"
+    rule_type = "INBOUND"
+    range = "22"
+  }
+}
+`
+
+var testAccSecurityGroupConfigUpdate = `
+resource "opennebula_secgroup" "test" {
+  name = "tf-acc-test-secgroup-renamed"
+  description = "Updated by the acceptance tests"
+  permissions = "600"
+  group = "users"
+  wait_for_commit = true
+
+  rule {
+    protocol = "ALL"
+    rule_type = "OUTBOUND"
+  }
+
+  rule {
+    protocol = "TCP"
+    rule_type = "INBOUND"
+    range = "22"
+  }
+
+  rule {
+    protocol = "ICMP"
+    rule_type = "INBOUND"
+  }
+
+  rule {
+    protocol = "ICMPV6"
+    rule_type = "INBOUND"
+    icmpv6_type = "135"
+  }
+}
+`
+
+var testAccSecurityGroupConfigMixed = `
+resource "opennebula_secgroup" "mixed" {
+  name = "tf-acc-test-secgroup-mixed"
+
+  rule {
+    protocol = "TCP"
+    rule_type = "INBOUND"
+    range = "22,80:81"
+  }
+
+  rule {
+    protocol = "UDP"
+    rule_type = "INBOUND"
+    range = "53"
+  }
+
+  rule {
+    protocol = "ICMP"
+    rule_type = "INBOUND"
+    icmp_type = "8"
+  }
+
+  rule {
+    protocol = "ALL"
+    rule_type = "INBOUND"
+    ip = "192.168.0.0"
+    size = "256"
+  }
+
+  rule {
+    protocol = "ALL"
+    rule_type = "OUTBOUND"
+  }
+}
+`
+
+var testAccSecurityGroupConfigNetworkName = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-secgroup-vnet"
+  bridge = "br-test"
+  ip_start = "192.168.7.1"
+  ip_size = 10
+}
+
+resource "opennebula_secgroup" "network" {
+  name = "tf-acc-test-secgroup-network"
+
+  rule {
+    protocol = "ALL"
+    rule_type = "INBOUND"
+    network_name = "${opennebula_vnet.test.name}"
+  }
+}
+`
@@ -1,10 +1,10 @@
 package opennebula
 
 import (
-	"encoding/xml"
 	"fmt"
 	"github.com/hashicorp/terraform/helper/schema"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -22,15 +22,21 @@ type UserTemplate struct {
 	Gname       string       `xml:"GNAME"`
 	RegTime     int          `xml:"REGTIME"`
 	Permissions *Permissions `xml:"PERMISSIONS"`
+	Template    *VmTemplate  `xml:"TEMPLATE"`
 }
 
+// templateTypedArgs are the arguments defining a template like the VM
+// definition of opennebula_vm, rather than in description.
+var templateTypedArgs = []string{"cpu", "vcpu", "memory", "context", "nic", "disk", "graphics", "os", "raw"}
+
 func resourceTemplate() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceTemplateCreate,
-		Read:   resourceTemplateRead,
-		Exists: resourceTemplateExists,
-		Update: resourceTemplateUpdate,
-		Delete: resourceTemplateDelete,
+		Create:        resourceTemplateCreate,
+		Read:          resourceTemplateRead,
+		Exists:        resourceTemplateExists,
+		Update:        resourceTemplateUpdate,
+		Delete:        resourceTemplateDelete,
+		CustomizeDiff: resourceVersionsCustomizeDiff("opennebula_template"),
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
@@ -43,33 +49,161 @@ func resourceTemplate() *schema.Resource {
 			},
 			"description": {
 				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Description of the template, in OpenNebula's XML or String format",
+				Optional:    true,
+				Description: "Raw template contents, in OpenNebula's XML or String format. Added to the typed arguments, for the attributes they do not cover (String format only)",
 			},
-			"permissions": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "Permissions for the template (in Unix format, owner-group-other, use-manage-admin)",
-				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
-					value := v.(string)
-
-					if len(value) != 3 {
-						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
-					}
-
-					all := true
-					for _, c := range strings.Split(value, "") {
-						if c < "0" || c > "7" {
-							all = false
-						}
-					}
-					if !all {
-						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
-					}
-
-					return
+			"cpu": {
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				Description: "Amount of CPU quota of the VMs",
+			},
+			"vcpu": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Number of virtual CPUs of the VMs",
+			},
+			"memory": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Amount of memory (RAM) in MB of the VMs",
+			},
+			"context": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "Context variables",
+			},
+			"nic": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Network adapter(s) of the VMs, in the order of their NIC_ID (eth0, eth1...)",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"network_id": {
+							Type:     schema.TypeInt,
+							Required: true,
+						},
+						"ip": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateIP,
+						},
+						"mac": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateMAC,
+						},
+						"model": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"security_groups": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeInt,
+							},
+						},
+					},
 				},
 			},
+			"disk": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Disks of the VMs, the first one being the boot disk",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"image_id": {
+							Type:     schema.TypeInt,
+							Required: true,
+						},
+						"size": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Size of the disk in MB",
+						},
+						"target": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"driver": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
+			"graphics": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Graphics adapter of the VMs",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"listen": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"type": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+			"os": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "OS boot and type of the VMs",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"arch": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"boot": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+			"raw": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "RAW parameters of the VMs, one per hypervisor",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"data": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"type": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Hypervisor the section is for: kvm, vmware or vcenter",
+							ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+								if !in_array(strings.ToLower(v.(string)), rawTypes) {
+									errors = append(errors, fmt.Errorf("%q must be one of: %s", k, strings.Join(rawTypes, ", ")))
+								}
+								return
+							},
+						},
+						"validate": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "Have OpenNebula validate data against the libvirt schema (VALIDATE=YES)",
+						},
+					},
+				},
+			},
+			"permissions": {
+				Type:         schema.TypeString,
+				Required:     true,
+				Description:  "Permissions for the template (in Unix format, owner-group-other, use-manage-admin)",
+				ValidateFunc: validatePermissions,
+			},
 
 			"uid": {
 				Type:        schema.TypeInt,
@@ -103,9 +237,13 @@ func resourceTemplate() *schema.Resource {
 func resourceTemplateCreate(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
 
-	resp, err := client.Call(
-		"one.template.allocate",
-		fmt.Sprintf("NAME = \"%s\"\n", d.Get("name").(string))+d.Get("description").(string),
+	tmpl, err := templateDefinition(d)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.TemplateAllocate(
+		fmt.Sprintf("NAME = \"%s\"\n", d.Get("name").(string)) + tmpl,
 	)
 	if err != nil {
 		return err
@@ -113,7 +251,7 @@ func resourceTemplateCreate(d *schema.ResourceData, meta interface{}) error {
 
 	d.SetId(resp)
 
-	if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.template.chmod"); err != nil {
+	if _, err = client.TemplateChmod(intId(d.Id()), permission(d.Get("permissions").(string)), false); err != nil {
 		return err
 	}
 
@@ -129,10 +267,10 @@ func resourceTemplateRead(d *schema.ResourceData, meta interface{}) error {
 
 	// Try to find the template by ID, if specified
 	if d.Id() != "" {
-		resp, err := client.Call("one.template.info", intId(d.Id()), false)
+		resp, err := client.TemplateInfo(intId(d.Id()), false)
 		if err == nil {
 			found = true
-			if err = xml.Unmarshal([]byte(resp), &tmpl); err != nil {
+			if err = client.Decode(resp, &tmpl); err != nil {
 				return err
 			}
 		} else {
@@ -142,12 +280,12 @@ func resourceTemplateRead(d *schema.ResourceData, meta interface{}) error {
 
 	// Otherwise, try to find the template by (user, name) as the de facto compound primary key
 	if d.Id() == "" || !found {
-		resp, err := client.Call("one.templatepool.info", -3, -1, -1)
+		resp, err := client.TemplatePoolInfo(-3, -1, -1)
 		if err != nil {
 			return err
 		}
 
-		if err = xml.Unmarshal([]byte(resp), &tmpls); err != nil {
+		if err = client.Decode(resp, &tmpls); err != nil {
 			return err
 		}
 
@@ -175,6 +313,10 @@ func resourceTemplateRead(d *schema.ResourceData, meta interface{}) error {
 	d.Set("reg_time", tmpl.RegTime)
 	d.Set("permissions", permissionString(tmpl.Permissions))
 
+	if templateTyped(d) && tmpl.Template != nil {
+		setTemplateDefinition(d, tmpl)
+	}
+
 	return nil
 }
 
@@ -191,8 +333,7 @@ func resourceTemplateUpdate(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
 
 	if d.HasChange("name") {
-		resp, err := client.Call(
-			"one.template.rename",
+		resp, err := client.TemplateRename(
 			intId(d.Id()),
 			d.Get("name").(string),
 		)
@@ -202,11 +343,15 @@ func resourceTemplateUpdate(d *schema.ResourceData, meta interface{}) error {
 		log.Printf("[INFO] Successfully updated template name to %s\n", resp)
 	}
 
-	if d.HasChange("description") {
-		_, err := client.Call(
-			"one.template.update",
+	if d.HasChange("description") || templateTypedChange(d) {
+		tmpl, err := templateDefinition(d)
+		if err != nil {
+			return err
+		}
+
+		_, err = client.TemplateUpdate(
 			intId(d.Id()),
-			d.Get("description").(string),
+			tmpl,
 			0, // replace the whole template instead of merging it with the existing one
 		)
 		if err != nil {
@@ -215,7 +360,7 @@ func resourceTemplateUpdate(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	if d.HasChange("permissions") {
-		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.template.chmod")
+		resp, err := client.TemplateChmod(intId(d.Id()), permission(d.Get("permissions").(string)), false)
 		if err != nil {
 			return err
 		}
@@ -232,7 +377,7 @@ func resourceTemplateDelete(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	client := meta.(*Client)
-	resp, err := client.Call("one.template.delete", intId(d.Id()), false)
+	resp, err := client.TemplateDelete(intId(d.Id()), false)
 	if err != nil {
 		return err
 	}
@@ -240,3 +385,186 @@ func resourceTemplateDelete(d *schema.ResourceData, meta interface{}) error {
 	log.Printf("[INFO] Successfully deleted template %s\n", resp)
 	return nil
 }
+
+// templateTyped reports whether the template is defined with the typed
+// arguments, or is being imported. Templates only defined in description
+// are not read back, as before.
+func templateTyped(d *schema.ResourceData) bool {
+	if d.Get("description").(string) == "" {
+		return true
+	}
+	for _, arg := range templateTypedArgs {
+		if _, ok := d.GetOk(arg); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// templateTypedChange reports whether one of the typed arguments changed.
+func templateTypedChange(d *schema.ResourceData) bool {
+	for _, arg := range templateTypedArgs {
+		if d.HasChange(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// templateDefinition returns the contents of the template: the typed
+// arguments, built with the structs of the VM definition and rendered in
+// OpenNebula's String format, followed by description.
+func templateDefinition(d *schema.ResourceData) (string, error) {
+	description := d.Get("description").(string)
+
+	context := d.Get("context").(map[string]interface{})
+	if err := validateVmContext(context, false, false, false); err != nil {
+		return "", err
+	}
+	vmcontext := make(StringMap)
+	for key, value := range context {
+		vmcontext[key] = fmt.Sprint(value)
+	}
+
+	tpl := &VmTemplate{
+		CPU:         d.Get("cpu").(float64),
+		VCPU:        d.Get("vcpu").(int),
+		Memory:      d.Get("memory").(int),
+		ContextVars: vmcontext,
+		NICs:        vmNICs(d.Get("nic").([]interface{}), ""),
+		Disks:       vmDisks(d.Get("disk").([]interface{})),
+		Graphics:    vmGraphics(d.Get("graphics").([]interface{})),
+		OS:          vmOS(d.Get("os").([]interface{})),
+		RAW:         vmRAWs(d.Get("raw").([]interface{})),
+	}
+
+	typed := vmTemplateString(tpl)
+	if typed != "" && strings.HasPrefix(strings.TrimSpace(description), "<") {
+		return "", fmt.Errorf("description must be in OpenNebula's String format to be combined with %s", strings.Join(templateTypedArgs, ", "))
+	}
+	if typed == "" {
+		return description, nil
+	}
+	return typed + "\n" + description, nil
+}
+
+// vmTemplateString renders the CPU, VCPU, MEMORY, CONTEXT, NIC, DISK,
+// GRAPHICS, OS and RAW attributes of tpl in OpenNebula's String format,
+// leaving out those unset.
+func vmTemplateString(tpl *VmTemplate) string {
+	var attrs []string
+
+	if tpl.CPU != 0 {
+		attrs = append(attrs, templateAttribute("CPU", strconv.FormatFloat(tpl.CPU, 'f', -1, 64)))
+	}
+	if tpl.VCPU != 0 {
+		attrs = append(attrs, templateAttribute("VCPU", strconv.Itoa(tpl.VCPU)))
+	}
+	if tpl.Memory != 0 {
+		attrs = append(attrs, templateAttribute("MEMORY", strconv.Itoa(tpl.Memory)))
+	}
+
+	if len(tpl.ContextVars) > 0 {
+		keys := make([]string, 0, len(tpl.ContextVars))
+		for key := range tpl.ContextVars {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		context := make([]string, 0, len(keys))
+		for _, key := range keys {
+			context = append(context, key, tpl.ContextVars[key])
+		}
+		attrs = append(attrs, templateVector("CONTEXT", context...))
+	}
+
+	for _, nic := range tpl.NICs {
+		attrs = append(attrs, templateVector("NIC",
+			"NETWORK_ID", strconv.Itoa(nic.Network_ID),
+			"IP", nic.IP,
+			"MAC", nic.MAC,
+			"MODEL", nic.Model,
+			"SECURITY_GROUPS", nic.Security_Groups,
+		))
+	}
+
+	for _, disk := range tpl.Disks {
+		size := ""
+		if disk.Size != 0 {
+			size = strconv.Itoa(disk.Size)
+		}
+		attrs = append(attrs, templateVector("DISK",
+			"IMAGE_ID", strconv.Itoa(disk.Image_ID),
+			"SIZE", size,
+			"TARGET", disk.Target,
+			"DRIVER", disk.Driver,
+		))
+	}
+
+	if tpl.Graphics.Listen != "" || tpl.Graphics.Type != "" {
+		attrs = append(attrs, templateVector("GRAPHICS", "LISTEN", tpl.Graphics.Listen, "TYPE", tpl.Graphics.Type))
+	}
+	if tpl.OS.Arch != "" || tpl.OS.Boot != "" {
+		attrs = append(attrs, templateVector("OS", "ARCH", tpl.OS.Arch, "BOOT", tpl.OS.Boot))
+	}
+	for _, raw := range tpl.RAW {
+		attrs = append(attrs, templateVector("RAW", "TYPE", raw.Type, "DATA", raw.Data, "VALIDATE", raw.Validate))
+	}
+
+	return strings.Join(attrs, "\n")
+}
+
+// templateAttribute renders the attribute key=value in OpenNebula's String
+// format, escaping the double quotes of value.
+func templateAttribute(key, value string) string {
+	return fmt.Sprintf("%s=\"%s\"", key, strings.Replace(value, "\"", "\\\"", -1))
+}
+
+// templateVector renders the vector attribute key from the key/value pairs
+// of kv, leaving out the empty values.
+func templateVector(key string, kv ...string) string {
+	var values []string
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			values = append(values, templateAttribute(kv[i], kv[i+1]))
+		}
+	}
+	return fmt.Sprintf("%s=[\n  %s ]", key, strings.Join(values, ",\n  "))
+}
+
+// setTemplateDefinition sets the typed arguments from the contents of a
+// template.
+func setTemplateDefinition(d *schema.ResourceData, tmpl *UserTemplate) {
+	tpl := tmpl.Template
+
+	d.Set("cpu", tpl.CPU)
+	d.Set("vcpu", tpl.VCPU)
+	d.Set("memory", tpl.Memory)
+
+	values := map[string]interface{}{
+		"context":  flattenVmContext(tpl.ContextVars, d.Get("context").(map[string]interface{})),
+		"nic":      templateBlockFields(flattenVmNICs(&tpl.NICs), "network_id", "ip", "mac", "model", "security_groups"),
+		"disk":     templateBlockFields(flattenVmDisks(&tpl.Disks), "image_id", "size", "target", "driver"),
+		"graphics": flattenVmGraphics(&tpl.Graphics),
+		"os":       flattenVmOS(&tpl.OS),
+		"raw":      flattenVmRAW(tpl.RAW),
+	}
+	for k, v := range values {
+		if err := d.Set(k, v); err != nil {
+			log.Printf("[WARN] Error setting %s for template %d, error: %s", k, tmpl.Id, err)
+		}
+	}
+}
+
+// templateBlockFields drops the fields of the VM blocks read which templates
+// do not have, such as the IDs given to the NICs and disks of a VM.
+func templateBlockFields(blocks []interface{}, fields ...string) []interface{} {
+	for _, b := range blocks {
+		block := b.(map[string]interface{})
+		for key := range block {
+			if !in_array(key, fields) {
+				delete(block, key)
+			}
+		}
+	}
+	return blocks
+}
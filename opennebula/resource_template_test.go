@@ -5,11 +5,49 @@ import (
 	"fmt"
 	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/terraform"
+	"log"
 	"reflect"
 	"strings"
 	"testing"
 )
 
+func init() {
+	resource.AddTestSweepers("opennebula_template", &resource.Sweeper{
+		Name: "opennebula_template",
+		F:    testSweepTemplates,
+	})
+}
+
+func testSweepTemplates(region string) error {
+	client, err := sharedClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.TemplatePoolInfo(-3, -1, -1)
+	if err != nil {
+		return err
+	}
+
+	var tmpls UserTemplates
+	if err = xml.Unmarshal([]byte(resp), &tmpls); err != nil {
+		return err
+	}
+
+	for _, t := range tmpls.UserTemplate {
+		if !strings.HasPrefix(t.Name, testAccPrefix) {
+			continue
+		}
+
+		log.Printf("[INFO] Sweeping template %s (%d)", t.Name, t.Id)
+		if _, err := client.TemplateDelete(t.Id, false); err != nil {
+			log.Printf("[ERROR] Failed to sweep template %d: %s", t.Id, err)
+		}
+	}
+
+	return nil
+}
+
 func TestAccTemplate(t *testing.T) {
 	resource.Test(t, resource.TestCase{
 		PreCheck:     func() { testAccPreCheck(t) },
@@ -19,7 +57,7 @@ func TestAccTemplate(t *testing.T) {
 			{
 				Config: testAccTemplateConfigBasic,
 				Check: resource.ComposeTestCheckFunc(
-					resource.TestCheckResourceAttr("opennebula_template.test", "name", "test-me"),
+					resource.TestCheckResourceAttr("opennebula_template.test", "name", "tf-acc-test-template"),
 					resource.TestCheckResourceAttr("opennebula_template.test", "permissions", "642"),
 					resource.TestCheckResourceAttrSet("opennebula_template.test", "uid"),
 					resource.TestCheckResourceAttrSet("opennebula_template.test", "gid"),
@@ -35,6 +73,12 @@ func TestAccTemplate(t *testing.T) {
 					}),
 				),
 			},
+			{
+				ResourceName:            "opennebula_template.test",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"description"},
+			},
 			{
 				Config: testAccTemplateConfigUpdate,
 				Check: resource.ComposeTestCheckFunc(
@@ -50,11 +94,125 @@ func TestAccTemplate(t *testing.T) {
 	})
 }
 
+func TestAccTemplateTyped(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckTemplateDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccTemplateConfigTyped, 512),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_template.typed", "cpu", "0.5"),
+					resource.TestCheckResourceAttr("opennebula_template.typed", "memory", "512"),
+					resource.TestCheckResourceAttr("opennebula_template.typed", "context.%", "2"),
+					resource.TestCheckResourceAttr("opennebula_template.typed", "nic.0.model", "virtio"),
+					resource.TestCheckResourceAttr("opennebula_template.typed", "graphics.0.type", "VNC"),
+					resource.TestCheckResourceAttr("opennebula_template.typed", "raw.0.validate", "true"),
+					testAccCheckTemplateAttributes(map[string]string{"LOGO": "images/logos/linux.png"}),
+				),
+			},
+			{
+				Config:   fmt.Sprintf(testAccTemplateConfigTyped, 512),
+				PlanOnly: true,
+			},
+			{
+				ResourceName:            "opennebula_template.typed",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"description"},
+			},
+			{
+				Config: fmt.Sprintf(testAccTemplateConfigTyped, 1024),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_template.typed", "memory", "1024"),
+					testAccCheckTemplateAttributes(map[string]string{"MEMORY": "1024", "LOGO": "images/logos/linux.png"}),
+				),
+			},
+		},
+	})
+}
+
+func TestVmTemplateString(t *testing.T) {
+	tpl := &VmTemplate{
+		CPU:         0.5,
+		Memory:      512,
+		ContextVars: StringMap{"SSH_PUBLIC_KEY": "ssh-rsa AAAA", "NETWORK": "YES"},
+		NICs:        []VirtualMachineNIC{{Network_ID: 3, Model: "virtio", Security_Groups: "0,100"}},
+		Disks:       []VirtualMachineDisk{{Image_ID: 7, Driver: "qcow2"}},
+		Graphics:    VirtualMachineGraphics{Listen: "0.0.0.0", Type: "VNC"},
+		RAW:         []VirtualMachineRAW{{Type: "kvm", Data: `<cpu mode="host-passthrough"/>`, Validate: "YES"}},
+	}
+
+	expected := `CPU="0.5"
+MEMORY="512"
+CONTEXT=[
+  NETWORK="YES",
+  SSH_PUBLIC_KEY="ssh-rsa AAAA" ]
+NIC=[
+  NETWORK_ID="3",
+  MODEL="virtio",
+  SECURITY_GROUPS="0,100" ]
+DISK=[
+  IMAGE_ID="7",
+  DRIVER="qcow2" ]
+GRAPHICS=[
+  LISTEN="0.0.0.0",
+  TYPE="VNC" ]
+RAW=[
+  TYPE="kvm",
+  DATA="<cpu mode=\"host-passthrough\"/>",
+  VALIDATE="YES" ]`
+
+	if s := vmTemplateString(tpl); s != expected {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", expected, s)
+	}
+	if s := vmTemplateString(&VmTemplate{}); s != "" {
+		t.Fatalf("Expected an empty template, got %q", s)
+	}
+}
+
+func TestTemplateReadBack(t *testing.T) {
+	var tmpl UserTemplate
+	if err := xml.Unmarshal([]byte(testTemplateInfo), &tmpl); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tpl := tmpl.Template
+	if tpl.CPU != 0.5 || tpl.VCPU != 2 || tpl.Memory != 512 {
+		t.Fatalf("Expected the capacity to be read, got CPU %v VCPU %d MEMORY %d", tpl.CPU, tpl.VCPU, tpl.Memory)
+	}
+
+	nics := templateBlockFields(flattenVmNICs(&tpl.NICs), "network_id", "ip", "mac", "model", "security_groups")
+	expectedNics := []interface{}{
+		map[string]interface{}{"network_id": 3, "model": "virtio", "security_groups": []interface{}{0, 100}},
+	}
+	if !reflect.DeepEqual(nics, expectedNics) {
+		t.Fatalf("Expected NICs %v, got %v", expectedNics, nics)
+	}
+
+	disks := templateBlockFields(flattenVmDisks(&tpl.Disks), "image_id", "size", "target", "driver")
+	expectedDisks := []interface{}{
+		map[string]interface{}{"image_id": 7, "driver": "qcow2"},
+	}
+	if !reflect.DeepEqual(disks, expectedDisks) {
+		t.Fatalf("Expected disks %v, got %v", expectedDisks, disks)
+	}
+
+	raws := flattenVmRAW(tpl.RAW)
+	if len(raws) != 1 || raws[0].(map[string]interface{})["validate"] != true {
+		t.Fatalf("Expected a validated RAW section, got %v", raws)
+	}
+	if context := flattenVmContext(tpl.ContextVars, nil); !reflect.DeepEqual(context, map[string]interface{}{"NETWORK": "YES"}) {
+		t.Fatalf("Expected the context without the generated variables, got %v", context)
+	}
+}
+
 func testAccCheckTemplateDestroy(s *terraform.State) error {
 	client := testAccProvider.Meta().(*Client)
 
 	for _, rs := range s.RootModule().Resources {
-		_, err := client.Call("one.template.info", intId(rs.Primary.ID), false)
+		_, err := client.TemplateInfo(intId(rs.Primary.ID), false)
 		if err == nil {
 			return fmt.Errorf("Expected template %s to have been destroyed", rs.Primary.ID)
 		}
@@ -68,7 +226,7 @@ func testAccCheckTemplateAttributes(attrs map[string]string) resource.TestCheckF
 		client := testAccProvider.Meta().(*Client)
 
 		for _, rs := range s.RootModule().Resources {
-			resp, err := client.Call("one.template.info", intId(rs.Primary.ID), false)
+			resp, err := client.TemplateInfo(intId(rs.Primary.ID), false)
 			if err != nil {
 				return fmt.Errorf("Expected template %s to exist", rs.Primary.ID)
 			}
@@ -89,7 +247,7 @@ func testAccCheckTemplatePermissions(expected *Permissions) resource.TestCheckFu
 		client := testAccProvider.Meta().(*Client)
 
 		for _, rs := range s.RootModule().Resources {
-			resp, err := client.Call("one.template.info", intId(rs.Primary.ID), false)
+			resp, err := client.TemplateInfo(intId(rs.Primary.ID), false)
 			if err != nil {
 				return fmt.Errorf("Expected template %s to exist", rs.Primary.ID)
 			}
@@ -113,9 +271,45 @@ func testAccCheckTemplatePermissions(expected *Permissions) resource.TestCheckFu
 	}
 }
 
+var testTemplateInfo = `
+<VMTEMPLATE>
+  <ID>12</ID>
+  <UID>0</UID>
+  <GID>0</GID>
+  <UNAME>oneadmin</UNAME>
+  <GNAME>oneadmin</GNAME>
+  <NAME>tf-acc-test-template-typed</NAME>
+  <REGTIME>1601976427</REGTIME>
+  <TEMPLATE>
+    <CONTEXT>
+      <NETWORK><![CDATA[YES]]></NETWORK>
+      <TARGET><![CDATA[hda]]></TARGET>
+    </CONTEXT>
+    <CPU><![CDATA[0.5]]></CPU>
+    <DISK>
+      <DRIVER><![CDATA[qcow2]]></DRIVER>
+      <IMAGE_ID><![CDATA[7]]></IMAGE_ID>
+    </DISK>
+    <LOGO><![CDATA[images/logos/linux.png]]></LOGO>
+    <MEMORY><![CDATA[512]]></MEMORY>
+    <NIC>
+      <MODEL><![CDATA[virtio]]></MODEL>
+      <NETWORK_ID><![CDATA[3]]></NETWORK_ID>
+      <SECURITY_GROUPS><![CDATA[0,100]]></SECURITY_GROUPS>
+    </NIC>
+    <RAW>
+      <DATA><![CDATA[<cpu mode="host-passthrough"/>]]></DATA>
+      <TYPE><![CDATA[kvm]]></TYPE>
+      <VALIDATE><![CDATA[YES]]></VALIDATE>
+    </RAW>
+    <VCPU><![CDATA[2]]></VCPU>
+  </TEMPLATE>
+</VMTEMPLATE>
+`
+
 var testAccTemplateConfigBasic = `
 resource "opennebula_template" "test" {
-  name = "test-me"
+  name = "tf-acc-test-template"
   description = <<EOF
 	FOO = "bar"
   EOF
@@ -125,7 +319,7 @@ resource "opennebula_template" "test" {
 
 var testAccTemplateConfigUpdate = `
 resource "opennebula_template" "test" {
-  name = "test-me"
+  name = "tf-acc-test-template"
   description = <<EOF
 	FOO = "bar"
 	BAR = "foo"
@@ -133,3 +327,43 @@ resource "opennebula_template" "test" {
   permissions = "600"
 }
 `
+
+var testAccTemplateConfigTyped = `
+resource "opennebula_template" "typed" {
+  name = "tf-acc-test-template-typed"
+  permissions = "642"
+  cpu = 0.5
+  vcpu = 1
+  memory = %d
+
+  context {
+    NETWORK = "YES"
+    SET_HOSTNAME = "$NAME"
+  }
+
+  nic {
+    network_id = 0
+    model = "virtio"
+  }
+
+  graphics {
+    listen = "0.0.0.0"
+    type = "VNC"
+  }
+
+  os {
+    arch = "x86_64"
+    boot = "disk0"
+  }
+
+  raw {
+    type = "kvm"
+    data = "<cpu mode=\"host-passthrough\"/>"
+    validate = true
+  }
+
+  description = <<EOF
+	LOGO = "images/logos/linux.png"
+  EOF
+}
+`
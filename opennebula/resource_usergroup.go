@@ -1,7 +1,7 @@
 package opennebula
 
 import (
-  "encoding/xml"
+  "fmt"
   "log"
   "strconv"
 	"github.com/hashicorp/terraform/helper/schema"
@@ -32,10 +32,10 @@ func resourceUserRead(d *schema.ResourceData, meta interface{}) error {
 
 	// Try to find the user by ID, if specified
 	if d.Id() != "" {
-		resp, err := client.Call("one.user.info", intId(d.Id()), false)
+		resp, err := client.UserInfo(intId(d.Id()))
 		if err == nil {
 			found = true
-			if err = xml.Unmarshal([]byte(resp), &user); err != nil {
+			if err = client.Decode(resp, &user); err != nil {
 				return err
 			}
 		} else {
@@ -45,12 +45,12 @@ func resourceUserRead(d *schema.ResourceData, meta interface{}) error {
 
 	// Otherwise, try to find the user by name as the de facto compound primary key
 	if d.Id() == "" || !found {
-		resp, err := client.Call("one.userpool.info", false)
+		resp, err := client.UserPoolInfo()
 		if err != nil {
 			return err
 		}
 
-		if err = xml.Unmarshal([]byte(resp), &users); err != nil {
+		if err = client.Decode(resp, &users); err != nil {
 			return err
 		}
 
@@ -84,10 +84,10 @@ func resourceGroupRead(d *schema.ResourceData, meta interface{}) error {
 
 	// Try to find the user by ID, if specified
 	if d.Id() != "" {
-		resp, err := client.Call("one.group.info", intId(d.Id()), false)
+		resp, err := client.GroupInfo(intId(d.Id()))
 		if err == nil {
 			found = true
-			if err = xml.Unmarshal([]byte(resp), &group); err != nil {
+			if err = client.Decode(resp, &group); err != nil {
 				return err
 			}
 		} else {
@@ -97,12 +97,12 @@ func resourceGroupRead(d *schema.ResourceData, meta interface{}) error {
 
 	// Otherwise, try to find the user by name as the de facto compound primary key
 	if d.Id() == "" || !found {
-		resp, err := client.Call("one.grouppool.info", false)
+		resp, err := client.GroupPoolInfo()
 		if err != nil {
 			return err
 		}
 
-		if err = xml.Unmarshal([]byte(resp), &groups); err != nil {
+		if err = client.Decode(resp, &groups); err != nil {
 			return err
 		}
 
@@ -126,3 +126,38 @@ func resourceGroupRead(d *schema.ResourceData, meta interface{}) error {
 
 	return nil
 }
+
+// ownerGroup returns the ID of the group given by the group or gid arguments
+// of a resource, and whether either is set.
+func ownerGroup(d *schema.ResourceData, client *Client) (int, bool, error) {
+	if name, ok := d.GetOk("group"); ok {
+		gid, err := groupIdByName(client, name.(string))
+		return gid, true, err
+	}
+	if gid, ok := d.GetOkExists("gid"); ok {
+		return gid.(int), true, nil
+	}
+	return -1, false, nil
+}
+
+// groupIdByName returns the ID of the group called name.
+func groupIdByName(client *Client, name string) (int, error) {
+	var groups *Groups
+
+	resp, err := client.GroupPoolInfo()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = client.Decode(resp, &groups); err != nil {
+		return 0, err
+	}
+
+	for _, g := range groups.Group {
+		if g.Name == name {
+			return g.Id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Could not find group with name %s", name)
+}
@@ -1,12 +1,18 @@
 package opennebula
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/xml"
 	"fmt"
 	"github.com/hashicorp/terraform/helper/hashcode"
 	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
 	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"bytes"
@@ -24,7 +30,20 @@ type UserVm struct {
 	State           int          `xml:"STATE"`
 	LcmState        int          `xml:"LCM_STATE"`
 	VmTemplate      *VmTemplate  `xml:"TEMPLATE"`
-	VmUserTemplate  StringMap    `xml:"USER_TEMPLATE"`
+	VmUserTemplate  VmUserTemplate `xml:"USER_TEMPLATE"`
+	Monitoring      StringMap    `xml:"MONITORING"`
+	History         []VmHistory  `xml:"HISTORY_RECORDS>HISTORY"`
+	DiskSnapshots   []VmDiskSnapshots `xml:"SNAPSHOTS"`
+}
+
+// VmHistory is a record of the hosts a VM ran on, the last one being the
+// current host.
+type VmHistory struct {
+	Seq             int          `xml:"SEQ"`
+	HID             int          `xml:"HID"`
+	Hostname        string       `xml:"HOSTNAME"`
+	CID             int          `xml:"CID"`
+	DSID            int          `xml:"DS_ID"`
 }
 
 type UserVms struct {
@@ -35,6 +54,8 @@ type VmTemplate struct {
 	//Context *Context `xml:"CONTEXT"`
 	XMLName     xml.Name               `xml:"TEMPLATE"`
 	Name        string                 `xml:"NAME,omitempty"`
+	TemplateID  string                 `xml:"TEMPLATE_ID,omitempty"`
+	SchedRequirements string           `xml:"SCHED_REQUIREMENTS,omitempty"`
 	VCPU        int                    `xml:"VCPU"`
 	CPU         float64                `xml:"CPU"`
 	Memory      int                    `xml:"MEMORY"`
@@ -43,13 +64,23 @@ type VmTemplate struct {
 	Disks       []VirtualMachineDisk   `xml:"DISK"`
 	Graphics    VirtualMachineGraphics `xml:"GRAPHICS"`
 	OS          VirtualMachineOS       `xml:"OS"`
-	RAW         VirtualMachineRAW      `xml:"RAW"`
+	RAW         []VirtualMachineRAW    `xml:"RAW"`
+	Snapshots   []VmSnapshot           `xml:"SNAPSHOT"`
+	VMGroup     *VirtualMachineVMGroup `xml:"VMGROUP,omitempty"`
+}
+
+// VirtualMachineVMGroup is the role of a VM in a VM group.
+type VirtualMachineVMGroup struct {
+	VMGroup_ID int    `xml:"VMGROUP_ID"`
+	Role       string `xml:"ROLE"`
 }
 
 type VirtualMachineNIC struct {
 	XMLName          xml.Name    `xml:"NIC"`
 	NIC_ID           int         `xml:"NIC_ID,omitempty"`
 	IP               string      `xml:"IP,omitempty"`
+	IP6              string      `xml:"IP6,omitempty"`
+	IP6_Global       string      `xml:"IP6_GLOBAL,omitempty"`
 	Model            string      `xml:"MODEL,omitempty"`
 	MAC              string      `xml:"MAC,omitempty"`
 	Network_ID       int         `xml:"NETWORK_ID"`
@@ -63,6 +94,9 @@ type VirtualMachineDisk struct {
 	Size          int         `xml:"SIZE,omitempty"`
 	Target        string      `xml:"TARGET,omitempty"`
 	Driver        string      `xml:"DRIVER,omitempty"`
+	Datastore_ID  int         `xml:"DATASTORE_ID,omitempty"`
+	Datastore     string      `xml:"DATASTORE,omitempty"`
+	Persistent    string      `xml:"PERSISTENT,omitempty"`
 }
 
 type VirtualMachineGraphics struct {
@@ -78,8 +112,12 @@ type VirtualMachineOS struct {
 type VirtualMachineRAW struct {
 	Type       string        `xml:"TYPE,omitempty"`
 	Data       string        `xml:"DATA,omitempty"`
+	Validate   string        `xml:"VALIDATE,omitempty"`
 }
 
+// rawTypes are the hypervisors RAW sections can be written for.
+var rawTypes = []string{"kvm", "vmware", "vcenter"}
+
 
 //This type and the MarshalXML functions are needed to handle converting the CONTEXT map to xml and back
 //From: https://stackoverflow.com/questions/30928770/marshall-map-to-xml-in-go/33110881
@@ -133,6 +171,52 @@ func (m *StringMap) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 }
 
 
+// vmNicResource is the schema of the blocks of nic and ordered_nic.
+func vmNicResource() *schema.Resource {
+	return &schema.Resource {
+		Schema: map[string]*schema.Schema {
+			"ip": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				ValidateFunc: validateIP,
+			},
+			"mac": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				ForceNew:     true,
+				ValidateFunc: validateMAC,
+				Description:  "MAC address of the network adapter, e.g. 02:00:0a:00:00:01. Leased by OpenNebula if empty",
+			},
+			"model": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Model of the network adapter. If empty, the default_nic_model of the provider is used, or else the hypervisor driver default",
+			},
+			"network_id": {
+				Type:     schema.TypeInt,
+				Required: true,
+				ForceNew: true,
+			},
+			"nic_id": {
+				Type:     schema.TypeInt,
+				Computed: true,
+				ForceNew: true,
+			},
+			"security_groups": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Schema {
+					Type:	schema.TypeInt,
+				},
+			},
+		},
+	}
+}
+
 func resourceVm() *schema.Resource {
 	return &schema.Resource{
 		Create: resourceVmCreate,
@@ -142,64 +226,169 @@ func resourceVm() *schema.Resource {
 		Delete: resourceVmDelete,
 		CustomizeDiff: resourceVMCustomizeDiff,
 		Importer: &schema.ResourceImporter{
-			State: schema.ImportStatePassthrough,
+			State: resourceVmImportState,
 		},
 
 		Schema: map[string]*schema.Schema{
 			"name": {
-				Type:        schema.TypeString,
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"name_prefix"},
+				Description:   "Name of the VM. If empty, defaults to 'templatename-<vmid>'",
+			},
+			"name_prefix": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"name"},
+				Description:   "Prefix of a unique name generated for the VM on creation, kept in instance",
+			},
+			"name_unique": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Fail if another VM already has the same name when creating the VM",
+			},
+			"name_suffix_random": {
+				Type:        schema.TypeBool,
 				Optional:    true,
+				Default:     false,
 				ForceNew:    true,
-				Description: "Name of the VM. If empty, defaults to 'templatename-<vmid>'",
+				Description: "Append a random suffix to the name of the VM, kept in name_suffix",
+			},
+			"name_suffix": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Random suffix appended to the name of the VM when name_suffix_random is set",
 			},
 			"instance": {
 				Type:        schema.TypeString,
 				Computed:    true,
 				Description: "Final name of the VM instance",
 			},
+			"description": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Description of the VM, stored as DESCRIPTION in its user template",
+			},
+			"host_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "ID of the host to deploy the VM on, instead of letting the scheduler choose. Only used on creation, see deployed_host_id for where the VM runs",
+			},
+			"cluster_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "ID of the cluster the scheduler deploys the VM in, ignored when host_id is set. Only used on creation, see deployed_cluster_id for where the VM runs",
+			},
+			"deployed_host_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the host the VM runs on, which changes when the VM is migrated",
+			},
+			"deployed_cluster_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the cluster the VM runs in, which changes when the VM is migrated",
+			},
+			"vmgroup": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				MaxItems:    1,
+				Description: "VM group the VM joins, in the given role, for the affinity rules between roles",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"vmgroup_id": {
+							Type:        schema.TypeInt,
+							Required:    true,
+							ForceNew:    true,
+							Description: "ID of the VM group",
+						},
+						"role": {
+							Type:        schema.TypeString,
+							Required:    true,
+							ForceNew:    true,
+							Description: "Role of the VM in the VM group",
+						},
+					},
+				},
+			},
+			"system_datastore_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the system datastore the VM runs from",
+			},
 			"template_id": {
 				Type:        schema.TypeInt,
 				Optional:    true,
 				ForceNew:    true,
 				Description: "Id of the VM template to use. Either 'template_name' or 'template_id' is required",
-				ConflictsWith: []string{"disk", "graphics", "nic", "context", "os"},
+				ConflictsWith: []string{"disk", "graphics", "nic", "ordered_nic", "context", "os"},
+			},
+			"instantiate_persistent": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				ForceNew:    true,
+				Description: "Instantiate template_id with persistent copies of its images, for the disks of the VM to outlive it",
+			},
+			"delete_persistent_disks": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Delete the persistent images and the template copied by instantiate_persistent when the VM is deleted, instead of leaving them",
+			},
+			"persistent_image_ids": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "IDs of the persistent images copied by instantiate_persistent when the VM was created",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+			},
+			"persistent_template_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the template copied by instantiate_persistent when the VM was created",
+			},
+			"template_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Current name of the template the VM was instantiated from, empty when it can't be read",
 			},
 			"permissions": {
 				Type:        schema.TypeString,
 				Optional:    true,
 				Computed:    true,
 				Description: "Permissions for the template (in Unix format, owner-group-other, use-manage-admin)",
-				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
-					value := v.(string)
-
-					if len(value) != 3 {
-						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
-					}
-
-					all := true
-					for _, c := range strings.Split(value, "") {
-						if c < "0" || c > "7" {
-							all = false
-						}
-					}
-					if !all {
-						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
-					}
-
-					return
-				},
+				ValidateFunc: validatePermissions,
 			},
 
 			"uid": {
 				Type:        schema.TypeInt,
+				Optional:    true,
 				Computed:    true,
-				Description: "ID of the user that will own the VM",
+				Description: "ID of the user that will own the VM, instead of the user creating it",
 			},
 			"gid": {
 				Type:        schema.TypeInt,
+				Optional:    true,
 				Computed:    true,
+				ConflictsWith: []string{"group"},
 				Description: "ID of the group that will own the VM",
 			},
+			"group": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ConflictsWith: []string{"gid"},
+				Description: "Name of the group that will own the VM, instead of the primary group of the user",
+			},
 			"uname": {
 				Type:        schema.TypeString,
 				Computed:    true,
@@ -220,23 +409,56 @@ func resourceVm() *schema.Resource {
 				Computed:    true,
 				Description: "Current LCM state of the VM",
 			},
+			"state_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the current state of the VM, e.g. ACTIVE",
+			},
+			"lcm_state_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Name of the current LCM state of the VM, e.g. RUNNING",
+			},
+			"error_message": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Last error reported by OpenNebula for the VM, in the ERROR attribute of its user template",
+			},
 			"cpu": {
 				Type:        schema.TypeFloat,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "Amount of CPU quota assigned to the virtual machine",
+				Description: "Amount of CPU quota assigned to the virtual machine. Required without template_id, overrides the template otherwise",
 			},
 			"vcpu": {
 				Type:        schema.TypeInt,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "Number of virtual CPUs assigned to the virtual machine",
+				Description: "Number of virtual CPUs assigned to the virtual machine. Required without template_id, overrides the template otherwise",
 			},
 			"memory": {
 				Type:        schema.TypeInt,
-				Required:    true,
+				Optional:    true,
+				Computed:    true,
 				ForceNew:    true,
-				Description: "Amount of memory (RAM) in MB assigned to the virtual machine",
+				Description: "Amount of memory (RAM) in MB assigned to the virtual machine. Required without template_id, overrides the template otherwise",
+			},
+			"effective_cpu": {
+				Type:        schema.TypeFloat,
+				Computed:    true,
+				Description: "CPU quota the VM currently has, which differs from cpu when it was resized outside of Terraform",
+			},
+			"effective_vcpu": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Number of virtual CPUs the VM currently has",
+			},
+			"effective_memory": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Amount of memory (RAM) in MB the VM currently has",
 			},
 			"context": {
 				Type:        schema.TypeMap,
@@ -244,6 +466,105 @@ func resourceVm() *schema.Resource {
 				ForceNew:    true,
 				Description: "Context variables",
 			},
+			"context_files": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				ForceNew:    true,
+				ConflictsWith: []string{"template_id"},
+				Description: "IDs of CONTEXT images whose files are added to the context CD-ROM (FILES_DS)",
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+			},
+			"onegate": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				ForceNew:    true,
+				ConflictsWith: []string{"template_id"},
+				Description: "Give the VM a OneGate token (TOKEN=YES in its context)",
+			},
+			"report_ready": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				ForceNew:    true,
+				ConflictsWith: []string{"template_id"},
+				Description: "Have the VM report READY=YES through OneGate once contextualized (REPORT_READY=YES and TOKEN=YES in its context), and wait for it on creation",
+			},
+			"release_on_hold": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Release the VM when it is put on HOLD by OpenNebula, e.g. by a default of the cloud, while waiting for it to be RUNNING",
+			},
+			"wait_for_state": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Wait on creation for the VM to be RUNNING. Without it, ip may be empty until the next refresh, and boot failures only show then",
+			},
+			"wait_for_ready": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Wait on creation for the VM to report READY=YES through OneGate. Defaults to the value of report_ready",
+			},
+			"disk_saveas": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Disk saved as a new image before the VM is destroyed. The VM is powered off for the copy, and kept if it fails",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"disk_id": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     0,
+							Description: "ID of the disk to save, the first one by default",
+						},
+						"image_name": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Name of the image to create",
+						},
+						"image_type": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Type of the image to create, the type of the disk image by default",
+						},
+					},
+				},
+			},
+			"strict_id_lookup": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Only look the VM up by ID: a VM deleted out of band is recreated, instead of being looked up by name",
+			},
+			"recreate_on_failure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Recreate the VM when it is found in BOOT_FAILURE state",
+			},
+			"recover_on_failure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Retry the failed action of the VM (onevm recover --retry) when it is found in BOOT_FAILURE state, instead of recreating it",
+			},
+			"recover_retry_count": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     0,
+				Description: "Number of times to retry the failed action of the VM (onevm recover --retry) when it enters BOOT_FAILURE on creation, backing off exponentially between tries",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					if v.(int) < 0 {
+						errors = append(errors, fmt.Errorf("%q must not be negative", k))
+					}
+					return
+				},
+			},
 			"disk": {
 				Type:        schema.TypeSet,
 				Optional:    true,
@@ -251,29 +572,87 @@ func resourceVm() *schema.Resource {
 				MinItems:    1,
 				MaxItems:    8,
 				ConflictsWith: []string{"template_id"},
-				ForceNew:    true,
-				Description: "Definition of disks assigned to the Virtual Machine",
+				Description: "Definition of disks assigned to the Virtual Machine. Growing a disk resizes it in place, other changes recreate the VM",
 				Elem: &schema.Resource {
 					Schema: map[string]*schema.Schema {
 						"image_id": {
 							Type:     schema.TypeInt,
 							Required: true,
-							ForceNew: true,
 						},
 						"size": {
-							Type:     schema.TypeInt,
-							Optional: true,
-							ForceNew: true,
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Description: "Size of the disk in MB. It can only grow",
 						},
 						"target": {
 							Type:     schema.TypeString,
 							Optional: true,
-							ForceNew: true,
 						},
 						"driver": {
 							Type:     schema.TypeString,
 							Optional: true,
-							ForceNew: true,
+						},
+						"disk_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "DISK_ID of the disk in the VM",
+						},
+						"computed_size": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "Size of the disk in MB, inherited from the image unless size is set",
+						},
+						"datastore_id": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "ID of the datastore of the disk image",
+						},
+						"datastore": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Name of the datastore of the disk image",
+						},
+					},
+				},
+			},
+			"rendered_template": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Template sent to OpenNebula on creation: the VM XML, or the extra template given with template_id. Never refreshed",
+			},
+			"template_disk": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "Disks of the VM as read from OpenNebula, by DISK_ID, including those inherited from template_id",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"disk_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"image_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"size": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"target": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"driver": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"datastore_id": {
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"datastore": {
+							Type:     schema.TypeString,
+							Computed: true,
 						},
 					},
 				},
@@ -285,19 +664,16 @@ func resourceVm() *schema.Resource {
 				MinItems:    1,
 				MaxItems:    1,
 				ConflictsWith: []string{"template_id"},
-				ForceNew:    true,
-				Description: "Definition of graphics adapter assigned to the Virtual Machine",
+				Description: "Definition of graphics adapter assigned to the Virtual Machine. Changes are applied in place, powering off and resuming the VM if it runs",
 				Elem: &schema.Resource {
 					Schema: map[string]*schema.Schema {
 						"listen": {
 							Type:     schema.TypeString,
 							Required: true,
-							ForceNew: true,
 						},
 						"type": {
 							Type:     schema.TypeString,
 							Required: true,
-							ForceNew: true,
 						},
 					},
 				},
@@ -308,48 +684,22 @@ func resourceVm() *schema.Resource {
 				//Computed:    true,
 				MinItems:    1,
 				MaxItems:    8,
-				ConflictsWith: []string{"template_id"},
+				ConflictsWith: []string{"template_id", "ordered_nic"},
 				ForceNew:    true,
 				Description: "Definition of network adapter(s) assigned to the Virtual Machine",
-				Elem: &schema.Resource {
-					Schema: map[string]*schema.Schema {
-						"ip": {
-							Type:     schema.TypeString,
-							Optional: true,
-							ForceNew: true,
-						},
-						"mac": {
-							Type:     schema.TypeString,
-							Computed: true,
-							ForceNew: true,
-						},
-						"model": {
-							Type:     schema.TypeString,
-							Required: true,
-							ForceNew: true,
-						},
-						"network_id": {
-							Type:     schema.TypeInt,
-							Required: true,
-							ForceNew: true,
-						},
-						"nic_id": {
-							Type:     schema.TypeInt,
-							Computed: true,
-							ForceNew: true,
-						},
-						"security_groups": {
-							Type:     schema.TypeList,
-							Optional: true,
-							ForceNew: true,
-							Elem: &schema.Schema {
-								Type:	schema.TypeInt,
-							},
-						},
-					},
-				},
+				Elem: vmNicResource(),
 				Set: resourceVMNicHash,
 			},
+			"ordered_nic": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MinItems:    1,
+				MaxItems:    8,
+				ConflictsWith: []string{"template_id", "nic"},
+				ForceNew:    true,
+				Description: "Network adapter(s) assigned to the Virtual Machine in the order of their NIC_ID (eth0, eth1...), instead of nic",
+				Elem: vmNicResource(),
+			},
 			"os": {
 				Type:        schema.TypeSet,
 				Optional:    true,
@@ -379,10 +729,9 @@ func resourceVm() *schema.Resource {
 				Optional:    true,
 				//Computed:    true,
 				MinItems:    0,
-				MaxItems:    1,
 				ConflictsWith: []string{"template_id"},
 				ForceNew:    true,
-				Description: "Definition of RAW parameters for the Virtual Machine",
+				Description: "Definition of RAW parameters for the Virtual Machine, one per hypervisor",
 				Elem: &schema.Resource {
 					Schema: map[string]*schema.Schema {
 						"data": {
@@ -394,10 +743,30 @@ func resourceVm() *schema.Resource {
 							Type:     schema.TypeString,
 							Required: true,
 							ForceNew: true,
+							Description: "Hypervisor the section is for: kvm, vmware or vcenter",
+							ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+								if !in_array(strings.ToLower(v.(string)), rawTypes) {
+									errors = append(errors, fmt.Errorf("%q must be one of: %s", k, strings.Join(rawTypes, ", ")))
+								}
+								return
+							},
+						},
+						"validate": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							ForceNew:    true,
+							Description: "Have OpenNebula validate data against the libvirt schema (VALIDATE=YES)",
 						},
 					},
 				},
 			},
+			"sched_action": vmSchedActionSchema(),
+			"monitoring": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Description: "Latest monitoring sample of the VM (cpu, memory, netrx, nettx...), as of the last refresh. Point-in-time values, never part of a diff",
+			},
 			"ip": {
 				Type:        schema.TypeString,
 				Computed:    true,
@@ -410,30 +779,58 @@ func resourceVm() *schema.Resource {
 func resourceVmCreate(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
 
+	if prefix, ok := d.GetOk("name_prefix"); ok {
+		name, err := prefixedVmName(prefix.(string))
+		if err != nil {
+			return err
+		}
+		d.Set("instance", name)
+	}
+
+	//Checked again here as the plan may be old, and other VMs created since
+	if d.Get("name_unique").(bool) {
+		if err := checkVmNameUnique(client, vmInstanceName(d)); err != nil {
+			return err
+		}
+	}
+
+	//VMs for a given host are created on hold, then deployed on the host
+	hostID, deployOnHost := d.GetOkExists("host_id")
+
 	//Call one.template.instantiate only if template_id is defined
 	//otherwise use one.vm.allocate
 	var resp string
 	var err error
 	if v, ok := d.GetOk("template_id"); ok {
-		resp, err = client.Call(
-			"one.template.instantiate",
-			v,
-			d.Get("name"),
-			false,
-			"",
-			false,
+		extra := vmCapacityOverrides(d)
+		if requirements := vmSchedRequirements(d); requirements != "" {
+			extra = append(extra, fmt.Sprintf("SCHED_REQUIREMENTS = \"%s\"", requirements))
+		}
+		if vmgroup := vmGroupRole(d); vmgroup != nil {
+			extra = append(extra, fmt.Sprintf("VMGROUP = [ VMGROUP_ID = \"%d\", ROLE = \"%s\" ]", vmgroup.VMGroup_ID, vmgroup.Role))
+		}
+
+		rendered := strings.Join(extra, "\n")
+		d.Set("rendered_template", rendered)
+
+		resp, err = client.TemplateInstantiate(
+			v.(int),
+			vmInstanceName(d),
+			deployOnHost,
+			rendered,
+			d.Get("instantiate_persistent").(bool),
 		)
 
 	} else {
-		vmxml, xmlerr := generateVmXML(d)
+		vmxml, xmlerr := generateVmXML(d, client.DefaultNicModel)
 		if xmlerr != nil {
 			return xmlerr
 		}
+		d.Set("rendered_template", vmxml)
 
-		resp, err = client.Call(
-			"one.vm.allocate",
+		resp, err = client.VmAllocate(
 			vmxml,
-			false,
+			deployOnHost,
 		)
 	}
 
@@ -443,15 +840,66 @@ func resourceVmCreate(d *schema.ResourceData, meta interface{}) error {
 
 	d.SetId(resp)
 
-	_, err = waitForVmState(d, meta, "running")
-	if err != nil {
-		return fmt.Errorf(
-			"Error waiting for virtual machine (%s) to be in state RUNNING: %s", d.Id(), err)
+	//The copies made by instantiate_persistent are recorded for
+	//delete_persistent_disks, disks attached later are not theirs to delete
+	if d.Get("instantiate_persistent").(bool) {
+		if err = setVmPersistentCopies(d, client); err != nil {
+			return err
+		}
 	}
 
-	//Set the permissions on the VM if it was defined, otherwise use the UMASK in OpenNebula
+	if deployOnHost {
+		if _, err = client.VmDeploy(intId(d.Id()), hostID.(int), false, -1); err != nil {
+			return fmt.Errorf("Error deploying virtual machine (%s) on host %d: %s", d.Id(), hostID.(int), err)
+		}
+	}
+
+	//Without wait_for_state, boot failures only show on the next refresh,
+	//through lcmstate and recreate_on_failure or recover_on_failure
+	if d.Get("wait_for_state").(bool) {
+		if err = waitForVmRunning(d, meta); err != nil {
+			return fmt.Errorf(
+				"Error waiting for virtual machine (%s) to be in state RUNNING: %s", d.Id(), err)
+		}
+	}
+
+	if vmWaitForReady(d) {
+		if _, err = waitForVmReady(d, meta); err != nil {
+			return fmt.Errorf(
+				"Error waiting for virtual machine (%s) to report READY through OneGate: %s", d.Id(), err)
+		}
+	}
+
+	//Set the permissions on the VM if it was defined, otherwise use the UMASK in OpenNebula
 	if _, ok := d.GetOk("permissions"); ok {
-		if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vm.chmod"); err != nil {
+		if _, err = client.VmChmod(intId(d.Id()), permission(d.Get("permissions").(string))); err != nil {
+			return err
+		}
+	}
+
+	//Hand the VM over to the user and group if they were defined
+	uid := -1
+	if v, ok := d.GetOkExists("uid"); ok {
+		uid = v.(int)
+	}
+	gid, _, err := ownerGroup(d, client)
+	if err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if _, err = client.VmChown(intId(d.Id()), uid, gid); err != nil {
+			return err
+		}
+	}
+
+	if description, ok := d.GetOk("description"); ok {
+		if err = updateVmDescription(client, intId(d.Id()), description.(string)); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := d.GetOk("sched_action"); ok {
+		if err = updateVmSchedActions(d, client); err != nil {
 			return err
 		}
 	}
@@ -461,52 +909,53 @@ func resourceVmCreate(d *schema.ResourceData, meta interface{}) error {
 
 func resourceVmRead(d *schema.ResourceData, meta interface{}) error {
 	var vm *UserVm
-	var vms *UserVms
 
 	client := meta.(*Client)
 	found := false
-	name := d.Get("name").(string)
+	name := vmInstanceName(d)
 	if name == "" {
 		name = d.Get("instance").(string)
 	}
 
 	// Try to find the vm by ID, if specified
 	if d.Id() != "" {
-		resp, err := client.Call("one.vm.info", intId(d.Id()))
+		resp, err := retryTransport(func() (string, error) {
+			return client.VmInfo(intId(d.Id()))
+		})
 		if err == nil {
 			found = true
-			if err = xml.Unmarshal([]byte(resp), &vm); err != nil {
+			if err = client.Decode(resp, &vm); err != nil {
 				return err
 			}
+		} else if _, ok := err.(*ResponseError); !ok {
+			// A VM that can't be reached isn't gone, and mustn't be
+			// removed from the state or looked up by name
+			return err
 		} else {
 			log.Printf("Could not find VM by ID %s", d.Id())
 		}
 	}
 
+	// A VM missing by ID is gone for good with strict_id_lookup
+	if d.Id() != "" && !found && d.Get("strict_id_lookup").(bool) {
+		log.Printf("[WARN] VM %s not found, removing it from state", d.Id())
+		d.SetId("")
+		return nil
+	}
+
 	// Otherwise, try to find the vm by (user, name) as the de facto compound primary key
 	if d.Id() == "" || !found {
-		resp, err := client.Call("one.vmpool.info", -3, -1, -1)
+		v, err := ownVmByName(client, name)
 		if err != nil {
 			return err
 		}
 
-		if err = xml.Unmarshal([]byte(resp), &vms); err != nil {
-			return err
-		}
-
-		for _, v := range vms.UserVm {
-			if v.Name == name {
-				vm = v
-				found = true
-				break
-			}
-		}
-
-		if !found || vm == nil {
+		if v == nil {
 			d.SetId("")
 			log.Printf("Could not find vm with name %s for user %s", name, client.Username)
 			return nil
 		}
+		vm = v
 	}
 
 	d.SetId(vm.Id)
@@ -515,21 +964,197 @@ func resourceVmRead(d *schema.ResourceData, meta interface{}) error {
 	d.Set("gid", vm.Gid)
 	d.Set("uname", vm.Uname)
 	d.Set("gname", vm.Gname)
+	if d.Get("group").(string) != "" {
+		d.Set("group", vm.Gname)
+	}
 	d.Set("state", vm.State)
 	d.Set("lcmstate", vm.LcmState)
+	d.Set("state_name", vmStateName(vm.State))
+	d.Set("lcm_state_name", vmLcmStateName(vm.LcmState))
+	d.Set("error_message", vm.VmUserTemplate.Get("ERROR"))
 	//TODO fix this:
 	//d.Set("ip", vm.VmTemplate.Context.IP)
 	d.Set("permissions", permissionString(vm.Permissions))
 
-	//Pull in NIC config from OpenNebula into schema
-	if vm.VmTemplate.NICs != nil {
-		d.Set("nic", flattenVmNICs(&vm.VmTemplate.NICs))
-		d.Set("ip", &vm.VmTemplate.NICs[0].IP)
+	//Pull in the VM definition from OpenNebula into schema. The definition
+	//of template based VMs comes from the template, not from the configuration,
+	//only their capacity is read back
+	if templateID, ok := vmTemplateID(vm); ok {
+		// VMs instantiated with instantiate_persistent run from a copy of
+		// template_id, which is kept unless importing
+		if copyID, ok := d.GetOk("persistent_template_id"); ok && copyID.(int) == templateID {
+			if configured, ok := d.GetOk("template_id"); ok {
+				templateID = configured.(int)
+			}
+		}
+		d.Set("template_id", templateID)
+		d.Set("template_name", vmTemplateName(client, templateID))
+		setVmCapacity(d, vm)
+	} else if _, ok := d.GetOk("template_id"); !ok {
+		setVmDefinition(d, vm)
+	}
+
+	d.Set("effective_cpu", vm.VmTemplate.CPU)
+	d.Set("effective_vcpu", vm.VmTemplate.VCPU)
+	d.Set("effective_memory", vm.VmTemplate.Memory)
+
+	d.Set("ip", vmPrimaryIP(vm.VmTemplate.NICs))
+	if n := len(vm.History); n > 0 {
+		d.Set("deployed_host_id", vm.History[n-1].HID)
+		d.Set("deployed_cluster_id", vm.History[n-1].CID)
+		d.Set("system_datastore_id", vm.History[n-1].DSID)
+	}
+
+	if err := d.Set("template_disk", flattenVmTemplateDisks(vm.VmTemplate.Disks)); err != nil {
+		log.Printf("[WARN] Error setting template_disk for VM %s, error: %s", vm.Id, err)
+	}
+	if err := d.Set("vmgroup", flattenVmGroupRole(vm.VmTemplate.VMGroup)); err != nil {
+		log.Printf("[WARN] Error setting vmgroup for VM %s, error: %s", vm.Id, err)
+	}
+	d.Set("description", vm.VmUserTemplate.Get("DESCRIPTION"))
+	if err := d.Set("sched_action", flattenVmSchedActions(vm.VmUserTemplate.SchedActions)); err != nil {
+		log.Printf("[WARN] Error setting sched_action for VM %s, error: %s", vm.Id, err)
+	}
+	if err := d.Set("monitoring", vmMonitoring(client, vm)); err != nil {
+		log.Printf("[WARN] Error setting monitoring for VM %s, error: %s", vm.Id, err)
 	}
 
 	return nil
 }
 
+// vmTemplateID returns the ID of the template a VM was instantiated from,
+// from TEMPLATE_ID in its template or else in its user template.
+func vmTemplateID(vm *UserVm) (int, bool) {
+	id := vm.VmTemplate.TemplateID
+	if id == "" {
+		id = vm.VmUserTemplate.Get("TEMPLATE_ID")
+	}
+
+	templateID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, false
+	}
+	return templateID, true
+}
+
+// vmTemplateName returns the name of a template, or "" when it was deleted
+// or can't be read by the user.
+func vmTemplateName(client *Client, id int) string {
+	var tmpl *UserTemplate
+
+	resp, err := client.TemplateInfo(id, false)
+	if err == nil {
+		err = client.Decode(resp, &tmpl)
+	}
+	if err != nil {
+		log.Printf("[WARN] Could not read template %d: %s", id, err)
+		return ""
+	}
+	return tmpl.Name
+}
+
+// setVmCapacity sets the capacity of a template based VM. Values already in
+// state are kept, as they were either declared, and passed on instantiation,
+// or read before: cpu, vcpu and memory force a new VM, so a resize outside
+// of Terraform only shows in the effective_ attributes.
+func setVmCapacity(d *schema.ResourceData, vm *UserVm) {
+	tpl := vm.VmTemplate
+
+	if d.Get("cpu").(float64) == 0 {
+		d.Set("cpu", tpl.CPU)
+	}
+	if d.Get("vcpu").(int) == 0 {
+		d.Set("vcpu", tpl.VCPU)
+	}
+	if d.Get("memory").(int) == 0 {
+		d.Set("memory", tpl.Memory)
+	}
+}
+
+// setVmDefinition sets the capacity, nic, disk, graphics, os, raw and
+// context arguments from the template of a VM created without template_id.
+func setVmDefinition(d *schema.ResourceData, vm *UserVm) {
+	tpl := vm.VmTemplate
+
+	d.Set("cpu", tpl.CPU)
+	d.Set("vcpu", tpl.VCPU)
+	d.Set("memory", tpl.Memory)
+
+	//ordered_nic, when declared, lists the NICs by NIC_ID
+	nicKey := "nic"
+	var nics []interface{}
+	if declared, ok := d.GetOk("ordered_nic"); ok {
+		nicKey = "ordered_nic"
+		nics = flattenVmNICs(orderedVmNICs(tpl.NICs))
+		nics = keepDeclaredFields(nics, declared.([]interface{}), "network_id", []string{"ip", "mac", "model", "security_groups"})
+	} else {
+		nics = flattenVmNICs(&tpl.NICs)
+		if declared, ok := d.GetOk("nic"); ok {
+			nics = keepDeclaredFields(nics, declared.(*schema.Set).List(), "network_id", []string{"ip", "mac", "model", "security_groups"})
+		}
+	}
+	disks := flattenVmDisks(&tpl.Disks)
+	if declared, ok := d.GetOk("disk"); ok {
+		disks = keepDeclaredFields(disks, declared.(*schema.Set).List(), "image_id", []string{"size", "target", "driver"})
+	}
+
+	declaredContext := d.Get("context").(map[string]interface{})
+	context := flattenVmContext(tpl.ContextVars, declaredContext)
+
+	//report_ready injects TOKEN as well, onegate is then kept as configured
+	reportReady := contextFlag(tpl.ContextVars, declaredContext, "REPORT_READY")
+	onegate := contextFlag(tpl.ContextVars, declaredContext, "TOKEN")
+	if reportReady {
+		onegate = d.Get("onegate").(bool)
+	}
+	d.Set("onegate", onegate)
+	d.Set("report_ready", reportReady)
+
+	values := map[string]interface{}{
+		nicKey:     nics,
+		"disk":     disks,
+		"graphics": flattenVmGraphics(&tpl.Graphics),
+		"os":       flattenVmOS(&tpl.OS),
+		"raw":      flattenVmRAW(tpl.RAW),
+		"context":  context,
+	}
+	for k, v := range values {
+		if err := d.Set(k, v); err != nil {
+			log.Printf("[WARN] Error setting %s for VM %s, error: %s", k, vm.Id, err)
+		}
+	}
+}
+
+// orderedVmNICs returns the NICs of a VM sorted by NIC_ID.
+func orderedVmNICs(nics []VirtualMachineNIC) *[]VirtualMachineNIC {
+	ordered := make([]VirtualMachineNIC, len(nics))
+	copy(ordered, nics)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].NIC_ID < ordered[j].NIC_ID
+	})
+	return &ordered
+}
+
+// vmPrimaryIP returns the first IPv4 address leased to the NICs of a VM,
+// skipping NICs without one (e.g. on ETHER address ranges). VMs only on
+// IPv6 networks get their first IPv6 address instead.
+func vmPrimaryIP(nics []VirtualMachineNIC) string {
+	for _, nic := range nics {
+		if nic.IP != "" {
+			return nic.IP
+		}
+	}
+	for _, nic := range nics {
+		if nic.IP6 != "" {
+			return nic.IP6
+		}
+		if nic.IP6_Global != "" {
+			return nic.IP6_Global
+		}
+	}
+	return ""
+}
+
 func flattenVmNICs(nics *[]VirtualMachineNIC) []interface{} {
 	result := make([]interface{}, 0, len(*nics))
 	for _, nic := range *nics {
@@ -544,14 +1169,16 @@ func flattenVmNICs(nics *[]VirtualMachineNIC) []interface{} {
 		if nic.Model != "" {
 			nicConfig["model"] = nic.Model
 		}
-		if nic.Network_ID != 0 {
-			nicConfig["network_id"] = nic.Network_ID
-		}
-		if nic.NIC_ID != 0 {
-			nicConfig["nic_id"] = nic.NIC_ID
-		}
+		nicConfig["network_id"] = nic.Network_ID
+		nicConfig["nic_id"] = nic.NIC_ID
 		if nic.Security_Groups != "" {
-			nicConfig["security_groups"] = nic.Security_Groups
+			secgroups := make([]interface{}, 0)
+			for _, sg := range strings.Split(nic.Security_Groups, ",") {
+				if id, err := strconv.Atoi(sg); err == nil {
+					secgroups = append(secgroups, id)
+				}
+			}
+			nicConfig["security_groups"] = secgroups
 		}
 
 		result = append(result, nicConfig)
@@ -559,6 +1186,219 @@ func flattenVmNICs(nics *[]VirtualMachineNIC) []interface{} {
 	return result
 }
 
+func flattenVmDisks(disks *[]VirtualMachineDisk) []interface{} {
+	result := make([]interface{}, 0, len(*disks))
+	for _, disk := range *disks {
+		diskConfig := make(map[string]interface{})
+
+		diskConfig["image_id"] = disk.Image_ID
+		if id, err := strconv.Atoi(disk.Disk_ID); err == nil {
+			diskConfig["disk_id"] = id
+		}
+		if disk.Size != 0 {
+			diskConfig["size"] = disk.Size
+			diskConfig["computed_size"] = disk.Size
+		}
+		if disk.Target != "" {
+			diskConfig["target"] = disk.Target
+		}
+		if disk.Driver != "" {
+			diskConfig["driver"] = disk.Driver
+		}
+		// Volatile disks have no image, and live in the system datastore
+		if disk.Datastore != "" {
+			diskConfig["datastore_id"] = disk.Datastore_ID
+			diskConfig["datastore"] = disk.Datastore
+		}
+
+		result = append(result, diskConfig)
+	}
+	return result
+}
+
+// flattenVmTemplateDisks lists the disks of a VM by DISK_ID, for the
+// template_disk attribute.
+func flattenVmTemplateDisks(disks []VirtualMachineDisk) []interface{} {
+	ordered := make([]VirtualMachineDisk, len(disks))
+	copy(ordered, disks)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, _ := strconv.Atoi(ordered[i].Disk_ID)
+		b, _ := strconv.Atoi(ordered[j].Disk_ID)
+		return a < b
+	})
+
+	result := flattenVmDisks(&ordered)
+	for _, disk := range result {
+		delete(disk.(map[string]interface{}), "computed_size")
+	}
+	return result
+}
+
+func flattenVmGraphics(graphics *VirtualMachineGraphics) []interface{} {
+	if graphics.Listen == "" && graphics.Type == "" {
+		return []interface{}{}
+	}
+
+	return []interface{}{
+		map[string]interface{}{
+			"listen": graphics.Listen,
+			"type":   graphics.Type,
+		},
+	}
+}
+
+func flattenVmOS(os *VirtualMachineOS) []interface{} {
+	if os.Arch == "" && os.Boot == "" {
+		return []interface{}{}
+	}
+
+	return []interface{}{
+		map[string]interface{}{
+			"arch": os.Arch,
+			"boot": os.Boot,
+		},
+	}
+}
+
+func flattenVmRAW(raws []VirtualMachineRAW) []interface{} {
+	result := make([]interface{}, 0, len(raws))
+	for _, raw := range raws {
+		if raw.Type == "" && raw.Data == "" {
+			continue
+		}
+
+		result = append(result, map[string]interface{}{
+			"type":     raw.Type,
+			"data":     raw.Data,
+			"validate": strings.ToUpper(raw.Validate) == "YES",
+		})
+	}
+	return result
+}
+
+// vmGeneratedContext matches the context variables OpenNebula generates
+// itself, from the NICs and disks of the VM or for OneGate, and FILES_DS in
+// which OpenNebula replaces the images of context_files by their paths.
+var vmGeneratedContext = regexp.MustCompile(`^(ETH[0-9]+_.*|DISK_ID|TARGET|VMID|ONEGATE_ENDPOINT|TOKEN|REPORT_READY|FILES_DS)$`)
+
+// flattenVmContext returns the context map to set in state. OpenNebula
+// upper-cases the variable names, so declared variables are matched
+// case-insensitively and keep the name used in the configuration. Declared
+// values referencing other variables ($NAME) are substituted by OpenNebula
+// and kept as declared. When the configuration declares variables, the other
+// ones are left out; otherwise (e.g. on import) everything but the generated
+// variables is kept.
+func flattenVmContext(context StringMap, declared map[string]interface{}) map[string]interface{} {
+	names := make(map[string]string)
+	for key := range declared {
+		names[strings.ToUpper(key)] = key
+	}
+
+	result := make(map[string]interface{})
+	for key, value := range context {
+		if name, ok := names[strings.ToUpper(key)]; ok {
+			if declaredValue := fmt.Sprint(declared[name]); strings.Contains(declaredValue, "$") {
+				value = declaredValue
+			}
+			result[name] = value
+		} else if len(declared) == 0 && !vmGeneratedContext.MatchString(key) {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
+// contextFlag reports whether the context variable key, injected by the
+// onegate or report_ready arguments, is set to YES. A variable declared in
+// the context map by hand does not count.
+func contextFlag(context StringMap, declared map[string]interface{}, key string) bool {
+	for name := range declared {
+		if strings.ToUpper(name) == key {
+			return false
+		}
+	}
+	return strings.ToUpper(context[key]) == "YES"
+}
+
+// keepDeclaredFields drops the given optional fields from blocks read back
+// from OpenNebula when the matching declared block (same value for key)
+// leaves them unset. This keeps values filled in by OpenNebula, such as a
+// leased IP or the default security group, from showing up as changes.
+// Without declared blocks (e.g. on import) everything read is kept.
+func keepDeclaredFields(read []interface{}, declared []interface{}, key string, fields []string) []interface{} {
+	if len(declared) == 0 {
+		return read
+	}
+
+	used := make([]bool, len(declared))
+	for _, r := range read {
+		block := r.(map[string]interface{})
+
+		for i, dec := range declared {
+			decblock := dec.(map[string]interface{})
+			if used[i] || fmt.Sprint(decblock[key]) != fmt.Sprint(block[key]) {
+				continue
+			}
+			used[i] = true
+
+			for _, f := range fields {
+				if isEmptyValue(decblock[f]) {
+					delete(block, f)
+				}
+			}
+			break
+		}
+	}
+
+	return read
+}
+
+// isEmptyValue reports whether v is the zero value of a schema field.
+func isEmptyValue(v interface{}) bool {
+	switch value := v.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case int:
+		return value == 0
+	case bool:
+		return !value
+	case []interface{}:
+		return len(value) == 0
+	case map[string]interface{}:
+		return len(value) == 0
+	case *schema.Set:
+		return value.Len() == 0
+	}
+	return false
+}
+
+// resourceVmImportState imports a VM by ID, or by the name of a VM of the
+// authenticated user, which must then be unique.
+func resourceVmImportState(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	if _, err := strconv.Atoi(d.Id()); err == nil {
+		return []*schema.ResourceData{d}, nil
+	}
+
+	client := meta.(*Client)
+	vms, err := ownVmsByName(client, d.Id())
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(vms) {
+	case 0:
+		return nil, fmt.Errorf("Could not find a VM named %q for user %s", d.Id(), client.Username)
+	case 1:
+		d.SetId(vms[0].Id)
+		return []*schema.ResourceData{d}, nil
+	default:
+		return nil, fmt.Errorf("VM name %q is used by VMs %s, import one of them by ID", d.Id(), vmIdsString(vms))
+	}
+}
+
 func resourceVmExists(d *schema.ResourceData, meta interface{}) (bool, error) {
 	err := resourceVmRead(d, meta)
 	// a terminated VM is in state 6 (DONE)
@@ -569,6 +1409,10 @@ func resourceVmExists(d *schema.ResourceData, meta interface{}) (bool, error) {
 	return true, nil
 }
 
+// resourceVmUpdate updates the attributes of a VM that don't force a new one.
+// os, raw and context do: one.vm.updateconf only applies them to VMs that
+// don't run, e.g. in POWEROFF or UNDEPLOYED state. graphics is updated with
+// it as well, powering the VM off and resuming it if it runs.
 func resourceVmUpdate(d *schema.ResourceData, meta interface{}) error {
 
 	// Enable partial state mode
@@ -576,8 +1420,44 @@ func resourceVmUpdate(d *schema.ResourceData, meta interface{}) error {
 
 	client := meta.(*Client)
 
-	if d.HasChange("permissions") && d.Get("permissions") != "" {
-		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vm.chmod")
+	// Planned by the CustomizeDiff when recover_on_failure is set
+	if o, _ := d.GetChange("lcmstate"); o.(int) == 36 && d.Get("recover_on_failure").(bool) {
+		if err := recoverVm(d, meta); err != nil {
+			return err
+		}
+		d.SetPartial("lcmstate")
+	}
+
+	if d.HasChange("name") {
+		// Without a name, the VM keeps the one OpenNebula generated
+		if name := vmName(d.Get("name").(string), d.Get("name_suffix").(string)); name != "" {
+			if d.Get("name_unique").(bool) {
+				if err := checkVmNameUnique(client, name); err != nil {
+					return err
+				}
+			}
+
+			resp, err := client.VmRename(
+				intId(d.Id()),
+				name,
+			)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully renamed VM %s\n", resp)
+		}
+		d.SetPartial("name")
+	}
+
+	if d.HasChange("graphics") {
+		if err := updateVmGraphics(d, meta); err != nil {
+			return err
+		}
+		d.SetPartial("graphics")
+	}
+
+	if perms := changedPermissions(d); perms != nil {
+		resp, err := client.VmChmod(intId(d.Id()), perms)
 		if err != nil {
 			return err
 		}
@@ -585,11 +1465,63 @@ func resourceVmUpdate(d *schema.ResourceData, meta interface{}) error {
 		log.Printf("[INFO] Successfully updated VM %s\n", resp)
 	}
 
+	if d.HasChange("uid") || d.HasChange("group") || d.HasChange("gid") {
+		// -1 keeps the current owner, as does unsetting uid, group or gid
+		newuid := -1
+		if d.HasChange("uid") {
+			if v, ok := d.GetOkExists("uid"); ok {
+				newuid = v.(int)
+			}
+		}
+		newgid := -1
+		if d.HasChange("group") || d.HasChange("gid") {
+			gid, _, err := ownerGroup(d, client)
+			if err != nil {
+				return err
+			}
+			newgid = gid
+		}
+
+		if newuid != -1 || newgid != -1 {
+			resp, err := client.VmChown(intId(d.Id()), newuid, newgid)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Successfully changed the owner of VM %s\n", resp)
+		}
+		d.SetPartial("uid")
+		d.SetPartial("group")
+		d.SetPartial("gid")
+	}
+
+	if d.HasChange("description") {
+		if err := updateVmDescription(client, intId(d.Id()), d.Get("description").(string)); err != nil {
+			return err
+		}
+		d.SetPartial("description")
+		log.Printf("[INFO] Successfully updated description of VM %s\n", d.Id())
+	}
+
+	if d.HasChange("disk") {
+		if err := resizeVmDisks(d, meta); err != nil {
+			return err
+		}
+		d.SetPartial("disk")
+	}
+
+	if d.HasChange("sched_action") {
+		if err := updateVmSchedActions(d, client); err != nil {
+			return err
+		}
+		d.SetPartial("sched_action")
+		log.Printf("[INFO] Successfully updated scheduled actions of VM %s\n", d.Id())
+	}
+
 	// We succeeded, disable partial mode. This causes Terraform to save
 	// save all fields again.
 	d.Partial(false)
 
-	return nil
+	return resourceVmRead(d, meta)
 }
 
 func resourceVmDelete(d *schema.ResourceData, meta interface{}) error {
@@ -599,7 +1531,14 @@ func resourceVmDelete(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	client := meta.(*Client)
-	resp, err := client.Call("one.vm.action", "terminate-hard", intId(d.Id()))
+
+	if saveas, ok := d.GetOk("disk_saveas"); ok {
+		if err = saveVmDisk(d, meta, saveas.([]interface{})[0].(map[string]interface{})); err != nil {
+			return err
+		}
+	}
+
+	resp, err := client.VmAction("terminate-hard", intId(d.Id()))
 	if err != nil {
 		return err
 	}
@@ -611,6 +1550,108 @@ func resourceVmDelete(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	log.Printf("[INFO] Successfully terminated VM %s\n", resp)
+
+	if !d.Get("instantiate_persistent").(bool) || !d.Get("delete_persistent_disks").(bool) {
+		return nil
+	}
+
+	var images []int
+	for _, image := range d.Get("persistent_image_ids").([]interface{}) {
+		images = append(images, image.(int))
+	}
+	if err = deleteVmImages(client, d.Id(), images); err != nil {
+		return err
+	}
+
+	if templateID, ok := d.GetOk("persistent_template_id"); ok {
+		if _, err = client.TemplateDelete(templateID.(int), false); err != nil && !isNotFound(err) {
+			return fmt.Errorf("Error deleting template %d of VM %s: %s", templateID.(int), d.Id(), err)
+		}
+		log.Printf("[INFO] Deleted persistent template %d of VM %s", templateID.(int), d.Id())
+	}
+
+	return nil
+}
+
+// setVmPersistentCopies records the images and the template copied by
+// instantiate_persistent for a new VM: its persistent disks and the template
+// it was instantiated from, when it isn't template_id.
+func setVmPersistentCopies(d *schema.ResourceData, client *Client) error {
+	var vm *UserVm
+	resp, err := retryTransport(func() (string, error) {
+		return client.VmInfo(intId(d.Id()))
+	})
+	if err != nil {
+		return fmt.Errorf("Couldn't fetch VM %s: %s", d.Id(), err)
+	}
+	if err = client.Decode(resp, &vm); err != nil {
+		return err
+	}
+
+	if err = d.Set("persistent_image_ids", vmPersistentImages(vm.VmTemplate.Disks)); err != nil {
+		return err
+	}
+	if templateID, ok := vmTemplateID(vm); ok && templateID != d.Get("template_id").(int) {
+		d.Set("persistent_template_id", templateID)
+	}
+	return nil
+}
+
+// vmPersistentImages returns the IDs of the persistent images of the disks
+// of a VM.
+func vmPersistentImages(disks []VirtualMachineDisk) []int {
+	var images []int
+	for _, disk := range disks {
+		if strings.ToUpper(disk.Persistent) == "YES" {
+			images = append(images, disk.Image_ID)
+		}
+	}
+	return images
+}
+
+// deleteVmImages deletes the images of a terminated VM, once they are
+// released by the VM.
+func deleteVmImages(client *Client, vmID string, images []int) error {
+	for _, image := range images {
+		id := strconv.Itoa(image)
+		if _, err := waitForImageIdState(client, id, "ready", imageTimeout); err != nil {
+			return fmt.Errorf("Error waiting for image %s of VM %s to be released: %s", id, vmID, err)
+		}
+		if _, err := client.ImageDelete(image); err != nil {
+			return fmt.Errorf("Error deleting image %s of VM %s: %s", id, vmID, err)
+		}
+		log.Printf("[INFO] Deleted persistent image %s of VM %s", id, vmID)
+	}
+	return nil
+}
+
+// saveVmDisk saves a disk of the VM as a new image as described by a
+// disk_saveas block, and waits for the copy to complete. The VM is powered
+// off first, as required to save a disk.
+func saveVmDisk(d *schema.ResourceData, meta interface{}, saveas map[string]interface{}) error {
+	client := meta.(*Client)
+	diskID := saveas["disk_id"].(int)
+	name := saveas["image_name"].(string)
+
+	if d.Get("state").(int) != 8 {
+		if _, err := client.VmAction("poweroff", intId(d.Id())); err != nil {
+			return fmt.Errorf("Error powering off VM %s to save disk %d: %s", d.Id(), diskID, err)
+		}
+		if _, err := waitForVmState(d, meta, "poweroff"); err != nil {
+			return fmt.Errorf("Error waiting for VM %s to be in state POWEROFF: %s", d.Id(), err)
+		}
+	}
+
+	imageID, err := client.VmDiskSaveas(intId(d.Id()), diskID, name, saveas["image_type"].(string), -1)
+	if err != nil {
+		return fmt.Errorf("Error saving disk %d of VM %s as image %s, the VM is kept: %s", diskID, d.Id(), name, err)
+	}
+
+	if _, err = waitForImageIdState(client, imageID, "ready", imageTimeout); err != nil {
+		return fmt.Errorf("Error waiting for image %s (%s) saved from VM %s, the VM is kept: %s", imageID, name, d.Id(), err)
+	}
+
+	log.Printf("[INFO] Saved disk %d of VM %s as image %s (%s)", diskID, d.Id(), imageID, name)
 	return nil
 }
 
@@ -618,84 +1659,542 @@ func waitForVmState(d *schema.ResourceData, meta interface{}, state string) (int
 	var vm *UserVm
 	client := meta.(*Client)
 
-	log.Printf("Waiting for VM (%s) to be in state Done", d.Id())
+	log.Printf("Waiting for VM (%s) to be in state %s", d.Id(), state)
+
+	//A VM held by a default of the cloud, not by us, never gets to run
+	//unless released
+	release := state == "running" && d.Get("release_on_hold").(bool)
 
 	stateConf := &resource.StateChangeConf{
-		Pending: []string{"anythingelse"},
+		Pending: []string{"pending", "hold", "anythingelse"},
 		Target:  []string{state},
 		Refresh: func() (interface{}, string, error) {
 			log.Println("Refreshing VM state...")
 			if d.Id() != "" {
-				resp, err := client.Call("one.vm.info", intId(d.Id()))
+				resp, err := retryTransport(func() (string, error) {
+					return client.VmInfo(intId(d.Id()))
+				})
 				if err == nil {
-					if err = xml.Unmarshal([]byte(resp), &vm); err != nil {
+					if err = client.Decode(resp, &vm); err != nil {
 						return nil, "", fmt.Errorf("Couldn't fetch VM state: %s", err)
 					}
 				} else {
 					return nil, "", fmt.Errorf("Could not find VM by ID %s", d.Id())
 				}
 			}
-			log.Printf("VM is currently in state %v and in LCM state %v", vm.State, vm.LcmState)
-			if vm.State == 3 && vm.LcmState == 3 {
-				return vm, "running", nil
-			} else if vm.State == 6 {
-				return vm, "done", nil
-			} else if vm.State == 3 && vm.LcmState == 36 {
-				errMsg := "No error was found"
-				if vm.VmUserTemplate["ERROR"] != "" {
-					errMsg = vm.VmUserTemplate["ERROR"]
+			log.Printf("VM is currently in state %s and in LCM state %s", vmStateName(vm.State), vmLcmStateName(vm.LcmState))
+			current := vmWaitState(vm)
+			switch current {
+			case "boot_failure":
+				return vm, current, &vmBootFailure{ID: d.Id(), Message: vm.VmUserTemplate.Get("ERROR")}
+			case "hold":
+				if release {
+					log.Printf("[INFO] Releasing VM %s from HOLD", d.Id())
+					if _, err := client.VmAction("release", intId(d.Id())); err != nil {
+						return vm, current, fmt.Errorf("Error releasing VM %s from HOLD: %s", d.Id(), err)
+					}
+					release = false
 				}
-				return vm, "boot_failure", fmt.Errorf("VM ID %s entered fail state, error message: %s", d.Id(), errMsg)
-			} else {
-				return vm, "anythingelse", nil
 			}
+			return vm, current, nil
 		},
 		Timeout:    10 * time.Minute,
 		Delay:      10 * time.Second,
 		MinTimeout: 3 * time.Second,
 	}
 
-	return stateConf.WaitForState()
+	result, err := stateConf.WaitForState()
+	if _, ok := err.(*resource.TimeoutError); ok && vm != nil {
+		return result, fmt.Errorf("%s: VM %s is still in state %s, LCM state %s", err, d.Id(), vmStateName(vm.State), vmLcmStateName(vm.LcmState))
+	}
+	return result, err
+}
+
+// vmBootFailure is the error of a VM entering BOOT_FAILURE while waited for.
+type vmBootFailure struct {
+	ID      string
+	Message string
+}
+
+func (e *vmBootFailure) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = "No error was found"
+	}
+	return fmt.Sprintf("VM ID %s entered LCM state %s, error message: %s", e.ID, vmLcmStateName(36), msg)
 }
 
-func generateVmXML (d *schema.ResourceData) (string, error) {
+// waitForVmRunning waits for a new VM to be RUNNING. A VM entering
+// BOOT_FAILURE has its failed action retried up to recover_retry_count
+// times, with an exponential backoff, and its error kept in error_message.
+func waitForVmRunning(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+	retries := d.Get("recover_retry_count").(int)
+
+	for try := 0; ; try++ {
+		_, err := waitForVmState(d, meta, "running")
+		failure, ok := err.(*vmBootFailure)
+		if !ok {
+			return err
+		}
+
+		d.Set("error_message", failure.Message)
+		if try >= retries {
+			return err
+		}
+
+		backoff := vmRecoverBackoff(try)
+		log.Printf("[WARN] VM %s failed to boot, retrying in %s (%d/%d): %s", d.Id(), backoff, try+1, retries, failure.Message)
+		time.Sleep(backoff)
+
+		if _, err = client.VmRecover(intId(d.Id()), VmRecoverRetry); err != nil {
+			return fmt.Errorf("Error recovering VM %s: %s", d.Id(), err)
+		}
+	}
+}
+
+// vmRecoverBackoff is the time to wait before the given retry of a failed
+// boot, doubling from 10 seconds up to 5 minutes.
+func vmRecoverBackoff(try int) time.Duration {
+	backoff := 10 * time.Second
+	for i := 0; i < try && backoff < 5*time.Minute; i++ {
+		backoff *= 2
+	}
+	if backoff > 5*time.Minute {
+		backoff = 5 * time.Minute
+	}
+	return backoff
+}
+
+// vmWaitState maps the state of a VM to the states waitForVmState knows.
+// PENDING and HOLD are told apart, as a VM only leaves HOLD when released.
+func vmWaitState(vm *UserVm) string {
+	switch {
+	case vm.State == 1:
+		return "pending"
+	case vm.State == 2:
+		return "hold"
+	case vm.State == 3 && vm.LcmState == 3:
+		return "running"
+	case vm.State == 3 && vm.LcmState == 36:
+		return "boot_failure"
+	case vm.State == 6:
+		return "done"
+	case vm.State == 8:
+		return "poweroff"
+	}
+	return "anythingelse"
+}
+
+// vmUpdateConf is the configuration of a VM sent to one.vm.updateconf.
+// OpenNebula replaces all the sections it updates, so the ones left as is
+// are sent back too.
+type vmUpdateConf struct {
+	XMLName     xml.Name                `xml:"TEMPLATE"`
+	OS          *VirtualMachineOS       `xml:"OS,omitempty"`
+	Graphics    *VirtualMachineGraphics `xml:"GRAPHICS,omitempty"`
+	RAW         []VirtualMachineRAW     `xml:"RAW"`
+	ContextVars StringMap               `xml:"CONTEXT"`
+}
+
+// vmGraphicsUpdateConf returns the configuration of a VM with graphics
+// replacing its GRAPHICS section, nil removing it.
+func vmGraphicsUpdateConf(tpl *VmTemplate, graphics *VirtualMachineGraphics) vmUpdateConf {
+	conf := vmUpdateConf{
+		Graphics:    graphics,
+		RAW:         tpl.RAW,
+		ContextVars: tpl.ContextVars,
+	}
+	if tpl.OS.Arch != "" || tpl.OS.Boot != "" {
+		os := tpl.OS
+		conf.OS = &os
+	}
+	return conf
+}
+
+// updateVmGraphics replaces the GRAPHICS section of a VM with its graphics
+// block. one.vm.updateconf is refused for active VMs, which are powered off
+// for the update and resumed afterwards.
+func updateVmGraphics(d *schema.ResourceData, meta interface{}) error {
+	var vm *UserVm
+	client := meta.(*Client)
+
+	resp, err := retryTransport(func() (string, error) {
+		return client.VmInfo(intId(d.Id()))
+	})
+	if err != nil {
+		return err
+	}
+	if err = client.Decode(resp, &vm); err != nil {
+		return err
+	}
+
+	var graphics *VirtualMachineGraphics
+	if g := d.Get("graphics").(*schema.Set).List(); len(g) > 0 {
+		config := g[0].(map[string]interface{})
+		graphics = &VirtualMachineGraphics{
+			Listen: config["listen"].(string),
+			Type:   config["type"].(string),
+		}
+	}
+
+	conf, err := xml.Marshal(vmGraphicsUpdateConf(vm.VmTemplate, graphics))
+	if err != nil {
+		return err
+	}
+
+	active := vm.State == 3
+	if active {
+		log.Printf("[INFO] Powering off VM %s to update its graphics", d.Id())
+		if _, err = client.VmAction("poweroff", intId(d.Id())); err != nil {
+			return fmt.Errorf("Error powering off VM %s to update its graphics: %s", d.Id(), err)
+		}
+		if _, err = waitForVmState(d, meta, "poweroff"); err != nil {
+			return fmt.Errorf("Error waiting for VM %s to be in state POWEROFF: %s", d.Id(), err)
+		}
+	}
+
+	if _, err = client.VmUpdateConf(intId(d.Id()), string(conf)); err != nil {
+		return fmt.Errorf("Error updating the graphics of VM %s: %s", d.Id(), err)
+	}
+	log.Printf("[INFO] Updated the graphics of VM %s", d.Id())
+
+	if active {
+		if _, err = client.VmAction("resume", intId(d.Id())); err != nil {
+			return fmt.Errorf("Error resuming VM %s after updating its graphics: %s", d.Id(), err)
+		}
+		if _, err = waitForVmState(d, meta, "running"); err != nil {
+			return fmt.Errorf("Error waiting for VM %s to be in state RUNNING: %s", d.Id(), err)
+		}
+	}
+	return nil
+}
+
+// updateVmDescription sets DESCRIPTION in the user template of a VM,
+// merging it with the other attributes.
+func updateVmDescription(client *Client, id int, description string) error {
+	tpl := VmUserTemplate{
+		Attributes: []vmTemplateAttribute{vmTemplateValue("DESCRIPTION", description)},
+	}
+
+	tplxml, err := tpl.XML()
+	if err != nil {
+		return err
+	}
+
+	if _, err = client.VmUpdate(id, tplxml, 1); err != nil {
+		return fmt.Errorf("Error updating the description of VM %d: %s", id, err)
+	}
+	return nil
+}
+
+// recoverVm retries the action a VM in BOOT_FAILURE state failed on, and
+// waits for it to run again.
+func recoverVm(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+
+	log.Printf("[INFO] Retrying the failed action of VM %s", d.Id())
+	if _, err := client.VmRecover(intId(d.Id()), VmRecoverRetry); err != nil {
+		return fmt.Errorf("Error recovering VM %s: %s", d.Id(), err)
+	}
+
+	if _, err := waitForVmState(d, meta, "running"); err != nil {
+		return fmt.Errorf("Error waiting for VM %s to be recovered: %s", d.Id(), err)
+	}
+
+	return nil
+}
+
+// vmWaitForReady tells whether creation waits for the VM to report READY,
+// which report_ready enables unless wait_for_ready says otherwise.
+func vmWaitForReady(d *schema.ResourceData) bool {
+	if wait, ok := d.GetOkExists("wait_for_ready"); ok {
+		return wait.(bool)
+	}
+	return d.Get("report_ready").(bool)
+}
+
+// waitForVmReady waits for a VM to set READY=YES in its user template
+// through OneGate, as done by the contextualization packages when
+// REPORT_READY=YES is in its context.
+func waitForVmReady(d *schema.ResourceData, meta interface{}) (interface{}, error) {
+	var vm *UserVm
+	client := meta.(*Client)
+
+	log.Printf("[INFO] Waiting for VM (%s) to report READY", d.Id())
+
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"notready"},
+		Target:  []string{"ready"},
+		Refresh: func() (interface{}, string, error) {
+			resp, err := retryTransport(func() (string, error) {
+				return client.VmInfo(intId(d.Id()))
+			})
+			if err != nil {
+				return nil, "", fmt.Errorf("Couldn't fetch VM %s: %s", d.Id(), err)
+			}
+			if err = client.Decode(resp, &vm); err != nil {
+				return nil, "", fmt.Errorf("Couldn't fetch VM user template: %s", err)
+			}
+
+			if strings.ToUpper(vm.VmUserTemplate.Get("READY")) == "YES" {
+				return vm, "ready", nil
+			}
+			if vm.State == 3 && vm.LcmState == 36 {
+				return vm, "boot_failure", fmt.Errorf("VM ID %s entered LCM state %s before reporting READY", d.Id(), vmLcmStateName(vm.LcmState))
+			}
+			return vm, "notready", nil
+		},
+		Timeout:    10 * time.Minute,
+		Delay:      10 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	result, err := stateConf.WaitForState()
+	if _, timeout := err.(*resource.TimeoutError); timeout {
+		return result, fmt.Errorf("READY=YES never appeared in the user template, check that REPORT_READY=YES and TOKEN=YES are in the VM context and that the guest can reach OneGate (%s)", err)
+	}
+	return result, err
+}
+
+// onegateContextKeys returns the context variables injected for the onegate
+// and report_ready arguments.
+func onegateContextKeys(onegate, reportReady bool) map[string]string {
+	keys := make(map[string]string)
+	if onegate || reportReady {
+		keys["TOKEN"] = "YES"
+	}
+	if reportReady {
+		keys["REPORT_READY"] = "YES"
+	}
+	return keys
+}
+
+// nicModels are the NIC models supported by KVM.
+var nicModels = []string{"virtio", "e1000", "e1000e", "rtl8139", "ne2k_pci", "pcnet", "i82551", "i82557b", "i82559er", "vmxnet3"}
+
+// validateNicModel rejects NIC models unknown to KVM, which would only fail
+// once the VM boots. An empty model leaves the choice to the driver.
+func validateNicModel(model string) error {
+	if model != "" && !in_array(model, nicModels) {
+		return fmt.Errorf("NIC model %q is not one of: %s. Set skip_nic_model_validation in the provider to use another one", model, strings.Join(nicModels, ", "))
+	}
+	return nil
+}
+
+// validateIP checks an IP address parses, to fail at plan time rather than
+// in OpenNebula.
+func validateIP(v interface{}, k string) (ws []string, errors []error) {
+	if ip := v.(string); ip != "" && net.ParseIP(ip) == nil {
+		errors = append(errors, fmt.Errorf("%q is not a valid IP address: %q", k, ip))
+	}
+	return
+}
+
+// macAddress is the format of MAC addresses OpenNebula accepts.
+var macAddress = regexp.MustCompile(`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`)
+
+// validateMAC checks a MAC address is six colon separated bytes.
+func validateMAC(v interface{}, k string) (ws []string, errors []error) {
+	if mac := v.(string); mac != "" && !macAddress.MatchString(mac) {
+		errors = append(errors, fmt.Errorf("%q is not a valid MAC address, e.g. 02:00:0a:00:00:01: %q", k, mac))
+	}
+	return
+}
+
+// vmCapacityOverrides returns the attributes overriding the capacity of the
+// template on instantiation, for the declared cpu, vcpu and memory.
+func vmCapacityOverrides(d *schema.ResourceData) []string {
+	var extra []string
+	if v, ok := d.GetOk("cpu"); ok {
+		extra = append(extra, fmt.Sprintf("CPU = \"%s\"", strconv.FormatFloat(v.(float64), 'f', -1, 64)))
+	}
+	if v, ok := d.GetOk("vcpu"); ok {
+		extra = append(extra, fmt.Sprintf("VCPU = \"%d\"", v.(int)))
+	}
+	if v, ok := d.GetOk("memory"); ok {
+		extra = append(extra, fmt.Sprintf("MEMORY = \"%d\"", v.(int)))
+	}
+	return extra
+}
+
+// vmSchedRequirements returns the SCHED_REQUIREMENTS of a VM deployed by the
+// scheduler in the cluster given by cluster_id, if any.
+func vmSchedRequirements(d *schema.ResourceData) string {
+	if _, ok := d.GetOkExists("host_id"); ok {
+		return ""
+	}
+	if clusterID, ok := d.GetOkExists("cluster_id"); ok {
+		return fmt.Sprintf("CLUSTER_ID = %d", clusterID.(int))
+	}
+	return ""
+}
+
+// vmGroupRole returns the VMGROUP of a VM from its vmgroup block, if any.
+func vmGroupRole(d *schema.ResourceData) *VirtualMachineVMGroup {
+	groups := d.Get("vmgroup").([]interface{})
+	if len(groups) == 0 || groups[0] == nil {
+		return nil
+	}
+
+	group := groups[0].(map[string]interface{})
+	return &VirtualMachineVMGroup{
+		VMGroup_ID: group["vmgroup_id"].(int),
+		Role:       group["role"].(string),
+	}
+}
+
+func flattenVmGroupRole(group *VirtualMachineVMGroup) []interface{} {
+	if group == nil {
+		return []interface{}{}
+	}
+
+	return []interface{}{
+		map[string]interface{}{
+			"vmgroup_id": group.VMGroup_ID,
+			"role":       group.Role,
+		},
+	}
+}
+
+// vmContextFiles returns the FILES_DS context variable adding the files of
+// the given CONTEXT images to the context CD-ROM.
+func vmContextFiles(ids []interface{}) string {
+	files := make([]string, 0, len(ids))
+	for _, id := range ids {
+		files = append(files, fmt.Sprintf("$FILE[IMAGE_ID=%d]", id.(int)))
+	}
+	return strings.Join(files, " ")
+}
+
+// validateVmContext checks the context map for keys only differing by case,
+// which OpenNebula would merge in an unpredictable way, and for keys managed
+// by the onegate, report_ready and context_files arguments.
+func validateVmContext(context map[string]interface{}, onegate, reportReady, contextFiles bool) error {
+	seen := make(map[string]string)
+	for key := range context {
+		upper := strings.ToUpper(key)
+		if other, ok := seen[upper]; ok {
+			return fmt.Errorf("Context variables %q and %q only differ by case", other, key)
+		}
+		seen[upper] = key
+	}
+
+	for key := range onegateContextKeys(onegate, reportReady) {
+		if manual, ok := seen[key]; ok {
+			return fmt.Errorf("Context variable %q is set by onegate/report_ready, remove it from context", manual)
+		}
+	}
+
+	if manual, ok := seen["FILES_DS"]; ok && contextFiles {
+		return fmt.Errorf("Context variable %q is set by context_files, remove it from context", manual)
+	}
+
+	return nil
+}
+
+// generateVmXML returns the template of a VM created without template_id,
+// NICs without model getting defaultNicModel.
+func generateVmXML (d *schema.ResourceData, defaultNicModel string) (string, error) {
 
 	//Generate CONTEXT definition
 	//context := d.Get("context").(*schema.Set).List()
 	context := d.Get("context").(map[string]interface{})
 	log.Printf("Number of CONTEXT vars: %d", len(context))
-	log.Printf("CONTEXT Map: ", context)
+	log.Printf("CONTEXT Map: %v", context)
 
 	vmcontext := make(StringMap)
 	for key, value := range context {
 		//contextvar = v.(map[string]interface{})
 		vmcontext[key] = fmt.Sprint(value)
 	}
+	for key, value := range onegateContextKeys(d.Get("onegate").(bool), d.Get("report_ready").(bool)) {
+		vmcontext[key] = value
+	}
+	if files := d.Get("context_files").([]interface{}); len(files) > 0 {
+		vmcontext["FILES_DS"] = vmContextFiles(files)
+	}
 
 
-	//Generate NIC definition
-	nics := d.Get("nic").(*schema.Set).List()
+	//Generate NIC definition, ordered_nic keeping the declared order
+	nics := append(d.Get("nic").(*schema.Set).List(), d.Get("ordered_nic").([]interface{})...)
 	log.Printf("Number of NICs: %d", len(nics))
+	vmnics := vmNICs(nics, defaultNicModel)
+
+	//Generate DISK definition
+	disks := d.Get("disk").(*schema.Set).List()
+	log.Printf("Number of disks: %d", len(disks))
+	vmdisks := vmDisks(disks)
+
+	//Generate GRAPHICS, OS and RAW definitions
+	vmgraphics := vmGraphics(d.Get("graphics").(*schema.Set).List())
+	vmos := vmOS(d.Get("os").(*schema.Set).List())
+	vmraws := vmRAWs(d.Get("raw").(*schema.Set).List())
+
+	//Pull all the bits together into the main VM template
+	vmname := vmInstanceName(d)
+	vmvcpu := d.Get("vcpu").(int)
+	vmcpu := d.Get("cpu").(float64)
+	vmmemory := d.Get("memory").(int)
+
+	vmtpl := &VmTemplate {
+		Name:        vmname,
+		VCPU:        vmvcpu,
+		CPU:         vmcpu,
+		Memory:      vmmemory,
+		ContextVars: vmcontext,
+		NICs:        vmnics,
+		Disks:       vmdisks,
+		Graphics:    vmgraphics,
+		OS:          vmos,
+		RAW:         vmraws,
+		SchedRequirements: vmSchedRequirements(d),
+		VMGroup:     vmGroupRole(d),
+	}
+
+	w := &bytes.Buffer{}
+
+	//Encode the VM template schema to XML
+	enc := xml.NewEncoder(w)
+	//enc.Indent("", "  ")
+	if err := enc.Encode(vmtpl); err != nil {
+		return "", err
+	}
+
+	log.Printf("VM XML: %s", w.String())
+	return w.String(), nil
+
+}
+
+// vmNICs returns the NICs of the nic blocks nics, those without model
+// getting defaultNicModel.
+func vmNICs(nics []interface{}, defaultNicModel string) []VirtualMachineNIC {
 	vmnics := make([]VirtualMachineNIC, len(nics))
 	for i := 0; i < len(nics); i++ {
 		nicconfig := nics[i].(map[string]interface{})
 		nicip := nicconfig["ip"].(string)
+		nicmac, _ := nicconfig["mac"].(string)
 		nicmodel := nicconfig["model"].(string)
+		if nicmodel == "" {
+			nicmodel = defaultNicModel
+		}
 		nicnetworkid := nicconfig["network_id"].(int)
 		nicsecgroups := arrayToString(nicconfig["security_groups"].([]interface{}) , ",")
 
 		vmnic := VirtualMachineNIC {
 			IP:              nicip,
+			MAC:             nicmac,
 			Model:           nicmodel,
 			Network_ID:      nicnetworkid,
 			Security_Groups: nicsecgroups,
 		}
 		vmnics[i] = vmnic
 	}
+	return vmnics
+}
 
-	//Generate DISK definition
-	disks := d.Get("disk").(*schema.Set).List()
-	log.Printf("Number of disks: %d", len(disks))
+// vmDisks returns the disks of the disk blocks disks.
+func vmDisks(disks []interface{}) []VirtualMachineDisk {
 	vmdisks := make([]VirtualMachineDisk, len(disks))
 	for i := 0; i < len(disks); i++ {
 		diskconfig := disks[i].(map[string]interface{})
@@ -712,11 +2211,13 @@ func generateVmXML (d *schema.ResourceData) (string, error) {
 		}
 		vmdisks[i] = vmdisk
 	}
+	return vmdisks
+}
 
-	//Generate GRAPHICS definition
+// vmGraphics returns the graphics of the first graphics block, if any.
+func vmGraphics(graphics []interface{}) VirtualMachineGraphics {
 	var vmgraphics VirtualMachineGraphics
-	if g, ok := d.GetOk("graphics"); ok {
-		graphics := g.(*schema.Set).List()
+	if len(graphics) > 0 && graphics[0] != nil {
 		graphicsconfig := graphics[0].(map[string]interface{})
 		gfxlisten := graphicsconfig["listen"].(string)
 		gfxtype := graphicsconfig["type"].(string)
@@ -725,11 +2226,13 @@ func generateVmXML (d *schema.ResourceData) (string, error) {
 			Type:        gfxtype,
 		}
 	}
+	return vmgraphics
+}
 
-	//Generate OS definition
+// vmOS returns the OS of the first os block, if any.
+func vmOS(os []interface{}) VirtualMachineOS {
 	var vmos VirtualMachineOS
-	if o, ok := d.GetOk("os"); ok {
-		os := o.(*schema.Set).List()
+	if len(os) > 0 && os[0] != nil {
 		osconfig := os[0].(map[string]interface{})
 		osarch := osconfig["arch"].(string)
 		osboot := osconfig["boot"].(string)
@@ -738,70 +2241,266 @@ func generateVmXML (d *schema.ResourceData) (string, error) {
 			Boot:        osboot,
 		}
 	}
-	//Generate RAW definition
-	var vmraw VirtualMachineRAW
-	if r, ok := d.GetOk("raw"); ok {
-		raw := r.(*schema.Set).List()
-		rawconfig := raw[0].(map[string]interface{})
-		rawtype := rawconfig["type"].(string)
-		rawdata := rawconfig["data"].(string)
-		vmraw = VirtualMachineRAW {
-			Type:        rawtype,
-			Data:        rawdata,
+	return vmos
+}
+
+// vmRAWs returns the RAW sections of the raw blocks raws.
+func vmRAWs(raws []interface{}) []VirtualMachineRAW {
+	vmraws := make([]VirtualMachineRAW, len(raws))
+	for i := 0; i < len(raws); i++ {
+		rawconfig := raws[i].(map[string]interface{})
+		vmraws[i] = VirtualMachineRAW {
+			Type:        rawconfig["type"].(string),
+			Data:        rawconfig["data"].(string),
+		}
+		if rawconfig["validate"].(bool) {
+			vmraws[i].Validate = "YES"
 		}
 	}
+	return vmraws
+}
 
-	//Pull all the bits together into the main VM template
-	vmname := d.Get("name").(string)
-	vmvcpu := d.Get("vcpu").(int)
-	vmcpu := d.Get("cpu").(float64)
-	vmmemory := d.Get("memory").(int)
+func arrayToString(a []interface{}, delim string) string {
+    return strings.Trim(strings.Replace(fmt.Sprint(a), " ", delim, -1), "[]")
+}
 
-	vmtpl := &VmTemplate {
-		Name:        vmname,
-		VCPU:        vmvcpu,
-		CPU:         vmcpu,
-		Memory:      vmmemory,
-		ContextVars: vmcontext,
-		NICs:        vmnics,
-		Disks:       vmdisks,
-		Graphics:    vmgraphics,
-		OS:          vmos,
-		RAW:         vmraw,
+// vmName returns the name given to the VM in OpenNebula, with the random
+// suffix if any. An empty name is left for OpenNebula to generate.
+func vmName(name, suffix string) string {
+	if name == "" || suffix == "" {
+		return name
 	}
+	return fmt.Sprintf("%s-%s", name, suffix)
+}
 
-	w := &bytes.Buffer{}
+// vmInstanceName returns the name of the VM in OpenNebula as configured: the
+// name with its suffix, or the name generated from name_prefix on creation.
+func vmInstanceName(d *schema.ResourceData) string {
+	if _, ok := d.GetOk("name_prefix"); ok {
+		return d.Get("instance").(string)
+	}
+	return vmName(d.Get("name").(string), d.Get("name_suffix").(string))
+}
 
-	//Encode the VM template schema to XML
-	enc := xml.NewEncoder(w)
-	//enc.Indent("", "  ")
-	if err := enc.Encode(vmtpl); err != nil {
+// prefixedVmName returns a unique name starting with prefix, for VMs created
+// with name_prefix.
+func prefixedVmName(prefix string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
+	return prefix + hex.EncodeToString(b), nil
+}
 
-	log.Printf("VM XML: %s", w.String())
-	return w.String(), nil
+// randomNameSuffix returns a short random suffix for VM names.
+func randomNameSuffix() (string, error) {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ownVmsByName returns the VMs of the authenticated user with the given
+// name. The owner of the VMs listed is checked as well, so that a VM of
+// another user is never adopted.
+func ownVmsByName(client *Client, name string) ([]*UserVm, error) {
+	var vms *UserVms
+
+	resp, err := client.VmPoolInfo(-3, -1, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = client.Decode(resp, &vms); err != nil {
+		return nil, err
+	}
 
+	var found []*UserVm
+	for _, vm := range vms.UserVm {
+		// a terminated VM is in state 6 (DONE) and can't be adopted
+		if vm.Name == name && vm.Uname == client.Username && vm.State != 6 {
+			found = append(found, vm)
+		}
+	}
+
+	return found, nil
 }
 
-func arrayToString(a []interface{}, delim string) string {
-    return strings.Trim(strings.Replace(fmt.Sprint(a), " ", delim, -1), "[]")
+// ownVmByName returns the live VM of the authenticated user with the given
+// name, or nil. Several of them, e.g. left over by an interrupted apply, is
+// an error rather than a guess.
+func ownVmByName(client *Client, name string) (*UserVm, error) {
+	vms, err := ownVmsByName(client, name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch len(vms) {
+	case 0:
+		return nil, nil
+	case 1:
+		return vms[0], nil
+	default:
+		return nil, fmt.Errorf("VM name %q is used by VMs %s of user %s, delete the extra ones or import one by ID", name, vmIdsString(vms), client.Username)
+	}
+}
+
+// vmIdsString lists the IDs of vms, for error messages.
+func vmIdsString(vms []*UserVm) string {
+	ids := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		ids = append(ids, vm.Id)
+	}
+	return strings.Join(ids, ", ")
+}
+
+// vmsByName returns the VMs named name among all the VMs visible to the
+// user, whatever their owner.
+func vmsByName(client *Client, name string) ([]*UserVm, error) {
+	var vms *UserVms
+
+	resp, err := client.VmPoolInfo(-2, -1, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = client.Decode(resp, &vms); err != nil {
+		return nil, err
+	}
+
+	var found []*UserVm
+	for _, vm := range vms.UserVm {
+		if vm.Name == name {
+			found = append(found, vm)
+		}
+	}
+
+	return found, nil
+}
+
+// checkVmNameUnique fails if a VM named name already exists.
+func checkVmNameUnique(client *Client, name string) error {
+	if name == "" {
+		return nil
+	}
+
+	vms, err := vmsByName(client, name)
+	if err != nil {
+		return err
+	}
+
+	if len(vms) > 0 {
+		ids := make([]string, 0, len(vms))
+		for _, vm := range vms {
+			ids = append(ids, vm.Id)
+		}
+		return fmt.Errorf("VM name %q is already used by VM(s) %s", name, strings.Join(ids, ", "))
+	}
+
+	return nil
 }
 
 func resourceVMNicHash(v interface{}) int {
 	var buf bytes.Buffer
 	m := v.(map[string]interface{})
-	buf.WriteString(fmt.Sprintf("%s-", m["model"].(string)))
-	buf.WriteString(fmt.Sprintf("%s-", m["network_id"].(int)))
+	// model is optional, an unset one hashes as an empty string
+	model, _ := m["model"].(string)
+	buf.WriteString(fmt.Sprintf("%s-", model))
+	buf.WriteString(fmt.Sprintf("%d-", m["network_id"].(int)))
+	// ip, mac and security groups tell apart several NICs on the same network
+	if ip, ok := m["ip"].(string); ok && ip != "" {
+		buf.WriteString(fmt.Sprintf("%s-", ip))
+	}
+	if mac, ok := m["mac"].(string); ok && mac != "" {
+		buf.WriteString(fmt.Sprintf("%s-", strings.ToLower(mac)))
+	}
+	if secgroups, ok := m["security_groups"].([]interface{}); ok {
+		for _, sg := range secgroups {
+			buf.WriteString(fmt.Sprintf("%d,", sg.(int)))
+		}
+	}
 	return hashcode.String(buf.String())
 }
 
 func resourceVMCustomizeDiff(diff *schema.ResourceDiff, v interface{}) error {
-    // If the VM is in error state, force the VM to be recreated
+    if err := checkResourceVersions(diff, v, "opennebula_vm"); err != nil {
+        return err
+    }
+
+    // If the VM is in error state, recover or recreate it as configured
     if diff.Get("lcmstate") == 36 {
-        log.Printf("[INFO] VM is in error state, forcing recreate.")
-        diff.SetNew("lcmstate", 3)
-        if err := diff.ForceNew("lcmstate"); err != nil {
+        switch {
+        case diff.Get("recover_on_failure").(bool):
+            log.Printf("[INFO] VM is in error state, planning recovery.")
+            if err := diff.SetNew("lcmstate", 3); err != nil {
+                return err
+            }
+        case diff.Get("recreate_on_failure").(bool):
+            log.Printf("[INFO] VM is in error state, forcing recreate.")
+            diff.SetNew("lcmstate", 3)
+            if err := diff.ForceNew("lcmstate"); err != nil {
+                return err
+            }
+        default:
+            log.Printf("[WARN] VM is in error state, leaving it as is.")
+        }
+    }
+
+    // Disks are resized in place when they only grow
+    if diff.Id() != "" && diff.HasChange("disk") {
+        o, n := diff.GetChange("disk")
+        if err := checkVmDiskChange(diff, v, o.(*schema.Set).List(), n.(*schema.Set).List()); err != nil {
+            return err
+        }
+    }
+
+    // VMs created without template get their capacity from the configuration
+    if _, ok := diff.GetOk("template_id"); diff.Id() == "" && !ok && diff.NewValueKnown("template_id") {
+        for _, key := range []string{"cpu", "vcpu", "memory"} {
+            if _, ok := diff.GetOk(key); !ok {
+                return fmt.Errorf("%s is required when template_id is not set", key)
+            }
+        }
+    }
+
+    // The random suffix is drawn once and kept in state
+    if diff.Get("name_suffix_random").(bool) {
+        if diff.Get("name_suffix").(string) == "" {
+            suffix, err := randomNameSuffix()
+            if err != nil {
+                return err
+            }
+            if err := diff.SetNew("name_suffix", suffix); err != nil {
+                return err
+            }
+        }
+    } else if diff.Get("name_suffix").(string) != "" {
+        if err := diff.SetNew("name_suffix", ""); err != nil {
+            return err
+        }
+    }
+
+    // Only new or renamed VMs are checked, an existing VM always finds itself
+    if (diff.Id() == "" || diff.HasChange("name")) && diff.Get("name_unique").(bool) && v != nil {
+        name := vmName(diff.Get("name").(string), diff.Get("name_suffix").(string))
+        if err := checkVmNameUnique(v.(*Client), name); err != nil {
+            return err
+        }
+    }
+
+    if client, ok := v.(*Client); ok && !client.SkipNicModelValidation {
+        nics := append(diff.Get("nic").(*schema.Set).List(), diff.Get("ordered_nic").([]interface{})...)
+        for _, nic := range nics {
+            if err := validateNicModel(nic.(map[string]interface{})["model"].(string)); err != nil {
+                return err
+            }
+        }
+    }
+
+    if context, ok := diff.Get("context").(map[string]interface{}); ok {
+        contextFiles := len(diff.Get("context_files").([]interface{})) > 0
+        if err := validateVmContext(context, diff.Get("onegate").(bool), diff.Get("report_ready").(bool), contextFiles); err != nil {
             return err
         }
     }
@@ -0,0 +1,225 @@
+package opennebula
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// VmDiskSnapshots are the snapshots of a disk of a VM.
+type VmDiskSnapshots struct {
+	DiskID    int              `xml:"DISK_ID"`
+	Snapshots []VmDiskSnapshot `xml:"SNAPSHOT"`
+}
+
+type VmDiskSnapshot struct {
+	ID     int    `xml:"ID"`
+	Name   string `xml:"NAME"`
+	Date   int    `xml:"DATE"`
+	Parent int    `xml:"PARENT"`
+	Size   int    `xml:"SIZE"`
+	Active string `xml:"ACTIVE"`
+}
+
+func resourceVmDiskSnapshot() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceVmDiskSnapshotCreate,
+		Read:   resourceVmDiskSnapshotRead,
+		Delete: resourceVmDiskSnapshotDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"vm_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the VM",
+			},
+			"disk_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "DISK_ID of the disk to snapshot in the VM",
+			},
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Name of the snapshot",
+			},
+			"snapshot_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the snapshot in the disk",
+			},
+			"date": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Unix time the snapshot was taken at",
+			},
+			"size": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Size of the snapshot in MB",
+			},
+		},
+	}
+}
+
+// vmDiskSnapshotId returns the ID of a disk snapshot resource,
+// <vm_id>:<disk_id>:<snapshot_id>.
+func vmDiskSnapshotId(vmID, diskID, snapID int) string {
+	return fmt.Sprintf("%d:%d:%d", vmID, diskID, snapID)
+}
+
+func parseVmDiskSnapshotId(id string) (int, int, int, error) {
+	ids, err := splitIds(id, 3)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("Unexpected VM disk snapshot ID %q, expected <vm_id>:<disk_id>:<snapshot_id>", id)
+	}
+	return ids[0], ids[1], ids[2], nil
+}
+
+func resourceVmDiskSnapshotCreate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+	vmID := d.Get("vm_id").(int)
+	diskID := d.Get("disk_id").(int)
+
+	resp, err := client.VmDiskSnapshotCreate(vmID, diskID, d.Get("name").(string))
+	if err != nil {
+		return fmt.Errorf("Error creating snapshot of disk %d of VM %d: %s", diskID, vmID, err)
+	}
+
+	d.SetId(vmDiskSnapshotId(vmID, diskID, intId(resp)))
+
+	if _, err = waitForVmDiskSnapshot(client, vmID); err != nil {
+		return fmt.Errorf("Error waiting for snapshot %s of disk %d of VM %d: %s", resp, diskID, vmID, err)
+	}
+
+	if err = resourceVmDiskSnapshotRead(d, meta); err != nil {
+		return err
+	}
+	if d.Id() == "" {
+		return fmt.Errorf("Snapshot %s of disk %d of VM %d failed, see the VM log", resp, diskID, vmID)
+	}
+
+	log.Printf("[INFO] Successfully created snapshot %s of disk %d of VM %d", resp, diskID, vmID)
+	return nil
+}
+
+func resourceVmDiskSnapshotRead(d *schema.ResourceData, meta interface{}) error {
+	var vm *UserVm
+	client := meta.(*Client)
+
+	vmID, diskID, snapID, err := parseVmDiskSnapshotId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	resp, err := retryTransport(func() (string, error) {
+		return client.VmInfo(vmID)
+	})
+	if isNotFound(err) {
+		log.Printf("[WARN] Could not find VM %d of disk snapshot %d, removing it from state", vmID, snapID)
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Couldn't fetch VM %d of disk snapshot %d: %s", vmID, snapID, err)
+	}
+	if err = client.Decode(resp, &vm); err != nil {
+		return err
+	}
+
+	snapshot := vmDiskSnapshot(vm, diskID, snapID)
+	if snapshot == nil || vm.State == 6 {
+		log.Printf("[WARN] Could not find snapshot %d of disk %d of VM %d, removing it from state", snapID, diskID, vmID)
+		d.SetId("")
+		return nil
+	}
+
+	d.Set("vm_id", vmID)
+	d.Set("disk_id", diskID)
+	d.Set("name", snapshot.Name)
+	d.Set("snapshot_id", snapshot.ID)
+	d.Set("date", snapshot.Date)
+	d.Set("size", snapshot.Size)
+
+	return nil
+}
+
+// vmDiskSnapshot returns the snapshot snapID of the disk diskID of the VM,
+// or nil.
+func vmDiskSnapshot(vm *UserVm, diskID, snapID int) *VmDiskSnapshot {
+	for _, disk := range vm.DiskSnapshots {
+		if disk.DiskID != diskID {
+			continue
+		}
+		for i, snapshot := range disk.Snapshots {
+			if snapshot.ID == snapID {
+				return &disk.Snapshots[i]
+			}
+		}
+	}
+	return nil
+}
+
+func resourceVmDiskSnapshotDelete(d *schema.ResourceData, meta interface{}) error {
+	err := resourceVmDiskSnapshotRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return err
+	}
+
+	client := meta.(*Client)
+	vmID, diskID, snapID, _ := parseVmDiskSnapshotId(d.Id())
+
+	if _, err = client.VmDiskSnapshotDelete(vmID, diskID, snapID); err != nil {
+		return fmt.Errorf("Error deleting snapshot %d of disk %d of VM %d: %s", snapID, diskID, vmID, err)
+	}
+
+	if _, err = waitForVmDiskSnapshot(client, vmID); err != nil {
+		return fmt.Errorf("Error waiting for snapshot %d of disk %d of VM %d to be deleted: %s", snapID, diskID, vmID, err)
+	}
+
+	log.Printf("[INFO] Successfully deleted snapshot %d of disk %d of VM %d", snapID, diskID, vmID)
+	return nil
+}
+
+// waitForVmDiskSnapshot waits for a VM to leave the DISK_SNAPSHOT* LCM
+// states, in which a disk snapshot is taken, reverted or deleted, whether the
+// VM runs, is powered off or suspended.
+func waitForVmDiskSnapshot(client *Client, vmID int) (interface{}, error) {
+	var vm *UserVm
+
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"snapshot"},
+		Target:  []string{"done"},
+		Refresh: func() (interface{}, string, error) {
+			resp, err := retryTransport(func() (string, error) {
+				return client.VmInfo(vmID)
+			})
+			if err != nil {
+				return nil, "", fmt.Errorf("Couldn't fetch VM %d: %s", vmID, err)
+			}
+			if err = client.Decode(resp, &vm); err != nil {
+				return nil, "", fmt.Errorf("Couldn't fetch VM state: %s", err)
+			}
+
+			if vm.State == 3 && strings.HasPrefix(vmLcmStateName(vm.LcmState), "DISK_SNAPSHOT") {
+				return vm, "snapshot", nil
+			}
+			return vm, "done", nil
+		},
+		Timeout:    10 * time.Minute,
+		Delay:      3 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	return stateConf.WaitForState()
+}
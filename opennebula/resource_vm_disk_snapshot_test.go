@@ -0,0 +1,199 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccVmDiskSnapshot(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDiskSnapshotDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmDiskSnapshotConfig, testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair("opennebula_vm_disk_snapshot.test", "vm_id", "opennebula_vm.test", "id"),
+					resource.TestCheckResourceAttr("opennebula_vm_disk_snapshot.test", "disk_id", "0"),
+					resource.TestCheckResourceAttr("opennebula_vm_disk_snapshot.test", "name", "tf-acc-test-nightly"),
+					resource.TestCheckResourceAttr("opennebula_vm_disk_snapshot.test", "snapshot_id", "0"),
+					resource.TestCheckResourceAttrSet("opennebula_vm_disk_snapshot.test", "date"),
+				),
+			},
+			{
+				ResourceName:      "opennebula_vm_disk_snapshot.test",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+		},
+	})
+}
+
+func TestParseVmDiskSnapshotId(t *testing.T) {
+	vmID, diskID, snapID, err := parseVmDiskSnapshotId(vmDiskSnapshotId(42, 1, 3))
+	if err != nil || vmID != 42 || diskID != 1 || snapID != 3 {
+		t.Fatalf("Expected VM 42, disk 1 and snapshot 3, got %d, %d and %d (err: %v)", vmID, diskID, snapID, err)
+	}
+
+	if _, _, _, err := parseVmDiskSnapshotId("42:3"); err == nil {
+		t.Errorf("Expected a VM snapshot ID to be rejected")
+	}
+}
+
+func TestVmDiskSnapshot(t *testing.T) {
+	var vm UserVm
+	if err := xml.Unmarshal([]byte(testVmInfoDiskSnapshots), &vm); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	snapshot := vmDiskSnapshot(&vm, 1, 0)
+	if snapshot == nil || snapshot.Name != "data-nightly" || snapshot.Size != 1024 || snapshot.Date != 1546300800 {
+		t.Fatalf("Expected snapshot data-nightly, got %v", snapshot)
+	}
+	if snapshot := vmDiskSnapshot(&vm, 0, 1); snapshot == nil || snapshot.Parent != 0 {
+		t.Fatalf("Expected snapshot 1 of disk 0, got %v", snapshot)
+	}
+	if snapshot := vmDiskSnapshot(&vm, 1, 1); snapshot != nil {
+		t.Fatalf("Expected no snapshot 1 of disk 1, got %v", snapshot)
+	}
+}
+
+func TestResourceVmDiskSnapshotReadErrors(t *testing.T) {
+	defer func(delay time.Duration) { transportRetryDelay = delay }(transportRetryDelay)
+	transportRetryDelay = 0
+
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch args[0] {
+		case 42:
+			return []interface{}{false, "[one.vm.info] Error getting virtual machine [42].", int64(0x0400)}, nil
+		case 43:
+			return []interface{}{false, "[one.vm.info] Not authorized", int64(0x0200)}, nil
+		}
+		return nil, fmt.Errorf("connection refused")
+	})
+
+	cases := []struct {
+		vmID  int
+		gone  bool
+		error string
+	}{
+		{42, true, ""},
+		{43, false, "Not authorized"},
+		{44, false, "connection refused"},
+	}
+
+	for _, c := range cases {
+		d := schema.TestResourceDataRaw(t, resourceVmDiskSnapshot().Schema, map[string]interface{}{})
+		d.SetId(vmDiskSnapshotId(c.vmID, 0, 1))
+
+		err := resourceVmDiskSnapshotRead(d, client)
+		if c.error == "" && err != nil {
+			t.Errorf("VM %d: Unexpected error: %s", c.vmID, err)
+		}
+		if c.error != "" && (err == nil || !strings.Contains(err.Error(), c.error)) {
+			t.Errorf("VM %d: Expected an error with %q, got: %v", c.vmID, c.error, err)
+		}
+		if gone := d.Id() == ""; gone != c.gone {
+			t.Errorf("VM %d: Expected removal from state to be %t, got %t", c.vmID, c.gone, gone)
+		}
+	}
+}
+
+func testAccCheckVmDiskSnapshotDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_vm_disk_snapshot" {
+			continue
+		}
+
+		vmID, diskID, snapID, err := parseVmDiskSnapshotId(rs.Primary.ID)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.VmInfo(vmID)
+		if err != nil {
+			continue
+		}
+
+		var vm UserVm
+		if err = xml.Unmarshal([]byte(resp), &vm); err != nil {
+			return err
+		}
+
+		if vm.State != 6 && vmDiskSnapshot(&vm, diskID, snapID) != nil {
+			return fmt.Errorf("Expected snapshot %d of disk %d of VM %d to have been deleted", snapID, diskID, vmID)
+		}
+	}
+
+	return nil
+}
+
+var testAccVmDiskSnapshotConfig = `
+resource "opennebula_image" "disk" {
+  name = "tf-acc-test-vm-disk-snapshot"
+  datastore_id = %d
+  type = "DATABLOCK"
+  size = 16
+  persistent = false
+}
+
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-disk-snapshot"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+
+  disk {
+    image_id = "${opennebula_image.disk.id}"
+  }
+}
+
+resource "opennebula_vm_disk_snapshot" "test" {
+  vm_id = "${opennebula_vm.test.id}"
+  disk_id = 0
+  name = "tf-acc-test-nightly"
+}
+`
+
+var testVmInfoDiskSnapshots = `
+<VM>
+  <ID>42</ID>
+  <SNAPSHOTS>
+    <DISK_ID>0</DISK_ID>
+    <SNAPSHOT>
+      <ACTIVE>YES</ACTIVE>
+      <DATE>1546297200</DATE>
+      <ID>0</ID>
+      <NAME>os-before-upgrade</NAME>
+      <PARENT>-1</PARENT>
+      <SIZE>2048</SIZE>
+    </SNAPSHOT>
+    <SNAPSHOT>
+      <DATE>1546300800</DATE>
+      <ID>1</ID>
+      <NAME>os-after-upgrade</NAME>
+      <PARENT>0</PARENT>
+      <SIZE>2048</SIZE>
+    </SNAPSHOT>
+  </SNAPSHOTS>
+  <SNAPSHOTS>
+    <DISK_ID>1</DISK_ID>
+    <SNAPSHOT>
+      <DATE>1546300800</DATE>
+      <ID>0</ID>
+      <NAME>data-nightly</NAME>
+      <PARENT>-1</PARENT>
+      <SIZE>1024</SIZE>
+    </SNAPSHOT>
+  </SNAPSHOTS>
+</VM>
+`
@@ -0,0 +1,227 @@
+package opennebula
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// VmSnapshot is a system snapshot of a VM, listed in its template.
+type VmSnapshot struct {
+	ID           int    `xml:"SNAPSHOT_ID"`
+	Name         string `xml:"NAME"`
+	Time         int    `xml:"TIME"`
+	HypervisorID string `xml:"HYPERVISOR_ID"`
+}
+
+func resourceVmSnapshot() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceVmSnapshotCreate,
+		Read:   resourceVmSnapshotRead,
+		Delete: resourceVmSnapshotDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"vm_id": {
+				Type:        schema.TypeInt,
+				Required:    true,
+				ForceNew:    true,
+				Description: "ID of the VM to snapshot",
+			},
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Name of the snapshot",
+			},
+			"snapshot_id": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "ID of the snapshot in the VM",
+			},
+			"time": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Unix time the snapshot was taken at",
+			},
+			"hypervisor_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "ID of the snapshot in the hypervisor",
+			},
+		},
+	}
+}
+
+// vmSnapshotId returns the ID of a snapshot resource, <vm_id>:<snapshot_id>
+// as snapshot IDs are only unique within a VM.
+func vmSnapshotId(vmID, snapID int) string {
+	return fmt.Sprintf("%d:%d", vmID, snapID)
+}
+
+func parseVmSnapshotId(id string) (int, int, error) {
+	ids, err := splitIds(id, 2)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Unexpected VM snapshot ID %q, expected <vm_id>:<snapshot_id>", id)
+	}
+	return ids[0], ids[1], nil
+}
+
+// splitIds parses the ID of a resource made of n colon separated IDs.
+func splitIds(id string, n int) ([]int, error) {
+	parts := strings.Split(id, ":")
+	if len(parts) != n {
+		return nil, fmt.Errorf("expected %d IDs in %q", n, id)
+	}
+
+	ids := make([]int, n)
+	for i, part := range parts {
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = v
+	}
+	return ids, nil
+}
+
+func resourceVmSnapshotCreate(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*Client)
+	vmID := d.Get("vm_id").(int)
+
+	resp, err := client.VmSnapshotCreate(vmID, d.Get("name").(string))
+	if err != nil {
+		return fmt.Errorf("Error creating snapshot of VM %d: %s", vmID, err)
+	}
+
+	d.SetId(vmSnapshotId(vmID, intId(resp)))
+
+	if _, err = waitForVmSnapshot(client, vmID); err != nil {
+		return fmt.Errorf("Error waiting for snapshot %s of VM %d: %s", resp, vmID, err)
+	}
+
+	if err = resourceVmSnapshotRead(d, meta); err != nil {
+		return err
+	}
+	// A failed snapshot is dropped by OpenNebula once the VM runs again
+	if d.Id() == "" {
+		return fmt.Errorf("Snapshot %s of VM %d failed, see the VM log", resp, vmID)
+	}
+
+	log.Printf("[INFO] Successfully created snapshot %s of VM %d", resp, vmID)
+	return nil
+}
+
+func resourceVmSnapshotRead(d *schema.ResourceData, meta interface{}) error {
+	var vm *UserVm
+	client := meta.(*Client)
+
+	vmID, snapID, err := parseVmSnapshotId(d.Id())
+	if err != nil {
+		return err
+	}
+
+	resp, err := retryTransport(func() (string, error) {
+		return client.VmInfo(vmID)
+	})
+	if isNotFound(err) {
+		log.Printf("[WARN] Could not find VM %d of snapshot %d, removing it from state", vmID, snapID)
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Couldn't fetch VM %d of snapshot %d: %s", vmID, snapID, err)
+	}
+	if err = client.Decode(resp, &vm); err != nil {
+		return err
+	}
+
+	snapshot := vmSnapshot(vm, snapID)
+	// Terminated VMs keep their template, not their snapshots
+	if snapshot == nil || vm.State == 6 {
+		log.Printf("[WARN] Could not find snapshot %d of VM %d, removing it from state", snapID, vmID)
+		d.SetId("")
+		return nil
+	}
+
+	d.Set("vm_id", vmID)
+	d.Set("name", snapshot.Name)
+	d.Set("snapshot_id", snapshot.ID)
+	d.Set("time", snapshot.Time)
+	d.Set("hypervisor_id", snapshot.HypervisorID)
+
+	return nil
+}
+
+// vmSnapshot returns the snapshot of the VM with the given ID, or nil.
+func vmSnapshot(vm *UserVm, snapID int) *VmSnapshot {
+	if vm.VmTemplate == nil {
+		return nil
+	}
+	for i, snapshot := range vm.VmTemplate.Snapshots {
+		if snapshot.ID == snapID {
+			return &vm.VmTemplate.Snapshots[i]
+		}
+	}
+	return nil
+}
+
+func resourceVmSnapshotDelete(d *schema.ResourceData, meta interface{}) error {
+	err := resourceVmSnapshotRead(d, meta)
+	if err != nil || d.Id() == "" {
+		return err
+	}
+
+	client := meta.(*Client)
+	vmID, snapID, _ := parseVmSnapshotId(d.Id())
+
+	if _, err = client.VmSnapshotDelete(vmID, snapID); err != nil {
+		return fmt.Errorf("Error deleting snapshot %d of VM %d: %s", snapID, vmID, err)
+	}
+
+	if _, err = waitForVmSnapshot(client, vmID); err != nil {
+		return fmt.Errorf("Error waiting for snapshot %d of VM %d to be deleted: %s", snapID, vmID, err)
+	}
+
+	log.Printf("[INFO] Successfully deleted snapshot %d of VM %d", snapID, vmID)
+	return nil
+}
+
+// waitForVmSnapshot waits for a VM to be done with its snapshot operation.
+func waitForVmSnapshot(client *Client, vmID int) (interface{}, error) {
+	var vm *UserVm
+
+	stateConf := &resource.StateChangeConf{
+		Pending: []string{"snapshot"},
+		Target:  []string{"done"},
+		Refresh: func() (interface{}, string, error) {
+			resp, err := retryTransport(func() (string, error) {
+				return client.VmInfo(vmID)
+			})
+			if err != nil {
+				return nil, "", fmt.Errorf("Couldn't fetch VM %d: %s", vmID, err)
+			}
+			if err = client.Decode(resp, &vm); err != nil {
+				return nil, "", fmt.Errorf("Couldn't fetch VM state: %s", err)
+			}
+
+			// HOTPLUG_SNAPSHOT while a snapshot is taken, reverted or deleted
+			if vm.State == 3 && vm.LcmState == 24 {
+				return vm, "snapshot", nil
+			}
+			return vm, "done", nil
+		},
+		Timeout:    10 * time.Minute,
+		Delay:      3 * time.Second,
+		MinTimeout: 3 * time.Second,
+	}
+
+	return stateConf.WaitForState()
+}
@@ -0,0 +1,200 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccVmSnapshot(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmSnapshotDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVmSnapshotConfig,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair("opennebula_vm_snapshot.test", "vm_id", "opennebula_vm.test", "id"),
+					resource.TestCheckResourceAttr("opennebula_vm_snapshot.test", "name", "tf-acc-test-checkpoint"),
+					resource.TestCheckResourceAttr("opennebula_vm_snapshot.test", "snapshot_id", "0"),
+					resource.TestCheckResourceAttrSet("opennebula_vm_snapshot.test", "time"),
+				),
+			},
+			{
+				ResourceName:      "opennebula_vm_snapshot.test",
+				ImportState:       true,
+				ImportStateVerify: true,
+			},
+		},
+	})
+}
+
+func TestParseVmSnapshotId(t *testing.T) {
+	vmID, snapID, err := parseVmSnapshotId(vmSnapshotId(42, 3))
+	if err != nil || vmID != 42 || snapID != 3 {
+		t.Fatalf("Expected VM 42 and snapshot 3, got %d and %d (err: %v)", vmID, snapID, err)
+	}
+
+	for _, id := range []string{"42", "42:", "a:3", "42:3:1"} {
+		if _, _, err := parseVmSnapshotId(id); err == nil {
+			t.Errorf("Expected %q to be rejected", id)
+		}
+	}
+}
+
+func TestVmSnapshot(t *testing.T) {
+	var vm UserVm
+	if err := xml.Unmarshal([]byte(testVmInfoSnapshots), &vm); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	snapshot := vmSnapshot(&vm, 1)
+	if snapshot == nil || snapshot.Name != "after-upgrade" || snapshot.HypervisorID != "onesnap-1" || snapshot.Time != 1546300800 {
+		t.Fatalf("Expected snapshot after-upgrade, got %v", snapshot)
+	}
+	if snapshot := vmSnapshot(&vm, 2); snapshot != nil {
+		t.Fatalf("Expected no snapshot 2, got %v", snapshot)
+	}
+}
+
+func TestResourceVmSnapshotReadErrors(t *testing.T) {
+	defer func(delay time.Duration) { transportRetryDelay = delay }(transportRetryDelay)
+	transportRetryDelay = 0
+
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch args[0] {
+		case 42:
+			return []interface{}{false, "[one.vm.info] Error getting virtual machine [42].", int64(0x0400)}, nil
+		case 43:
+			return []interface{}{false, "[one.vm.info] Not authorized", int64(0x0200)}, nil
+		}
+		return nil, fmt.Errorf("connection refused")
+	})
+
+	cases := []struct {
+		id    string
+		gone  bool
+		error string
+	}{
+		{"42:1", true, ""},
+		{"43:1", false, "Not authorized"},
+		{"44:1", false, "connection refused"},
+	}
+
+	for _, c := range cases {
+		d := schema.TestResourceDataRaw(t, resourceVmSnapshot().Schema, map[string]interface{}{})
+		d.SetId(c.id)
+
+		err := resourceVmSnapshotRead(d, client)
+		if c.error == "" && err != nil {
+			t.Errorf("%s: Unexpected error: %s", c.id, err)
+		}
+		if c.error != "" && (err == nil || !strings.Contains(err.Error(), c.error)) {
+			t.Errorf("%s: Expected an error with %q, got: %v", c.id, c.error, err)
+		}
+		if gone := d.Id() == ""; gone != c.gone {
+			t.Errorf("%s: Expected removal from state to be %t, got %t", c.id, c.gone, gone)
+		}
+	}
+}
+
+func TestWaitForVmSnapshot(t *testing.T) {
+	defer func(delay time.Duration) { transportRetryDelay = delay }(transportRetryDelay)
+	transportRetryDelay = 0
+
+	infos := 0
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.vm.info" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		if args[0] == 43 {
+			return []interface{}{false, "[one.vm.info] Not authorized", int64(0x0200)}, nil
+		}
+		infos++
+		if infos == 1 {
+			return nil, fmt.Errorf("connection reset by peer")
+		}
+		return []interface{}{true, "<VM><ID>42</ID><STATE>3</STATE><LCM_STATE>3</LCM_STATE></VM>", int64(0)}, nil
+	})
+
+	if _, err := waitForVmSnapshot(client, 42); err != nil {
+		t.Fatalf("Expected transport errors to be retried, got: %s", err)
+	}
+
+	_, err := waitForVmSnapshot(client, 43)
+	if err == nil || !strings.Contains(err.Error(), "Not authorized") {
+		t.Fatalf("Expected the error of one.vm.info, got: %v", err)
+	}
+}
+
+func testAccCheckVmSnapshotDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_vm_snapshot" {
+			continue
+		}
+
+		vmID, snapID, err := parseVmSnapshotId(rs.Primary.ID)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.VmInfo(vmID)
+		if err != nil {
+			continue
+		}
+
+		var vm UserVm
+		if err = xml.Unmarshal([]byte(resp), &vm); err != nil {
+			return err
+		}
+
+		if vm.State != 6 && vmSnapshot(&vm, snapID) != nil {
+			return fmt.Errorf("Expected snapshot %d of VM %d to have been deleted", snapID, vmID)
+		}
+	}
+
+	return nil
+}
+
+var testAccVmSnapshotConfig = `
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-snapshot"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+}
+
+resource "opennebula_vm_snapshot" "test" {
+  vm_id = "${opennebula_vm.test.id}"
+  name = "tf-acc-test-checkpoint"
+}
+`
+
+var testVmInfoSnapshots = `
+<VM>
+  <ID>42</ID>
+  <TEMPLATE>
+    <SNAPSHOT>
+      <ACTIVE>YES</ACTIVE>
+      <HYPERVISOR_ID>onesnap-0</HYPERVISOR_ID>
+      <NAME>before-upgrade</NAME>
+      <SNAPSHOT_ID>0</SNAPSHOT_ID>
+      <TIME>1546297200</TIME>
+    </SNAPSHOT>
+    <SNAPSHOT>
+      <HYPERVISOR_ID>onesnap-1</HYPERVISOR_ID>
+      <NAME>after-upgrade</NAME>
+      <SNAPSHOT_ID>1</SNAPSHOT_ID>
+      <TIME>1546300800</TIME>
+    </SNAPSHOT>
+  </TEMPLATE>
+</VM>
+`
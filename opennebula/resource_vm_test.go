@@ -0,0 +1,1778 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"log"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	resource.AddTestSweepers("opennebula_vm", &resource.Sweeper{
+		Name: "opennebula_vm",
+		F:    testSweepVms,
+	})
+}
+
+func testSweepVms(region string) error {
+	client, err := sharedClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.VmPoolInfo(-3, -1, -1, -1)
+	if err != nil {
+		return err
+	}
+
+	var vms UserVms
+	if err = xml.Unmarshal([]byte(resp), &vms); err != nil {
+		return err
+	}
+
+	for _, vm := range vms.UserVm {
+		if !strings.HasPrefix(vm.Name, testAccPrefix) {
+			continue
+		}
+
+		log.Printf("[INFO] Sweeping VM %s (%s)", vm.Name, vm.Id)
+		if _, err := client.VmAction("terminate-hard", intId(vm.Id)); err != nil {
+			log.Printf("[ERROR] Failed to sweep VM %s: %s", vm.Id, err)
+		}
+	}
+
+	return nil
+}
+
+func TestAccVm(t *testing.T) {
+	var id string
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmConfigBasic, testAccDatastoreID(t), "tf-acc-test-vm", testAccVnetID(t), "642"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "name", "tf-acc-test-vm"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "instance", "tf-acc-test-vm"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "permissions", "642"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "state", "3"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "lcmstate", "3"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "state_name", "ACTIVE"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "lcm_state_name", "RUNNING"),
+					resource.TestCheckResourceAttrSet("opennebula_vm.test", "uid"),
+					resource.TestCheckResourceAttrSet("opennebula_vm.test", "gid"),
+					resource.TestCheckResourceAttrSet("opennebula_vm.test", "deployed_host_id"),
+					resource.TestCheckResourceAttrSet("opennebula_vm.test", "system_datastore_id"),
+					resource.TestCheckResourceAttrSet("opennebula_vm.test", "effective_memory"),
+					resource.TestMatchResourceAttr("opennebula_vm.test", "rendered_template", regexp.MustCompile("<NAME>tf-acc-test-vm</NAME>")),
+				),
+			},
+			{
+				ResourceName:      "opennebula_vm.test",
+				ImportState:       true,
+				ImportStateVerify: true,
+				// OpenNebula fills in the disk target and size and the leased IP,
+				// the name arguments only matter on creation and monitoring changes
+				// between refreshes
+				ImportStateVerifyIgnore: []string{"disk", "nic", "name_unique", "name_suffix_random", "monitoring", "recreate_on_failure", "recover_on_failure", "strict_id_lookup", "release_on_hold", "recover_retry_count", "wait_for_state", "rendered_template"},
+			},
+			{
+				// VMs can be imported by name as well
+				ResourceName:            "opennebula_vm.test",
+				ImportState:             true,
+				ImportStateId:           "tf-acc-test-vm",
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"disk", "nic", "name_unique", "name_suffix_random", "monitoring", "recreate_on_failure", "recover_on_failure", "strict_id_lookup", "release_on_hold", "recover_retry_count", "wait_for_state", "rendered_template"},
+			},
+			{
+				Config: fmt.Sprintf(testAccVmConfigBasic, testAccDatastoreID(t), "tf-acc-test-vm-renamed", testAccVnetID(t), "600"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "name", "tf-acc-test-vm-renamed"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "instance", "tf-acc-test-vm-renamed"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "permissions", "600"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccVmDiskResize(t *testing.T) {
+	var id string
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmConfigDiskSize, testAccDatastoreID(t), 32),
+				Check:  testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+			},
+			{
+				Config: fmt.Sprintf(testAccVmConfigDiskSize, testAccDatastoreID(t), 64),
+				Check:  testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+			},
+			{
+				Config:   fmt.Sprintf(testAccVmConfigDiskSize, testAccDatastoreID(t), 64),
+				PlanOnly: true,
+			},
+			{
+				Config:      fmt.Sprintf(testAccVmConfigDiskSize, testAccDatastoreID(t), 32),
+				ExpectError: regexp.MustCompile("can't shrink"),
+			},
+		},
+	})
+}
+
+func TestAccVmNicsOnSameNetwork(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVmConfigNicsOnSameNetwork,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vm.test", "nic.#", "2"),
+				),
+			},
+			{
+				// a second apply of the same configuration must not find any change
+				Config:   testAccVmConfigNicsOnSameNetwork,
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVmNicMAC(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVmConfigNicMAC,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vm.test", "ordered_nic.0.mac", "02:00:ac:10:64:09"),
+				),
+			},
+			{
+				Config:   testAccVmConfigNicMAC,
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVmOrderedNics(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVmConfigOrderedNics,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vm.test", "ordered_nic.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "ordered_nic.0.nic_id", "0"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "ordered_nic.0.ip", "172.16.100.3"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "ordered_nic.1.nic_id", "1"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "ordered_nic.1.ip", "172.16.100.2"),
+				),
+			},
+			{
+				Config:   testAccVmConfigOrderedNics,
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVmSchedActions(t *testing.T) {
+	var id string
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmConfigSchedActions, testAccSchedActionPoweroff),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "sched_action.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "sched_action.0.id", "0"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "sched_action.0.action", "poweroff"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "sched_action.0.repeat", "weekly"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "sched_action.0.days", "1,2,3,4,5"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccVmConfigSchedActions, testAccSchedActionPoweroff+testAccSchedActionTerminate),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "sched_action.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "sched_action.0.id", "0"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "sched_action.1.id", "1"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "sched_action.1.action", "terminate"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccVmConfigSchedActions, ""),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "sched_action.#", "0"),
+				),
+			},
+		},
+	})
+}
+
+// The files datastore must be on the frontend running the tests, which
+// registers the fixture from its local path.
+func TestAccVmContextFiles(t *testing.T) {
+	path, err := filepath.Abs("test-fixtures/context-script.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmConfigContextFiles, testAccFilesDatastoreID(t), path),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_image.scripts", "type", "CONTEXT"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "context_files.#", "1"),
+					resource.TestCheckResourceAttrPair("opennebula_vm.test", "context_files.0", "opennebula_image.scripts", "id"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "lcm_state_name", "RUNNING"),
+				),
+			},
+			{
+				Config:   fmt.Sprintf(testAccVmConfigContextFiles, testAccFilesDatastoreID(t), path),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVmGroup(t *testing.T) {
+	var id string
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmConfigGroup, `group = "users"`),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "group", "users"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "gname", "users"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "gid", "1"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccVmConfigGroup, `gid = 0`),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "gname", "oneadmin"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "gid", "0"),
+				),
+			},
+			{
+				// the group change must converge
+				Config:   fmt.Sprintf(testAccVmConfigGroup, `gid = 0`),
+				PlanOnly: true,
+			},
+			{
+				Config: fmt.Sprintf(testAccVmConfigGroup, "uid = 0\n  group = \"users\""),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "uid", "0"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "uname", "oneadmin"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "gname", "users"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccVmUpdate(t *testing.T) {
+	var id string
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmConfigUpdate, "tf-acc-test-vm-update", "642", "Before", "false"),
+				Check:  testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+			},
+			{
+				Config: fmt.Sprintf(testAccVmConfigUpdate, "tf-acc-test-vm-updated", "600", "After", "true"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "instance", "tf-acc-test-vm-updated"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "permissions", "600"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "description", "After"),
+				),
+			},
+			{
+				// every update must converge
+				Config:   fmt.Sprintf(testAccVmConfigUpdate, "tf-acc-test-vm-updated", "600", "After", "true"),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVmNamePrefix(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVmConfigNamePrefix,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestMatchResourceAttr("opennebula_vm.test.0", "instance", regexp.MustCompile("^tf-acc-test-vm-prefix-[0-9a-f]{16}$")),
+					resource.TestMatchResourceAttr("opennebula_vm.test.1", "instance", regexp.MustCompile("^tf-acc-test-vm-prefix-[0-9a-f]{16}$")),
+					func(s *terraform.State) error {
+						first := s.RootModule().Resources["opennebula_vm.test.0"].Primary.Attributes["instance"]
+						second := s.RootModule().Resources["opennebula_vm.test.1"].Primary.Attributes["instance"]
+						if first == second {
+							return fmt.Errorf("Expected distinct names, got %q twice", first)
+						}
+						return nil
+					},
+				),
+			},
+			{
+				Config:   testAccVmConfigNamePrefix,
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVmGraphics(t *testing.T) {
+	var id string
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmConfigGraphics, "127.0.0.1"),
+				Check:  testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+			},
+			{
+				Config: fmt.Sprintf(testAccVmConfigGraphics, "0.0.0.0"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "graphics.#", "1"),
+				),
+			},
+			{
+				Config:   fmt.Sprintf(testAccVmConfigGraphics, "0.0.0.0"),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVmNoWaitForState(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVmConfigNoWaitForState,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vm.test", "wait_for_state", "false"),
+					resource.TestCheckResourceAttrSet("opennebula_vm.test", "state"),
+				),
+			},
+			{
+				// booting doesn't show as a change
+				Config:   testAccVmConfigNoWaitForState,
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVmGroupRole(t *testing.T) {
+	vmgroupID := testAccVmGroupID(t)
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmConfigGroupRole, vmgroupID),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vm.test", "vmgroup.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "vmgroup.0.vmgroup_id", fmt.Sprint(vmgroupID)),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "vmgroup.0.role", "tf-acc-test"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccVmDescription(t *testing.T) {
+	var id string
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmConfigDescription, "Web frontend"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "description", "Web frontend"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccVmConfigDescription, "Web frontend, <do not stop>"),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmNotReplaced("opennebula_vm.test", &id),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "description", "Web frontend, <do not stop>"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccVmTemplate(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVmDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVmConfigTemplate,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair("opennebula_vm.test", "template_id", "opennebula_template.test", "id"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "template_name", "tf-acc-test-vm-template"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "cpu", "0.1"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "vcpu", "1"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "memory", "128"),
+					resource.TestCheckResourceAttr("opennebula_vm.override", "memory", "64"),
+					resource.TestCheckResourceAttr("opennebula_vm.override", "rendered_template", `MEMORY = "64"`),
+				),
+			},
+			{
+				ResourceName:            "opennebula_vm.test",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"name_unique", "name_suffix_random", "monitoring", "recreate_on_failure", "recover_on_failure", "strict_id_lookup", "release_on_hold", "recover_retry_count", "wait_for_state", "rendered_template"},
+			},
+		},
+	})
+}
+
+func TestAccVmInstantiatePersistent(t *testing.T) {
+	var images []int
+	var templateID int
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t) },
+		Providers: testAccProviders,
+		CheckDestroy: func(s *terraform.State) error {
+			if err := testAccCheckVmDestroy(s); err != nil {
+				return err
+			}
+			client := testAccProvider.Meta().(*Client)
+			for _, image := range images {
+				if _, err := client.ImageInfo(image); err == nil {
+					return fmt.Errorf("Expected persistent image %d to have been deleted with its VM", image)
+				}
+			}
+			if _, err := client.TemplateInfo(templateID, false); err == nil {
+				return fmt.Errorf("Expected persistent template %d to have been deleted with its VM", templateID)
+			}
+			return nil
+		},
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVmConfigInstantiatePersistent, testAccDatastoreID(t)),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckVmPersistentImages("opennebula_vm.test", &images),
+					testAccCheckVmPersistentTemplate("opennebula_vm.test", &templateID),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "persistent_image_ids.#", "1"),
+					resource.TestCheckResourceAttrPair("opennebula_vm.test", "template_id", "opennebula_template.test", "id"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "template_disk.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_vm.test", "template_disk.0.disk_id", "0"),
+					resource.TestCheckResourceAttrSet("opennebula_vm.test", "template_disk.0.size"),
+				),
+			},
+			{
+				// The VM runs from the copy, template_id is kept as configured
+				Config:   fmt.Sprintf(testAccVmConfigInstantiatePersistent, testAccDatastoreID(t)),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+// testAccCheckVmPersistentImages checks the disks of a VM are persistent
+// copies of the images of its template, and records their IDs.
+func testAccCheckVmPersistentImages(name string, images *[]int) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("Not found: %s", name)
+		}
+		image := s.RootModule().Resources["opennebula_image.disk"].Primary.ID
+
+		client := testAccProvider.Meta().(*Client)
+		resp, err := client.VmInfo(intId(rs.Primary.ID))
+		if err != nil {
+			return err
+		}
+		var vm UserVm
+		if err = xml.Unmarshal([]byte(resp), &vm); err != nil {
+			return err
+		}
+
+		*images = vmPersistentImages(vm.VmTemplate.Disks)
+		if len(*images) != 1 || fmt.Sprint((*images)[0]) == image {
+			return fmt.Errorf("Expected a persistent copy of image %s, got disks %v", image, vm.VmTemplate.Disks)
+		}
+		return nil
+	}
+}
+
+// testAccCheckVmPersistentTemplate checks the VM recorded the copy of its
+// template, and records its ID.
+func testAccCheckVmPersistentTemplate(name string, templateID *int) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[name]
+		if !ok {
+			return fmt.Errorf("Not found: %s", name)
+		}
+
+		id, err := strconv.Atoi(rs.Primary.Attributes["persistent_template_id"])
+		if err != nil || rs.Primary.Attributes["persistent_template_id"] == rs.Primary.Attributes["template_id"] {
+			return fmt.Errorf("Expected a copy of template %s, got %q", rs.Primary.Attributes["template_id"], rs.Primary.Attributes["persistent_template_id"])
+		}
+		*templateID = id
+		return nil
+	}
+}
+
+func TestVmPersistentImages(t *testing.T) {
+	var tpl VmTemplate
+	err := xml.Unmarshal([]byte(`<TEMPLATE>
+  <DISK><DISK_ID>0</DISK_ID><IMAGE_ID>12</IMAGE_ID><PERSISTENT>YES</PERSISTENT></DISK>
+  <DISK><DISK_ID>1</DISK_ID><IMAGE_ID>13</IMAGE_ID></DISK>
+  <DISK><DISK_ID>2</DISK_ID><IMAGE_ID>14</IMAGE_ID><PERSISTENT>NO</PERSISTENT></DISK>
+</TEMPLATE>`), &tpl)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if images := vmPersistentImages(tpl.Disks); !reflect.DeepEqual(images, []int{12}) {
+		t.Fatalf("Expected only image 12 to be persistent, got %v", images)
+	}
+}
+
+func TestSetVmPersistentCopies(t *testing.T) {
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.vm.info" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		return []interface{}{true, `<VM><ID>42</ID><TEMPLATE>
+  <DISK><DISK_ID>0</DISK_ID><IMAGE_ID>21</IMAGE_ID><PERSISTENT>YES</PERSISTENT></DISK>
+  <DISK><DISK_ID>1</DISK_ID><IMAGE_ID>22</IMAGE_ID><PERSISTENT>YES</PERSISTENT></DISK>
+  <TEMPLATE_ID>13</TEMPLATE_ID>
+</TEMPLATE></VM>`, int64(0)}, nil
+	})
+
+	d := schema.TestResourceDataRaw(t, resourceVm().Schema, map[string]interface{}{
+		"template_id":            12,
+		"instantiate_persistent": true,
+	})
+	d.SetId("42")
+
+	if err := setVmPersistentCopies(d, client); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if images := fmt.Sprint(d.Get("persistent_image_ids")); images != "[21 22]" {
+		t.Errorf("Expected the copies of the images to be images 21 and 22, got %s", images)
+	}
+	if templateID := d.Get("persistent_template_id"); templateID != 13 {
+		t.Errorf("Expected the copy of the template to be template 13, got %v", templateID)
+	}
+}
+
+func TestVmTemplateID(t *testing.T) {
+	cases := []struct {
+		xml string
+		id  int
+		ok  bool
+	}{
+		{"<VM><TEMPLATE><TEMPLATE_ID>12</TEMPLATE_ID></TEMPLATE></VM>", 12, true},
+		{"<VM><TEMPLATE></TEMPLATE><USER_TEMPLATE><TEMPLATE_ID>13</TEMPLATE_ID></USER_TEMPLATE></VM>", 13, true},
+		{"<VM><TEMPLATE></TEMPLATE></VM>", 0, false},
+	}
+
+	for i, c := range cases {
+		var vm UserVm
+		if err := xml.Unmarshal([]byte(c.xml), &vm); err != nil {
+			t.Fatalf("%d: Unexpected error: %s", i, err)
+		}
+		if id, ok := vmTemplateID(&vm); id != c.id || ok != c.ok {
+			t.Errorf("%d: Expected %d (%v), got %d (%v)", i, c.id, c.ok, id, ok)
+		}
+	}
+}
+
+func TestVmCapacityOverrides(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceVm().Schema, map[string]interface{}{
+		"template_id": 12,
+		"cpu":         0.5,
+		"memory":      512,
+	})
+
+	expected := []string{`CPU = "0.5"`, `MEMORY = "512"`}
+	if extra := vmCapacityOverrides(d); !reflect.DeepEqual(extra, expected) {
+		t.Errorf("Expected %v, got %v", expected, extra)
+	}
+}
+
+func TestSetVmCapacity(t *testing.T) {
+	var vm UserVm
+	if err := xml.Unmarshal([]byte(`<VM><ID>42</ID><TEMPLATE><CPU>2</CPU><VCPU>4</VCPU><MEMORY>2048</MEMORY></TEMPLATE></VM>`), &vm); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	// The VM was resized since the last refresh
+	d := schema.TestResourceDataRaw(t, resourceVm().Schema, map[string]interface{}{
+		"template_id": 12,
+		"cpu":         0.5,
+		"vcpu":        1,
+		"memory":      512,
+	})
+
+	setVmCapacity(d, &vm)
+	if cpu, vcpu, memory := d.Get("cpu"), d.Get("vcpu"), d.Get("memory"); cpu != 0.5 || vcpu != 1 || memory != 512 {
+		t.Errorf("Expected the capacity in state to be kept, got cpu %v, vcpu %v and memory %v", cpu, vcpu, memory)
+	}
+
+	// Capacity taken from the template is filled in
+	d = schema.TestResourceDataRaw(t, resourceVm().Schema, map[string]interface{}{
+		"template_id": 12,
+	})
+
+	setVmCapacity(d, &vm)
+	if cpu, vcpu, memory := d.Get("cpu"), d.Get("vcpu"), d.Get("memory"); cpu != 2.0 || vcpu != 4 || memory != 2048 {
+		t.Errorf("Expected the capacity of the VM, got cpu %v, vcpu %v and memory %v", cpu, vcpu, memory)
+	}
+}
+
+func TestOrderedVmNICs(t *testing.T) {
+	nics := []VirtualMachineNIC{
+		{NIC_ID: 2, Network_ID: 5},
+		{NIC_ID: 0, Network_ID: 3},
+		{NIC_ID: 1, Network_ID: 3},
+	}
+
+	ordered := *orderedVmNICs(nics)
+	for i, nic := range ordered {
+		if nic.NIC_ID != i {
+			t.Fatalf("Expected the NICs sorted by NIC_ID, got %v", ordered)
+		}
+	}
+	if nics[0].NIC_ID != 2 {
+		t.Errorf("Expected the NICs of the VM to be left as is, got %v", nics)
+	}
+}
+
+// TestResourceVmUpdatableAttributes makes sure that no attribute of a VM can
+// show a diff that never converges: each optional attribute either forces a
+// new VM, is updated by resourceVmUpdate or only matters to the provider.
+func TestResourceVmUpdatableAttributes(t *testing.T) {
+	updated := []string{"name", "permissions", "uid", "group", "gid", "description", "disk", "sched_action", "graphics"}
+	stateOnly := []string{"name_unique", "wait_for_state", "wait_for_ready", "disk_saveas", "recreate_on_failure", "recover_on_failure", "strict_id_lookup", "release_on_hold", "recover_retry_count", "delete_persistent_disks"}
+
+	for key, s := range resourceVm().Schema {
+		if !s.Optional || s.ForceNew {
+			continue
+		}
+		if !in_array(key, updated) && !in_array(key, stateOnly) {
+			t.Errorf("%s can change without forcing a new VM, but isn't updated", key)
+		}
+	}
+}
+
+func TestResourceVMNicHash(t *testing.T) {
+	nic := func(ip string, secgroups ...interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"model":           "virtio",
+			"network_id":      3,
+			"ip":              ip,
+			"security_groups": secgroups,
+		}
+	}
+
+	if resourceVMNicHash(nic("10.0.0.10")) == resourceVMNicHash(nic("10.0.0.11")) {
+		t.Fatalf("Expected NICs on the same network with different IPs to hash differently")
+	}
+	if resourceVMNicHash(nic("", 0)) == resourceVMNicHash(nic("", 100)) {
+		t.Fatalf("Expected NICs on the same network with different security groups to hash differently")
+	}
+	if resourceVMNicHash(nic("10.0.0.10", 100)) != resourceVMNicHash(nic("10.0.0.10", 100)) {
+		t.Fatalf("Expected identical NICs to hash identically")
+	}
+
+	withMAC := func(mac string) map[string]interface{} {
+		m := nic("")
+		m["mac"] = mac
+		return m
+	}
+	if resourceVMNicHash(withMAC("02:00:0a:00:00:01")) == resourceVMNicHash(withMAC("02:00:0a:00:00:02")) {
+		t.Fatalf("Expected NICs on the same network with different MACs to hash differently")
+	}
+	if resourceVMNicHash(withMAC("02:00:0A:00:00:01")) != resourceVMNicHash(withMAC("02:00:0a:00:00:01")) {
+		t.Fatalf("Expected MACs to hash regardless of case")
+	}
+
+	// A NIC read back without model must keep the hash of a declared one without model
+	declared := map[string]interface{}{"network_id": 3}
+	read := map[string]interface{}{"network_id": 3, "model": "", "ip": "", "security_groups": []interface{}{}}
+	if resourceVMNicHash(declared) != resourceVMNicHash(read) {
+		t.Fatalf("Expected unset optional fields not to change the hash")
+	}
+}
+
+func TestPrefixedVmName(t *testing.T) {
+	names := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		name, err := prefixedVmName("web-")
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+		if !regexp.MustCompile(`^web-[0-9a-f]{16}$`).MatchString(name) {
+			t.Fatalf("Expected a name made of the prefix and 16 hex digits, got %q", name)
+		}
+		if names[name] {
+			t.Fatalf("Expected unique names, got %q twice", name)
+		}
+		names[name] = true
+	}
+}
+
+func TestValidateIPAndMAC(t *testing.T) {
+	for _, ip := range []string{"", "10.0.0.1", "2001:db8::5"} {
+		if _, errs := validateIP(ip, "nic.0.ip"); len(errs) > 0 {
+			t.Errorf("Expected %q to be valid, got: %v", ip, errs)
+		}
+	}
+	for _, ip := range []string{"10.0.0.300", "10.0.0", "host"} {
+		_, errs := validateIP(ip, "nic.0.ip")
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), ip) {
+			t.Errorf("Expected %q to be rejected with the value in the error, got: %v", ip, errs)
+		}
+	}
+
+	for _, mac := range []string{"", "02:00:0a:00:00:01", "02:00:0A:00:00:FF"} {
+		if _, errs := validateMAC(mac, "nic.0.mac"); len(errs) > 0 {
+			t.Errorf("Expected %q to be valid, got: %v", mac, errs)
+		}
+	}
+	for _, mac := range []string{"02:00:0a:00:00", "02-00-0a-00-00-01", "02:00:0a:00:00:0g", "0200.0a00.0001"} {
+		_, errs := validateMAC(mac, "nic.0.mac")
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), mac) {
+			t.Errorf("Expected %q to be rejected with the value in the error, got: %v", mac, errs)
+		}
+	}
+}
+
+func TestValidateNicModel(t *testing.T) {
+	for _, model := range []string{"", "virtio", "e1000"} {
+		if err := validateNicModel(model); err != nil {
+			t.Errorf("Expected %q to be valid, got: %s", model, err)
+		}
+	}
+	for _, model := range []string{"virito", "VIRTIO"} {
+		if err := validateNicModel(model); err == nil {
+			t.Errorf("Expected %q to be rejected", model)
+		}
+	}
+}
+
+func TestVmPrimaryIP(t *testing.T) {
+	cases := []struct {
+		nics     []VirtualMachineNIC
+		expected string
+	}{
+		{nil, ""},
+		{[]VirtualMachineNIC{}, ""},
+		{[]VirtualMachineNIC{{MAC: "02:00:0a:00:00:05"}}, ""},
+		{[]VirtualMachineNIC{{MAC: "02:00:0a:00:00:05"}, {IP: "10.0.0.6"}}, "10.0.0.6"},
+		{[]VirtualMachineNIC{{IP6_Global: "2001:db8::5"}, {IP: "10.0.0.6"}}, "10.0.0.6"},
+		{[]VirtualMachineNIC{{MAC: "02:00:0a:00:00:05"}, {IP6_Global: "2001:db8::5"}}, "2001:db8::5"},
+		{[]VirtualMachineNIC{{IP6: "2001:db8::7"}}, "2001:db8::7"},
+	}
+
+	for i, c := range cases {
+		if ip := vmPrimaryIP(c.nics); ip != c.expected {
+			t.Errorf("%d: Expected %q, got %q", i, c.expected, ip)
+		}
+	}
+}
+
+func TestKeepDeclaredFields(t *testing.T) {
+	declared := []interface{}{
+		map[string]interface{}{"network_id": 3, "ip": "", "model": "virtio", "security_groups": []interface{}{}},
+		map[string]interface{}{"network_id": 3, "ip": "10.0.0.20", "model": "", "security_groups": []interface{}{}},
+	}
+	read := []interface{}{
+		map[string]interface{}{"network_id": 3, "ip": "10.0.0.5", "model": "virtio", "security_groups": []interface{}{0}, "nic_id": 0},
+		map[string]interface{}{"network_id": 3, "ip": "10.0.0.20", "model": "virtio", "security_groups": []interface{}{0}, "nic_id": 1},
+	}
+
+	nics := keepDeclaredFields(read, declared, "network_id", []string{"ip", "model", "security_groups"})
+
+	first := nics[0].(map[string]interface{})
+	if _, ok := first["ip"]; ok {
+		t.Fatalf("Expected the leased IP of the first NIC to be dropped, got %v", first)
+	}
+	if first["model"] != "virtio" {
+		t.Fatalf("Expected the declared model of the first NIC to be kept, got %v", first)
+	}
+
+	second := nics[1].(map[string]interface{})
+	if second["ip"] != "10.0.0.20" {
+		t.Fatalf("Expected the static IP of the second NIC to be kept, got %v", second)
+	}
+	if _, ok := second["model"]; ok {
+		t.Fatalf("Expected the default model of the second NIC to be dropped, got %v", second)
+	}
+	if _, ok := second["security_groups"]; ok {
+		t.Fatalf("Expected the default security group of the second NIC to be dropped, got %v", second)
+	}
+	if second["nic_id"] != 1 {
+		t.Fatalf("Expected computed fields to be kept, got %v", second)
+	}
+}
+
+func TestValidateVmContext(t *testing.T) {
+	cases := []struct {
+		context      map[string]interface{}
+		onegate      bool
+		reportReady  bool
+		contextFiles bool
+		valid        bool
+	}{
+		{map[string]interface{}{"NETWORK": "YES"}, true, true, true, true},
+		{map[string]interface{}{"TOKEN": "YES"}, false, false, false, true},
+		{map[string]interface{}{"token": "YES"}, true, false, false, false},
+		{map[string]interface{}{"Report_Ready": "YES"}, false, true, false, false},
+		{map[string]interface{}{"REPORT_READY": "YES"}, true, false, false, true},
+		{map[string]interface{}{"NETWORK": "YES", "network": "NO"}, false, false, false, false},
+		{map[string]interface{}{"FILES_DS": "$FILE[IMAGE_ID=12]"}, false, false, false, true},
+		{map[string]interface{}{"files_ds": "$FILE[IMAGE_ID=12]"}, false, false, true, false},
+	}
+
+	for i, c := range cases {
+		err := validateVmContext(c.context, c.onegate, c.reportReady, c.contextFiles)
+		if c.valid && err != nil {
+			t.Errorf("%d: Expected %v to be valid, got: %s", i, c.context, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%d: Expected %v to be rejected", i, c.context)
+		}
+	}
+}
+
+func TestVmContextFiles(t *testing.T) {
+	if files := vmContextFiles([]interface{}{12}); files != "$FILE[IMAGE_ID=12]" {
+		t.Fatalf("Expected a single file, got %q", files)
+	}
+	if files := vmContextFiles([]interface{}{12, 7}); files != "$FILE[IMAGE_ID=12] $FILE[IMAGE_ID=7]" {
+		t.Fatalf("Expected the files in order, got %q", files)
+	}
+}
+
+func TestOnegateContextKeys(t *testing.T) {
+	if keys := onegateContextKeys(false, false); len(keys) != 0 {
+		t.Fatalf("Expected no context keys, got %v", keys)
+	}
+	if keys := onegateContextKeys(true, false); len(keys) != 1 || keys["TOKEN"] != "YES" {
+		t.Fatalf("Expected TOKEN=YES, got %v", keys)
+	}
+	if keys := onegateContextKeys(false, true); len(keys) != 2 || keys["TOKEN"] != "YES" || keys["REPORT_READY"] != "YES" {
+		t.Fatalf("Expected TOKEN=YES and REPORT_READY=YES, got %v", keys)
+	}
+}
+
+func TestFlattenVmContext(t *testing.T) {
+	read := StringMap{
+		"NETWORK":          "YES",
+		"HOSTNAME":         "test-vm",
+		"ETH0_IP":          "10.0.0.5",
+		"IP":               "10.0.0.5",
+		"DISK_ID":          "1",
+		"TARGET":           "hdb",
+		"TOKEN":            "YES",
+		"REPORT_READY":     "YES",
+		"ONEGATE_ENDPOINT": "http://onegate:5030",
+	}
+
+	declared := map[string]interface{}{"network": "YES", "Hostname": "test-vm", "IP": "$NIC[IP]"}
+	expected := map[string]interface{}{"network": "YES", "Hostname": "test-vm", "IP": "$NIC[IP]"}
+	if context := flattenVmContext(read, declared); !reflect.DeepEqual(context, expected) {
+		t.Fatalf("Expected declared context %v, got %v", expected, context)
+	}
+
+	expected = map[string]interface{}{"NETWORK": "YES", "HOSTNAME": "test-vm", "IP": "10.0.0.5"}
+	if context := flattenVmContext(read, map[string]interface{}{}); !reflect.DeepEqual(context, expected) {
+		t.Fatalf("Expected imported context %v, got %v", expected, context)
+	}
+
+	if !contextFlag(read, declared, "TOKEN") {
+		t.Fatalf("Expected TOKEN to be read as onegate")
+	}
+	if contextFlag(read, map[string]interface{}{"token": "YES"}, "TOKEN") {
+		t.Fatalf("Expected a TOKEN declared in context not to be read as onegate")
+	}
+}
+
+func TestFlattenVmDisks(t *testing.T) {
+	read := []VirtualMachineDisk{
+		{Disk_ID: "0", Image_ID: 5, Size: 2048, Target: "vda", Driver: "qcow2", Datastore_ID: 100, Datastore: "ceph"},
+		{Disk_ID: "1", Image_ID: 6, Size: 16, Target: "vdb"},
+	}
+	declared := []interface{}{
+		map[string]interface{}{"image_id": 5, "size": 0, "target": "", "driver": "qcow2"},
+		map[string]interface{}{"image_id": 6, "size": 16, "target": "", "driver": ""},
+	}
+
+	disks := keepDeclaredFields(flattenVmDisks(&read), declared, "image_id", []string{"size", "target", "driver"})
+	expected := []interface{}{
+		map[string]interface{}{"image_id": 5, "disk_id": 0, "computed_size": 2048, "driver": "qcow2", "datastore_id": 100, "datastore": "ceph"},
+		map[string]interface{}{"image_id": 6, "disk_id": 1, "size": 16, "computed_size": 16},
+	}
+	if !reflect.DeepEqual(disks, expected) {
+		t.Fatalf("Expected disks %v, got %v", expected, disks)
+	}
+
+	if graphics := flattenVmGraphics(&VirtualMachineGraphics{}); len(graphics) != 0 {
+		t.Fatalf("Expected no graphics, got %v", graphics)
+	}
+	if os := flattenVmOS(&VirtualMachineOS{Arch: "x86_64", Boot: "disk0"}); len(os) != 1 {
+		t.Fatalf("Expected an os block, got %v", os)
+	}
+}
+
+func TestFlattenVmTemplateDisks(t *testing.T) {
+	var tpl VmTemplate
+	err := xml.Unmarshal([]byte(`<TEMPLATE>
+  <DISK><DISK_ID>1</DISK_ID><IMAGE_ID>13</IMAGE_ID><SIZE>1024</SIZE><TARGET>vdb</TARGET></DISK>
+  <DISK><DISK_ID>0</DISK_ID><IMAGE_ID>12</IMAGE_ID><SIZE>8192</SIZE><TARGET>vda</TARGET><DATASTORE_ID>1</DATASTORE_ID><DATASTORE>default</DATASTORE></DISK>
+</TEMPLATE>`), &tpl)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{"disk_id": 0, "image_id": 12, "size": 8192, "target": "vda", "datastore_id": 1, "datastore": "default"},
+		map[string]interface{}{"disk_id": 1, "image_id": 13, "size": 1024, "target": "vdb"},
+	}
+	if disks := flattenVmTemplateDisks(tpl.Disks); !reflect.DeepEqual(disks, expected) {
+		t.Fatalf("Expected template disks %v, got %v", expected, disks)
+	}
+}
+
+func TestVmGraphicsUpdateConf(t *testing.T) {
+	var vm UserVm
+	err := xml.Unmarshal([]byte(`<VM><TEMPLATE>
+  <OS><ARCH>x86_64</ARCH><BOOT>disk0</BOOT></OS>
+  <GRAPHICS><LISTEN>127.0.0.1</LISTEN><TYPE>VNC</TYPE></GRAPHICS>
+  <CONTEXT><NETWORK>YES</NETWORK></CONTEXT>
+</TEMPLATE></VM>`), &vm)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	conf, err := xml.Marshal(vmGraphicsUpdateConf(vm.VmTemplate, &VirtualMachineGraphics{Listen: "0.0.0.0", Type: "VNC"}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, section := range []string{
+		"<OS><ARCH>x86_64</ARCH><BOOT>disk0</BOOT></OS>",
+		"<GRAPHICS><LISTEN>0.0.0.0</LISTEN><TYPE>VNC</TYPE></GRAPHICS>",
+		"<CONTEXT><NETWORK>YES</NETWORK></CONTEXT>",
+	} {
+		if !strings.Contains(string(conf), section) {
+			t.Errorf("Expected %s in the configuration, got %s", section, conf)
+		}
+	}
+
+	if conf, _ = xml.Marshal(vmGraphicsUpdateConf(&VmTemplate{}, nil)); strings.Contains(string(conf), "GRAPHICS") || strings.Contains(string(conf), "<OS>") {
+		t.Fatalf("Expected no GRAPHICS nor OS section, got %s", conf)
+	}
+}
+
+func TestUpdateVmGraphicsPoweredOff(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.vm.info":
+			return []interface{}{true, "<VM><ID>42</ID><STATE>8</STATE><LCM_STATE>0</LCM_STATE><TEMPLATE></TEMPLATE></VM>", int64(0)}, nil
+		case "one.vm.updateconf":
+			return []interface{}{true, int64(42), int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	d := schema.TestResourceDataRaw(t, resourceVm().Schema, map[string]interface{}{
+		"graphics": []interface{}{map[string]interface{}{"type": "VNC", "listen": "0.0.0.0"}},
+	})
+	d.SetId("42")
+
+	if err := updateVmGraphics(d, client); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if calls := caller.callsTo("one.vm.action"); len(calls) != 0 {
+		t.Errorf("Expected a powered off VM to be updated as is, got actions %v", calls)
+	}
+	if calls := caller.callsTo("one.vm.updateconf"); len(calls) != 1 || !strings.Contains(fmt.Sprint(calls[0].Args[1]), "<LISTEN>0.0.0.0</LISTEN>") {
+		t.Errorf("Expected the new graphics to be sent, got %v", calls)
+	}
+}
+
+func TestFlattenVmGroupRole(t *testing.T) {
+	var tpl VmTemplate
+	err := xml.Unmarshal([]byte(`<TEMPLATE>
+  <VMGROUP><ROLE>worker</ROLE><VMGROUP_ID>7</VMGROUP_ID></VMGROUP>
+</TEMPLATE>`), &tpl)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{"vmgroup_id": 7, "role": "worker"},
+	}
+	if groups := flattenVmGroupRole(tpl.VMGroup); !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("Expected vmgroup %v, got %v", expected, groups)
+	}
+	if groups := flattenVmGroupRole(nil); len(groups) != 0 {
+		t.Fatalf("Expected no vmgroup, got %v", groups)
+	}
+
+	out, err := xml.Marshal(&VmTemplate{VMGroup: tpl.VMGroup})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !strings.Contains(string(out), "<VMGROUP><VMGROUP_ID>7</VMGROUP_ID><ROLE>worker</ROLE></VMGROUP>") {
+		t.Fatalf("Expected a VMGROUP section, got %s", out)
+	}
+	if out, _ = xml.Marshal(&VmTemplate{}); strings.Contains(string(out), "VMGROUP") {
+		t.Fatalf("Expected no VMGROUP section, got %s", out)
+	}
+}
+
+func TestFlattenVmRAW(t *testing.T) {
+	var tpl VmTemplate
+	err := xml.Unmarshal([]byte(`<TEMPLATE>
+  <RAW><TYPE>kvm</TYPE><DATA><![CDATA[<devices><serial type="pty"/></devices>]]></DATA><VALIDATE>YES</VALIDATE></RAW>
+  <RAW><TYPE>vcenter</TYPE><DATA>guestinfo.foo = "bar"</DATA></RAW>
+</TEMPLATE>`), &tpl)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{"type": "kvm", "data": `<devices><serial type="pty"/></devices>`, "validate": true},
+		map[string]interface{}{"type": "vcenter", "data": `guestinfo.foo = "bar"`, "validate": false},
+	}
+	if raws := flattenVmRAW(tpl.RAW); !reflect.DeepEqual(raws, expected) {
+		t.Fatalf("Expected RAW sections %v, got %v", expected, raws)
+	}
+
+	validate := resourceVm().Schema["raw"].Elem.(*schema.Resource).Schema["type"].ValidateFunc
+	for _, rawType := range []string{"kvm", "KVM", "vmware", "vcenter"} {
+		if _, errs := validate(rawType, "type"); len(errs) > 0 {
+			t.Errorf("Expected %q to be valid, got: %v", rawType, errs)
+		}
+	}
+	if _, errs := validate("xen", "type"); len(errs) == 0 {
+		t.Errorf("Expected xen to be rejected")
+	}
+}
+
+func TestVmWaitForReady(t *testing.T) {
+	cases := []struct {
+		raw      map[string]interface{}
+		expected bool
+	}{
+		{map[string]interface{}{}, false},
+		{map[string]interface{}{"report_ready": true}, true},
+		{map[string]interface{}{"report_ready": true, "wait_for_ready": false}, false},
+		{map[string]interface{}{"wait_for_ready": true}, true},
+	}
+
+	for i, c := range cases {
+		d := schema.TestResourceDataRaw(t, resourceVm().Schema, c.raw)
+		if wait := vmWaitForReady(d); wait != c.expected {
+			t.Errorf("%d: Expected %v for %v, got %v", i, c.expected, c.raw, wait)
+		}
+	}
+}
+
+func TestVmSchedRequirements(t *testing.T) {
+	cases := []struct {
+		raw      map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{}, ""},
+		{map[string]interface{}{"cluster_id": 0}, "CLUSTER_ID = 0"},
+		{map[string]interface{}{"cluster_id": 100}, "CLUSTER_ID = 100"},
+		{map[string]interface{}{"cluster_id": 100, "host_id": 2}, ""},
+	}
+
+	for i, c := range cases {
+		d := schema.TestResourceDataRaw(t, resourceVm().Schema, c.raw)
+		if requirements := vmSchedRequirements(d); requirements != c.expected {
+			t.Errorf("%d: Expected %q for %v, got %q", i, c.expected, c.raw, requirements)
+		}
+	}
+}
+
+func TestVmHistory(t *testing.T) {
+	var vm UserVm
+	if err := xml.Unmarshal([]byte(testVmInfoHistory), &vm); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(vm.History) != 2 {
+		t.Fatalf("Expected 2 history records, got %v", vm.History)
+	}
+	if last := vm.History[len(vm.History)-1]; last.HID != 3 || last.Hostname != "node3" || last.CID != 100 || last.DSID != 101 {
+		t.Fatalf("Expected the VM to run on node3 (3) in cluster 100 from datastore 101, got %v", last)
+	}
+}
+
+func TestOwnerGroup(t *testing.T) {
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.grouppool.info" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		return []interface{}{true, "<GROUP_POOL><GROUP><ID>0</ID><NAME>oneadmin</NAME></GROUP><GROUP><ID>105</ID><NAME>project</NAME></GROUP></GROUP_POOL>", int64(0)}, nil
+	})
+
+	cases := []struct {
+		raw   map[string]interface{}
+		gid   int
+		set   bool
+		valid bool
+	}{
+		{map[string]interface{}{}, -1, false, true},
+		{map[string]interface{}{"group": "project"}, 105, true, true},
+		{map[string]interface{}{"gid": 0}, 0, true, true},
+		{map[string]interface{}{"group": "missing"}, 0, true, false},
+	}
+
+	for i, c := range cases {
+		d := schema.TestResourceDataRaw(t, resourceVm().Schema, c.raw)
+		gid, set, err := ownerGroup(d, client)
+		if c.valid && err != nil {
+			t.Errorf("%d: Unexpected error for %v: %s", i, c.raw, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%d: Expected an error for %v", i, c.raw)
+		}
+		if c.valid && (gid != c.gid || set != c.set) {
+			t.Errorf("%d: Expected %d (%v) for %v, got %d (%v)", i, c.gid, c.set, c.raw, gid, set)
+		}
+	}
+}
+
+func TestCheckVmNameUnique(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.vmpool.info" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		return []interface{}{true, testVmPoolInfo, int64(0)}, nil
+	})
+
+	if err := checkVmNameUnique(client, "tf-vm-free"); err != nil {
+		t.Fatalf("Expected a free name to be accepted, got: %s", err)
+	}
+
+	err := checkVmNameUnique(client, "tf-vm-taken")
+	if err == nil {
+		t.Fatalf("Expected a name collision to be reported")
+	}
+	if !strings.Contains(err.Error(), "41, 43") {
+		t.Fatalf("Expected the colliding VM IDs in the error, got: %s", err)
+	}
+
+	if err := checkVmNameUnique(client, ""); err != nil {
+		t.Fatalf("Expected an empty name to be left to OpenNebula, got: %s", err)
+	}
+
+	calls := caller.callsTo("one.vmpool.info")
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 pool lookups, got %d", len(calls))
+	}
+	if calls[0].Args[0] != -2 {
+		t.Fatalf("Expected the pool of all VMs to be searched, got filter %v", calls[0].Args[0])
+	}
+}
+
+func TestVmWaitState(t *testing.T) {
+	cases := []struct {
+		state, lcmState int
+		expected        string
+	}{
+		{1, 0, "pending"},
+		{2, 0, "hold"},
+		{3, 2, "anythingelse"},
+		{3, 3, "running"},
+		{3, 36, "boot_failure"},
+		{6, 0, "done"},
+		{8, 0, "poweroff"},
+	}
+
+	for _, c := range cases {
+		vm := &UserVm{State: c.state, LcmState: c.lcmState}
+		if got := vmWaitState(vm); got != c.expected {
+			t.Errorf("Expected state %d, LCM state %d to be %q, got %q", c.state, c.lcmState, c.expected, got)
+		}
+	}
+}
+
+func TestVmRecoverBackoff(t *testing.T) {
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 160 * time.Second, 5 * time.Minute, 5 * time.Minute}
+	for try, e := range expected {
+		if got := vmRecoverBackoff(try); got != e {
+			t.Errorf("Expected a backoff of %s before retry %d, got %s", e, try, got)
+		}
+	}
+}
+
+func TestVmBootFailure(t *testing.T) {
+	err := &vmBootFailure{ID: "42", Message: "Error executing image transfer script"}
+	if !strings.Contains(err.Error(), "VM ID 42 entered LCM state BOOT_FAILURE") || !strings.Contains(err.Error(), err.Message) {
+		t.Fatalf("Expected the error to name the VM, its state and message, got %q", err)
+	}
+
+	if err = (&vmBootFailure{ID: "42"}); !strings.Contains(err.Error(), "No error was found") {
+		t.Fatalf("Expected a default message, got %q", err)
+	}
+}
+
+func TestOwnVmByName(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.vmpool.info" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		return []interface{}{true, `<VM_POOL>
+  <VM><ID>41</ID><NAME>tf-vm-shared</NAME><UNAME>someone</UNAME></VM>
+  <VM><ID>42</ID><NAME>tf-vm-shared</NAME><UNAME>oneadmin</UNAME></VM>
+  <VM><ID>43</ID><NAME>tf-vm-other</NAME><UNAME>someone</UNAME></VM>
+  <VM><ID>44</ID><NAME>tf-vm-shared</NAME><UNAME>oneadmin</UNAME><STATE>6</STATE></VM>
+  <VM><ID>45</ID><NAME>tf-vm-twice</NAME><UNAME>oneadmin</UNAME><STATE>3</STATE></VM>
+  <VM><ID>46</ID><NAME>tf-vm-twice</NAME><UNAME>oneadmin</UNAME><STATE>8</STATE></VM>
+</VM_POOL>`, int64(0)}, nil
+	})
+
+	vm, err := ownVmByName(client, "tf-vm-shared")
+	if err != nil || vm == nil || vm.Id != "42" {
+		t.Fatalf("Expected VM 42 of oneadmin, got %v (err: %v)", vm, err)
+	}
+
+	if vm, err = ownVmByName(client, "tf-vm-other"); err != nil || vm != nil {
+		t.Fatalf("Expected the VM of another user to be left out, got %v (err: %v)", vm, err)
+	}
+
+	vm, err = ownVmByName(client, "tf-vm-twice")
+	if err == nil || !strings.Contains(err.Error(), "45, 46") {
+		t.Fatalf("Expected an error listing VMs 45 and 46, got %v (err: %v)", vm, err)
+	}
+
+	if calls := caller.callsTo("one.vmpool.info"); calls[0].Args[0] != -3 {
+		t.Fatalf("Expected the pool of the user to be searched, got filter %v", calls[0].Args[0])
+	}
+}
+
+func TestResourceVmImportState(t *testing.T) {
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.vmpool.info" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		return []interface{}{true, `<VM_POOL>
+  <VM><ID>41</ID><NAME>web</NAME><UNAME>oneadmin</UNAME></VM>
+  <VM><ID>42</ID><NAME>db</NAME><UNAME>oneadmin</UNAME></VM>
+  <VM><ID>43</ID><NAME>db</NAME><UNAME>oneadmin</UNAME></VM>
+</VM_POOL>`, int64(0)}, nil
+	})
+
+	cases := []struct {
+		id       string
+		expected string
+		err      string
+	}{
+		{"1234", "1234", ""},
+		{"web", "41", ""},
+		{"db", "", "42, 43"},
+		{"missing", "", "Could not find"},
+	}
+
+	for _, c := range cases {
+		d := schema.TestResourceDataRaw(t, resourceVm().Schema, map[string]interface{}{})
+		d.SetId(c.id)
+
+		imported, err := resourceVmImportState(d, client)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: Expected an error with %q, got %v", c.id, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %s", c.id, err)
+			continue
+		}
+		if len(imported) != 1 || imported[0].Id() != c.expected {
+			t.Errorf("%s: Expected VM %s to be imported, got %v", c.id, c.expected, imported)
+		}
+	}
+}
+
+func TestVmName(t *testing.T) {
+	if name := vmName("web", ""); name != "web" {
+		t.Fatalf("Expected web, got %s", name)
+	}
+	if name := vmName("web", "a1b2c3"); name != "web-a1b2c3" {
+		t.Fatalf("Expected web-a1b2c3, got %s", name)
+	}
+	if name := vmName("", "a1b2c3"); name != "" {
+		t.Fatalf("Expected an empty name to stay empty, got %s", name)
+	}
+
+	suffix, err := randomNameSuffix()
+	if err != nil || len(suffix) != 6 {
+		t.Fatalf("Expected a 6 characters suffix, got %q (err: %v)", suffix, err)
+	}
+}
+
+// testAccCheckVmNotReplaced records the ID of the VM n on its first call and
+// fails if a later call finds another VM.
+func testAccCheckVmNotReplaced(n string, id *string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[n]
+		if !ok {
+			return fmt.Errorf("Not found: %s", n)
+		}
+
+		if *id == "" {
+			*id = rs.Primary.ID
+		} else if *id != rs.Primary.ID {
+			return fmt.Errorf("Expected VM %s to be updated in place, it was replaced by VM %s", *id, rs.Primary.ID)
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckVmDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*Client)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "opennebula_vm" {
+			continue
+		}
+
+		resp, err := client.VmInfo(intId(rs.Primary.ID))
+		if err != nil {
+			continue
+		}
+
+		var vm UserVm
+		if err = xml.Unmarshal([]byte(resp), &vm); err != nil {
+			return err
+		}
+
+		// a terminated VM is kept in state 6 (DONE)
+		if vm.State != 6 {
+			return fmt.Errorf("Expected VM %s to have been terminated, it is in state %d", rs.Primary.ID, vm.State)
+		}
+	}
+
+	return nil
+}
+
+var testAccVmConfigBasic = `
+resource "opennebula_image" "disk" {
+  name = "tf-acc-test-vm-disk"
+  datastore_id = %d
+  type = "DATABLOCK"
+  size = 16
+  persistent = false
+}
+
+resource "opennebula_vm" "test" {
+  name = "%s"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+
+  disk {
+    image_id = "${opennebula_image.disk.id}"
+  }
+
+  nic {
+    model = "virtio"
+    network_id = %d
+  }
+
+  permissions = "%s"
+}
+`
+
+var testAccVmConfigDiskSize = `
+resource "opennebula_image" "disk" {
+  name = "tf-acc-test-vm-disk-resize"
+  datastore_id = %d
+  type = "DATABLOCK"
+  size = 16
+  persistent = false
+}
+
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-disk-resize"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+
+  disk {
+    image_id = "${opennebula_image.disk.id}"
+    size = %d
+  }
+}
+`
+
+var testAccVmConfigNicsOnSameNetwork = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-vm-vnet"
+  vn_mad = "bridge"
+  bridge = "br-tf-acc"
+  ip_start = "172.16.100.1"
+  ip_size = 10
+}
+
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-nics"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+
+  nic {
+    model = "virtio"
+    network_id = "${opennebula_vnet.test.id}"
+    ip = "172.16.100.2"
+  }
+
+  nic {
+    model = "virtio"
+    network_id = "${opennebula_vnet.test.id}"
+    ip = "172.16.100.3"
+  }
+}
+`
+
+var testAccVmConfigNicMAC = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-vm-vnet"
+  vn_mad = "bridge"
+  bridge = "br-tf-acc"
+  ip_start = "172.16.100.1"
+  ip_size = 10
+}
+
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-nic-mac"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+
+  ordered_nic {
+    network_id = "${opennebula_vnet.test.id}"
+    mac = "02:00:ac:10:64:09"
+  }
+}
+`
+
+var testAccVmConfigOrderedNics = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-vm-vnet"
+  vn_mad = "bridge"
+  bridge = "br-tf-acc"
+  ip_start = "172.16.100.1"
+  ip_size = 10
+}
+
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-ordered-nics"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+
+  ordered_nic {
+    network_id = "${opennebula_vnet.test.id}"
+    ip = "172.16.100.3"
+  }
+
+  ordered_nic {
+    network_id = "${opennebula_vnet.test.id}"
+    ip = "172.16.100.2"
+  }
+}
+`
+
+var testAccVmConfigSchedActions = `
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-sched"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+%s}
+`
+
+var testAccSchedActionPoweroff = `
+  sched_action {
+    action = "poweroff"
+    time = "+86400"
+    repeat = "weekly"
+    days = "1,2,3,4,5"
+  }
+`
+
+var testAccSchedActionTerminate = `
+  sched_action {
+    action = "terminate"
+    time = "+604800"
+  }
+`
+
+var testAccVmConfigContextFiles = `
+resource "opennebula_image" "scripts" {
+  name = "tf-acc-test-context-script"
+  datastore_id = %d
+  type = "CONTEXT"
+  path = "%s"
+}
+
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-context-files"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+
+  context {
+    NETWORK = "YES"
+    INIT_SCRIPTS = "context-script.sh"
+  }
+  context_files = ["${opennebula_image.scripts.id}"]
+}
+`
+
+var testAccVmConfigTemplate = `
+resource "opennebula_template" "test" {
+  name = "tf-acc-test-vm-template"
+  description = <<EOF
+	CPU = "0.1"
+	VCPU = "1"
+	MEMORY = "128"
+  EOF
+}
+
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-from-template"
+  template_id = "${opennebula_template.test.id}"
+}
+
+resource "opennebula_vm" "override" {
+  name = "tf-acc-test-vm-from-template-override"
+  template_id = "${opennebula_template.test.id}"
+  memory = 64
+}
+`
+
+var testAccVmConfigInstantiatePersistent = `
+resource "opennebula_image" "disk" {
+  name = "tf-acc-test-vm-persistent-disk"
+  datastore_id = %d
+  type = "DATABLOCK"
+  size = 16
+  persistent = false
+}
+
+resource "opennebula_template" "test" {
+  name = "tf-acc-test-vm-persistent-template"
+  description = <<EOF
+	CPU = "0.1"
+	VCPU = "1"
+	MEMORY = "64"
+	DISK = [ IMAGE_ID = "${opennebula_image.disk.id}" ]
+  EOF
+}
+
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-persistent"
+  template_id = "${opennebula_template.test.id}"
+  instantiate_persistent = true
+  delete_persistent_disks = true
+}
+`
+
+var testAccVmConfigUpdate = `
+resource "opennebula_vm" "test" {
+  name = "%s"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+  permissions = "%s"
+  description = "%s"
+  strict_id_lookup = %s
+}
+`
+
+var testAccVmConfigNamePrefix = `
+resource "opennebula_vm" "test" {
+  count = 2
+  name_prefix = "tf-acc-test-vm-prefix-"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+}
+`
+
+var testAccVmConfigGraphics = `
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-graphics"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+
+  graphics {
+    type = "VNC"
+    listen = "%s"
+  }
+}
+`
+
+var testAccVmConfigNoWaitForState = `
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-no-wait"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+  wait_for_state = false
+}
+`
+
+var testAccVmConfigGroupRole = `
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-vmgroup"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+
+  vmgroup {
+    vmgroup_id = %d
+    role = "tf-acc-test"
+  }
+}
+`
+
+var testAccVmConfigDescription = `
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-description"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+  description = "%s"
+}
+`
+
+var testAccVmConfigGroup = `
+resource "opennebula_vm" "test" {
+  name = "tf-acc-test-vm-group"
+  cpu = 0.1
+  vcpu = 1
+  memory = 64
+  %s
+}
+`
+
+var testVmInfoHistory = `
+<VM>
+  <ID>42</ID>
+  <NAME>tf-vm-migrated</NAME>
+  <HISTORY_RECORDS>
+    <HISTORY>
+      <SEQ>0</SEQ>
+      <HOSTNAME>node1</HOSTNAME>
+      <HID>1</HID>
+      <CID>0</CID>
+      <DS_ID>0</DS_ID>
+    </HISTORY>
+    <HISTORY>
+      <SEQ>1</SEQ>
+      <HOSTNAME>node3</HOSTNAME>
+      <HID>3</HID>
+      <CID>100</CID>
+      <DS_ID>101</DS_ID>
+    </HISTORY>
+  </HISTORY_RECORDS>
+</VM>
+`
+
+var testVmPoolInfo = `
+<VM_POOL>
+  <VM>
+    <ID>41</ID>
+    <NAME>tf-vm-taken</NAME>
+  </VM>
+  <VM>
+    <ID>42</ID>
+    <NAME>tf-vm-other</NAME>
+  </VM>
+  <VM>
+    <ID>43</ID>
+    <NAME>tf-vm-taken</NAME>
+  </VM>
+</VM_POOL>
+`
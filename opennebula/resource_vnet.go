@@ -1,10 +1,12 @@
 package opennebula
 
 import (
-	"encoding/xml"
+	"encoding/binary"
 	"fmt"
 	"log"
 	"net"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -24,8 +26,33 @@ type UserVnet struct {
 	Gname       string        `xml:"GNAME"`
 	Permissions *Permissions  `xml:"PERMISSIONS"`
 	Bridge      string        `xml:"BRIDGE"`
-	ParentVnet  int           `xml:"PARENT_NETWORK_ID,omitempty"`
+	ParentVnet  string        `xml:"PARENT_NETWORK_ID,omitempty"`
+	VlanId      string        `xml:"VLAN_ID,omitempty"`
+	VlanIdAuto  string        `xml:"VLAN_ID_AUTOMATIC,omitempty"`
+	UsedLeases  int           `xml:"USED_LEASES"`
 	Template    *VnetTemplate `xml:"TEMPLATE,omitempty"`
+	ARs         []VnetAR      `xml:"AR_POOL>AR"`
+}
+
+type VnetAR struct {
+	Id         int         `xml:"AR_ID"`
+	ParentAR   string      `xml:"PARENT_NETWORK_AR_ID,omitempty"`
+	Type       string      `xml:"TYPE"`
+	IP         string      `xml:"IP,omitempty"`
+	MAC        string      `xml:"MAC"`
+	Size       int         `xml:"SIZE"`
+	UsedLeases int         `xml:"USED_LEASES"`
+	Leases     []VnetLease `xml:"LEASES>LEASE"`
+}
+
+// VnetLease is a used address of a VNET: leased to a VM (VM is its ID),
+// held (VM is -1) or given to a reservation or a virtual router.
+type VnetLease struct {
+	IP      string `xml:"IP,omitempty"`
+	MAC     string `xml:"MAC"`
+	VM      string `xml:"VM,omitempty"`
+	Vnet    string `xml:"VNET,omitempty"`
+	VRouter string `xml:"VROUTER,omitempty"`
 }
 
 type VnetTemplate struct {
@@ -37,17 +64,55 @@ type VnetTemplate struct {
 	Dns             string `xml:"DNS,omitempty"`
 	Gateway         string `xml:"GATEWAY,omitempty"`
 	NetworkMask     string `xml:"NETWORK_MASK,omitempty"`
+	NetworkAddress  string `xml:"NETWORK_ADDRESS,omitempty"`
+	SearchDomain    string `xml:"SEARCH_DOMAIN,omitempty"`
+	Gateway6        string `xml:"GATEWAY6,omitempty"`
+	Dns6            string `xml:"DNS6,omitempty"`
+	MTU             int    `xml:"MTU,omitempty"`
+	GuestMTU        int    `xml:"GUEST_MTU,omitempty"`
+	// Custom holds the attributes not known above
+	Custom []vmTemplateAttribute `xml:",any"`
+}
+
+// Get returns the value of the custom attribute key, or "" when it isn't set
+// or isn't a single value.
+func (t *VnetTemplate) Get(key string) string {
+	return (&VmUserTemplate{Attributes: t.Custom}).Get(key)
+}
+
+// vnetTemplateAttributes are the attributes of the vnet template set by the
+// fixed arguments, which take precedence over custom_attributes.
+var vnetTemplateAttributes = []string{
+	"NAME", "DESCRIPTION", "BRIDGE", "VN_MAD", "PHYDEV", "VLAN_ID", "AUTOMATIC_VLAN_ID",
+	"SECURITY_GROUPS", "DNS", "GATEWAY", "NETWORK_MASK", "MTU", "GUEST_MTU",
+	"NETWORK_ADDRESS", "SEARCH_DOMAIN", "GATEWAY6", "DNS6",
+}
+
+// vnetTemplateArgs are the arguments updated in the vnet template.
+var vnetTemplateArgs = []string{
+	"description", "dns", "gateway", "networkmask", "network_address", "search_domain",
+	"gateway6", "dns6", "mtu", "guest_mtu", "security_groups", "custom_attributes",
+}
+
+// vnetContextAttributes are the string arguments of the network context
+// added after dns, gateway and networkmask, with their template attribute.
+var vnetContextAttributes = [][2]string{
+	{"network_address", "NETWORK_ADDRESS"},
+	{"search_domain", "SEARCH_DOMAIN"},
+	{"gateway6", "GATEWAY6"},
+	{"dns6", "DNS6"},
 }
 
 func resourceVnet() *schema.Resource {
 	return &schema.Resource{
-		Create: resourceVnetCreate,
-		Read:   resourceVnetRead,
-		Exists: resourceVnetExists,
-		Update: resourceVnetUpdate,
-		Delete: resourceVnetDelete,
+		Create:        resourceVnetCreate,
+		Read:          resourceVnetRead,
+		Exists:        resourceVnetExists,
+		Update:        resourceVnetUpdate,
+		Delete:        resourceVnetDelete,
+		CustomizeDiff: resourceVnetCustomizeDiff,
 		Importer: &schema.ResourceImporter{
-			State: schema.ImportStatePassthrough,
+			State: resourceVnetImportState,
 		},
 
 		Schema: map[string]*schema.Schema{
@@ -59,32 +124,14 @@ func resourceVnet() *schema.Resource {
 			"description": {
 				Type:        schema.TypeString,
 				Optional:    true,
-				Description: "Description of the vnet, in OpenNebula's XML or String format",
+				Description: "Description of the vnet. Other template attributes go in custom_attributes",
 			},
 			"permissions": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Computed:    true,
-				Description: "Permissions for the vnet (in Unix format, owner-group-other, use-manage-admin)",
-				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
-					value := v.(string)
-
-					if len(value) != 3 {
-						errors = append(errors, fmt.Errorf("%q has specify 3 permission sets: owner-group-other", k))
-					}
-
-					all := true
-					for _, c := range strings.Split(value, "") {
-						if c < "0" || c > "7" {
-							all = false
-						}
-					}
-					if !all {
-						errors = append(errors, fmt.Errorf("Each character in %q should specify a Unix-like permission set with a number from 0 to 7", k))
-					}
-
-					return
-				},
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				Description:  "Permissions for the vnet (in Unix format, owner-group-other, use-manage-admin)",
+				ValidateFunc: validatePermissions,
 			},
 
 			"uid": {
@@ -94,10 +141,17 @@ func resourceVnet() *schema.Resource {
 				Description: "ID of the user that will own the vnet",
 			},
 			"gid": {
-				Type:        schema.TypeInt,
-				Optional:    true,
-				Computed:    true,
-				Description: "ID of the group that will own the vnet",
+				Type:          schema.TypeInt,
+				Optional:      true,
+				Computed:      true,
+				ConflictsWith: []string{"group"},
+				Description:   "ID of the group that will own the vnet",
+			},
+			"group": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"gid"},
+				Description:   "Name of the group that will own the vnet",
 			},
 			"uname": {
 				Type:        schema.TypeString,
@@ -114,7 +168,7 @@ func resourceVnet() *schema.Resource {
 				Optional:    true,
 				Description: "VN driver to use. If empty, defaults to 'fw'",
 				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
-					validdrivers := []string{"bridge", "fw", "802.1Q"}
+					validdrivers := []string{"dummy", "bridge", "fw", "802.1Q", "vxlan", "ovswitch", "ovswitch_vxlan"}
 					value := v.(string)
 
 					if !in_array(value, validdrivers) {
@@ -135,24 +189,34 @@ func resourceVnet() *schema.Resource {
 				Type:          schema.TypeString,
 				Optional:      true,
 				Computed:      true,
-				Description:   "Name of the physical device to which the vlan should be associated",
-				ConflictsWith: []string{"bridge", "reservation_vnet", "reservation_size"},
+				Description:   "Name of the physical device to which the vlan should be associated. Required by the 802.1Q, vxlan and ovswitch_vxlan drivers",
+				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
 			},
 			"vlan_id": {
 				Type:          schema.TypeInt,
 				Optional:      true,
-				Description:   "ID of the vlan to be associated",
-				ConflictsWith: []string{"bridge", "reservation_vnet", "reservation_size"},
+				Computed:      true,
+				Description:   "ID of the vlan to be associated, the VNI of vxlan networks. Set to the one OpenNebula picked with automatic_vlan_id",
+				ConflictsWith: []string{"reservation_vnet", "reservation_size", "automatic_vlan_id"},
+			},
+			"automatic_vlan_id": {
+				Type:          schema.TypeBool,
+				Optional:      true,
+				ForceNew:      true,
+				Description:   "Let OpenNebula pick the vlan ID of the vnet",
+				ConflictsWith: []string{"reservation_vnet", "reservation_size", "vlan_id"},
 			},
 			"ip_start": {
 				Type:          schema.TypeString,
 				Optional:      true,
+				Computed:      true,
 				Description:   "Start IP of the range to be allocated",
 				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
 			},
 			"ip_size": {
 				Type:          schema.TypeInt,
 				Optional:      true,
+				Computed:      true,
 				Description:   "Size (in number) of the ip range, defaults to 1 if empty",
 				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
 			},
@@ -162,6 +226,15 @@ func resourceVnet() *schema.Resource {
 				Description:   "Carve a network reservation of this size from the reservation starting from `ip-start`",
 				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
 			},
+			"hold_ips": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "List of IPs of the vnet to hold",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateIP,
+				},
+			},
 			"reservation_vnet": {
 				Type:          schema.TypeInt,
 				Optional:      true,
@@ -172,13 +245,69 @@ func resourceVnet() *schema.Resource {
 			"reservation_size": {
 				Type:          schema.TypeInt,
 				Optional:      true,
-				Description:   "Reserve this many IPs from reservation_vnet",
+				Description:   "Reserve this many IPs from reservation_vnet. Growing a reservation reserves more IPs into it, shrinking it creates a new one",
 				ConflictsWith: []string{"bridge", "ip_start", "ip_size", "hold_size"},
 			},
+			"reservation_ar_id": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				ForceNew:    true,
+				Default:     -1,
+				Description: "Reserve the IPs from this address range of reservation_vnet, any of them if -1",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					if v.(int) < -1 {
+						errors = append(errors, fmt.Errorf("%q must be an address range ID or -1", k))
+					}
+					return
+				},
+			},
+			"reservation_first_ip": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Description:  "First IP of the reservation in the address range reservation_ar_id",
+				ValidateFunc: validateIP,
+			},
+			"max_leases": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     1024,
+				Description: "Maximum number of leases kept in the leases attribute",
+				ValidateFunc: func(v interface{}, k string) (ws []string, errors []error) {
+					if v.(int) < 0 {
+						errors = append(errors, fmt.Errorf("%q must not be negative", k))
+					}
+					return
+				},
+			},
+			"leases": vnetLeasesSchema(),
+			"used_leases": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Number of addresses of the VNET in use",
+			},
+			"total_leases": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Number of addresses of the address ranges of the VNET",
+			},
+			"cascade_delete": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Delete the reservations carved from this VNET along with it, as long as none of their leases is in use",
+			},
+			"rollback_on_failure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Delete the VNET when its configuration fails after it was created, instead of leaving it tainted",
+			},
 			"security_groups": {
 				Type:        schema.TypeList,
 				Optional:    true,
-				Description: "List of Security Group IDs to be applied to the VNET",
+				Computed:    true,
+				Description: "List of Security Group IDs to be applied to the VNET. OpenNebula applies the default one, 0, when not set",
 				Elem: &schema.Schema{
 					Type: schema.TypeInt,
 				},
@@ -201,11 +330,74 @@ func resourceVnet() *schema.Resource {
 				Description:   "CONTEXT: Network mask",
 				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
 			},
+			"network_address": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "CONTEXT: Network address",
+				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
+			},
+			"search_domain": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "CONTEXT: Search domains of the DNS resolver",
+				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
+			},
+			"gateway6": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "CONTEXT: IPv6 gateway",
+				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
+			},
+			"dns6": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "CONTEXT: Space separated list of IPv6 dns IPs",
+				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
+			},
+			"mtu": {
+				Type:          schema.TypeInt,
+				Optional:      true,
+				Description:   "MTU of the network interfaces of the vnet on the hosts, e.g. 1450 for VXLAN",
+				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
+				ValidateFunc:  validateVnetMTU,
+			},
+			"guest_mtu": {
+				Type:          schema.TypeInt,
+				Optional:      true,
+				Description:   "CONTEXT: MTU of the network interfaces of the guests",
+				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
+				ValidateFunc:  validateVnetMTU,
+			},
+			"custom_attributes": {
+				Type:          schema.TypeMap,
+				Optional:      true,
+				Description:   "Custom attributes of the vnet template, e.g. ZONE = \"dmz\". The ones set by other arguments are ignored",
+				ConflictsWith: []string{"reservation_vnet", "reservation_size"},
+				ValidateFunc:  validateCustomAttributes(vnetTemplateAttributes),
+			},
 		},
 	}
 }
 
 func resourceVnetCreate(d *schema.ResourceData, meta interface{}) error {
+	err := createVnet(d, meta)
+	if err != nil {
+		if d.Id() == "" || !d.Get("rollback_on_failure").(bool) {
+			return err
+		}
+		deleted, rollbackErr := rollbackVnet(meta.(*Client), intId(d.Id()), err)
+		if deleted {
+			d.SetId("")
+		}
+		return rollbackErr
+	}
+
+	return resourceVnetRead(d, meta)
+}
+
+// createVnet allocates or reserves the vnet and configures it, its ID being
+// set as soon as it exists.
+func createVnet(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
 
 	//VNET reservation
@@ -221,13 +413,15 @@ func resourceVnetCreate(d *schema.ResourceData, meta interface{}) error {
 		}
 
 		//The API only takes ATTRIBUTE=VALUE for VNET reservations...
-		reservation_string := "SIZE=%d\nNAME=\"%s\""
+		reservation_string := fmt.Sprintf("SIZE=%d\nNAME=\"%s\"", reservation_size, reservation_name)
+		if ar_id := d.Get("reservation_ar_id").(int); ar_id >= 0 {
+			reservation_string += fmt.Sprintf("\nAR_ID=%d", ar_id)
+		}
+		if first_ip, ok := d.GetOk("reservation_first_ip"); ok {
+			reservation_string += fmt.Sprintf("\nIP=\"%s\"", first_ip.(string))
+		}
 
-		resp, err := client.Call(
-			"one.vn.reserve",
-			reservation_vnet,
-			fmt.Sprintf(reservation_string, reservation_size, reservation_name),
-		)
+		resp, err := client.VnetReserve(reservation_vnet, reservation_string)
 
 		if err != nil {
 			return err
@@ -246,40 +440,15 @@ func resourceVnetCreate(d *schema.ResourceData, meta interface{}) error {
 		var resp string
 		var err error
 
+		if err = checkVnetDriver(d.Get("vn_mad").(string), d.Get("phydev").(string), d.Get("vlan_id").(int), d.Get("automatic_vlan_id").(bool)); err != nil {
+			return err
+		}
+
 		// build the vn template
 		var vntmpl strings.Builder
 		fmt.Fprintf(&vntmpl, "NAME=\"%s\"", d.Get("name").(string))
-		if dscr, ok := d.GetOk("description"); ok {
-			fmt.Fprintf(&vntmpl, "\nDESCRIPTION=\"%s\"", dscr.(string))
-		}
-		if br, ok := d.GetOk("bridge"); ok {
-			fmt.Fprintf(&vntmpl, "\nBRIDGE=\"%s\"", br.(string))
-		}
-		if vnmad, ok := d.GetOk("vn_mad"); ok {
-			fmt.Fprintf(&vntmpl, "\nVN_MAD=\"%s\"", d.Get("vn_mad").(string))
-			if vnmad.(string) == "802.1Q" {
-				pdev, pdevok := d.GetOk("phydev")
-				vlanid, vlanok := d.GetOk("vlan_id")
-				if pdevok && vlanok {
-					fmt.Fprintf(&vntmpl, "\nPHYDEV=\"%s\"", pdev.(string))
-					fmt.Fprintf(&vntmpl, "\nVLAN_ID=\"%d\"", vlanid.(int))
-				} else {
-					return fmt.Errorf("For vn_mad 802.1Q, both phydev and vlan_id should be given")
-				}
-			}
-		}
-		// CONTEXT params
-		if nm, ok := d.GetOk("networkmask"); ok {
-			fmt.Fprintf(&vntmpl, "\nNETWORK_MASK=\"%s\"", nm.(string))
-		}
-		if gw, ok := d.GetOk("gateway"); ok {
-			fmt.Fprintf(&vntmpl, "\nGATEWAY=\"%s\"", gw.(string))
-		}
-		if dns, ok := d.GetOk("dns"); ok {
-			fmt.Fprintf(&vntmpl, "\nDNS=\"%s\"", dns.(string))
-		}
-		resp, err = client.Call(
-			"one.vn.allocate",
+		vntmpl.WriteString(vnetTemplate(vnetTemplateValues(d, false), nil, false))
+		resp, err = client.VnetAllocate(
 			vntmpl.String(),
 			-1,
 		)
@@ -291,7 +460,7 @@ func resourceVnetCreate(d *schema.ResourceData, meta interface{}) error {
 
 		// update permisions
 		if _, ok := d.GetOk("permissions"); ok {
-			if _, err = changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vn.chmod"); err != nil {
+			if _, err = client.VnetChmod(intId(d.Id()), permission(d.Get("permissions").(string))); err != nil {
 				return err
 			}
 		}
@@ -308,8 +477,7 @@ func resourceVnetCreate(d *schema.ResourceData, meta interface{}) error {
 			} else {
 				size = 1
 			}
-			_, a_err := client.Call(
-				"one.vn.add_ar",
+			_, a_err := client.VnetAddAR(
 				intId(d.Id()),
 				fmt.Sprintf(address_range_string, ar.(string), size),
 			)
@@ -320,36 +488,57 @@ func resourceVnetCreate(d *schema.ResourceData, meta interface{}) error {
 
 		if d.Get("hold_size").(int) > 0 {
 			// add address range and reservations
-			ip := net.ParseIP(d.Get("ip_start").(string))
-			ip = ip.To4()
+			ip := net.ParseIP(d.Get("ip_start").(string)).To4()
+			if ip == nil {
+				return fmt.Errorf("Can't hold addresses from %q, not an IPv4 address", d.Get("ip_start").(string))
+			}
 
 			for i := 0; i < d.Get("hold_size").(int); i++ {
 				var address_reservation_string = `LEASES=[IP=%s]`
-				_, r_err := client.Call(
-					"one.vn.hold",
+				_, r_err := client.VnetHold(
 					intId(d.Id()),
-					fmt.Sprintf(address_reservation_string, ip),
+					fmt.Sprintf(address_reservation_string, ipv4Add(ip, i)),
 				)
 
 				if r_err != nil {
 					return r_err
 				}
-
-				ip[3]++
 			}
 
 		}
 	}
 
-	//Apply the security group rules if defined
-	if security_groups, ok := d.GetOk("security_groups"); ok {
+	//Hand the vnet over to the user and group if they were defined
+	uid := -1
+	if v, ok := d.GetOkExists("uid"); ok {
+		uid = v.(int)
+	}
+	gid, _, err := ownerGroup(d, client)
+	if err != nil {
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if _, err = client.VnetChown(intId(d.Id()), uid, gid); err != nil {
+			return err
+		}
+	}
+
+	if ips, ok := d.GetOk("hold_ips"); ok {
+		if err := holdVnetIPs(client, intId(d.Id()), ips.([]interface{})); err != nil {
+			return err
+		}
+	}
+
+	//Apply the security group rules if defined, new VNETs have them in their
+	//template already
+	if security_groups, ok := d.GetOk("security_groups"); ok && d.Get("reservation_vnet").(int) != 0 {
 		err := setVnetSecurityGroups(client, intId(d.Id()), security_groups.([]interface{}))
 		if err != nil {
 			return err
 		}
 	}
 
-	return resourceVnetRead(d, meta)
+	return nil
 }
 
 func setVnetSecurityGroups(client *Client, vnet_id int, security_group_ids []interface{}) error {
@@ -358,8 +547,7 @@ func setVnetSecurityGroups(client *Client, vnet_id int, security_group_ids []int
 	secgroup_list := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(security_group_ids)), ","), "[]")
 
 	log.Printf("[DEBUG] Security group list: %s", secgroup_list)
-	_, err := client.Call(
-		"one.vn.update",
+	_, err := client.VnetUpdate(
 		vnet_id,
 		fmt.Sprintf("SECURITY_GROUPS=\"%s\"", secgroup_list),
 		1,
@@ -379,27 +567,32 @@ func resourceVnetRead(d *schema.ResourceData, meta interface{}) error {
 	client := meta.(*Client)
 	found := false
 
-	// Try to find the vnet by ID, if specified
+	// Try to find the vnet by ID, if specified. A vnet missing by ID is gone:
+	// another vnet with its name must not be adopted, and renamed by Update
 	if d.Id() != "" {
-		resp, err := client.Call("one.vn.info", intId(d.Id()), false)
-		if err == nil {
-			found = true
-			if err = xml.Unmarshal([]byte(resp), &vn); err != nil {
-				return err
-			}
-		} else {
-			log.Printf("Could not find vnet by ID %s", d.Id())
+		resp, err := client.VnetInfo(intId(d.Id()))
+		if isNotFound(err) {
+			log.Printf("[WARN] Could not find vnet by ID %s, removing it from state", d.Id())
+			d.SetId("")
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+		if err = client.Decode(resp, &vn); err != nil {
+			return err
 		}
 	}
 
 	// Otherwise, try to find the vnet by (user, name) as the de facto compound primary key
-	if d.Id() == "" || !found {
-		resp, err := client.Call("one.vnpool.info", -2, -1, -1)
+	if !found {
+		resp, err := client.VnetPoolInfo(-2, -1, -1)
 		if err != nil {
 			return err
 		}
 
-		if err = xml.Unmarshal([]byte(resp), &vns); err != nil {
+		if err = client.Decode(resp, &vns); err != nil {
 			return err
 		}
 
@@ -416,45 +609,126 @@ func resourceVnetRead(d *schema.ResourceData, meta interface{}) error {
 			log.Printf("Could not find vnet with name %s for user %s", d.Get("name").(string), client.Username)
 			return nil
 		}
+
+		// The pool does not list the leases of the address ranges
+		resp, err = client.VnetInfo(vn.Id)
+		if err != nil {
+			return err
+		}
+		vn = nil
+		if err = client.Decode(resp, &vn); err != nil {
+			return err
+		}
 	}
 
 	d.SetId(strconv.Itoa(vn.Id))
 	d.Set("name", vn.Name)
+	d.Set("description", vn.Template.Description)
 	d.Set("uid", vn.Uid)
 	d.Set("gid", vn.Gid)
 	d.Set("uname", vn.Uname)
 	d.Set("gname", vn.Gname)
+	if group, ok := d.Get("group").(string); ok && group != "" {
+		d.Set("group", vn.Gname)
+	}
 	d.Set("bridge", vn.Bridge)
-	d.Set("reservation_vnet", vn.ParentVnet)
+	if vn.ParentVnet != "" {
+		d.Set("reservation_vnet", intId(vn.ParentVnet))
+		d.Set("reservation_size", vnetTotalLeases(vn.ARs))
+		// Only confirm the address range and first IP asked for, a
+		// reservation from any of them stays so
+		if len(vn.ARs) > 0 {
+			if arID, ok := d.Get("reservation_ar_id").(int); ok && arID >= 0 && vn.ARs[0].ParentAR != "" {
+				d.Set("reservation_ar_id", intId(vn.ARs[0].ParentAR))
+			}
+			if _, ok := d.GetOk("reservation_first_ip"); ok {
+				d.Set("reservation_first_ip", vn.ARs[0].IP)
+			}
+		}
+	} else {
+		d.Set("reservation_vnet", 0)
+		// The address range is only read when unknown, on import: changes
+		// of its start IP are not applied
+		if ar := vnetAddressRange(vn.ARs); ar != nil && d.Get("ip_start") == "" {
+			d.Set("ip_start", ar.IP)
+			d.Set("ip_size", ar.Size)
+		}
+	}
 	d.Set("permissions", permissionString(vn.Permissions))
 	d.Set("vn_mad", vn.Template.Vn_Mad)
 	d.Set("phydev", vn.Template.Phydev)
-	d.Set("vlan_id", vn.Template.Vlan_id)
+	d.Set("vlan_id", vnetVlanID(vn))
+	d.Set("automatic_vlan_id", vn.VlanIdAuto == "1")
 	d.Set("dns", vn.Template.Dns)
 	d.Set("gateway", vn.Template.Gateway)
 	d.Set("networkmask", vn.Template.NetworkMask)
-
-	secgroups_str := strings.Split(vn.Template.Security_Groups, ",")
-	secgroups_int := []int{}
-
-	for _, i := range secgroups_str {
-		if i != "" {
-			j, err := strconv.Atoi(i)
-			if err != nil {
-				return err
+	d.Set("network_address", vn.Template.NetworkAddress)
+	d.Set("search_domain", vn.Template.SearchDomain)
+	d.Set("gateway6", vn.Template.Gateway6)
+	d.Set("dns6", vn.Template.Dns6)
+	d.Set("mtu", vn.Template.MTU)
+	d.Set("guest_mtu", vn.Template.GuestMTU)
+
+	// Only the custom attributes managed here are read, the others are
+	// left to whoever set them
+	if attrs, ok := d.Get("custom_attributes").(map[string]interface{}); ok && len(attrs) > 0 {
+		custom := make(map[string]interface{}, len(attrs))
+		for key := range attrs {
+			if value := vn.Template.Get(key); value != "" {
+				custom[key] = value
 			}
-			secgroups_int = append(secgroups_int, j)
 		}
+		d.Set("custom_attributes", custom)
+	}
+
+	secgroups_int, err := vnetSecurityGroups(vn.Template.Security_Groups)
+	if err != nil {
+		return err
 	}
 
-	err := d.Set("security_groups", secgroups_int)
+	err = d.Set("security_groups", secgroups_int)
 	if err != nil {
 		log.Printf("[DEBUG] Error setting security groups on vnet: %s", err)
 	}
 
+	if err = d.Set("leases", flattenVnetLeases(vn.ARs, d.Get("max_leases").(int))); err != nil {
+		log.Printf("[DEBUG] Error setting leases on vnet: %s", err)
+	}
+	d.Set("used_leases", vn.UsedLeases)
+	d.Set("total_leases", vnetTotalLeases(vn.ARs))
+
 	return nil
 }
 
+// resourceVnetImportState sets the addresses held in the vnet, which Read
+// leaves to the configuration: as hold_size when they are the first ones of
+// its address range, as hold_ips otherwise.
+func resourceVnetImportState(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	var vn *UserVnet
+
+	client := meta.(*Client)
+	resp, err := client.VnetInfo(intId(d.Id()))
+	if err != nil {
+		return nil, err
+	}
+	if err = client.Decode(resp, &vn); err != nil {
+		return nil, err
+	}
+
+	// Reservations are imported as from any address range, as Read only
+	// confirms the one asked for
+	d.Set("reservation_ar_id", -1)
+
+	held := vnetHeldIPs(vn.ARs)
+	if ar := vnetAddressRange(vn.ARs); ar != nil && vn.ParentVnet == "" && vnetHoldsFirstIPs(ar.IP, held) {
+		d.Set("hold_size", len(held))
+	} else if len(held) > 0 {
+		d.Set("hold_ips", held)
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
+
 func resourceVnetExists(d *schema.ResourceData, meta interface{}) (bool, error) {
 	err := resourceVnetRead(d, meta)
 	if err != nil || d.Id() == "" {
@@ -468,95 +742,58 @@ func resourceVnetUpdate(d *schema.ResourceData, meta interface{}) error {
 	d.Partial(true)
 	client := meta.(*Client)
 
-	if d.HasChange("description") {
-		_, err := client.Call(
-			"one.vn.update",
+	// Shrinking a reservation forces a new one
+	if d.HasChange("reservation_size") && d.Get("reservation_vnet").(int) != 0 {
+		oldsize, newsize := d.GetChange("reservation_size")
+		err := growVnetReservation(
+			client,
+			d.Get("reservation_vnet").(int),
 			intId(d.Id()),
-			fmt.Sprintf("DESCRIPTION=\"%s\"", d.Get("description").(string)),
-			1,
+			newsize.(int)-oldsize.(int),
+			d.Get("reservation_ar_id").(int),
 		)
 		if err != nil {
 			return err
 		}
+		d.SetPartial("reservation_size")
 	}
 
-	if d.HasChange("dns") {
-		resp, err := client.Call(
-			"one.vn.update",
+	// All the managed attributes are sent together, the unchanged ones
+	// included, for OpenNebula versions replacing the template on update
+	if vnetTemplateChanged(d) {
+		reservation := d.Get("reservation_vnet").(int) != 0
+		oldcustom, _ := d.GetChange("custom_attributes")
+		resp, err := client.VnetUpdate(
 			intId(d.Id()),
-			fmt.Sprintf("DNS=\"%s\"", d.Get("dns").(string)),
+			strings.TrimPrefix(vnetTemplate(vnetTemplateValues(d, reservation), oldcustom.(map[string]interface{}), true), "\n"),
 			1,
 		)
 		if err != nil {
 			return err
 		}
-		d.SetPartial("dns")
-		log.Printf("[INFO] Successfully updated DNS for Vnet %s\n", resp)
-	}
-
-	if d.HasChange("gateway") {
-		resp, err := client.Call(
-			"one.vn.update",
-			intId(d.Id()),
-			fmt.Sprintf("GATEWAY=\"%s\"", d.Get("gateway").(string)),
-			1,
-		)
-		if err != nil {
-			return err
-		}
-		d.SetPartial("gateway")
-		log.Printf("[INFO] Successfully updated GATEWAY for Vnet %s\n", resp)
-	}
-
-	if d.HasChange("networkmask") {
-		resp, err := client.Call(
-			"one.vn.update",
-			intId(d.Id()),
-			fmt.Sprintf("NETWORK_MASK=\"%s\"", d.Get("networkmask").(string)),
-			1,
-		)
-		if err != nil {
-			return err
-		}
-		d.SetPartial("networkmask")
-		log.Printf("[INFO] Successfully updated NETWORK_MASK for Vnet %s\n", resp)
-	}
-
-	if d.HasChange("security_groups") {
-		vnet_id, err := strconv.Atoi(d.Id())
-		if err != nil {
-			return nil
-		}
-
-		err = setVnetSecurityGroups(client, vnet_id, d.Get("security_groups").([]interface{}))
-		if err != nil {
-			return err
+		for _, key := range vnetTemplateArgs {
+			d.SetPartial(key)
 		}
+		log.Printf("[INFO] Successfully updated the template of Vnet %s\n", resp)
 	}
 
 	if d.HasChange("name") {
-		resp, err := client.Call(
-			"one.vn.rename",
-			intId(d.Id()),
-			d.Get("name").(string),
-		)
-		if err != nil {
+		oldname, newname := d.GetChange("name")
+		if err := renameVnet(client, intId(d.Id()), oldname.(string), newname.(string)); err != nil {
 			return err
 		}
-		log.Printf("[INFO] Successfully updated name for Vnet %s\n", resp)
+		d.SetPartial("name")
 	}
 
-	var vn_ar_cmd string
+	vn_ar_call := client.VnetUpdateAR
 	if d.HasChange("ip_start") {
 		oldv, _ := d.GetChange("ip_start")
 		if oldv.(string) == "" {
 			// new address address_range_string
-			vn_ar_cmd = "one.vn.add_ar"
+			vn_ar_call = client.VnetAddAR
 		} else {
 			log.Printf("[WARNING] Changing the IP address of the Vnet address range is currently not supported")
 		}
-	} else {
-		vn_ar_cmd = "one.vn.update_ar"
 	}
 
 	if d.HasChange("ip_size") {
@@ -565,8 +802,7 @@ func resourceVnetUpdate(d *schema.ResourceData, meta interface{}) error {
 		TYPE = IP4,
 		IP = %s,
 		SIZE = %d ]`
-		resp, a_err := client.Call(
-			vn_ar_cmd,
+		resp, a_err := vn_ar_call(
 			intId(d.Id()),
 			fmt.Sprintf(address_range_string, d.Get("ip_start").(string), d.Get("ip_size").(int)),
 		)
@@ -579,6 +815,18 @@ func resourceVnetUpdate(d *schema.ResourceData, meta interface{}) error {
 		log.Printf("[INFO] Successfully updated size of address range for Vnet %s\n", resp)
 	}
 
+	if d.HasChange("hold_ips") {
+		oldips, newips := d.GetChange("hold_ips")
+		held, released := vnetIPsChange(oldips.([]interface{}), newips.([]interface{}))
+		if err := releaseVnetIPs(client, intId(d.Id()), released); err != nil {
+			return err
+		}
+		if err := holdVnetIPs(client, intId(d.Id()), held); err != nil {
+			return err
+		}
+		d.SetPartial("hold_ips")
+	}
+
 	var change_own bool = false
 	var newuid int = -1
 	var newgid int = -1
@@ -586,13 +834,16 @@ func resourceVnetUpdate(d *schema.ResourceData, meta interface{}) error {
 		change_own = true
 		newuid = d.Get("uid").(int)
 	}
-	if d.HasChange("gid") && d.Get("gid") != "" {
-		change_own = true
-		newgid = d.Get("gid").(int)
+	if d.HasChange("group") || d.HasChange("gid") {
+		gid, set, err := ownerGroup(d, client)
+		if err != nil {
+			return err
+		}
+		change_own = change_own || set
+		newgid = gid
 	}
 	if change_own {
-		resp, co_err := client.Call(
-			"one.vn.chown",
+		resp, co_err := client.VnetChown(
 			intId(d.Id()),
 			newuid,
 			newgid,
@@ -603,11 +854,12 @@ func resourceVnetUpdate(d *schema.ResourceData, meta interface{}) error {
 		}
 		d.SetPartial("uid")
 		d.SetPartial("gid")
+		d.SetPartial("group")
 		log.Printf("[INFO] Successfully updated owner uid and gid for Vnet %s\n", resp)
 	}
 
-	if d.HasChange("permissions") && d.Get("permissions") != "" {
-		resp, err := changePermissions(intId(d.Id()), permission(d.Get("permissions").(string)), client, "one.vn.chmod")
+	if perms := changedPermissions(d); perms != nil {
+		resp, err := client.VnetChmod(intId(d.Id()), perms)
 		if err != nil {
 			return err
 		}
@@ -625,33 +877,566 @@ func resourceVnetDelete(d *schema.ResourceData, meta interface{}) error {
 	}
 
 	client := meta.(*Client)
-	if d.Get("hold_size").(int) > 0 {
-		// add address range and reservations
-		ip := net.ParseIP(d.Get("ip_start").(string))
-		ip = ip.To4()
+	if err = deleteVnetReservations(client, intId(d.Id()), d.Get("cascade_delete").(bool)); err != nil {
+		return err
+	}
 
-		for i := 0; i < d.Get("reservation_size").(int); i++ {
-			var address_reservation_string = `LEASES=[IP=%s]`
-			_, r_err := client.Call(
-				"one.vn.release",
-				intId(d.Id()),
-				fmt.Sprintf(address_reservation_string, ip),
-			)
+	// Held leases go away with the vnet: releasing them first would only
+	// add calls that can fail. A reservation is only deleted once none of
+	// its leases is used though, held ones included.
+	if d.Get("reservation_vnet").(int) != 0 {
+		if err = releaseVnetIPs(client, intId(d.Id()), d.Get("hold_ips").([]interface{})); err != nil {
+			return err
+		}
+	}
+	resp, err := client.VnetDelete(intId(d.Id()))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Successfully deleted Vnet %s\n", resp)
+	return nil
+}
+
+// vnetVlanID returns the vlan ID of a vnet: the one OpenNebula picked, or
+// the one of its template for drivers not handling vlans.
+func vnetVlanID(vn *UserVnet) int {
+	if vn.VlanId != "" {
+		if id, err := strconv.Atoi(vn.VlanId); err == nil {
+			return id
+		}
+	}
+	if vn.Template != nil {
+		return vn.Template.Vlan_id
+	}
+	return 0
+}
+
+// checkVnetDriver checks the settings a VN_MAD driver needs: a physical
+// device for the drivers tagging or encapsulating traffic, and a vlan ID for
+// 802.1Q, unless OpenNebula picks it.
+func checkVnetDriver(vnMad, phydev string, vlanID int, automaticVlanID bool) error {
+	switch vnMad {
+	case "802.1Q":
+		if phydev == "" || (vlanID == 0 && !automaticVlanID) {
+			return fmt.Errorf("For vn_mad 802.1Q, phydev and either vlan_id or automatic_vlan_id should be given")
+		}
+	case "vxlan", "ovswitch_vxlan":
+		if phydev == "" {
+			return fmt.Errorf("For vn_mad %s, phydev should be given", vnMad)
+		}
+	}
+	return nil
+}
+
+func resourceVnetCustomizeDiff(diff *schema.ResourceDiff, v interface{}) error {
+	if err := checkResourceVersions(diff, v, "opennebula_vnet"); err != nil {
+		return err
+	}
+
+	// Reservations have no driver of their own, and interpolated values
+	// are only checked on creation
+	if !diff.NewValueKnown("reservation_vnet") {
+		return nil
+	}
+	reservationVnet := diff.Get("reservation_vnet").(int)
+	if err := checkVnetReservation(reservationVnet, diff.Get("reservation_ar_id").(int), diff.Get("reservation_first_ip").(string)); err != nil {
+		return err
+	}
+	if reservationVnet != 0 {
+		// Reserved addresses can be added to a reservation, not taken back
+		if diff.Id() != "" && diff.HasChange("reservation_size") {
+			oldsize, newsize := diff.GetChange("reservation_size")
+			if newsize.(int) < oldsize.(int) {
+				return diff.ForceNew("reservation_size")
+			}
+		}
+		return nil
+	}
+	// Addresses are only checked against the address range of the vnet,
+	// the ones of reservations are not known
+	if ipStart, ok := diff.GetOk("ip_start"); ok && diff.NewValueKnown("ip_size") && diff.NewValueKnown("hold_ips") {
+		size := diff.Get("ip_size").(int)
+		if size == 0 {
+			size = 1
+		}
+		if err := checkVnetHoldIPs(ipStart.(string), size, diff.Get("hold_ips").([]interface{})); err != nil {
+			return err
+		}
+	}
+	keys := []string{"vn_mad", "phydev", "automatic_vlan_id"}
+	// vlan_id is only known after creation when OpenNebula picks it
+	if !diff.Get("automatic_vlan_id").(bool) {
+		keys = append(keys, "vlan_id")
+	}
+	for _, key := range keys {
+		if !diff.NewValueKnown(key) {
+			return nil
+		}
+	}
+	return checkVnetDriver(diff.Get("vn_mad").(string), diff.Get("phydev").(string), diff.Get("vlan_id").(int), diff.Get("automatic_vlan_id").(bool))
+}
+
+// checkVnetReservation checks the address range and first IP of a reservation
+// are only given for reservations, the first IP along with its range.
+func checkVnetReservation(reservationVnet, arID int, firstIP string) error {
+	if reservationVnet == 0 && (arID >= 0 || firstIP != "") {
+		return fmt.Errorf("reservation_ar_id and reservation_first_ip can only be used with reservation_vnet")
+	}
+	if firstIP != "" && arID < 0 {
+		return fmt.Errorf("reservation_first_ip needs the reservation_ar_id it belongs to")
+	}
+	return nil
+}
+
+// vnetTemplateChanged returns whether any argument of the vnet template
+// changed.
+func vnetTemplateChanged(d *schema.ResourceData) bool {
+	for _, key := range vnetTemplateArgs {
+		if d.HasChange(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// vnetTemplateValues returns the values of the arguments of the vnet d
+// making its template, only the description and security groups for
+// reservations, which take the others from the vnet they are carved from.
+func vnetTemplateValues(d *schema.ResourceData, reservation bool) map[string]interface{} {
+	keys := []string{"description", "security_groups"}
+	if !reservation {
+		keys = append([]string{"bridge", "vn_mad", "phydev", "vlan_id", "automatic_vlan_id"}, vnetTemplateArgs...)
+	}
+
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		values[key] = d.Get(key)
+	}
+	return values
+}
+
+// vnetTemplate returns the template attributes of a vnet, from the values of
+// its arguments, each on its own line. On updates the driver settings,
+// which can't change, are left out and the attributes of empty values are
+// emptied, as are the custom attributes of oldcustom left out.
+func vnetTemplate(values, oldcustom map[string]interface{}, update bool) string {
+	var tmpl strings.Builder
+
+	str := func(key, attr string) {
+		if v, ok := values[key].(string); ok && (v != "" || update) {
+			fmt.Fprintf(&tmpl, "\n%s=\"%s\"", attr, v)
+		}
+	}
+	mtu := func(key, attr string) {
+		if v, ok := values[key].(int); ok && (v != 0 || update) {
+			fmt.Fprintf(&tmpl, "\n%s", vnetMTU(attr, v))
+		}
+	}
+
+	str("description", "DESCRIPTION")
+	if !update {
+		str("bridge", "BRIDGE")
+		str("vn_mad", "VN_MAD")
+		str("phydev", "PHYDEV")
+		if automatic, _ := values["automatic_vlan_id"].(bool); automatic {
+			fmt.Fprintf(&tmpl, "\nAUTOMATIC_VLAN_ID=\"YES\"")
+		} else if vlanid, _ := values["vlan_id"].(int); vlanid != 0 {
+			fmt.Fprintf(&tmpl, "\nVLAN_ID=\"%d\"", vlanid)
+		}
+	}
+	// CONTEXT params
+	str("networkmask", "NETWORK_MASK")
+	str("gateway", "GATEWAY")
+	str("dns", "DNS")
+	for _, ctx := range vnetContextAttributes {
+		str(ctx[0], ctx[1])
+	}
+	mtu("mtu", "MTU")
+	mtu("guest_mtu", "GUEST_MTU")
+	if secgroups, ok := values["security_groups"].([]interface{}); ok && (len(secgroups) > 0 || update) {
+		ids := make([]string, 0, len(secgroups))
+		for _, id := range secgroups {
+			ids = append(ids, strconv.Itoa(id.(int)))
+		}
+		fmt.Fprintf(&tmpl, "\nSECURITY_GROUPS=\"%s\"", strings.Join(ids, ","))
+	}
+	if custom, ok := values["custom_attributes"].(map[string]interface{}); ok {
+		tmpl.WriteString(vnetCustomAttributes(oldcustom, custom))
+	}
+
+	return tmpl.String()
+}
 
-			if r_err != nil {
-				return r_err
+var customAttributeKey = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// validateCustomAttributes checks the keys of a map of custom template
+// attributes, warning about the fixed ones set by other arguments.
+func validateCustomAttributes(fixed []string) schema.SchemaValidateFunc {
+	return func(v interface{}, k string) (ws []string, errors []error) {
+		for key := range v.(map[string]interface{}) {
+			if !customAttributeKey.MatchString(key) {
+				errors = append(errors, fmt.Errorf("%q key %q must be made of upper case letters, digits and underscores", k, key))
+			} else if in_array(key, fixed) {
+				ws = append(ws, fmt.Sprintf("%q key %q is set by other arguments and ignored", k, key))
 			}
+		}
+		return
+	}
+}
+
+// vnetCustomAttributes returns the template attributes setting the custom
+// attributes attrs of a vnet.
+func vnetCustomAttributes(old, attrs map[string]interface{}) string {
+	return customAttributes(old, attrs, vnetTemplateAttributes)
+}
+
+// customAttributes returns the template attributes setting the custom
+// attributes attrs, each on its own line, emptying the ones of old left out.
+// The fixed attributes, set by other arguments, are skipped.
+func customAttributes(old, attrs map[string]interface{}, fixed []string) string {
+	values := make(map[string]string, len(attrs))
+	for key := range old {
+		values[key] = ""
+	}
+	for key, value := range attrs {
+		values[key] = value.(string)
+	}
 
-			ip[3]++
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if in_array(key, fixed) {
+			log.Printf("[WARN] Ignoring custom attribute %s, set by other arguments", key)
+			continue
 		}
-		log.Printf("[INFO] Successfully released reservered IP addresses.")
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	resp, err := client.Call("one.vn.delete", intId(d.Id()), false)
+	var tmpl strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&tmpl, "\n%s=\"%s\"", key, values[key])
+	}
+	return tmpl.String()
+}
+
+// vnetMTU returns the vnet template attribute setting an MTU, an empty one
+// for 0 to go back to the default of the driver.
+func vnetMTU(attr string, mtu int) string {
+	if mtu == 0 {
+		return fmt.Sprintf("%s=\"\"", attr)
+	}
+	return fmt.Sprintf("%s=\"%d\"", attr, mtu)
+}
+
+// validateVnetMTU checks an MTU is large enough for IPv4.
+func validateVnetMTU(v interface{}, k string) (ws []string, errors []error) {
+	if mtu := v.(int); mtu != 0 && (mtu < 576 || mtu > 65535) {
+		errors = append(errors, fmt.Errorf("%q must be between 576 and 65535, got %d", k, mtu))
+	}
+	return
+}
+
+// ipv4Add returns the IPv4 address n addresses after ip, carrying over
+// octets: 10.0.0.250 + 10 is 10.0.1.4.
+func ipv4Add(ip net.IP, n int) net.IP {
+	v := binary.BigEndian.Uint32(ip.To4()) + uint32(n)
+	next := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(next, v)
+	return next
+}
+
+// rollbackVnet deletes the vnet id whose configuration failed with cause,
+// and returns whether it was deleted along with the error to report.
+func rollbackVnet(client *Client, id int, cause error) (bool, error) {
+	log.Printf("[WARN] Deleting Vnet %d, its creation failed: %s", id, cause)
+	if _, err := client.VnetDelete(id); err != nil {
+		return false, fmt.Errorf("%s. Vnet %d could not be deleted either: %s", cause, id, err)
+	}
+	return true, fmt.Errorf("%s. Vnet %d was deleted", cause, id)
+}
+
+// growVnetReservation reserves size more addresses of the vnet parent into
+// its reservation id, from the address range arID unless it is -1.
+func growVnetReservation(client *Client, parent, id, size, arID int) error {
+	template := fmt.Sprintf("SIZE=%d\nNETWORK_ID=%d", size, id)
+	if arID >= 0 {
+		template += fmt.Sprintf("\nAR_ID=%d", arID)
+	}
+
+	if _, err := client.VnetReserve(parent, template); err != nil {
+		return fmt.Errorf("Error growing reservation Vnet %d by %d addresses of Vnet %d: %s", id, size, parent, err)
+	}
+	log.Printf("[INFO] Successfully grew reservation Vnet %d by %d addresses\n", id, size)
+	return nil
+}
+
+// renameVnet renames the vnet id from oldname to newname, after checking
+// that id is still the vnet named oldname.
+func renameVnet(client *Client, id int, oldname, newname string) error {
+	var vn *UserVnet
+
+	resp, err := client.VnetInfo(id)
 	if err != nil {
+		return fmt.Errorf("Error renaming Vnet %d from %s to %s, it could not be found by ID: %s", id, oldname, newname, err)
+	}
+	if err = client.Decode(resp, &vn); err != nil {
 		return err
 	}
+	if vn.Name != oldname {
+		return fmt.Errorf("Error renaming Vnet %d from %s to %s: it is named %s", id, oldname, newname, vn.Name)
+	}
 
-	log.Printf("[INFO] Successfully deleted Vnet %s\n", resp)
+	if _, err = client.VnetRename(id, newname); err != nil {
+		return fmt.Errorf("Error renaming Vnet %d from %s to %s: %s", id, oldname, newname, err)
+	}
+	log.Printf("[INFO] Successfully renamed Vnet %d from %s to %s\n", id, oldname, newname)
+	return nil
+}
+
+// holdVnetIPs holds each of the ips of the vnet id.
+func holdVnetIPs(client *Client, id int, ips []interface{}) error {
+	for _, ip := range ips {
+		if _, err := client.VnetHold(id, fmt.Sprintf("LEASES=[IP=%s]", ip.(string))); err != nil {
+			return fmt.Errorf("Error holding IP %s of Vnet %d: %s", ip.(string), id, err)
+		}
+		log.Printf("[INFO] Held IP %s of Vnet %d\n", ip.(string), id)
+	}
+	return nil
+}
+
+// releaseVnetIPs releases each of the held ips of the vnet id.
+func releaseVnetIPs(client *Client, id int, ips []interface{}) error {
+	for _, ip := range ips {
+		if _, err := client.VnetRelease(id, fmt.Sprintf("LEASES=[IP=%s]", ip.(string))); err != nil {
+			return fmt.Errorf("Error releasing IP %s of Vnet %d: %s", ip.(string), id, err)
+		}
+		log.Printf("[INFO] Released IP %s of Vnet %d\n", ip.(string), id)
+	}
+	return nil
+}
+
+// vnetIPsChange returns the IPs to hold and to release to go from the held
+// oldips to newips, leaving the ones in both alone.
+func vnetIPsChange(oldips, newips []interface{}) (held, released []interface{}) {
+	in := func(ip interface{}, ips []interface{}) bool {
+		for _, i := range ips {
+			if i == ip {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, ip := range newips {
+		if !in(ip, oldips) {
+			held = append(held, ip)
+		}
+	}
+	for _, ip := range oldips {
+		if !in(ip, newips) {
+			released = append(released, ip)
+		}
+	}
+	return held, released
+}
+
+// checkVnetHoldIPs checks the ips to hold are in the address range of size
+// addresses starting from ipStart.
+func checkVnetHoldIPs(ipStart string, size int, ips []interface{}) error {
+	start := net.ParseIP(ipStart).To4()
+	if start == nil {
+		return nil
+	}
+	first := binary.BigEndian.Uint32(start)
+	for _, ip := range ips {
+		addr := net.ParseIP(ip.(string)).To4()
+		if addr == nil {
+			return fmt.Errorf("Can't hold %s in the address range from %s, not an IPv4 address", ip.(string), ipStart)
+		}
+		if v := binary.BigEndian.Uint32(addr); v < first || v-first >= uint32(size) {
+			return fmt.Errorf("Can't hold %s, out of the address range of %d IPs from %s", ip.(string), size, ipStart)
+		}
+	}
 	return nil
 }
+
+// vnetReservations returns the VNETs reserved from the VNET id.
+func vnetReservations(client *Client, id int) ([]*UserVnet, error) {
+	var vns *UserVnets
+
+	resp, err := client.VnetPoolInfo(-2, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = client.Decode(resp, &vns); err != nil {
+		return nil, err
+	}
+
+	var reservations []*UserVnet
+	for _, vn := range vns.UserVnet {
+		if vn.ParentVnet == strconv.Itoa(id) {
+			reservations = append(reservations, vn)
+		}
+	}
+
+	return reservations, nil
+}
+
+// deleteVnetReservations deletes the reservations of the VNET id when
+// cascade is set, OpenNebula refusing to delete a VNET which still has
+// some. Nothing is deleted if a reservation has leases in use.
+func deleteVnetReservations(client *Client, id int, cascade bool) error {
+	reservations, err := vnetReservations(client, id)
+	if err != nil || len(reservations) == 0 {
+		return err
+	}
+
+	ids := make([]string, 0, len(reservations))
+	var used []string
+	for _, vn := range reservations {
+		ids = append(ids, strconv.Itoa(vn.Id))
+		if vn.UsedLeases > 0 {
+			used = append(used, strconv.Itoa(vn.Id))
+		}
+	}
+
+	if !cascade {
+		return fmt.Errorf("Vnet %d still has reservations %s, delete them first or set cascade_delete", id, strings.Join(ids, ", "))
+	}
+	if len(used) > 0 {
+		return fmt.Errorf("Vnet %d has reservations with leases in use: %s", id, strings.Join(used, ", "))
+	}
+
+	for _, vn := range reservations {
+		resp, err := client.VnetDelete(vn.Id)
+		if err != nil {
+			return fmt.Errorf("Error deleting reservation %d of Vnet %d: %s", vn.Id, id, err)
+		}
+		log.Printf("[INFO] Successfully deleted reservation Vnet %s\n", resp)
+	}
+
+	return nil
+}
+
+// vnetAddressRange returns the first address range of a vnet, the one
+// managed by ip_start and ip_size, or nil if it has none.
+func vnetAddressRange(ars []VnetAR) *VnetAR {
+	for i := range ars {
+		if ars[i].Id == 0 {
+			return &ars[i]
+		}
+	}
+	return nil
+}
+
+// vnetHeldIPs returns the IPs held in the address ranges ars.
+func vnetHeldIPs(ars []VnetAR) []string {
+	var held []string
+	for _, ar := range ars {
+		for _, lease := range ar.Leases {
+			if lease.IP != "" && (lease.VM == "" || lease.VM == "-1") && lease.Vnet == "" && lease.VRouter == "" {
+				held = append(held, lease.IP)
+			}
+		}
+	}
+	return held
+}
+
+// vnetHoldsFirstIPs returns whether the held IPs are the ones hold_size
+// holds: consecutive ones from ipStart.
+func vnetHoldsFirstIPs(ipStart string, held []string) bool {
+	start := net.ParseIP(ipStart).To4()
+	if start == nil || len(held) == 0 {
+		return false
+	}
+	for i, ip := range held {
+		if !ipv4Add(start, i).Equal(net.ParseIP(ip)) {
+			return false
+		}
+	}
+	return true
+}
+
+// vnetSecurityGroups returns the IDs of the comma separated SECURITY_GROUPS
+// of a vnet template.
+func vnetSecurityGroups(secgroups string) ([]int, error) {
+	ids := []int{}
+	for _, i := range strings.Split(secgroups, ",") {
+		if i != "" {
+			j, err := strconv.Atoi(i)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, j)
+		}
+	}
+	return ids, nil
+}
+
+// vnetTotalLeases returns the number of addresses of the address ranges ars.
+func vnetTotalLeases(ars []VnetAR) int {
+	total := 0
+	for _, ar := range ars {
+		total += ar.Size
+	}
+	return total
+}
+
+func vnetLeasesSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Computed:    true,
+		Description: "Addresses of the VNET in use: leased to a VM, held, or given to a reservation or virtual router",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"ip": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"mac": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"vm_id": {
+					Type:        schema.TypeInt,
+					Computed:    true,
+					Description: "ID of the VM using the address, -1 if none",
+				},
+				"hold": {
+					Type:     schema.TypeBool,
+					Computed: true,
+				},
+			},
+		},
+	}
+}
+
+// flattenVnetLeases lists the leases of the address ranges, keeping at most
+// max of them. Free addresses are not listed.
+func flattenVnetLeases(ars []VnetAR, max int) []interface{} {
+	leases := make([]interface{}, 0)
+	for _, ar := range ars {
+		for _, lease := range ar.Leases {
+			if len(leases) >= max {
+				log.Printf("[WARN] Vnet has more than %d leases, only the first ones are kept in state", max)
+				return leases
+			}
+
+			vmId := -1
+			if lease.VM != "" {
+				vmId = intId(lease.VM)
+			}
+
+			leases = append(leases, map[string]interface{}{
+				"ip":    lease.IP,
+				"mac":   lease.MAC,
+				"vm_id": vmId,
+				"hold":  vmId == -1 && lease.Vnet == "" && lease.VRouter == "",
+			})
+		}
+	}
+
+	return leases
+}
@@ -4,12 +4,54 @@ import (
 	"encoding/xml"
 	"fmt"
 	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
+	"log"
+	"net"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 )
 
+func init() {
+	resource.AddTestSweepers("opennebula_vnet", &resource.Sweeper{
+		Name:         "opennebula_vnet",
+		Dependencies: []string{"opennebula_vm"},
+		F:            testSweepVnets,
+	})
+}
+
+func testSweepVnets(region string) error {
+	client, err := sharedClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.VnetPoolInfo(-3, -1, -1)
+	if err != nil {
+		return err
+	}
+
+	var vns UserVnets
+	if err = xml.Unmarshal([]byte(resp), &vns); err != nil {
+		return err
+	}
+
+	for _, vn := range vns.UserVnet {
+		if !strings.HasPrefix(vn.Name, testAccPrefix) {
+			continue
+		}
+
+		log.Printf("[INFO] Sweeping vnet %s (%d)", vn.Name, vn.Id)
+		if _, err := client.VnetDelete(vn.Id); err != nil {
+			log.Printf("[ERROR] Failed to sweep vnet %d: %s", vn.Id, err)
+		}
+	}
+
+	return nil
+}
+
 func TestAccVnet(t *testing.T) {
 	resource.Test(t, resource.TestCase{
 		PreCheck:     func() { testAccPreCheck(t) },
@@ -19,16 +61,23 @@ func TestAccVnet(t *testing.T) {
 			{
 				Config: testAccVnetConfigBasic,
 				Check: resource.ComposeTestCheckFunc(
-					resource.TestCheckResourceAttr("opennebula_vnet.test", "name", "test-vnet"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "name", "tf-acc-test-vnet"),
 					resource.TestCheckResourceAttr("opennebula_vnet.test", "bridge", "br-test"),
 					resource.TestCheckResourceAttr("opennebula_vnet.test", "ip_start", "192.168.0.1"),
 					resource.TestCheckResourceAttr("opennebula_vnet.test", "ip_size", "10"),
 					resource.TestCheckResourceAttr("opennebula_vnet.test", "permissions", "642"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "mtu", "1450"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "guest_mtu", "0"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "leases.#", "0"),
 					resource.TestCheckResourceAttrSet("opennebula_vnet.test", "uid"),
 					resource.TestCheckResourceAttrSet("opennebula_vnet.test", "gid"),
 					resource.TestCheckResourceAttrSet("opennebula_vnet.test", "uname"),
 					resource.TestCheckResourceAttrSet("opennebula_vnet.test", "gname"),
 					testAccCheckVnetAttributes(map[string]string{"FOO": "bar"}),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "custom_attributes.%", "3"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "description", "Created by the acceptance tests"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "network_address", "192.168.0.0"),
+					testAccCheckVnetAttributes(map[string]string{"ZONE": "dmz", "VRF": "100"}),
 					testAccCheckVnetPermissions(&Permissions{
 						Owner_U: 1,
 						Owner_M: 1,
@@ -37,11 +86,27 @@ func TestAccVnet(t *testing.T) {
 					}),
 				),
 			},
+			{
+				ResourceName:            "opennebula_vnet.test",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"cascade_delete", "rollback_on_failure", "max_leases", "custom_attributes"},
+			},
 			{
 				Config: testAccVnetConfigUpdate,
 				Check: resource.ComposeTestCheckFunc(
 					resource.TestCheckResourceAttr("opennebula_vnet.test", "permissions", "700"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "gname", "users"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "mtu", "1500"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "guest_mtu", "1450"),
+					testAccCheckVnetAttributes(map[string]string{"MTU": "1500", "GUEST_MTU": "1450"}),
 					testAccCheckVnetAttributes(map[string]string{"FOO": "bar2"}),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "custom_attributes.%", "2"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "description", "Updated by the acceptance tests"),
+					testAccCheckVnetAttributes(map[string]string{"DESCRIPTION": "Updated by the acceptance tests", "DNS6": "fd00::53"}),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "search_domain", "tf-acc-test.local"),
+					testAccCheckVnetAttributes(map[string]string{"GATEWAY6": "fd00::1", "DNS6": "fd00::53"}),
+					testAccCheckVnetAttributes(map[string]string{"ZONE": "lan"}),
 					testAccCheckVnetPermissions(&Permissions{
 						Owner_U: 1,
 						Owner_M: 1,
@@ -53,11 +118,629 @@ func TestAccVnet(t *testing.T) {
 	})
 }
 
+func TestAccVnetRename(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVnetConfigRename, "tf-acc-test-vnet-rename"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "name", "tf-acc-test-vnet-rename"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "security_groups.#", "1"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "security_groups.0", "0"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccVnetConfigRename, "tf-acc-test-vnet-renamed"),
+				Check:  resource.TestCheckResourceAttr("opennebula_vnet.test", "name", "tf-acc-test-vnet-renamed"),
+			},
+			{
+				Config:   fmt.Sprintf(testAccVnetConfigRename, "tf-acc-test-vnet-renamed"),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVnetHoldSize(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVnetConfigHoldSize,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "hold_size", "5"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "used_leases", "5"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "total_leases", "10"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "leases.#", "5"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "leases.4.hold", "true"),
+				),
+			},
+			{
+				ResourceName:            "opennebula_vnet.test",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"cascade_delete", "rollback_on_failure", "max_leases"},
+			},
+		},
+	})
+}
+
+func TestAccVnetHoldIPs(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVnetConfigHoldIPs, `"192.168.5.1", "192.168.5.7"`),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "hold_ips.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "leases.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "leases.1.ip", "192.168.5.7"),
+				),
+			},
+			{
+				ResourceName:            "opennebula_vnet.test",
+				ImportState:             true,
+				ImportStateVerify:       true,
+				ImportStateVerifyIgnore: []string{"cascade_delete", "rollback_on_failure", "max_leases"},
+			},
+			{
+				Config: fmt.Sprintf(testAccVnetConfigHoldIPs, `"192.168.5.1", "192.168.5.9"`),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "leases.#", "2"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "leases.1.ip", "192.168.5.9"),
+				),
+			},
+			{
+				Config:      fmt.Sprintf(testAccVnetConfigHoldIPs, `"192.168.5.20"`),
+				ExpectError: regexp.MustCompile("out of the address range"),
+			},
+		},
+	})
+}
+
+func TestVnetIPsChange(t *testing.T) {
+	held, released := vnetIPsChange(
+		[]interface{}{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		[]interface{}{"10.0.0.3", "10.0.0.1", "10.0.0.4"},
+	)
+	if !reflect.DeepEqual(held, []interface{}{"10.0.0.4"}) {
+		t.Errorf("Expected to hold 10.0.0.4 only, got %v", held)
+	}
+	if !reflect.DeepEqual(released, []interface{}{"10.0.0.2"}) {
+		t.Errorf("Expected to release 10.0.0.2 only, got %v", released)
+	}
+
+	if held, released = vnetIPsChange(nil, []interface{}{"10.0.0.1"}); len(held) != 1 || len(released) != 0 {
+		t.Errorf("Expected to hold the new IP only, got %v and %v", held, released)
+	}
+}
+
+func TestCheckVnetHoldIPs(t *testing.T) {
+	cases := []struct {
+		ips   []interface{}
+		valid bool
+	}{
+		{nil, true},
+		{[]interface{}{"192.168.5.1", "192.168.5.10"}, true},
+		{[]interface{}{"192.168.4.255"}, false},
+		{[]interface{}{"192.168.5.11"}, false},
+		{[]interface{}{"fe80::1"}, false},
+	}
+
+	for _, c := range cases {
+		err := checkVnetHoldIPs("192.168.5.1", 10, c.ips)
+		if (err == nil) != c.valid {
+			t.Errorf("Expected %v to be valid: %t, got error: %v", c.ips, c.valid, err)
+		}
+	}
+}
+
+func TestAccVnetRollback(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config:      testAccVnetConfigRollback,
+				ExpectError: regexp.MustCompile("Error holding IP 10.9.9.9 .* was deleted"),
+			},
+		},
+	})
+}
+
+func TestRollbackVnet(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.vn.delete" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		if args[0].(int) == 2 {
+			return []interface{}{false, "[one.vn.delete] Not authorized", int64(0x0200)}, nil
+		}
+		return []interface{}{true, int64(args[0].(int)), int64(0)}, nil
+	})
+
+	cause := fmt.Errorf("Error holding IP 10.0.0.1 of Vnet 1")
+	deleted, err := rollbackVnet(client, 1, cause)
+	if !deleted || err == nil || err.Error() != "Error holding IP 10.0.0.1 of Vnet 1. Vnet 1 was deleted" {
+		t.Fatalf("Expected vnet 1 to be deleted, got %t: %v", deleted, err)
+	}
+
+	deleted, err = rollbackVnet(client, 2, cause)
+	if deleted || err == nil || !strings.Contains(err.Error(), "could not be deleted either: [one.vn.delete] Not authorized") {
+		t.Fatalf("Expected the failed deletion of vnet 2 to be reported, got %t: %v", deleted, err)
+	}
+	if calls := caller.callsTo("one.vn.delete"); len(calls) != 2 {
+		t.Fatalf("Expected 2 deletions, got %v", calls)
+	}
+}
+
+func TestAccVnetVxlan(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVnetConfigVxlan,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "vn_mad", "vxlan"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "phydev", "eth0"),
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "vlan_id", "4242"),
+				),
+			},
+			{
+				Config:   testAccVnetConfigVxlan,
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVnetAutomaticVlanID(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccVnetConfigAutomaticVlanID,
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.test", "automatic_vlan_id", "true"),
+					resource.TestCheckResourceAttrSet("opennebula_vnet.test", "vlan_id"),
+				),
+			},
+			{
+				Config:   testAccVnetConfigAutomaticVlanID,
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestAccVnetReservationFirstIP(t *testing.T) {
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckVnetDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccVnetConfigReservationFirstIP, 5),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrPair("opennebula_vnet.reservation", "reservation_vnet", "opennebula_vnet.test", "id"),
+					resource.TestCheckResourceAttr("opennebula_vnet.reservation", "reservation_ar_id", "0"),
+					resource.TestCheckResourceAttr("opennebula_vnet.reservation", "reservation_first_ip", "192.168.4.10"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccVnetConfigReservationFirstIP, 8),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("opennebula_vnet.reservation", "reservation_size", "8"),
+					resource.TestCheckResourceAttr("opennebula_vnet.reservation", "total_leases", "8"),
+				),
+			},
+			{
+				Config:   fmt.Sprintf(testAccVnetConfigReservationFirstIP, 8),
+				PlanOnly: true,
+			},
+		},
+	})
+}
+
+func TestGrowVnetReservation(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method == "one.vn.reserve" {
+			return []interface{}{true, int64(args[0].(int)), int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	if err := growVnetReservation(client, 1, 2, 3, -1); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := growVnetReservation(client, 1, 2, 4, 0); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	calls := caller.callsTo("one.vn.reserve")
+	expected := []testCall{
+		{Method: "one.vn.reserve", Args: []interface{}{1, "SIZE=3\nNETWORK_ID=2"}},
+		{Method: "one.vn.reserve", Args: []interface{}{1, "SIZE=4\nNETWORK_ID=2\nAR_ID=0"}},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("Expected %v, got %v", expected, calls)
+	}
+}
+
+func TestCheckVnetReservation(t *testing.T) {
+	cases := []struct {
+		reservationVnet int
+		arID            int
+		firstIP         string
+		valid           bool
+	}{
+		{0, -1, "", true},
+		{12, -1, "", true},
+		{12, 0, "", true},
+		{12, 1, "192.168.4.10", true},
+		{0, 0, "", false},
+		{0, -1, "192.168.4.10", false},
+		{12, -1, "192.168.4.10", false},
+	}
+
+	for _, c := range cases {
+		err := checkVnetReservation(c.reservationVnet, c.arID, c.firstIP)
+		if (err == nil) != c.valid {
+			t.Errorf("Expected %+v to be valid: %t, got error: %v", c, c.valid, err)
+		}
+	}
+}
+
+func TestVnetVlanID(t *testing.T) {
+	cases := []struct {
+		vn       *UserVnet
+		expected int
+	}{
+		{&UserVnet{}, 0},
+		{&UserVnet{Template: &VnetTemplate{Vlan_id: 12}}, 12},
+		{&UserVnet{VlanId: "42", VlanIdAuto: "1", Template: &VnetTemplate{}}, 42},
+		{&UserVnet{VlanId: "42", Template: &VnetTemplate{Vlan_id: 42}}, 42},
+	}
+
+	for _, c := range cases {
+		if id := vnetVlanID(c.vn); id != c.expected {
+			t.Errorf("Expected vlan ID %d for %+v, got %d", c.expected, c.vn, id)
+		}
+	}
+}
+
+func TestCheckVnetDriver(t *testing.T) {
+	cases := []struct {
+		vnMad     string
+		phydev    string
+		vlanID    int
+		automatic bool
+		valid     bool
+	}{
+		{"", "", 0, false, true},
+		{"bridge", "", 0, false, true},
+		{"ovswitch", "", 12, false, true},
+		{"802.1Q", "eth0", 12, false, true},
+		{"802.1Q", "eth0", 0, true, true},
+		{"802.1Q", "eth0", 0, false, false},
+		{"802.1Q", "", 12, false, false},
+		{"vxlan", "eth0", 0, false, true},
+		{"vxlan", "", 12, false, false},
+		{"ovswitch_vxlan", "", 0, true, false},
+	}
+
+	for _, c := range cases {
+		err := checkVnetDriver(c.vnMad, c.phydev, c.vlanID, c.automatic)
+		if (err == nil) != c.valid {
+			t.Errorf("Expected %+v to be valid: %t, got error: %v", c, c.valid, err)
+		}
+	}
+}
+
+func TestRenameVnet(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.vn.info":
+			if args[0].(int) == 1 {
+				return []interface{}{true, "<VNET><ID>1</ID><NAME>tf-acc-test-vnet</NAME></VNET>", int64(0)}, nil
+			}
+			return []interface{}{false, "[one.vn.info] Error getting virtual network", int64(0x0400)}, nil
+		case "one.vn.rename":
+			return []interface{}{true, int64(args[0].(int)), int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	err := renameVnet(client, 2, "tf-acc-test-vnet", "tf-acc-test-vnet-renamed")
+	if err == nil || !strings.Contains(err.Error(), "from tf-acc-test-vnet to tf-acc-test-vnet-renamed") {
+		t.Fatalf("Expected renaming a missing vnet to fail with both names, got: %v", err)
+	}
+
+	err = renameVnet(client, 1, "tf-acc-test-other", "tf-acc-test-vnet-renamed")
+	if err == nil || !strings.Contains(err.Error(), "it is named tf-acc-test-vnet") {
+		t.Fatalf("Expected renaming another vnet to fail, got: %v", err)
+	}
+	if calls := caller.callsTo("one.vn.rename"); len(calls) != 0 {
+		t.Fatalf("Expected no vnet to be renamed, got %v", calls)
+	}
+
+	if err = renameVnet(client, 1, "tf-acc-test-vnet", "tf-acc-test-vnet-renamed"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if calls := caller.callsTo("one.vn.rename"); len(calls) != 1 || calls[0].Args[1] != "tf-acc-test-vnet-renamed" {
+		t.Fatalf("Expected vnet 1 to be renamed, got %v", calls)
+	}
+}
+
+func TestResourceVnetReadMissing(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.vn.info":
+			return []interface{}{false, "[one.vn.info] Error getting virtual network", int64(0x0400)}, nil
+		case "one.vnpool.info":
+			return []interface{}{true, "<VNET_POOL><VNET><ID>1</ID><NAME>tf-acc-test-vnet</NAME></VNET></VNET_POOL>", int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	// Vnet 2 was deleted, vnet 1 has its name: it must not be adopted, or
+	// the next rename would apply to it
+	d := schema.TestResourceDataRaw(t, resourceVnet().Schema, map[string]interface{}{
+		"name": "tf-acc-test-vnet",
+	})
+	d.SetId("2")
+
+	if err := resourceVnetRead(d, client); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if d.Id() != "" {
+		t.Fatalf("Expected the vnet to be removed from state, got ID %s", d.Id())
+	}
+	if calls := caller.callsTo("one.vnpool.info"); len(calls) != 0 {
+		t.Fatalf("Expected no lookup by name, got %v", calls)
+	}
+}
+
+func TestVnetCustomAttributes(t *testing.T) {
+	attrs := vnetCustomAttributes(nil, map[string]interface{}{"ZONE": "dmz", "VRF": "100", "DNS": "10.0.0.1"})
+	if attrs != "\nVRF=\"100\"\nZONE=\"dmz\"" {
+		t.Fatalf("Expected VRF and ZONE to be set, without DNS, got %q", attrs)
+	}
+
+	attrs = vnetCustomAttributes(map[string]interface{}{"ZONE": "dmz", "VRF": "100"}, map[string]interface{}{"ZONE": "lan"})
+	if attrs != "\nVRF=\"\"\nZONE=\"lan\"" {
+		t.Fatalf("Expected VRF to be emptied and ZONE to be updated, got %q", attrs)
+	}
+
+	var vn UserVnet
+	if err := xml.Unmarshal([]byte(`<VNET><TEMPLATE><DNS>10.0.0.1</DNS><ZONE><![CDATA[dmz]]></ZONE></TEMPLATE></VNET>`), &vn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if vn.Template.Dns != "10.0.0.1" || vn.Template.Get("ZONE") != "dmz" || vn.Template.Get("DNS") != "" {
+		t.Fatalf("Expected ZONE to be the only custom attribute, got %+v", vn.Template)
+	}
+}
+
+func TestVnetTemplate(t *testing.T) {
+	values := map[string]interface{}{
+		"description":       "",
+		"bridge":            "br0",
+		"vn_mad":            "802.1Q",
+		"phydev":            "eth0",
+		"vlan_id":           100,
+		"automatic_vlan_id": false,
+		"gateway":           "172.16.100.1",
+		"dns":               "172.16.100.1",
+		"networkmask":       "255.255.255.0",
+		"mtu":               0,
+		"security_groups":   []interface{}{0},
+		"custom_attributes": map[string]interface{}{"ZONE": "dmz"},
+	}
+	tmpl := vnetTemplate(values, nil, false)
+	expected := "\nBRIDGE=\"br0\"\nVN_MAD=\"802.1Q\"\nPHYDEV=\"eth0\"\nVLAN_ID=\"100\"" +
+		"\nNETWORK_MASK=\"255.255.255.0\"\nGATEWAY=\"172.16.100.1\"\nDNS=\"172.16.100.1\"" +
+		"\nSECURITY_GROUPS=\"0\"\nZONE=\"dmz\""
+	if tmpl != expected {
+		t.Fatalf("Expected the template to be %q, got %q", expected, tmpl)
+	}
+
+	// Updating the dns must not lose the gateway, nor any other attribute
+	values["dns"] = "8.8.8.8"
+	tmpl = vnetTemplate(values, map[string]interface{}{"ZONE": "dmz", "VRF": "100"}, true)
+	for _, attr := range []string{`DNS="8.8.8.8"`, `GATEWAY="172.16.100.1"`, `NETWORK_MASK="255.255.255.0"`,
+		`DESCRIPTION=""`, `MTU=""`, `SECURITY_GROUPS="0"`, `ZONE="dmz"`, `VRF=""`} {
+		if !strings.Contains(tmpl, "\n"+attr) {
+			t.Errorf("Expected the update to set %s, got %q", attr, tmpl)
+		}
+	}
+	if strings.Contains(tmpl, "VN_MAD") || strings.Contains(tmpl, "VLAN_ID") {
+		t.Errorf("Expected the update to leave the driver alone, got %q", tmpl)
+	}
+
+	tmpl = vnetTemplate(map[string]interface{}{"description": "reserved", "security_groups": []interface{}{0, 100}}, nil, true)
+	if tmpl != "\nDESCRIPTION=\"reserved\"\nSECURITY_GROUPS=\"0,100\"" {
+		t.Fatalf("Expected a reservation to only update its description and security groups, got %q", tmpl)
+	}
+}
+
+func TestVnetMTU(t *testing.T) {
+	if attr := vnetMTU("MTU", 1450); attr != `MTU="1450"` {
+		t.Fatalf("Expected MTU=\"1450\", got %s", attr)
+	}
+	if attr := vnetMTU("GUEST_MTU", 0); attr != `GUEST_MTU=""` {
+		t.Fatalf("Expected an empty GUEST_MTU, got %s", attr)
+	}
+
+	for _, mtu := range []int{0, 576, 1450, 9000} {
+		if _, errs := validateVnetMTU(mtu, "mtu"); len(errs) > 0 {
+			t.Errorf("Expected %d to be valid, got: %v", mtu, errs)
+		}
+	}
+	for _, mtu := range []int{-1, 68, 65536} {
+		if _, errs := validateVnetMTU(mtu, "mtu"); len(errs) == 0 {
+			t.Errorf("Expected %d to be rejected", mtu)
+		}
+	}
+}
+
+func TestIpv4Add(t *testing.T) {
+	cases := []struct {
+		ip       string
+		n        int
+		expected string
+	}{
+		{"10.0.0.1", 0, "10.0.0.1"},
+		{"10.0.0.1", 1, "10.0.0.2"},
+		{"10.0.0.250", 10, "10.0.1.4"},
+		{"10.0.0.255", 1, "10.0.1.0"},
+		{"10.0.255.255", 1, "10.1.0.0"},
+		// last address held from 172.16.0.1 with a hold_size of 70000
+		{"172.16.0.1", 69999, "172.17.17.112"},
+	}
+
+	for _, c := range cases {
+		start := net.ParseIP(c.ip)
+		if got := ipv4Add(start, c.n).String(); got != c.expected {
+			t.Errorf("Expected %s + %d to be %s, got %s", c.ip, c.n, c.expected, got)
+		}
+		if start.String() != c.ip {
+			t.Errorf("Expected %s to be left unchanged, got %s", c.ip, start)
+		}
+	}
+}
+
+func TestDeleteVnetReservations(t *testing.T) {
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		switch method {
+		case "one.vnpool.info":
+			return []interface{}{true, testVnetPoolInfo, int64(0)}, nil
+		case "one.vn.delete":
+			return []interface{}{true, int64(args[0].(int)), int64(0)}, nil
+		}
+		return nil, fmt.Errorf("unexpected call %s", method)
+	})
+
+	err := deleteVnetReservations(client, 1, false)
+	if err == nil || !strings.Contains(err.Error(), "2, 3") {
+		t.Fatalf("Expected the reservations 2 and 3 to be reported, got: %v", err)
+	}
+
+	err = deleteVnetReservations(client, 4, true)
+	if err == nil || !strings.Contains(err.Error(), "leases in use: 5") {
+		t.Fatalf("Expected reservation 5 to be reported as used, got: %v", err)
+	}
+	if calls := caller.callsTo("one.vn.delete"); len(calls) != 0 {
+		t.Fatalf("Expected no reservation to be deleted, got %v", calls)
+	}
+
+	if err = deleteVnetReservations(client, 1, true); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	calls := caller.callsTo("one.vn.delete")
+	if len(calls) != 2 || calls[0].Args[0] != 2 || calls[1].Args[0] != 3 {
+		t.Fatalf("Expected reservations 2 and 3 to be deleted, got %v", calls)
+	}
+
+	// vnet 0 must not be mistaken for the parent of vnets without one
+	if err = deleteVnetReservations(client, 0, false); err != nil {
+		t.Fatalf("Expected vnet 0 to have no reservations, got: %s", err)
+	}
+}
+
+func TestFlattenVnetLeases(t *testing.T) {
+	var vn UserVnet
+	if err := xml.Unmarshal([]byte(testVnetInfoLeases), &vn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{"ip": "10.0.0.1", "mac": "02:00:0a:00:00:01", "vm_id": -1, "hold": true},
+		map[string]interface{}{"ip": "10.0.0.2", "mac": "02:00:0a:00:00:02", "vm_id": 42, "hold": false},
+		map[string]interface{}{"ip": "10.0.0.3", "mac": "02:00:0a:00:00:03", "vm_id": -1, "hold": false},
+		map[string]interface{}{"ip": "", "mac": "02:00:00:00:01:00", "vm_id": 43, "hold": false},
+	}
+	if leases := flattenVnetLeases(vn.ARs, 1024); !reflect.DeepEqual(leases, expected) {
+		t.Fatalf("Expected leases %v, got %v", expected, leases)
+	}
+
+	if leases := flattenVnetLeases(vn.ARs, 2); !reflect.DeepEqual(leases, expected[:2]) {
+		t.Fatalf("Expected the leases to be capped to 2, got %v", leases)
+	}
+	if leases := flattenVnetLeases(vn.ARs, 0); len(leases) != 0 {
+		t.Fatalf("Expected no leases, got %v", leases)
+	}
+}
+
+func TestVnetImportedAddresses(t *testing.T) {
+	var vn UserVnet
+	if err := xml.Unmarshal([]byte(testVnetInfoLeases), &vn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ar := vnetAddressRange(vn.ARs)
+	if ar == nil || ar.IP != "10.0.0.1" || ar.Size != 10 {
+		t.Fatalf("Expected the address range of 10 IPs from 10.0.0.1, got %+v", ar)
+	}
+	if ar := vnetAddressRange(nil); ar != nil {
+		t.Fatalf("Expected no address range, got %+v", ar)
+	}
+
+	held := vnetHeldIPs(vn.ARs)
+	if !reflect.DeepEqual(held, []string{"10.0.0.1"}) {
+		t.Fatalf("Expected 10.0.0.1 to be the only held IP, got %v", held)
+	}
+
+	cases := []struct {
+		held     []string
+		expected bool
+	}{
+		{[]string{"10.0.0.1"}, true},
+		{[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, true},
+		{[]string{"10.0.0.1", "10.0.0.3"}, false},
+		{[]string{"10.0.0.2"}, false},
+		{nil, false},
+	}
+	for _, c := range cases {
+		if first := vnetHoldsFirstIPs("10.0.0.1", c.held); first != c.expected {
+			t.Errorf("Expected %v to be the first IPs of the range: %t, got %t", c.held, c.expected, first)
+		}
+	}
+}
+
+func TestVnetTotalLeases(t *testing.T) {
+	var vn UserVnet
+	if err := xml.Unmarshal([]byte(testVnetInfoLeases), &vn); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if vn.UsedLeases != 4 {
+		t.Fatalf("Expected 4 used leases, got %d", vn.UsedLeases)
+	}
+	if total := vnetTotalLeases(vn.ARs); total != 15 {
+		t.Fatalf("Expected 15 leases in the address ranges, got %d", total)
+	}
+	if total := vnetTotalLeases(nil); total != 0 {
+		t.Fatalf("Expected no leases without address ranges, got %d", total)
+	}
+}
+
 func testAccCheckVnetDestroy(s *terraform.State) error {
 	client := testAccProvider.Meta().(*Client)
 
 	for _, rs := range s.RootModule().Resources {
-		_, err := client.Call("one.vn.info", intId(rs.Primary.ID), false)
+		_, err := client.VnetInfo(intId(rs.Primary.ID))
 		if err == nil {
 			return fmt.Errorf("Expected vnet %s to have been destroyed", rs.Primary.ID)
 		}
@@ -71,14 +754,14 @@ func testAccCheckVnetAttributes(attrs map[string]string) resource.TestCheckFunc
 		client := testAccProvider.Meta().(*Client)
 
 		for _, rs := range s.RootModule().Resources {
-			resp, err := client.Call("one.vn.info", intId(rs.Primary.ID), false)
+			resp, err := client.VnetInfo(intId(rs.Primary.ID))
 			if err != nil {
 				return fmt.Errorf("Expected vnet %s to exist when checking attributes", rs.Primary.ID)
 			}
 
 			for k, v := range attrs {
 				if !strings.Contains(resp, fmt.Sprintf("<%s><![CDATA[%s]]></%s>", k, v, k)) {
-					return fmt.Errorf("Expected vnet to contain attribute %s=%s, specified in the description. The vnet contents were %s", k, v, resp)
+					return fmt.Errorf("Expected vnet to contain attribute %s=%s, specified in the configuration. The vnet contents were %s", k, v, resp)
 				}
 			}
 		}
@@ -92,7 +775,7 @@ func testAccCheckVnetPermissions(expected *Permissions) resource.TestCheckFunc {
 		client := testAccProvider.Meta().(*Client)
 
 		for _, rs := range s.RootModule().Resources {
-			resp, err := client.Call("one.vn.info", intId(rs.Primary.ID), false)
+			resp, err := client.VnetInfo(intId(rs.Primary.ID))
 			if err != nil {
 				return fmt.Errorf("Expected vnet %s to exist when checking permissions", rs.Primary.ID)
 			}
@@ -118,32 +801,203 @@ func testAccCheckVnetPermissions(expected *Permissions) resource.TestCheckFunc {
 
 var testAccVnetConfigBasic = `
 resource "opennebula_vnet" "test" {
-  name = "test-vnet"
-  description = <<EOF
-	FOO = "bar"
-  PHYDEV=""
-  SECURITY_GROUPS="0"
-  VN_MAD="dummy"
-  EOF
+  name = "tf-acc-test-vnet"
+  description = "Created by the acceptance tests"
+  vn_mad = "dummy"
   bridge = "br-test"
   ip_start = "192.168.0.1"
   ip_size = 10
   permissions = "642"
+  mtu = 1450
+  network_address = "192.168.0.0"
+  custom_attributes = {
+    FOO = "bar"
+    ZONE = "dmz"
+    VRF = "100"
+  }
+}
+`
+
+var testAccVnetConfigHoldSize = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-vnet-hold"
+  vn_mad = "dummy"
+  bridge = "br-test"
+  ip_start = "192.168.1.1"
+  ip_size = 10
+  hold_size = 5
+}
+`
+
+var testAccVnetConfigRename = `
+resource "opennebula_vnet" "test" {
+  name = "%s"
+  bridge = "br-test"
+  ip_start = "192.168.6.1"
+  ip_size = 10
+}
+`
+
+var testAccVnetConfigHoldIPs = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-vnet-hold-ips"
+  bridge = "br-test"
+  ip_start = "192.168.5.1"
+  ip_size = 10
+  hold_ips = [%s]
+}
+`
+
+var testAccVnetConfigRollback = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-vnet-rollback"
+  bridge = "br-test"
+  hold_ips = ["10.9.9.9"]
+}
+`
+
+var testAccVnetConfigVxlan = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-vnet-vxlan"
+  vn_mad = "vxlan"
+  phydev = "eth0"
+  vlan_id = 4242
+  bridge = "br-tf-acc-vxlan"
+  ip_start = "192.168.2.1"
+  ip_size = 10
+}
+`
+
+var testAccVnetConfigAutomaticVlanID = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-vnet-auto-vlan"
+  vn_mad = "802.1Q"
+  phydev = "eth0"
+  automatic_vlan_id = true
+  bridge = "br-tf-acc-auto"
+  ip_start = "192.168.3.1"
+  ip_size = 10
+}
+`
+
+var testAccVnetConfigReservationFirstIP = `
+resource "opennebula_vnet" "test" {
+  name = "tf-acc-test-vnet-reserved"
+  bridge = "br-tf-acc-reserved"
+  ip_start = "192.168.4.1"
+  ip_size = 20
+}
+
+resource "opennebula_vnet" "reservation" {
+  name = "tf-acc-test-vnet-reservation"
+  reservation_vnet = "${opennebula_vnet.test.id}"
+  reservation_size = %d
+  reservation_ar_id = 0
+  reservation_first_ip = "192.168.4.10"
 }
 `
 
 var testAccVnetConfigUpdate = `
 resource "opennebula_vnet" "test" {
-  name = "test-vnet"
-  description = <<EOF
-	FOO = "bar2"
-  PHYDEV=""
-  SECURITY_GROUPS="0"
-  VN_MAD="dummy"
-  EOF
+  name = "tf-acc-test-vnet"
+  description = "Updated by the acceptance tests"
+  vn_mad = "dummy"
   bridge = "br-test"
   ip_start = "192.168.0.10"
   ip_size = 20
   permissions = "700"
+  group = "users"
+  mtu = 1500
+  guest_mtu = 1450
+  search_domain = "tf-acc-test.local"
+  gateway6 = "fd00::1"
+  dns6 = "fd00::53"
+  custom_attributes = {
+    FOO = "bar2"
+    ZONE = "lan"
+  }
 }
 `
+
+var testVnetPoolInfo = `
+<VNET_POOL>
+  <VNET>
+    <ID>1</ID>
+    <NAME>parent</NAME>
+    <PARENT_NETWORK_ID/>
+    <USED_LEASES>2</USED_LEASES>
+  </VNET>
+  <VNET>
+    <ID>2</ID>
+    <NAME>reservation-a</NAME>
+    <PARENT_NETWORK_ID>1</PARENT_NETWORK_ID>
+    <USED_LEASES>0</USED_LEASES>
+  </VNET>
+  <VNET>
+    <ID>3</ID>
+    <NAME>reservation-b</NAME>
+    <PARENT_NETWORK_ID>1</PARENT_NETWORK_ID>
+    <USED_LEASES>0</USED_LEASES>
+  </VNET>
+  <VNET>
+    <ID>4</ID>
+    <NAME>other-parent</NAME>
+    <PARENT_NETWORK_ID/>
+    <USED_LEASES>1</USED_LEASES>
+  </VNET>
+  <VNET>
+    <ID>5</ID>
+    <NAME>reservation-used</NAME>
+    <PARENT_NETWORK_ID>4</PARENT_NETWORK_ID>
+    <USED_LEASES>1</USED_LEASES>
+  </VNET>
+</VNET_POOL>
+`
+
+var testVnetInfoLeases = `
+<VNET>
+  <ID>7</ID>
+  <NAME>leases</NAME>
+  <USED_LEASES>4</USED_LEASES>
+  <AR_POOL>
+    <AR>
+      <AR_ID><![CDATA[0]]></AR_ID>
+      <IP><![CDATA[10.0.0.1]]></IP>
+      <MAC><![CDATA[02:00:0a:00:00:01]]></MAC>
+      <SIZE><![CDATA[10]]></SIZE>
+      <TYPE><![CDATA[IP4]]></TYPE>
+      <USED_LEASES>3</USED_LEASES>
+      <LEASES>
+        <LEASE>
+          <IP><![CDATA[10.0.0.1]]></IP>
+          <MAC><![CDATA[02:00:0a:00:00:01]]></MAC>
+          <VM><![CDATA[-1]]></VM>
+        </LEASE>
+        <LEASE>
+          <IP><![CDATA[10.0.0.2]]></IP>
+          <MAC><![CDATA[02:00:0a:00:00:02]]></MAC>
+          <VM><![CDATA[42]]></VM>
+        </LEASE>
+        <LEASE>
+          <IP><![CDATA[10.0.0.3]]></IP>
+          <MAC><![CDATA[02:00:0a:00:00:03]]></MAC>
+          <VNET><![CDATA[8]]></VNET>
+        </LEASE>
+      </LEASES>
+    </AR>
+    <AR>
+      <AR_ID><![CDATA[1]]></AR_ID>
+      <MAC><![CDATA[02:00:00:00:01:00]]></MAC>
+      <SIZE><![CDATA[5]]></SIZE>
+      <TYPE><![CDATA[ETHER]]></TYPE>
+      <USED_LEASES>1</USED_LEASES>
+      <LEASES>
+        <LEASE>
+          <MAC><![CDATA[02:00:00:00:01:00]]></MAC>
+          <VM><![CDATA[43]]></VM>
+        </LEASE>
+      </LEASES>
+    </AR>
+  </AR_POOL>
+</VNET>
+`
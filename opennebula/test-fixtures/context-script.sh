@@ -0,0 +1,3 @@
+#!/bin/sh
+# Run by the contextualization packages of the acceptance test VMs
+echo "context files work" > /tmp/tf-acc-test-context
@@ -0,0 +1,165 @@
+package opennebula
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// minVersions lists, per resource, the attributes only supported by recent
+// OpenNebula versions along with the first version supporting them.
+// Configurations setting them are rejected at plan time when the endpoint
+// runs an older version.
+//
+// Keys of the form "attribute:operation" are operations on an attribute,
+// such as growing a disk in place, which resources check themselves with
+// checkOperationVersion when they plan them.
+var minVersions = map[string]map[string]string{
+	"opennebula_image": {
+		"lock": "5.8",
+	},
+	"opennebula_vm": {
+		"vmgroup":         "5.2",
+		"disk:resize":     "5.0",
+		"monitoring:pool": "5.12",
+	},
+}
+
+// Version is an OpenNebula version, as returned by one.system.version.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+var versionRegexp = regexp.MustCompile(`^\s*(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// parseVersion parses versions such as "5.4", "5.4.15" or "5.12.0.1",
+// ignoring anything after the patch number.
+func parseVersion(s string) (Version, error) {
+	m := versionRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, fmt.Errorf("Unexpected OpenNebula version %q", s)
+	}
+
+	var v Version
+	for i, p := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if m[i+1] == "" {
+			break
+		}
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return Version{}, fmt.Errorf("Unexpected OpenNebula version %q", s)
+		}
+		*p = n
+	}
+
+	return v, nil
+}
+
+// LessThan reports whether v is older than o.
+func (v Version) LessThan(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// detectVersion asks the endpoint for its version. A failure is only
+// logged: minimum versions are then not enforced.
+func (c *Client) detectVersion() {
+	resp, err := c.SystemVersion()
+	if err != nil {
+		log.Printf("[WARN] Could not get the OpenNebula version, attribute compatibility is not checked: %s", err)
+		return
+	}
+
+	v, err := parseVersion(resp)
+	if err != nil {
+		log.Printf("[WARN] %s, attribute compatibility is not checked", err)
+		return
+	}
+
+	log.Printf("[INFO] OpenNebula endpoint runs version %s", v)
+	c.Version = &v
+}
+
+// checkMinVersion returns an error if attribute of resource requires a more
+// recent OpenNebula than version, according to table.
+func checkMinVersion(table map[string]map[string]string, resource, attribute string, version Version) error {
+	min, ok := table[resource][attribute]
+	if !ok {
+		return nil
+	}
+
+	minVersion, err := parseVersion(min)
+	if err != nil {
+		return err
+	}
+
+	if version.LessThan(minVersion) {
+		return fmt.Errorf("%s: %q requires OpenNebula %s or later, the endpoint runs %s", resource, attribute, minVersion, version)
+	}
+
+	return nil
+}
+
+// checkResourceVersions rejects a diff setting attributes of resource that
+// the OpenNebula endpoint is too old for. Resources call it from their
+// CustomizeDiff function.
+func checkResourceVersions(diff *schema.ResourceDiff, meta interface{}, resource string) error {
+	client, ok := meta.(*Client)
+	if !ok || client.Version == nil {
+		return nil
+	}
+
+	attributes := make([]string, 0, len(minVersions[resource]))
+	for attribute := range minVersions[resource] {
+		if !strings.Contains(attribute, ":") {
+			attributes = append(attributes, attribute)
+		}
+	}
+	sort.Strings(attributes)
+
+	for _, attribute := range attributes {
+		if _, set := diff.GetOk(attribute); !set {
+			continue
+		}
+		if err := checkMinVersion(minVersions, resource, attribute, *client.Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkOperationVersion returns an error if the operation of resource, keyed
+// "attribute:operation" in minVersions, requires a more recent OpenNebula
+// than the endpoint runs. Unknown versions are not checked.
+func checkOperationVersion(meta interface{}, resource, operation string) error {
+	client, ok := meta.(*Client)
+	if !ok || client.Version == nil {
+		return nil
+	}
+	return checkMinVersion(minVersions, resource, operation, *client.Version)
+}
+
+// resourceVersionsCustomizeDiff is the CustomizeDiff function of resources
+// only checking attribute compatibility.
+func resourceVersionsCustomizeDiff(resource string) schema.CustomizeDiffFunc {
+	return func(diff *schema.ResourceDiff, meta interface{}) error {
+		return checkResourceVersions(diff, meta, resource)
+	}
+}
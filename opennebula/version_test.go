@@ -0,0 +1,162 @@
+package opennebula
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := map[string]Version{
+		"5":         {5, 0, 0},
+		"5.4":       {5, 4, 0},
+		"5.4.15":    {5, 4, 15},
+		"5.12.0.1":  {5, 12, 0},
+		"6.0.0-ee":  {6, 0, 0},
+		" 5.10.1\n": {5, 10, 1},
+	}
+
+	for s, expected := range cases {
+		v, err := parseVersion(s)
+		if err != nil {
+			t.Errorf("%q: err: %s", s, err)
+		} else if v != expected {
+			t.Errorf("%q: Expected %s, got %s", s, expected, v)
+		}
+	}
+
+	for _, s := range []string{"", "unknown", "v5.4"} {
+		if _, err := parseVersion(s); err == nil {
+			t.Errorf("Expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestCheckMinVersion(t *testing.T) {
+	table := map[string]map[string]string{
+		"opennebula_vm": {
+			"lock":       "5.10",
+			"hot_resize": "5.8.1",
+		},
+	}
+
+	cases := []struct {
+		version   string
+		attribute string
+		valid     bool
+	}{
+		{"5.4.1", "lock", false},
+		{"5.8.5", "lock", false},
+		{"5.10.0", "lock", true},
+		{"5.12", "lock", true},
+		{"6.0.0.2", "lock", true},
+		{"5.8.0", "hot_resize", false},
+		{"5.8.1", "hot_resize", true},
+		{"5.4.1", "name", true},
+	}
+
+	for _, c := range cases {
+		v, err := parseVersion(c.version)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		err = checkMinVersion(table, "opennebula_vm", c.attribute, v)
+		if c.valid && err != nil {
+			t.Errorf("%s on %s: Expected no error, got: %s", c.attribute, c.version, err)
+		}
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%s on %s: Expected an error", c.attribute, c.version)
+			} else if !strings.Contains(err.Error(), fmt.Sprintf("the endpoint runs %s", v)) {
+				t.Errorf("%s on %s: Unexpected error message: %s", c.attribute, c.version, err)
+			}
+		}
+	}
+
+	if err := checkMinVersion(table, "opennebula_vnet", "lock", Version{4, 0, 0}); err != nil {
+		t.Errorf("Expected attributes of other resources to be ignored, got: %s", err)
+	}
+}
+
+func TestMinVersions(t *testing.T) {
+	cases := []struct {
+		resource  string
+		attribute string
+		version   string
+		valid     bool
+	}{
+		{"opennebula_image", "lock", "5.6.2", false},
+		{"opennebula_image", "lock", "5.8.0", true},
+		{"opennebula_image", "lock", "6.0.0.2", true},
+		{"opennebula_vm", "vmgroup", "5.0.2", false},
+		{"opennebula_vm", "vmgroup", "5.2.0", true},
+		{"opennebula_vm", "disk:resize", "4.14.2", false},
+		{"opennebula_vm", "disk:resize", "5.0", true},
+		{"opennebula_vm", "monitoring:pool", "5.10.1", false},
+		{"opennebula_vm", "monitoring:pool", "5.12.0", true},
+		{"opennebula_vm", "name", "4.14.2", true},
+	}
+
+	for _, c := range cases {
+		if _, ok := minVersions[c.resource][c.attribute]; !ok && !c.valid {
+			t.Fatalf("%s: %q is not in minVersions", c.resource, c.attribute)
+		}
+
+		v, err := parseVersion(c.version)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		err = checkMinVersion(minVersions, c.resource, c.attribute, v)
+		if c.valid && err != nil {
+			t.Errorf("%s %s on %s: Expected no error, got: %s", c.resource, c.attribute, c.version, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%s %s on %s: Expected an error", c.resource, c.attribute, c.version)
+		}
+
+		client := &Client{Version: &v}
+		err = checkOperationVersion(client, c.resource, c.attribute)
+		if c.valid != (err == nil) {
+			t.Errorf("%s %s on %s: checkOperationVersion returned %v", c.resource, c.attribute, c.version, err)
+		}
+	}
+
+	for resource, attributes := range minVersions {
+		for attribute, min := range attributes {
+			if _, err := parseVersion(min); err != nil {
+				t.Errorf("%s %s: %s", resource, attribute, err)
+			}
+		}
+	}
+
+	if err := checkOperationVersion(&Client{}, "opennebula_vm", "disk:resize"); err != nil {
+		t.Errorf("Expected unknown versions not to be checked, got: %s", err)
+	}
+}
+
+func TestDetectVersion(t *testing.T) {
+	version := "5.10.1"
+	client, _ := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if method != "one.system.version" {
+			return nil, fmt.Errorf("unexpected call %s", method)
+		}
+		if version == "" {
+			return []interface{}{false, "[one.system.version] Not authorized", int64(0x0200)}, nil
+		}
+		return []interface{}{true, version, int64(0)}, nil
+	})
+
+	client.detectVersion()
+	if client.Version == nil || *client.Version != (Version{5, 10, 1}) {
+		t.Fatalf("Expected version 5.10.1, got %v", client.Version)
+	}
+
+	version = ""
+	client.Version = nil
+	client.detectVersion()
+	if client.Version != nil {
+		t.Fatalf("Expected an unknown version, got %v", client.Version)
+	}
+}
@@ -0,0 +1,160 @@
+package opennebula
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// vmDiskResize is a disk of a VM to grow to Size MB.
+type vmDiskResize struct {
+	ImageID int
+	Target  string
+	Size    int
+}
+
+// matchVmDisks pairs the disk blocks of old and new with the same image_id,
+// target and driver. It fails when a disk was added, removed or changed in
+// any other way than its size.
+func matchVmDisks(old, new []interface{}) ([][2]map[string]interface{}, bool) {
+	if len(old) != len(new) {
+		return nil, false
+	}
+
+	used := make([]bool, len(old))
+	pairs := make([][2]map[string]interface{}, 0, len(new))
+	for _, n := range new {
+		newdisk := n.(map[string]interface{})
+
+		found := false
+		for i, o := range old {
+			olddisk := o.(map[string]interface{})
+			if used[i] || olddisk["image_id"] != newdisk["image_id"] ||
+				olddisk["target"] != newdisk["target"] || olddisk["driver"] != newdisk["driver"] {
+				continue
+			}
+			used[i] = true
+			found = true
+			pairs = append(pairs, [2]map[string]interface{}{olddisk, newdisk})
+			break
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return pairs, true
+}
+
+// vmDiskResizes returns the disks to grow to go from the old disk blocks to
+// the new ones, and whether that can be done in place. Shrinking a disk is an
+// error. A size removed from the configuration leaves the disk as is.
+func vmDiskResizes(old, new []interface{}) ([]vmDiskResize, bool, error) {
+	pairs, ok := matchVmDisks(old, new)
+	if !ok {
+		return nil, false, nil
+	}
+
+	var resizes []vmDiskResize
+	for _, p := range pairs {
+		size := p[1]["size"].(int)
+		if size == 0 {
+			continue
+		}
+
+		// The size of a disk inheriting it from its image is only known
+		// from computed_size
+		current, _ := p[0]["computed_size"].(int)
+		if current == 0 {
+			current = p[0]["size"].(int)
+		}
+
+		if size < current {
+			return nil, false, fmt.Errorf("Disk of image %d can't shrink from %d to %d MB, only grow", p[1]["image_id"].(int), current, size)
+		}
+		if size > current {
+			resizes = append(resizes, vmDiskResize{
+				ImageID: p[1]["image_id"].(int),
+				Target:  p[1]["target"].(string),
+				Size:    size,
+			})
+		}
+	}
+	return resizes, true, nil
+}
+
+// checkVmDiskChange lets disk changes growing disks through, and forces the
+// VM to be recreated for any other change.
+func checkVmDiskChange(diff *schema.ResourceDiff, meta interface{}, old, new []interface{}) error {
+	resizes, inPlace, err := vmDiskResizes(old, new)
+	if err != nil {
+		return err
+	}
+	if !inPlace {
+		return diff.ForceNew("disk")
+	}
+	if len(resizes) > 0 {
+		return checkOperationVersion(meta, "opennebula_vm", "disk:resize")
+	}
+	return nil
+}
+
+// vmDiskID returns the DISK_ID of the first disk of the VM not used yet with
+// the given image and target, any target matching an empty one.
+func vmDiskID(disks []VirtualMachineDisk, imageID int, target string, used map[string]bool) (int, bool) {
+	for _, disk := range disks {
+		if used[disk.Disk_ID] || disk.Image_ID != imageID || (target != "" && disk.Target != target) {
+			continue
+		}
+		if id, err := strconv.Atoi(disk.Disk_ID); err == nil {
+			used[disk.Disk_ID] = true
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// resizeVmDisks grows the disks of the VM whose size was increased, one at a
+// time, waiting for the VM to get back to its state after each resize.
+func resizeVmDisks(d *schema.ResourceData, meta interface{}) error {
+	var vm *UserVm
+	client := meta.(*Client)
+
+	o, n := d.GetChange("disk")
+	resizes, _, err := vmDiskResizes(o.(*schema.Set).List(), n.(*schema.Set).List())
+	if err != nil || len(resizes) == 0 {
+		return err
+	}
+
+	resp, err := client.VmInfo(intId(d.Id()))
+	if err != nil {
+		return err
+	}
+	if err = client.Decode(resp, &vm); err != nil {
+		return err
+	}
+
+	state := "running"
+	if vm.State == 8 {
+		state = "poweroff"
+	}
+
+	used := make(map[string]bool)
+	for _, r := range resizes {
+		diskID, ok := vmDiskID(vm.VmTemplate.Disks, r.ImageID, r.Target, used)
+		if !ok {
+			return fmt.Errorf("Could not find the disk of image %d in VM %s", r.ImageID, d.Id())
+		}
+
+		if _, err = client.VmDiskResize(intId(d.Id()), diskID, strconv.Itoa(r.Size)); err != nil {
+			return fmt.Errorf("Error resizing disk %d of VM %s to %d MB: %s", diskID, d.Id(), r.Size, err)
+		}
+		if _, err = waitForVmState(d, meta, state); err != nil {
+			return fmt.Errorf("Error waiting for disk %d of VM %s to be resized: %s", diskID, d.Id(), err)
+		}
+		log.Printf("[INFO] Resized disk %d of VM %s to %d MB", diskID, d.Id(), r.Size)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package opennebula
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVmDiskResizes(t *testing.T) {
+	disk := func(imageID, size, computedSize int, target string) map[string]interface{} {
+		return map[string]interface{}{
+			"image_id":      imageID,
+			"size":          size,
+			"computed_size": computedSize,
+			"target":        target,
+			"driver":        "",
+		}
+	}
+	old := []interface{}{disk(5, 0, 2048, "vda"), disk(6, 1024, 1024, "")}
+
+	cases := []struct {
+		new     []interface{}
+		resizes []vmDiskResize
+		inPlace bool
+		valid   bool
+	}{
+		{[]interface{}{disk(5, 4096, 0, "vda"), disk(6, 1024, 0, "")}, []vmDiskResize{{5, "vda", 4096}}, true, true},
+		{[]interface{}{disk(6, 2048, 0, ""), disk(5, 0, 0, "vda")}, []vmDiskResize{{6, "", 2048}}, true, true},
+		{[]interface{}{disk(5, 2048, 0, "vda"), disk(6, 0, 0, "")}, nil, true, true},
+		{[]interface{}{disk(5, 1024, 0, "vda"), disk(6, 1024, 0, "")}, nil, false, false},
+		{[]interface{}{disk(5, 0, 0, "vdb"), disk(6, 1024, 0, "")}, nil, false, true},
+		{[]interface{}{disk(5, 0, 0, "vda")}, nil, false, true},
+	}
+
+	for i, c := range cases {
+		resizes, inPlace, err := vmDiskResizes(old, c.new)
+		if c.valid && err != nil {
+			t.Errorf("%d: Unexpected error: %s", i, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%d: Expected an error for shrinking a disk", i)
+		}
+		if !reflect.DeepEqual(resizes, c.resizes) || inPlace != c.inPlace {
+			t.Errorf("%d: Expected %v (%v), got %v (%v)", i, c.resizes, c.inPlace, resizes, inPlace)
+		}
+	}
+}
+
+func TestVmDiskID(t *testing.T) {
+	disks := []VirtualMachineDisk{
+		{Disk_ID: "0", Image_ID: 5, Target: "vda"},
+		{Disk_ID: "1", Image_ID: 6, Target: "vdb"},
+		{Disk_ID: "2", Image_ID: 6, Target: "vdc"},
+	}
+	used := make(map[string]bool)
+
+	if id, ok := vmDiskID(disks, 6, "vdc", used); !ok || id != 2 {
+		t.Errorf("Expected disk 2, got %d (%v)", id, ok)
+	}
+	if id, ok := vmDiskID(disks, 6, "", used); !ok || id != 1 {
+		t.Errorf("Expected disk 1, got %d (%v)", id, ok)
+	}
+	if _, ok := vmDiskID(disks, 6, "", used); ok {
+		t.Errorf("Expected no disk left for image 6")
+	}
+}
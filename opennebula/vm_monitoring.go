@@ -0,0 +1,62 @@
+package opennebula
+
+import (
+	"log"
+	"strings"
+)
+
+// vmMonitoringData is the reply of one.vm.monitoring. Up to 5.10, each
+// record is a VM element holding a MONITORING section, from 5.12 the
+// records are the MONITORING sections themselves.
+type vmMonitoringData struct {
+	VMs []struct {
+		Monitoring StringMap `xml:"MONITORING"`
+	} `xml:"VM"`
+	Monitoring []StringMap `xml:"MONITORING"`
+}
+
+// vmMonitoring returns the latest monitoring sample of a VM, with the names
+// of the values lower-cased. It comes with one.vm.info up to 5.10, and is
+// otherwise looked up with one.vm.monitoring, which is only worth a call on
+// 5.12 and newer or when the version is unknown. Monitoring is informational
+// only: failures are logged and give an empty sample.
+func vmMonitoring(client *Client, vm *UserVm) map[string]interface{} {
+	if len(vm.Monitoring) > 0 {
+		return flattenVmMonitoring(vm.Monitoring)
+	}
+	if err := checkOperationVersion(client, "opennebula_vm", "monitoring:pool"); err != nil {
+		log.Printf("[DEBUG] No monitoring for VM %s yet", vm.Id)
+		return map[string]interface{}{}
+	}
+
+	resp, err := client.VmMonitoring(intId(vm.Id))
+	if err != nil {
+		log.Printf("[WARN] Could not get the monitoring of VM %s: %s", vm.Id, err)
+		return map[string]interface{}{}
+	}
+
+	var data vmMonitoringData
+	if err = client.Decode(resp, &data); err != nil {
+		log.Printf("[WARN] Could not decode the monitoring of VM %s: %s", vm.Id, err)
+		return map[string]interface{}{}
+	}
+
+	if n := len(data.Monitoring); n > 0 {
+		return flattenVmMonitoring(data.Monitoring[n-1])
+	}
+	if n := len(data.VMs); n > 0 {
+		return flattenVmMonitoring(data.VMs[n-1].Monitoring)
+	}
+
+	return map[string]interface{}{}
+}
+
+func flattenVmMonitoring(monitoring StringMap) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, value := range monitoring {
+		if value = strings.TrimSpace(value); value != "" {
+			result[strings.ToLower(key)] = value
+		}
+	}
+	return result
+}
@@ -0,0 +1,77 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestVmMonitoring(t *testing.T) {
+	replies := map[string]string{
+		// 5.10 and older
+		"1": `<MONITORING_DATA>
+  <VM><ID>1</ID><MONITORING><CPU><![CDATA[3.0]]></CPU><MEMORY><![CDATA[524288]]></MEMORY></MONITORING></VM>
+  <VM><ID>1</ID><MONITORING><CPU><![CDATA[5.0]]></CPU><MEMORY><![CDATA[524300]]></MEMORY></MONITORING></VM>
+</MONITORING_DATA>`,
+		// 5.12 and newer
+		"2": `<MONITORING_DATA>
+  <MONITORING><ID>2</ID><CPU><![CDATA[3.0]]></CPU><NETRX><![CDATA[100]]></NETRX></MONITORING>
+  <MONITORING><ID>2</ID><CPU><![CDATA[7.0]]></CPU><NETRX><![CDATA[200]]></NETRX></MONITORING>
+</MONITORING_DATA>`,
+		"3": `<MONITORING_DATA></MONITORING_DATA>`,
+	}
+
+	client, caller := testClient(func(method string, args []interface{}) ([]interface{}, error) {
+		if reply, ok := replies[fmt.Sprint(args[0])]; ok {
+			return []interface{}{true, reply, int64(0)}, nil
+		}
+		return []interface{}{false, "[one.vm.monitoring] Error getting virtual machine", int64(0x0400)}, nil
+	})
+
+	var vm UserVm
+	if err := xml.Unmarshal([]byte(`<VM><ID>0</ID><MONITORING><CPU>1.0</CPU><NETTX>42</NETTX><DISK_SIZE><ID>0</ID></DISK_SIZE></MONITORING></VM>`), &vm); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	cases := []struct {
+		vm       *UserVm
+		expected map[string]interface{}
+	}{
+		{&vm, map[string]interface{}{"cpu": "1.0", "nettx": "42"}},
+		{&UserVm{Id: "1"}, map[string]interface{}{"cpu": "5.0", "memory": "524300"}},
+		{&UserVm{Id: "2"}, map[string]interface{}{"id": "2", "cpu": "7.0", "netrx": "200"}},
+		{&UserVm{Id: "3"}, map[string]interface{}{}},
+		{&UserVm{Id: "4"}, map[string]interface{}{}},
+	}
+
+	for _, c := range cases {
+		if monitoring := vmMonitoring(client, c.vm); !reflect.DeepEqual(monitoring, c.expected) {
+			t.Errorf("VM %s: Expected %v, got %v", c.vm.Id, c.expected, monitoring)
+		}
+	}
+
+	if calls := caller.callsTo("one.vm.monitoring"); len(calls) != 4 {
+		t.Errorf("Expected one.vm.monitoring to be called for VMs without monitoring only, got %d calls", len(calls))
+	}
+
+	// Up to 5.10 one.vm.info has all the monitoring there is
+	for _, c := range []struct {
+		version string
+		calls   int
+	}{{"5.10.1", 4}, {"5.12.0", 5}} {
+		version, calls := c.version, c.calls
+		v, err := parseVersion(version)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		client.Version = &v
+
+		if monitoring := vmMonitoring(client, &UserVm{Id: "2"}); version == "5.12.0" && len(monitoring) == 0 {
+			t.Errorf("%s: Expected monitoring to be looked up", version)
+		}
+		if n := len(caller.callsTo("one.vm.monitoring")); n != calls {
+			t.Errorf("%s: Expected %d calls to one.vm.monitoring, got %d", version, calls, n)
+		}
+	}
+}
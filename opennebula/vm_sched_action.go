@@ -0,0 +1,280 @@
+package opennebula
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// VmUserTemplate is the user template of a VM. Its attributes other than
+// the scheduled actions are kept verbatim, so that the template can be
+// written back with one.vm.update without losing them.
+type VmUserTemplate struct {
+	SchedActions []VmSchedAction       `xml:"SCHED_ACTION"`
+	Attributes   []vmTemplateAttribute `xml:",any"`
+}
+
+type vmTemplateAttribute struct {
+	XMLName xml.Name
+	Content string `xml:",innerxml"`
+}
+
+// VmSchedAction is a scheduled action (SCHED_ACTION) of a VM. IDs are
+// assigned by whoever adds the action to the user template, and tell the
+// actions apart when it is updated.
+type VmSchedAction struct {
+	ID       int    `xml:"ID"`
+	Action   string `xml:"ACTION"`
+	Time     string `xml:"TIME"`
+	Repeat   string `xml:"REPEAT,omitempty"`
+	Days     string `xml:"DAYS,omitempty"`
+	EndType  string `xml:"END_TYPE,omitempty"`
+	EndValue string `xml:"END_VALUE,omitempty"`
+	Done     string `xml:"DONE,omitempty"`
+	Message  string `xml:"MESSAGE,omitempty"`
+}
+
+// Values of REPEAT and END_TYPE, indexed by their number in OpenNebula.
+var (
+	vmSchedRepeats  = []string{"weekly", "monthly", "yearly", "hourly"}
+	vmSchedEndTypes = []string{"never", "times", "date"}
+)
+
+var vmSchedActionTypes = []string{
+	"terminate", "terminate-hard", "undeploy", "undeploy-hard", "hold", "release",
+	"stop", "suspend", "resume", "reboot", "reboot-hard", "poweroff", "poweroff-hard",
+	"snapshot-create",
+}
+
+// Get returns the value of the attribute key, or "" when it isn't set or
+// isn't a single value.
+func (t *VmUserTemplate) Get(key string) string {
+	for _, a := range t.Attributes {
+		if a.XMLName.Local != key {
+			continue
+		}
+
+		var value struct {
+			Value string `xml:",chardata"`
+		}
+		if err := xml.Unmarshal([]byte("<VALUE>"+a.Content+"</VALUE>"), &value); err != nil {
+			return ""
+		}
+		return value.Value
+	}
+	return ""
+}
+
+// vmTemplateValue returns a single value attribute of a user template.
+func vmTemplateValue(key, value string) vmTemplateAttribute {
+	w := &bytes.Buffer{}
+	xml.EscapeText(w, []byte(value))
+	return vmTemplateAttribute{XMLName: xml.Name{Local: key}, Content: w.String()}
+}
+
+// XML renders the user template as sent to one.vm.update.
+func (t *VmUserTemplate) XML() (string, error) {
+	w := &bytes.Buffer{}
+
+	enc := xml.NewEncoder(w)
+	if err := enc.EncodeElement(t, xml.StartElement{Name: xml.Name{Local: "TEMPLATE"}}); err != nil {
+		return "", err
+	}
+
+	return w.String(), nil
+}
+
+func vmSchedActionSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeList,
+		Optional:    true,
+		Description: "Actions OpenNebula runs on the VM at a given time, possibly repeated",
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"id": {
+					Type:        schema.TypeInt,
+					Computed:    true,
+					Description: "ID of the scheduled action in the VM user template",
+				},
+				"action": {
+					Type:         schema.TypeString,
+					Required:     true,
+					Description:  "Action to run, e.g. poweroff or terminate",
+					ValidateFunc: validateInList(vmSchedActionTypes),
+				},
+				"time": {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: "When to run the action: a Unix timestamp, or +<seconds> after the VM was created",
+				},
+				"repeat": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Description:  "Repeat the action weekly, monthly, yearly or hourly",
+					ValidateFunc: validateInList(vmSchedRepeats),
+				},
+				"days": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: "Comma separated days the action is repeated on: days of the week (0 is Sunday), of the month or of the year, or the number of hours between two runs for hourly",
+				},
+				"end_type": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Description:  "How the repetition ends: never, after end_value times, or on the date end_value",
+					ValidateFunc: validateInList(vmSchedEndTypes),
+				},
+				"end_value": {
+					Type:        schema.TypeInt,
+					Optional:    true,
+					Description: "Number of repetitions, or Unix timestamp of the end date, depending on end_type",
+				},
+			},
+		},
+	}
+}
+
+// validateInList returns a ValidateFunc accepting the given values only.
+func validateInList(valid []string) schema.SchemaValidateFunc {
+	return func(v interface{}, k string) (ws []string, errors []error) {
+		if !in_array(v.(string), valid) {
+			errors = append(errors, fmt.Errorf("%q must be one of: %s", k, strings.Join(valid, ", ")))
+		}
+		return
+	}
+}
+
+// schedActionName returns the name of the numbered value of REPEAT or
+// END_TYPE, or the value itself when it isn't known.
+func schedActionName(names []string, value string) string {
+	if i, err := strconv.Atoi(value); err == nil && i >= 0 && i < len(names) {
+		return names[i]
+	}
+	return value
+}
+
+// schedActionNumber is the reverse of schedActionName.
+func schedActionNumber(names []string, name string) string {
+	for i, n := range names {
+		if n == name {
+			return strconv.Itoa(i)
+		}
+	}
+	return name
+}
+
+func flattenVmSchedActions(actions []VmSchedAction) []interface{} {
+	result := make([]interface{}, 0, len(actions))
+	for _, a := range actions {
+		action := map[string]interface{}{
+			"id":     a.ID,
+			"action": a.Action,
+			"time":   a.Time,
+			"days":   a.Days,
+		}
+		if a.Repeat != "" {
+			action["repeat"] = schedActionName(vmSchedRepeats, a.Repeat)
+		}
+		if a.EndType != "" {
+			action["end_type"] = schedActionName(vmSchedEndTypes, a.EndType)
+		}
+		if v, err := strconv.Atoi(a.EndValue); err == nil {
+			action["end_value"] = v
+		}
+
+		result = append(result, action)
+	}
+	return result
+}
+
+// expandVmSchedActions returns the scheduled actions to write for the
+// declared sched_action blocks. A block applied before (present in
+// previous) keeps the ID of its action, along with DONE and MESSAGE as long
+// as it is unchanged. New blocks get IDs after the highest one in use.
+func expandVmSchedActions(declared, previous []interface{}, current []VmSchedAction) []VmSchedAction {
+	nextID := 0
+	byID := make(map[int]VmSchedAction)
+	for _, a := range current {
+		byID[a.ID] = a
+		if a.ID >= nextID {
+			nextID = a.ID + 1
+		}
+	}
+	for _, p := range previous {
+		if id := p.(map[string]interface{})["id"].(int); id >= nextID {
+			nextID = id + 1
+		}
+	}
+
+	result := make([]VmSchedAction, 0, len(declared))
+	for i, dec := range declared {
+		block := dec.(map[string]interface{})
+
+		action := VmSchedAction{
+			Action: block["action"].(string),
+			Time:   block["time"].(string),
+			Days:   block["days"].(string),
+		}
+		if v := block["repeat"].(string); v != "" {
+			action.Repeat = schedActionNumber(vmSchedRepeats, v)
+		}
+		if v := block["end_type"].(string); v != "" {
+			action.EndType = schedActionNumber(vmSchedEndTypes, v)
+		}
+		if v := block["end_value"].(int); v != 0 {
+			action.EndValue = strconv.Itoa(v)
+		}
+
+		if i < len(previous) {
+			action.ID = previous[i].(map[string]interface{})["id"].(int)
+			if old, ok := byID[action.ID]; ok && sameSchedAction(old, action) {
+				action.Done = old.Done
+				action.Message = old.Message
+			}
+		} else {
+			action.ID = nextID
+			nextID++
+		}
+
+		result = append(result, action)
+	}
+	return result
+}
+
+func sameSchedAction(a, b VmSchedAction) bool {
+	return a.Action == b.Action && a.Time == b.Time && a.Repeat == b.Repeat &&
+		a.Days == b.Days && a.EndType == b.EndType && a.EndValue == b.EndValue
+}
+
+// updateVmSchedActions replaces the scheduled actions in the user template
+// of the VM with the declared ones, keeping its other attributes.
+func updateVmSchedActions(d *schema.ResourceData, client *Client) error {
+	var vm *UserVm
+
+	resp, err := client.VmInfo(intId(d.Id()))
+	if err != nil {
+		return err
+	}
+	if err = client.Decode(resp, &vm); err != nil {
+		return err
+	}
+
+	previous, declared := d.GetChange("sched_action")
+	tpl := vm.VmUserTemplate
+	tpl.SchedActions = expandVmSchedActions(declared.([]interface{}), previous.([]interface{}), tpl.SchedActions)
+
+	tplxml, err := tpl.XML()
+	if err != nil {
+		return err
+	}
+
+	if _, err = client.VmUpdate(intId(d.Id()), tplxml, 0); err != nil {
+		return fmt.Errorf("Error updating the scheduled actions of VM %s: %s", d.Id(), err)
+	}
+
+	return nil
+}
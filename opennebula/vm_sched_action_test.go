@@ -0,0 +1,113 @@
+package opennebula
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testVmUserTemplate = `
+<USER_TEMPLATE>
+  <READY><![CDATA[YES]]></READY>
+  <LABELS><![CDATA[dev]]></LABELS>
+  <SCHED_ACTION>
+    <ACTION><![CDATA[poweroff]]></ACTION>
+    <DAYS><![CDATA[1,2,3,4,5]]></DAYS>
+    <DONE><![CDATA[1546300800]]></DONE>
+    <END_TYPE><![CDATA[0]]></END_TYPE>
+    <ID><![CDATA[0]]></ID>
+    <REPEAT><![CDATA[0]]></REPEAT>
+    <TIME><![CDATA[1546282800]]></TIME>
+  </SCHED_ACTION>
+  <SCHED_ACTION>
+    <ACTION><![CDATA[terminate]]></ACTION>
+    <ID><![CDATA[3]]></ID>
+    <TIME><![CDATA[+86400]]></TIME>
+  </SCHED_ACTION>
+</USER_TEMPLATE>
+`
+
+func TestVmUserTemplate(t *testing.T) {
+	var tpl VmUserTemplate
+	if err := xml.Unmarshal([]byte(testVmUserTemplate), &tpl); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if v := tpl.Get("READY"); v != "YES" {
+		t.Errorf("Expected READY to be YES, got %q", v)
+	}
+	if v := tpl.Get("ERROR"); v != "" {
+		t.Errorf("Expected ERROR to be empty, got %q", v)
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{"id": 0, "action": "poweroff", "time": "1546282800", "days": "1,2,3,4,5", "repeat": "weekly", "end_type": "never"},
+		map[string]interface{}{"id": 3, "action": "terminate", "time": "+86400", "days": ""},
+	}
+	if actions := flattenVmSchedActions(tpl.SchedActions); !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected scheduled actions %v, got %v", expected, actions)
+	}
+
+	tplxml, err := tpl.XML()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for _, s := range []string{"<TEMPLATE>", "<READY><![CDATA[YES]]></READY>", "<LABELS><![CDATA[dev]]></LABELS>", "<ID>3</ID>"} {
+		if !strings.Contains(tplxml, s) {
+			t.Errorf("Expected %s in the template, got %s", s, tplxml)
+		}
+	}
+}
+
+func TestExpandVmSchedActions(t *testing.T) {
+	current := []VmSchedAction{
+		{ID: 0, Action: "poweroff", Time: "1546282800", Repeat: "0", Days: "1,2,3,4,5", EndType: "0", Done: "1546300800"},
+		{ID: 3, Action: "terminate", Time: "+86400"},
+	}
+	previous := []interface{}{
+		map[string]interface{}{"id": 0, "action": "poweroff", "time": "1546282800", "days": "1,2,3,4,5", "repeat": "weekly", "end_type": "never", "end_value": 0},
+		map[string]interface{}{"id": 3, "action": "terminate", "time": "+86400", "days": "", "repeat": "", "end_type": "", "end_value": 0},
+	}
+	declared := []interface{}{
+		map[string]interface{}{"id": 0, "action": "poweroff", "time": "1546282800", "days": "1,2,3,4,5", "repeat": "weekly", "end_type": "never", "end_value": 0},
+		map[string]interface{}{"id": 3, "action": "terminate", "time": "+172800", "days": "", "repeat": "", "end_type": "", "end_value": 0},
+		map[string]interface{}{"id": 0, "action": "resume", "time": "1546322400", "days": "1,2,3,4,5", "repeat": "weekly", "end_type": "times", "end_value": 10},
+	}
+
+	expected := []VmSchedAction{
+		{ID: 0, Action: "poweroff", Time: "1546282800", Repeat: "0", Days: "1,2,3,4,5", EndType: "0", Done: "1546300800"},
+		{ID: 3, Action: "terminate", Time: "+172800"},
+		{ID: 4, Action: "resume", Time: "1546322400", Repeat: "0", Days: "1,2,3,4,5", EndType: "1", EndValue: "10"},
+	}
+	if actions := expandVmSchedActions(declared, previous, current); !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected scheduled actions %v, got %v", expected, actions)
+	}
+
+	if actions := expandVmSchedActions(declared[:1], previous, current); len(actions) != 1 || actions[0].ID != 0 {
+		t.Errorf("Expected the first scheduled action only, got %v", actions)
+	}
+
+	if actions := expandVmSchedActions(declared[2:], []interface{}{}, nil); len(actions) != 1 || actions[0].ID != 0 {
+		t.Errorf("Expected the first ID on a VM without scheduled actions, got %v", actions)
+	}
+}
+
+func TestVmTemplateValue(t *testing.T) {
+	tpl := VmUserTemplate{
+		Attributes: []vmTemplateAttribute{vmTemplateValue("DESCRIPTION", "web <frontend> & proxy")},
+	}
+
+	tplxml, err := tpl.XML()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	var read VmUserTemplate
+	if err = xml.Unmarshal([]byte(tplxml), &read); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if v := read.Get("DESCRIPTION"); v != "web <frontend> & proxy" {
+		t.Errorf("Expected the description back, got %q from %s", v, tplxml)
+	}
+}
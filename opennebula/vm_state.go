@@ -0,0 +1,112 @@
+package opennebula
+
+import "fmt"
+
+// vmStates are the names of the VM states, indexed by their number.
+var vmStates = []string{
+	"INIT",
+	"PENDING",
+	"HOLD",
+	"ACTIVE",
+	"STOPPED",
+	"SUSPENDED",
+	"DONE",
+	"FAILED",
+	"POWEROFF",
+	"UNDEPLOYED",
+	"CLONING",
+	"CLONING_FAILURE",
+}
+
+// vmLcmStates are the names of the LCM states of ACTIVE VMs, indexed by
+// their number.
+var vmLcmStates = []string{
+	"LCM_INIT",
+	"PROLOG",
+	"BOOT",
+	"RUNNING",
+	"MIGRATE",
+	"SAVE_STOP",
+	"SAVE_SUSPEND",
+	"SAVE_MIGRATE",
+	"PROLOG_MIGRATE",
+	"PROLOG_RESUME",
+	"EPILOG_STOP",
+	"EPILOG",
+	"SHUTDOWN",
+	"CANCEL",
+	"FAILURE",
+	"CLEANUP_RESUBMIT",
+	"UNKNOWN",
+	"HOTPLUG",
+	"SHUTDOWN_POWEROFF",
+	"BOOT_UNKNOWN",
+	"BOOT_POWEROFF",
+	"BOOT_SUSPENDED",
+	"BOOT_STOPPED",
+	"CLEANUP_DELETE",
+	"HOTPLUG_SNAPSHOT",
+	"HOTPLUG_NIC",
+	"HOTPLUG_SAVEAS",
+	"HOTPLUG_SAVEAS_POWEROFF",
+	"HOTPLUG_SAVEAS_SUSPENDED",
+	"SHUTDOWN_UNDEPLOY",
+	"EPILOG_UNDEPLOY",
+	"PROLOG_UNDEPLOY",
+	"BOOT_UNDEPLOY",
+	"HOTPLUG_PROLOG_POWEROFF",
+	"HOTPLUG_EPILOG_POWEROFF",
+	"BOOT_MIGRATE",
+	"BOOT_FAILURE",
+	"BOOT_MIGRATE_FAILURE",
+	"PROLOG_MIGRATE_FAILURE",
+	"PROLOG_FAILURE",
+	"EPILOG_FAILURE",
+	"EPILOG_STOP_FAILURE",
+	"EPILOG_UNDEPLOY_FAILURE",
+	"PROLOG_MIGRATE_POWEROFF",
+	"PROLOG_MIGRATE_POWEROFF_FAILURE",
+	"PROLOG_MIGRATE_SUSPEND",
+	"PROLOG_MIGRATE_SUSPEND_FAILURE",
+	"BOOT_UNDEPLOY_FAILURE",
+	"BOOT_STOPPED_FAILURE",
+	"PROLOG_RESUME_FAILURE",
+	"PROLOG_UNDEPLOY_FAILURE",
+	"DISK_SNAPSHOT_POWEROFF",
+	"DISK_SNAPSHOT_REVERT_POWEROFF",
+	"DISK_SNAPSHOT_DELETE_POWEROFF",
+	"DISK_SNAPSHOT_SUSPENDED",
+	"DISK_SNAPSHOT_REVERT_SUSPENDED",
+	"DISK_SNAPSHOT_DELETE_SUSPENDED",
+	"DISK_SNAPSHOT",
+	"DISK_SNAPSHOT_REVERT",
+	"DISK_SNAPSHOT_DELETE",
+	"PROLOG_MIGRATE_UNKNOWN",
+	"PROLOG_MIGRATE_UNKNOWN_FAILURE",
+	"DISK_RESIZE",
+	"DISK_RESIZE_POWEROFF",
+	"DISK_RESIZE_UNDEPLOYED",
+	"HOTPLUG_NIC_POWEROFF",
+	"HOTPLUG_RESIZE",
+	"HOTPLUG_SAVEAS_UNDEPLOYED",
+	"HOTPLUG_SAVEAS_STOPPED",
+	"BACKUP",
+	"BACKUP_POWEROFF",
+}
+
+// stateName returns the name of state in names, or its number when
+// unknown to this version of the provider.
+func stateName(names []string, state int) string {
+	if state < 0 || state >= len(names) {
+		return fmt.Sprintf("%d", state)
+	}
+	return names[state]
+}
+
+func vmStateName(state int) string {
+	return stateName(vmStates, state)
+}
+
+func vmLcmStateName(state int) string {
+	return stateName(vmLcmStates, state)
+}
@@ -0,0 +1,36 @@
+package opennebula
+
+import "testing"
+
+func TestVmStateNames(t *testing.T) {
+	states := map[int]string{
+		0:  "INIT",
+		3:  "ACTIVE",
+		6:  "DONE",
+		8:  "POWEROFF",
+		11: "CLONING_FAILURE",
+		12: "12",
+		-1: "-1",
+	}
+	for state, name := range states {
+		if n := vmStateName(state); n != name {
+			t.Errorf("Expected state %d to be %s, got %s", state, name, n)
+		}
+	}
+
+	lcmStates := map[int]string{
+		0:  "LCM_INIT",
+		3:  "RUNNING",
+		16: "UNKNOWN",
+		36: "BOOT_FAILURE",
+		64: "DISK_RESIZE_UNDEPLOYED",
+		66: "HOTPLUG_RESIZE",
+		70: "BACKUP_POWEROFF",
+		71: "71",
+	}
+	for state, name := range lcmStates {
+		if n := vmLcmStateName(state); n != name {
+			t.Errorf("Expected LCM state %d to be %s, got %s", state, name, n)
+		}
+	}
+}